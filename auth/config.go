@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"log"
+	"strings"
+
+	"github.com/saroopmathur/rest-api/config"
+)
+
+// Password hashing configuration, overridden from .env / environment
+var PASSWORD_HASH = HASH_BCRYPT
+var BCRYPT_COST = 12
+var ARGON2_TIME = 3
+var ARGON2_MEMORY = 64 * 1024 // KiB
+var ARGON2_THREADS = 2
+
+// Access tokens: opaque session tokens (session), or signed JWTs (jwt)
+var AUTH_MODE = AUTH_MODE_SESSION
+var JWT_KEY_DIR = "./keys"
+var JWT_KID = ""
+var JWT_ISSUER = ""
+
+// Issuer shown by authenticator apps for TOTP
+var MFA_ISSUER = "Xpress"
+
+// OpenID Connect login, on when OIDC_ISSUER is set
+var OIDC_ISSUER = ""
+var OIDC_CLIENT_ID = ""
+var OIDC_CLIENT_SECRET = ""
+var OIDC_REDIRECT_URL = ""
+var OIDC_SCOPES = "openid profile email"
+var OIDC_USERNAME_CLAIM = "preferred_username"
+var OIDC_DOMAIN_CLAIM = "email"
+var OIDC_DOMAIN_MAP = map[string]string{}
+var OIDC_ROLE_CLAIM = "groups"
+var OIDC_ADMIN_VALUES []string
+var OIDC_USER_VALUES []string
+var OIDC_AUTO_CREATE = false
+var PASSWORD_LOGIN = true
+
+// Encrypts secrets stored in the database, base64 of 32 bytes
+var SECRET_KEY = ""
+
+func init() {
+	config.String("PASSWORD_HASH", &PASSWORD_HASH)
+	config.Int("BCRYPT_COST", &BCRYPT_COST)
+	config.Int("ARGON2_TIME", &ARGON2_TIME)
+	config.Int("ARGON2_MEMORY", &ARGON2_MEMORY)
+	config.Int("ARGON2_THREADS", &ARGON2_THREADS)
+
+	if Hasher(PASSWORD_HASH) == nil {
+		log.Printf("auth: unknown PASSWORD_HASH=%s - using %s\n", PASSWORD_HASH, HASH_BCRYPT)
+		PASSWORD_HASH = HASH_BCRYPT
+	}
+
+	config.String("AUTH_MODE", &AUTH_MODE)
+	config.String("JWT_KEY_DIR", &JWT_KEY_DIR)
+	config.String("JWT_KID", &JWT_KID)
+	config.String("JWT_ISSUER", &JWT_ISSUER)
+	config.String("MFA_ISSUER", &MFA_ISSUER)
+	config.String("SECRET_KEY", &SECRET_KEY)
+
+	config.String("OIDC_ISSUER", &OIDC_ISSUER)
+	config.String("OIDC_CLIENT_ID", &OIDC_CLIENT_ID)
+	config.String("OIDC_CLIENT_SECRET", &OIDC_CLIENT_SECRET)
+	config.String("OIDC_REDIRECT_URL", &OIDC_REDIRECT_URL)
+	config.String("OIDC_SCOPES", &OIDC_SCOPES)
+	config.String("OIDC_USERNAME_CLAIM", &OIDC_USERNAME_CLAIM)
+	config.String("OIDC_DOMAIN_CLAIM", &OIDC_DOMAIN_CLAIM)
+	config.String("OIDC_ROLE_CLAIM", &OIDC_ROLE_CLAIM)
+	config.List("OIDC_ADMIN_VALUES", &OIDC_ADMIN_VALUES)
+	config.List("OIDC_USER_VALUES", &OIDC_USER_VALUES)
+	config.Bool("OIDC_AUTO_CREATE", &OIDC_AUTO_CREATE)
+	config.Bool("PASSWORD_LOGIN", &PASSWORD_LOGIN)
+
+	// OIDC_DOMAIN_MAP=example.com=acme,example.org=other - the tenant of each
+	// identity provider domain. Domains not listed can not login
+	var domainMap []string
+	config.List("OIDC_DOMAIN_MAP", &domainMap)
+	for _, item := range domainMap {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			log.Printf("auth: invalid OIDC_DOMAIN_MAP entry %s\n", item)
+			continue
+		}
+		OIDC_DOMAIN_MAP[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+
+	if OIDC_ISSUER != "" {
+		OIDC = NewOIDCProvider(OIDCConfig{
+			Issuer:       OIDC_ISSUER,
+			ClientID:     OIDC_CLIENT_ID,
+			ClientSecret: OIDC_CLIENT_SECRET,
+			RedirectURL:  OIDC_REDIRECT_URL,
+			Scopes:       strings.Fields(OIDC_SCOPES),
+		})
+		log.Printf("auth: OpenID Connect login with %s\n", OIDC_ISSUER)
+	}
+
+	if JWTEnabled() {
+		if err := LoadJWTKeys(JWT_KEY_DIR); err != nil {
+			log.Fatalf("auth: loading JWT keys from %s: %v", JWT_KEY_DIR, err)
+		}
+		log.Printf("auth: JWT access tokens, signing key %s\n", JWT_KID)
+	}
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	AUTH_MODE_SESSION = "session"
+	AUTH_MODE_JWT     = "jwt"
+
+	JWT_ALG_EDDSA = "EdDSA"
+	JWT_ALG_HS256 = "HS256"
+)
+
+var ErrTokenInvalid = errors.New("invalid token")
+var ErrTokenExpired = errors.New("token expired")
+
+// Claims carried by the access token. SessionID identifies the row in the
+// sessions table, so the token can be revoked on logout
+type Claims struct {
+	Issuer     string `json:"iss,omitempty"`
+	UserID     int    `json:"uid"`
+	Name       string `json:"name"`
+	Role       string `json:"role"`
+	DomainID   int    `json:"did"`
+	DomainName string `json:"domain"`
+	SessionID  string `json:"sid"`
+	IssuedAt   int64  `json:"iat"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type jwtKey struct {
+	alg    string
+	priv   ed25519.PrivateKey
+	pub    ed25519.PublicKey
+	secret []byte
+}
+
+// All keys by kid. Retired keys stay here to verify tokens issued before
+// the rotation, only JWT_KID is used to sign
+var jwtKeys = map[string]*jwtKey{}
+
+func JWTEnabled() bool {
+	return AUTH_MODE == AUTH_MODE_JWT
+}
+
+// Load signing and verification keys from dir. The file name without
+// extension is the kid:
+//
+//	<kid>.pem     Ed25519 "PRIVATE KEY" (PKCS#8), or "PUBLIC KEY" to only verify
+//	              e.g. openssl genpkey -algorithm ed25519 -out keys/2022-01.pem
+//	<kid>.secret  HS256 shared secret, at least 32 bytes
+func LoadJWTKeys(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*jwtKey{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(f.Name())
+		kid := strings.TrimSuffix(f.Name(), ext)
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+
+		switch ext {
+		case ".pem":
+			key, err := parseEd25519PEM(data)
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.Name(), err)
+			}
+			keys[kid] = key
+		case ".secret":
+			secret := []byte(strings.TrimSpace(string(data)))
+			if len(secret) < 32 {
+				return fmt.Errorf("%s: HS256 secret must be at least 32 bytes", f.Name())
+			}
+			keys[kid] = &jwtKey{alg: JWT_ALG_HS256, secret: secret}
+		}
+	}
+
+	if JWT_KID == "" {
+		// Only one key that can sign, use it
+		for kid, key := range keys {
+			if key.priv == nil && key.secret == nil {
+				continue
+			}
+			if JWT_KID != "" {
+				return fmt.Errorf("several signing keys in %s, set JWT_KID", dir)
+			}
+			JWT_KID = kid
+		}
+	}
+	signer := keys[JWT_KID]
+	if signer == nil || (signer.priv == nil && signer.secret == nil) {
+		return fmt.Errorf("no signing key '%s' in %s", JWT_KID, dir)
+	}
+
+	jwtKeys = keys
+	return nil
+}
+
+func parseEd25519PEM(data []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := k.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("not an Ed25519 key")
+		}
+		return &jwtKey{alg: JWT_ALG_EDDSA, priv: priv, pub: priv.Public().(ed25519.PublicKey)}, nil
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := k.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an Ed25519 key")
+		}
+		return &jwtKey{alg: JWT_ALG_EDDSA, pub: pub}, nil
+	}
+	return nil, fmt.Errorf("unexpected PEM type %s", block.Type)
+}
+
+// IsJWT tells a JWT from an opaque session token
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Sign the claims with the JWT_KID key, valid for lifetime
+func SignJWT(claims *Claims, lifetime time.Duration) (string, error) {
+	key := jwtKeys[JWT_KID]
+	if key == nil {
+		return "", fmt.Errorf("no JWT signing key")
+	}
+
+	now := time.Now()
+	claims.Issuer = JWT_ISSUER
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(lifetime).Unix()
+
+	header, err := json.Marshal(&jwtHeader{Alg: key.alg, Typ: "JWT", Kid: JWT_KID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := key.sign([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify signature and expiry of token and return its claims
+func VerifyJWT(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	key := jwtKeys[header.Kid]
+	if key == nil || key.alg != header.Alg {
+		// Unknown kid, or alg not the one of the key
+		return nil, ErrTokenInvalid
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrTokenInvalid
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if JWT_ISSUER != "" && claims.Issuer != JWT_ISSUER {
+		return nil, ErrTokenInvalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (k *jwtKey) sign(data []byte) []byte {
+	if k.alg == JWT_ALG_EDDSA {
+		return ed25519.Sign(k.priv, data)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (k *jwtKey) verify(data []byte, sig []byte) bool {
+	if k.alg == JWT_ALG_EDDSA {
+		return ed25519.Verify(k.pub, data, sig)
+	}
+	return hmac.Equal(k.sign(data), sig)
+}
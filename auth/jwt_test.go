@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Key directory with an Ed25519 key for each of kids, signing with the
+// last one. The keys are restored when the test ends
+func jwtKeyDir(t *testing.T, kids ...string) (string, map[string]ed25519.PrivateKey) {
+	savedKeys, savedKid, savedIssuer := jwtKeys, JWT_KID, JWT_ISSUER
+	t.Cleanup(func() {
+		jwtKeys, JWT_KID, JWT_ISSUER = savedKeys, savedKid, savedIssuer
+	})
+
+	dir := t.TempDir()
+	privs := map[string]ed25519.PrivateKey{}
+	for _, kid := range kids {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		privs[kid] = priv
+	}
+
+	JWT_KID = kids[len(kids)-1]
+	JWT_ISSUER = "xpress"
+	if err := LoadJWTKeys(dir); err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+	return dir, privs
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClaims() *Claims {
+	return &Claims{UserID: 7, Name: "alice", Role: "ADMIN", DomainID: 1, DomainName: "acme", SessionID: "s1"}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Token with the given header, signed by sign over header.payload
+func rawJWT(header map[string]string, claims *Claims, sign func(data []byte) []byte) string {
+	h, _ := json.Marshal(header)
+	p, _ := json.Marshal(claims)
+	data := b64(h) + "." + b64(p)
+	return data + "." + b64(sign([]byte(data)))
+}
+
+func hs256(secret []byte) func(data []byte) []byte {
+	return func(data []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+}
+
+func TestSignJWT(t *testing.T) {
+	jwtKeyDir(t, "k1")
+
+	token, err := SignJWT(testClaims(), time.Minute)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+	if !IsJWT(token) {
+		t.Errorf("IsJWT(%s) = false", token)
+	}
+	claims, err := VerifyJWT(token)
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	want := testClaims()
+	want.Issuer, want.IssuedAt, want.ExpiresAt = "xpress", claims.IssuedAt, claims.IssuedAt+60
+	if *claims != *want {
+		t.Errorf("VerifyJWT = %+v, want %+v", claims, want)
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	_, privs := jwtKeyDir(t, "k1")
+	priv := privs["k1"]
+
+	valid := func() *Claims {
+		c := testClaims()
+		c.Issuer = "xpress"
+		c.IssuedAt = time.Now().Unix()
+		c.ExpiresAt = time.Now().Add(time.Minute).Unix()
+		return c
+	}
+	eddsa := func(data []byte) []byte { return ed25519.Sign(priv, data) }
+	header := map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": "k1"}
+	if _, err := VerifyJWT(rawJWT(header, valid(), eddsa)); err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"tampered payload", func() string {
+			token := rawJWT(header, valid(), eddsa)
+			c := valid()
+			c.Role = "POWERADMIN"
+			p, _ := json.Marshal(c)
+			parts := strings.Split(token, ".")
+			return parts[0] + "." + b64(p) + "." + parts[2]
+		}},
+		{"tampered signature", func() string {
+			return rawJWT(header, valid(), func(data []byte) []byte {
+				sig := eddsa(data)
+				sig[0] ^= 1
+				return sig
+			})
+		}},
+		{"no signature", func() string {
+			token := rawJWT(header, valid(), eddsa)
+			return token[:strings.LastIndex(token, ".")+1]
+		}},
+		{"alg none", func() string {
+			return rawJWT(map[string]string{"alg": "none", "typ": "JWT", "kid": "k1"}, valid(), func([]byte) []byte { return nil })
+		}},
+		{"alg none signed", func() string {
+			return rawJWT(map[string]string{"alg": "none", "typ": "JWT", "kid": "k1"}, valid(), eddsa)
+		}},
+		{"alg HS256 with the public key as secret", func() string {
+			return rawJWT(map[string]string{"alg": "HS256", "typ": "JWT", "kid": "k1"}, valid(), hs256(priv.Public().(ed25519.PublicKey)))
+		}},
+		{"alg HS256 with the public key in PEM as secret", func() string {
+			der, _ := x509.MarshalPKIXPublicKey(priv.Public())
+			secret := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+			return rawJWT(map[string]string{"alg": "HS256", "typ": "JWT", "kid": "k1"}, valid(), hs256(secret))
+		}},
+		{"unknown kid", func() string {
+			return rawJWT(map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": "k2"}, valid(), eddsa)
+		}},
+		{"no kid", func() string {
+			return rawJWT(map[string]string{"alg": "EdDSA", "typ": "JWT"}, valid(), eddsa)
+		}},
+		{"wrong issuer", func() string {
+			c := valid()
+			c.Issuer = "other"
+			return rawJWT(header, c, eddsa)
+		}},
+		{"not a JWT", func() string {
+			return "abc.def"
+		}},
+		{"bad base64", func() string {
+			token := rawJWT(header, valid(), eddsa)
+			return token + "!"
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := VerifyJWT(tt.token()); err != ErrTokenInvalid {
+			t.Errorf("%s: VerifyJWT error %v, want %v", tt.name, err, ErrTokenInvalid)
+		}
+	}
+}
+
+func TestVerifyJWTExpired(t *testing.T) {
+	jwtKeyDir(t, "k1")
+
+	token, err := SignJWT(testClaims(), -time.Second)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+	claims, err := VerifyJWT(token)
+	if err != ErrTokenExpired {
+		t.Fatalf("VerifyJWT error %v, want %v", err, ErrTokenExpired)
+	}
+	// Claims still returned, to tell whose token expired
+	if claims == nil || claims.SessionID != "s1" {
+		t.Errorf("VerifyJWT claims %+v", claims)
+	}
+}
+
+// A retired key still verifies the tokens it signed until it is removed
+func TestJWTKeyRotation(t *testing.T) {
+	dir, _ := jwtKeyDir(t, "old")
+
+	old, err := SignJWT(testClaims(), time.Minute)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	writeFile(t, filepath.Join(dir, "new.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	JWT_KID = "new"
+	if err := LoadJWTKeys(dir); err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+
+	current, err := SignJWT(testClaims(), time.Minute)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+	var header jwtHeader
+	decodeSegment(current[:strings.Index(current, ".")], &header)
+	if header.Kid != "new" || header.Alg != JWT_ALG_EDDSA {
+		t.Errorf("new token header %+v", header)
+	}
+	for name, token := range map[string]string{"old": old, "new": current} {
+		if _, err := VerifyJWT(token); err != nil {
+			t.Errorf("%s token after rotation: %v", name, err)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "old.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadJWTKeys(dir); err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+	if _, err := VerifyJWT(old); err != ErrTokenInvalid {
+		t.Errorf("token of the removed key: error %v, want %v", err, ErrTokenInvalid)
+	}
+	if _, err := VerifyJWT(current); err != nil {
+		t.Errorf("new token after removing old key: %v", err)
+	}
+}
+
+// An HS256 secret must not verify EdDSA tokens, nor an Ed25519 key HS256 ones
+func TestJWTKeyConfusion(t *testing.T) {
+	dir, privs := jwtKeyDir(t, "ed")
+	secret := []byte(strings.Repeat("s", 32))
+	writeFile(t, filepath.Join(dir, "hs.secret"), secret)
+	if err := LoadJWTKeys(dir); err != nil {
+		t.Fatalf("LoadJWTKeys: %v", err)
+	}
+
+	c := testClaims()
+	c.Issuer = "xpress"
+	c.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	eddsa := func(data []byte) []byte { return ed25519.Sign(privs["ed"], data) }
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256 kid hs", rawJWT(map[string]string{"alg": "HS256", "kid": "hs"}, c, hs256(secret)), true},
+		{"EdDSA kid ed", rawJWT(map[string]string{"alg": "EdDSA", "kid": "ed"}, c, eddsa), true},
+		{"EdDSA kid hs", rawJWT(map[string]string{"alg": "EdDSA", "kid": "hs"}, c, eddsa), false},
+		{"HS256 kid ed", rawJWT(map[string]string{"alg": "HS256", "kid": "ed"}, c, hs256(secret)), false},
+		{"HS256 kid ed, public key as secret", rawJWT(map[string]string{"alg": "HS256", "kid": "ed"}, c, hs256(privs["ed"].Public().(ed25519.PublicKey))), false},
+		{"EdDSA kid hs, HMAC signature", rawJWT(map[string]string{"alg": "EdDSA", "kid": "hs"}, c, hs256(secret)), false},
+	}
+	for _, tt := range tests {
+		_, err := VerifyJWT(tt.token)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: VerifyJWT error %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	jwtKeyDir(t, "k1")
+
+	short := t.TempDir()
+	writeFile(t, filepath.Join(short, "hs.secret"), []byte("too short"))
+	if err := LoadJWTKeys(short); err == nil {
+		t.Errorf("LoadJWTKeys with a short HS256 secret: want error")
+	}
+
+	// Two keys that can sign and no JWT_KID
+	dir, _ := jwtKeyDir(t, "a", "b")
+	JWT_KID = ""
+	if err := LoadJWTKeys(dir); err == nil {
+		t.Errorf("LoadJWTKeys with two signing keys and no JWT_KID: want error")
+	}
+
+	JWT_KID = "missing"
+	if err := LoadJWTKeys(dir); err == nil {
+		t.Errorf("LoadJWTKeys with JWT_KID not in the directory: want error")
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAP simple bind and search with go-ldap, over ldap:// with optional
+// StartTLS, or ldaps://. Enough to authenticate users and to read users and
+// groups for the sync
+
+var ErrLDAPInvalidCredentials = errors.New("invalid LDAP credentials")
+
+var LDAP_TIMEOUT = 10 * time.Second
+
+// Entries per page of a search (RFC 2696). Active Directory returns at most
+// MaxPageSize, 1000 by default, entries of an unpaged search
+var LDAP_PAGE_SIZE = 1000
+
+// LDAPDial connects to ldap://host[:389] or ldaps://host[:636]
+func LDAPDial(rawurl string, startTLS bool) (*ldap.Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("ldap: unsupported URL %s", rawurl)
+	}
+
+	config := &tls.Config{ServerName: u.Hostname()}
+	c, err := ldap.DialURL(rawurl,
+		ldap.DialWithDialer(&net.Dialer{Timeout: LDAP_TIMEOUT}),
+		ldap.DialWithTLSConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	c.SetTimeout(LDAP_TIMEOUT)
+
+	if startTLS && u.Scheme == "ldap" {
+		if err = c.StartTLS(config); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Simple bind as dn, a wrong password is ErrLDAPInvalidCredentials. An
+// empty password would be an unauthenticated bind, which servers accept
+// without checking anything - go-ldap refuses it
+func ldapBind(c *ldap.Conn, dn string, password string) error {
+	err := c.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) || ldap.IsErrorWithCode(err, ldap.ErrorEmptyPassword) {
+		return ErrLDAPInvalidCredentials
+	}
+	return err
+}
+
+// Search the subtree of base for filter (RFC 4515), e.g.
+// (&(objectClass=person)(uid=bob)). sizeLimit 0 reads all entries in pages
+// of LDAP_PAGE_SIZE, from servers without paging all at once
+func ldapSearch(c *ldap.Conn, base string, filter string, attrs []string, sizeLimit int) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		sizeLimit, int(LDAP_TIMEOUT/time.Second), false, filter, attrs, nil)
+
+	var res *ldap.SearchResult
+	var err error
+	if sizeLimit > 0 {
+		res, err = c.Search(req)
+	} else {
+		res, err = c.SearchWithPaging(req, uint32(LDAP_PAGE_SIZE))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.Entries, nil
+}
@@ -0,0 +1,390 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// In-process LDAP server over a small directory. Like Active Directory it
+// returns at most maxPageSize entries of a search, more only page by page
+type fakeLDAP struct {
+	listener    net.Listener
+	entries     []*ldap.Entry
+	passwords   map[string]string
+	maxPageSize int
+
+	mutex sync.Mutex
+	pages []int
+	binds []string
+}
+
+func newFakeLDAP(t *testing.T, entries []*ldap.Entry, passwords map[string]string) *fakeLDAP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeLDAP{listener: l, entries: entries, passwords: passwords, maxPageSize: 1000}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeLDAP) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func ldapMessage(id int64, op *ber.Packet, controls ...ldap.Control) []byte {
+	msg := ber.NewSequence("LDAP Message")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	msg.AppendChild(op)
+	if len(controls) > 0 {
+		list := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, c := range controls {
+			list.AppendChild(c.Encode())
+		}
+		msg.AppendChild(list)
+	}
+	return msg.Bytes()
+}
+
+func ldapResultOp(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return op
+}
+
+func (s *fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id := msg.Children[0].Value.(int64)
+		op := msg.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Value.(string), op.Children[2].Data.String()
+			s.mutex.Lock()
+			s.binds = append(s.binds, dn)
+			s.mutex.Unlock()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if p, ok := s.passwords[dn]; ok && p == password {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapMessage(id, ldapResultOp(ldap.ApplicationBindResponse, code)))
+		case ldap.ApplicationSearchRequest:
+			var controls []*ber.Packet
+			if len(msg.Children) > 2 {
+				controls = msg.Children[2].Children
+			}
+			s.search(conn, id, op, controls)
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAP) search(conn net.Conn, id int64, op *ber.Packet, controls []*ber.Packet) {
+	base := strings.ToLower(op.Children[0].Value.(string))
+	sizeLimit := int(op.Children[3].Value.(int64))
+	var matches []*ldap.Entry
+	for _, e := range s.entries {
+		if strings.HasSuffix(strings.ToLower(e.DN), base) && ldapMatch(op.Children[6], e) {
+			matches = append(matches, e)
+		}
+	}
+
+	// Paged results control, the cookie is the offset of the page
+	size, offset, paged := s.maxPageSize, 0, false
+	for _, packet := range controls {
+		c, err := ldap.DecodeControl(packet)
+		if err != nil {
+			continue
+		}
+		if paging, ok := c.(*ldap.ControlPaging); ok {
+			paged = true
+			if n := int(paging.PagingSize); n < size {
+				size = n
+			}
+			fmt.Sscan(string(paging.Cookie), &offset)
+		}
+	}
+
+	limited := sizeLimit > 0 && len(matches) > sizeLimit
+	if limited {
+		matches = matches[:sizeLimit]
+	}
+	code := uint16(ldap.LDAPResultSuccess)
+	end := offset + size
+	if end >= len(matches) {
+		end = len(matches)
+		if limited {
+			code = ldap.LDAPResultSizeLimitExceeded
+		}
+	} else if !paged {
+		code = ldap.LDAPResultSizeLimitExceeded
+	}
+	s.mutex.Lock()
+	s.pages = append(s.pages, end-offset)
+	s.mutex.Unlock()
+
+	for _, e := range matches[offset:end] {
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+		attrs := ber.NewSequence("Attributes")
+		for _, a := range e.Attributes {
+			attr := ber.NewSequence("Attribute")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "Type"))
+			vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+			for _, v := range a.Values {
+				vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+			}
+			attr.AppendChild(vals)
+			attrs.AppendChild(attr)
+		}
+		entry.AppendChild(attrs)
+		conn.Write(ldapMessage(id, entry))
+	}
+
+	done := ldapResultOp(ldap.ApplicationSearchResultDone, code)
+	if !paged {
+		conn.Write(ldapMessage(id, done))
+		return
+	}
+	next := ldap.NewControlPaging(0)
+	if end < len(matches) {
+		next.SetCookie([]byte(fmt.Sprint(end)))
+	}
+	conn.Write(ldapMessage(id, done, next))
+}
+
+// Filter evaluation, enough for the filters of the tests
+func ldapMatch(f *ber.Packet, e *ldap.Entry) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !ldapMatch(c, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if ldapMatch(c, e) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !ldapMatch(f.Children[0], e)
+	case ldap.FilterPresent:
+		return len(e.GetEqualFoldAttributeValues(f.Data.String())) > 0
+	case ldap.FilterEqualityMatch:
+		for _, v := range e.GetEqualFoldAttributeValues(f.Children[0].Value.(string)) {
+			if strings.EqualFold(v, f.Children[1].Value.(string)) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		for _, v := range e.GetEqualFoldAttributeValues(f.Children[0].Value.(string)) {
+			rest, ok := strings.ToLower(v), true
+			for _, sub := range f.Children[1].Children {
+				part := strings.ToLower(sub.Data.String())
+				i := strings.Index(rest, part)
+				if i < 0 || (sub.Tag == ldap.FilterSubstringsInitial && i != 0) ||
+					(sub.Tag == ldap.FilterSubstringsFinal && !strings.HasSuffix(rest, part)) {
+					ok = false
+					break
+				}
+				rest = rest[i+len(part):]
+			}
+			if ok {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+const (
+	testBaseDN    = "dc=example,dc=com"
+	testServiceDN = "cn=svc,ou=system,dc=example,dc=com"
+)
+
+// Directory of n users user0..., with group staff of all of them by DN and
+// group ops of user1 and user2 by name
+func testDirectory(n int) ([]*ldap.Entry, map[string]string) {
+	passwords := map[string]string{testServiceDN: "svc-secret"}
+	entries := []*ldap.Entry{}
+	var members []string
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("user%d", i)
+		dn := "uid=" + name + ",ou=people," + testBaseDN
+		entries = append(entries, ldap.NewEntry(dn, map[string][]string{
+			"objectClass": {"person"}, "uid": {name},
+		}))
+		passwords[dn] = name + "-pass"
+		members = append(members, strings.ToUpper(dn))
+	}
+	entries = append(entries,
+		ldap.NewEntry("cn=staff,ou=groups,"+testBaseDN, map[string][]string{
+			"objectClass": {"groupOfNames"}, "cn": {"staff"}, "member": members,
+		}),
+		ldap.NewEntry("cn=ops,ou=groups,"+testBaseDN, map[string][]string{
+			"objectClass": {"posixGroup"}, "cn": {"ops"}, "member": {"user1", "user2", "nobody"},
+		}))
+	return entries, passwords
+}
+
+func testLDAPConfig(s *fakeLDAP) *model.LDAPConfig {
+	return &model.LDAPConfig{
+		URL:          s.url(),
+		BindDN:       testServiceDN,
+		BindPassword: "svc-secret",
+		BaseDN:       testBaseDN,
+		UserFilter:   "(&(objectClass=person)(uid=%s))",
+		UserAttr:     "uid",
+		GroupBaseDN:  "ou=groups," + testBaseDN,
+		GroupFilter:  "(|(objectClass=groupOfNames)(objectClass=posixGroup))",
+		GroupAttr:    "cn",
+		MemberAttr:   "member",
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	entries, passwords := testDirectory(3)
+	s := newFakeLDAP(t, entries, passwords)
+	cfg := testLDAPConfig(s)
+
+	tests := []struct {
+		name, password string
+		want           error
+	}{
+		{"user1", "user1-pass", nil},
+		{"user1", "wrong", ErrLDAPInvalidCredentials},
+		{"user1", "", ErrLDAPInvalidCredentials},
+		{"nobody", "x", ErrLDAPInvalidCredentials},
+		// Escaped, not a wildcard matching all users
+		{"*", "user1-pass", ErrLDAPInvalidCredentials},
+		{"user*", "user1-pass", ErrLDAPInvalidCredentials},
+		{"user1)(uid=*", "user1-pass", ErrLDAPInvalidCredentials},
+	}
+	for _, tt := range tests {
+		if err := LDAPAuthenticate(cfg, tt.name, tt.password); err != tt.want {
+			t.Errorf("LDAPAuthenticate(%s, %s) = %v, want %v", tt.name, tt.password, err, tt.want)
+		}
+	}
+
+	// Only the service account and user1 with its password ever bind
+	for _, dn := range s.binds {
+		if dn != testServiceDN && dn != "uid=user1,ou=people,"+testBaseDN {
+			t.Errorf("bind as %s", dn)
+		}
+	}
+
+	// A filter matching several users is ambiguous, no bind as any of them
+	wide := *cfg
+	wide.UserFilter = "(objectClass=person)"
+	if err := LDAPAuthenticate(&wide, "user1", "user1-pass"); err != ErrLDAPInvalidCredentials {
+		t.Errorf("LDAPAuthenticate with an ambiguous filter = %v, want %v", err, ErrLDAPInvalidCredentials)
+	}
+
+	// A wrong service password is a configuration error, not a wrong user
+	// password
+	cfg.BindPassword = "wrong"
+	if err := LDAPAuthenticate(cfg, "user1", "user1-pass"); err == nil || err == ErrLDAPInvalidCredentials {
+		t.Errorf("LDAPAuthenticate with a wrong service password = %v", err)
+	}
+}
+
+func TestLDAPReadPaged(t *testing.T) {
+	entries, passwords := testDirectory(2500)
+	s := newFakeLDAP(t, entries, passwords)
+
+	dir, err := LDAPRead(testLDAPConfig(s))
+	if err != nil {
+		t.Fatalf("LDAPRead: %v", err)
+	}
+	if len(dir.Users) != 2500 {
+		t.Errorf("%d users, want 2500", len(dir.Users))
+	}
+	if dir.Users["uid=user2499,ou=people,dc=example,dc=com"] != "user2499" {
+		t.Errorf("user2499 missing")
+	}
+	if len(dir.Groups["staff"]) != 2500 {
+		t.Errorf("staff has %d members, want 2500", len(dir.Groups["staff"]))
+	}
+	if got := strings.Join(dir.Groups["ops"], ","); got != "user1,user2" {
+		t.Errorf("ops members %s, want user1,user2", got)
+	}
+
+	// 3 pages of users, 1 of groups
+	want := []int{1000, 1000, 500, 2}
+	if fmt.Sprint(s.pages) != fmt.Sprint(want) {
+		t.Errorf("pages %v, want %v", s.pages, want)
+	}
+}
+
+func TestLDAPSearch(t *testing.T) {
+	entries, passwords := testDirectory(1500)
+	s := newFakeLDAP(t, entries, passwords)
+	c, err := ldapOpen(testLDAPConfig(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Pages smaller than the server maximum
+	LDAP_PAGE_SIZE = 400
+	defer func() { LDAP_PAGE_SIZE = 1000 }()
+	users, err := ldapSearch(c, testBaseDN, "(&(objectClass=person)(uid=user1*))", []string{"uid"}, 0)
+	if err != nil {
+		t.Fatalf("ldapSearch: %v", err)
+	}
+	// user1, user10-19, user100-199, user1000-1499
+	if len(users) != 1+10+100+500 {
+		t.Errorf("%d users, want 611", len(users))
+	}
+	if fmt.Sprint(s.pages) != "[400 211]" {
+		t.Errorf("pages %v, want [400 211]", s.pages)
+	}
+
+	// Size limit of the whole search
+	_, err = ldapSearch(c, testBaseDN, "(objectClass=person)", []string{"uid"}, 500)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		t.Errorf("ldapSearch with size limit = %v", err)
+	}
+
+	if _, err = ldapSearch(c, testBaseDN, "(uid=user1", nil, 0); err == nil {
+		t.Errorf("ldapSearch with a bad filter: want error")
+	}
+}
+
+func TestLDAPDial(t *testing.T) {
+	for _, url := range []string{"http://ldap.example.com", "ldapi:///run/slapd", "::"} {
+		if c, err := LDAPDial(url, false); err == nil {
+			c.Close()
+			t.Errorf("LDAPDial(%s): want error", url)
+		}
+	}
+}
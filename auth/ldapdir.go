@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Users and groups of a domain directory, as configured in model.LDAPConfig.
+// UserFilter holds %s for the escaped user name, e.g.
+// (&(objectClass=person)(uid=%s)) or (&(objectClass=user)(sAMAccountName=%s))
+
+// Connect and bind with the service account of the domain
+func ldapOpen(cfg *model.LDAPConfig) (*ldap.Conn, error) {
+	c, err := LDAPDial(cfg.URL, cfg.StartTLS)
+	if err != nil {
+		return nil, err
+	}
+	if err = ldapBind(c, cfg.BindDN, cfg.BindPassword); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("ldap: service bind %s: %v", cfg.BindDN, err)
+	}
+	return c, nil
+}
+
+func ldapUserFilter(cfg *model.LDAPConfig, value string) string {
+	return strings.Replace(cfg.UserFilter, "%s", value, -1)
+}
+
+// LDAPAuthenticate looks name up with the service account, then binds as
+// the entry found with password
+func LDAPAuthenticate(cfg *model.LDAPConfig, name string, password string) error {
+	if password == "" {
+		return ErrLDAPInvalidCredentials
+	}
+
+	c, err := ldapOpen(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	entries, err := ldapSearch(c, cfg.BaseDN, ldapUserFilter(cfg, ldap.EscapeFilter(name)), []string{cfg.UserAttr}, 2)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return ErrLDAPInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 {
+		// Unknown, or ambiguous
+		return ErrLDAPInvalidCredentials
+	}
+	return ldapBind(c, entries[0].DN, password)
+}
+
+// LDAPDirectory is a snapshot of the users and groups of a directory
+type LDAPDirectory struct {
+	// DN (lowercased) to user name
+	Users map[string]string
+	// Group name to user names of its members
+	Groups map[string][]string
+}
+
+// LDAPRead reads all users and groups
+func LDAPRead(cfg *model.LDAPConfig) (*LDAPDirectory, error) {
+	c, err := ldapOpen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	dir := &LDAPDirectory{Users: map[string]string{}, Groups: map[string][]string{}}
+
+	users, err := ldapSearch(c, cfg.BaseDN, ldapUserFilter(cfg, "*"), []string{cfg.UserAttr}, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, e := range users {
+		if name := e.GetEqualFoldAttributeValue(cfg.UserAttr); name != "" {
+			dir.Users[strings.ToLower(e.DN)] = name
+			names[name] = true
+		}
+	}
+
+	if cfg.GroupFilter == "" {
+		return dir, nil
+	}
+	base := cfg.GroupBaseDN
+	if base == "" {
+		base = cfg.BaseDN
+	}
+	groups, err := ldapSearch(c, base, cfg.GroupFilter, []string{cfg.GroupAttr, cfg.MemberAttr}, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		name := g.GetEqualFoldAttributeValue(cfg.GroupAttr)
+		if name == "" {
+			continue
+		}
+		members := []string{}
+		for _, m := range g.GetEqualFoldAttributeValues(cfg.MemberAttr) {
+			// member holds DNs, memberUid (posixGroup) holds names
+			if user, ok := dir.Users[strings.ToLower(m)]; ok {
+				members = append(members, user)
+			} else if names[m] {
+				members = append(members, m)
+			}
+		}
+		dir.Groups[name] = members
+	}
+	return dir, nil
+}
@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect authorization code flow with PKCE (RFC 7636). The ID token
+// returned by the provider is verified against its published JWKS
+
+var ErrOIDCDenied = errors.New("identity provider login not allowed")
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type OIDCProvider struct {
+	Config OIDCConfig
+	Client *http.Client
+
+	mutex      sync.Mutex
+	discovery  *oidcDiscovery
+	keys       map[string]crypto.PublicKey
+	keysLoaded time.Time
+}
+
+// Provider configured from OIDC_* settings, nil if OIDC is off
+var OIDC *OIDCProvider
+
+func OIDCEnabled() bool {
+	return OIDC != nil
+}
+
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{Config: cfg, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// NewOIDCState returns random state and nonce for a login
+func NewOIDCState() (state string, nonce string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	state = base64.RawURLEncoding.EncodeToString(b[:16])
+	nonce = base64.RawURLEncoding.EncodeToString(b[16:])
+	return state, nonce, nil
+}
+
+// NewPKCE returns a code verifier and its S256 challenge
+func NewPKCE() (verifier string, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (p *OIDCProvider) getJSON(uri string, v interface{}) error {
+	resp, err := p.Client.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", uri, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Provider metadata, fetched once
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	d := &oidcDiscovery{}
+	err := p.getJSON(strings.TrimSuffix(p.Config.Issuer, "/")+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: %s", d.Issuer)
+	}
+	p.discovery = d
+	return d, nil
+}
+
+// AuthURL is where the browser is sent to login at the provider
+func (p *OIDCProvider) AuthURL(state string, nonce string, challenge string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.Config.ClientID)
+	q.Set("redirect_uri", p.Config.RedirectURL)
+	q.Set("scope", strings.Join(p.Config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange the authorization code for tokens, returns the claims of the
+// verified ID token
+func (p *OIDCProvider) Exchange(code string, verifier string, nonce string) (map[string]interface{}, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("client_id", p.Config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("token endpoint: %s %v", resp.Status, err)
+	}
+	if tok.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", tok.Error, tok.ErrorDescription)
+	}
+	if tok.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint: no id_token")
+	}
+	return p.VerifyIDToken(tok.IDToken, nonce)
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(raw string, nonce string) (map[string]interface{}, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if !verifyOIDCSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrTokenInvalid
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, fmt.Errorf("id_token: wrong issuer %s", iss)
+	}
+	if !ClaimHas(claims, "aud", p.Config.ClientID) {
+		return nil, fmt.Errorf("id_token: wrong audience")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Unix() >= int64(exp) {
+		return nil, ErrTokenExpired
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("id_token: wrong nonce")
+	}
+	return claims, nil
+}
+
+// Signing key kid of the provider. The JWKS is reloaded for an unknown
+// kid, the provider may have rotated its keys, at most once a minute
+func (p *OIDCProvider) key(kid string) (crypto.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysLoaded) < time.Minute {
+		return nil, ErrTokenInvalid
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(p.discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	p.keys = keys
+	p.keysLoaded = time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrTokenInvalid
+}
+
+func verifyOIDCSignature(alg string, key crypto.PublicKey, data []byte, sig []byte) bool {
+	sum := sha256.Sum256(data)
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	}
+	// none, HS256 with the client secret, ... are not accepted
+	return false
+}
+
+// ClaimHas reports whether claim, a string or a list of strings, holds value
+func ClaimHas(claims map[string]interface{}, claim string, value string) bool {
+	for _, v := range ClaimStrings(claims, claim) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimStrings returns claim as a list of strings
+func ClaimStrings(claims map[string]interface{}, claim string) []string {
+	switch v := claims[claim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// Local identity an ID token maps to. Issuer and Subject identify the
+// account at the identity provider, Name is only used to create a new one
+type OIDCIdentity struct {
+	Issuer  string
+	Subject string
+	Name    string
+	Domain  string
+	Admin   bool
+	User    bool
+}
+
+// MapOIDCClaims applies the OIDC_*_CLAIM settings. The name is the username
+// claim up to any @, the domain comes from the domain claim (the part after
+// @ for an email, which must have email_verified) and must be listed in
+// OIDC_DOMAIN_MAP. Values of the role claim listed in OIDC_ADMIN_VALUES
+// allow admin login, those in OIDC_USER_VALUES user login - any identity
+// may login as user if OIDC_USER_VALUES is empty
+func MapOIDCClaims(claims map[string]interface{}) (*OIDCIdentity, error) {
+	id := &OIDCIdentity{}
+
+	id.Issuer, _ = claims["iss"].(string)
+	id.Subject, _ = claims["sub"].(string)
+	if id.Issuer == "" || id.Subject == "" {
+		return nil, fmt.Errorf("id_token: no iss or sub claim")
+	}
+
+	names := ClaimStrings(claims, OIDC_USERNAME_CLAIM)
+	if len(names) == 0 || names[0] == "" {
+		return nil, fmt.Errorf("id_token: no %s claim", OIDC_USERNAME_CLAIM)
+	}
+	id.Name = strings.Split(names[0], "@")[0]
+
+	values := ClaimStrings(claims, OIDC_DOMAIN_CLAIM)
+	if len(values) == 0 || values[0] == "" {
+		return nil, fmt.Errorf("id_token: no %s claim", OIDC_DOMAIN_CLAIM)
+	}
+	value := values[0]
+	if i := strings.LastIndex(value, "@"); i >= 0 {
+		// Anyone can claim an address the provider did not verify
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return nil, fmt.Errorf("id_token: %s %s not verified", OIDC_DOMAIN_CLAIM, value)
+		}
+		value = value[i+1:]
+	}
+	id.Domain = OIDC_DOMAIN_MAP[strings.ToLower(value)]
+	if id.Domain == "" {
+		return nil, fmt.Errorf("id_token: %s not in OIDC_DOMAIN_MAP", value)
+	}
+
+	id.User = len(OIDC_USER_VALUES) == 0
+	for _, v := range ClaimStrings(claims, OIDC_ROLE_CLAIM) {
+		for _, admin := range OIDC_ADMIN_VALUES {
+			if v == admin {
+				id.Admin = true
+			}
+		}
+		for _, user := range OIDC_USER_VALUES {
+			if v == user {
+				id.User = true
+			}
+		}
+	}
+	return id, nil
+}
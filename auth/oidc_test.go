@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake identity provider: discovery, JWKS and a token endpoint checking
+// the PKCE verifier of the codes it handed out
+type fakeOIDC struct {
+	*httptest.Server
+	key *ecdsa.PrivateKey
+
+	mutex sync.Mutex
+	codes map[string]fakeCode
+	next  int
+}
+
+type fakeCode struct {
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeOIDC{key: key, codes: map[string]fakeCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "crv": "P-256", "kid": "k1", "use": "sig",
+			"x": b64(f.key.X.FillBytes(make([]byte, 32))),
+			"y": b64(f.key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("/token", f.token)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// The browser at the authorization endpoint, the user logs in as claims
+func (f *fakeOIDC) authorize(t *testing.T, authURL string, claims map[string]interface{}) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "client" {
+		t.Fatalf("authorization request %s", authURL)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.next++
+	code := fmt.Sprintf("code%d", f.next)
+	f.codes[code] = fakeCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	return code
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.mutex.Lock()
+	c, ok := f.codes[r.Form.Get("code")]
+	delete(f.codes, r.Form.Get("code"))
+	f.mutex.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	id, secret, _ := r.BasicAuth()
+	switch {
+	case !ok:
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "unknown code"})
+	case b64(sum[:]) != c.challenge:
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+	case id != "client" || secret != "secret" || r.Form.Get("redirect_uri") != "https://vpn.example.com/api/v1/oidc/callback":
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+	default:
+		claims := map[string]interface{}{
+			"iss":   f.URL,
+			"aud":   "client",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": c.nonce,
+		}
+		for k, v := range c.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": f.sign("ES256", "k1", claims)})
+	}
+}
+
+func (f *fakeOIDC) sign(alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	data := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(data))
+	r, s, _ := ecdsa.Sign(rand.Reader, f.key, sum[:])
+	return data + "." + b64(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+}
+
+func (f *fakeOIDC) provider() *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Issuer:       f.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://vpn.example.com/api/v1/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+	})
+}
+
+// One login up to the claims of the ID token
+func oidcLogin(t *testing.T, f *fakeOIDC, p *OIDCProvider, claims map[string]interface{}) (map[string]interface{}, error) {
+	state, nonce, err := NewOIDCState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthURL(state, nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Query().Get("state") != state {
+		t.Fatalf("state %s, want %s", u.Query().Get("state"), state)
+	}
+	return p.Exchange(f.authorize(t, authURL, claims), verifier, nonce)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := f.provider()
+
+	OIDC_USERNAME_CLAIM, OIDC_DOMAIN_CLAIM, OIDC_ROLE_CLAIM = "preferred_username", "email", "groups"
+	OIDC_DOMAIN_MAP = map[string]string{"example.com": "acme"}
+	OIDC_ADMIN_VALUES, OIDC_USER_VALUES = []string{"vpn-admins"}, nil
+	defer func() {
+		OIDC_DOMAIN_MAP, OIDC_ADMIN_VALUES = map[string]string{}, nil
+	}()
+
+	claims, err := oidcLogin(t, f, p, map[string]interface{}{
+		"sub":                "248289761001",
+		"preferred_username": "alice",
+		"email":              "alice@Example.com",
+		"email_verified":     true,
+		"groups":             []string{"staff", "vpn-admins"},
+	})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	id, err := MapOIDCClaims(claims)
+	if err != nil {
+		t.Fatalf("MapOIDCClaims: %v", err)
+	}
+	if id.Issuer != f.URL || id.Subject != "248289761001" || id.Name != "alice" || id.Domain != "acme" || !id.Admin || !id.User {
+		t.Errorf("identity %+v", id)
+	}
+}
+
+func TestMapOIDCClaims(t *testing.T) {
+	OIDC_USERNAME_CLAIM, OIDC_DOMAIN_CLAIM, OIDC_ROLE_CLAIM = "preferred_username", "email", "groups"
+	OIDC_DOMAIN_MAP = map[string]string{"example.com": "acme"}
+	defer func() { OIDC_DOMAIN_MAP = map[string]string{} }()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                "https://idp.example.com",
+			"sub":                "1",
+			"preferred_username": "bob@other.com",
+			"email":              "bob@example.com",
+			"email_verified":     true,
+		}
+	}
+	if id, err := MapOIDCClaims(valid()); err != nil || id.Name != "bob" || id.Domain != "acme" || !id.User || id.Admin {
+		t.Fatalf("MapOIDCClaims = %+v, %v", id, err)
+	}
+
+	tests := []struct {
+		name  string
+		claim func(map[string]interface{})
+	}{
+		{"unverified email", func(c map[string]interface{}) { c["email_verified"] = false }},
+		{"no email_verified", func(c map[string]interface{}) { delete(c, "email_verified") }},
+		{"email_verified string", func(c map[string]interface{}) { c["email_verified"] = "true" }},
+		{"unmapped domain", func(c map[string]interface{}) { c["email"] = "bob@evil.com" }},
+		{"subdomain", func(c map[string]interface{}) { c["email"] = "bob@corp.example.com" }},
+		{"no email", func(c map[string]interface{}) { delete(c, "email") }},
+		{"no sub", func(c map[string]interface{}) { delete(c, "sub") }},
+		{"no iss", func(c map[string]interface{}) { delete(c, "iss") }},
+		{"no username", func(c map[string]interface{}) { delete(c, "preferred_username") }},
+	}
+	for _, tt := range tests {
+		c := valid()
+		tt.claim(c)
+		if id, err := MapOIDCClaims(c); err == nil {
+			t.Errorf("%s: MapOIDCClaims = %+v, want error", tt.name, id)
+		}
+	}
+
+	// Without a map no domain is trusted
+	OIDC_DOMAIN_MAP = map[string]string{}
+	if id, err := MapOIDCClaims(valid()); err == nil {
+		t.Errorf("empty OIDC_DOMAIN_MAP: MapOIDCClaims = %+v, want error", id)
+	}
+
+	// A domain claim that is not an email needs no email_verified
+	OIDC_DOMAIN_CLAIM = "tenant"
+	OIDC_DOMAIN_MAP = map[string]string{"t1": "acme"}
+	defer func() { OIDC_DOMAIN_CLAIM = "email" }()
+	c := valid()
+	delete(c, "email_verified")
+	c["tenant"] = "T1"
+	if id, err := MapOIDCClaims(c); err != nil || id.Domain != "acme" {
+		t.Errorf("tenant claim: MapOIDCClaims = %+v, %v", id, err)
+	}
+}
+
+func TestOIDCState(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		state, nonce, err := NewOIDCState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(state) < 22 || len(nonce) < 22 || seen[state] || seen[nonce] || state == nonce {
+			t.Fatalf("state %s nonce %s not random", state, nonce)
+		}
+		seen[state], seen[nonce] = true, true
+	}
+}
+
+func TestOIDCPKCE(t *testing.T) {
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 7636 - 43 to 128 characters, S256 of the verifier
+	sum := sha256.Sum256([]byte(verifier))
+	if len(verifier) < 43 || len(verifier) > 128 || challenge != b64(sum[:]) {
+		t.Errorf("verifier %s challenge %s", verifier, challenge)
+	}
+
+	f := newFakeOIDC(t)
+	p := f.provider()
+	state, nonce, _ := NewOIDCState()
+	authURL, err := p.AuthURL(state, nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := f.authorize(t, authURL, map[string]interface{}{"sub": "1"})
+
+	other, _, _ := NewPKCE()
+	if _, err := p.Exchange(code, other, nonce); err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Errorf("Exchange with the wrong verifier: %v, want PKCE error", err)
+	}
+
+	// Codes are single use
+	code = f.authorize(t, authURL, map[string]interface{}{"sub": "1"})
+	if _, err := p.Exchange(code, verifier, nonce); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Exchange(code, verifier, nonce); err == nil {
+		t.Errorf("Exchange of a used code: want error")
+	}
+}
+
+func TestOIDCNonce(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := f.provider()
+
+	state, nonce, _ := NewOIDCState()
+	verifier, challenge, _ := NewPKCE()
+	authURL, err := p.AuthURL(state, nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := f.authorize(t, authURL, map[string]interface{}{"sub": "1"})
+
+	// The ID token of another login
+	_, other, _ := NewOIDCState()
+	if _, err := p.Exchange(code, verifier, other); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Exchange with another nonce: %v, want nonce error", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := f.provider()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"iss": f.URL, "aud": "client", "exp": time.Now().Add(time.Minute).Unix(), "nonce": "n"}
+	}
+	if _, err := p.VerifyIDToken(f.sign("ES256", "k1", valid()), "n"); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"wrong issuer", func() string {
+			c := valid()
+			c["iss"] = "https://evil.example.com"
+			return f.sign("ES256", "k1", c)
+		}},
+		{"wrong audience", func() string {
+			c := valid()
+			c["aud"] = []string{"other"}
+			return f.sign("ES256", "k1", c)
+		}},
+		{"expired", func() string {
+			c := valid()
+			c["exp"] = time.Now().Add(-time.Second).Unix()
+			return f.sign("ES256", "k1", c)
+		}},
+		{"no nonce", func() string {
+			c := valid()
+			delete(c, "nonce")
+			return f.sign("ES256", "k1", c)
+		}},
+		{"unknown kid", func() string {
+			return f.sign("ES256", "k2", valid())
+		}},
+		{"alg none", func() string {
+			token := f.sign("ES256", "k1", valid())
+			header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "k1"})
+			return b64(header) + token[strings.Index(token, "."):strings.LastIndex(token, ".")+1]
+		}},
+		{"HS256 with the client secret", func() string {
+			header, _ := json.Marshal(map[string]string{"alg": "HS256", "kid": "k1"})
+			payload, _ := json.Marshal(valid())
+			data := b64(header) + "." + b64(payload)
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(data))
+			return data + "." + b64(mac.Sum(nil))
+		}},
+		{"bad signature", func() string {
+			token := f.sign("ES256", "k1", valid())
+			sig, _ := base64.RawURLEncoding.DecodeString(token[strings.LastIndex(token, ".")+1:])
+			r := new(big.Int).SetBytes(sig[:32])
+			r.Add(r, big.NewInt(1))
+			return token[:strings.LastIndex(token, ".")+1] + b64(append(r.FillBytes(make([]byte, 32)), sig[32:]...))
+		}},
+		{"not a JWT", func() string {
+			return "abc.def"
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := p.VerifyIDToken(tt.token(), "n"); err == nil {
+			t.Errorf("%s: want error", tt.name)
+		}
+	}
+}
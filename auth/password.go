@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HASH_BCRYPT   = "bcrypt"
+	HASH_ARGON2ID = "argon2id"
+
+	ARGON2_SALT_LEN = 16
+	ARGON2_KEY_LEN  = 32
+)
+
+// PasswordHasher hashes passwords into a self describing string, i.e. the
+// stored value carries the algorithm and its parameters
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// Owns reports whether encoded was produced by this hasher
+	Owns(encoded string) bool
+	// Verify checks password against encoded
+	Verify(encoded string, password string) bool
+	// NeedsRehash reports whether encoded uses parameters other than the current ones
+	NeedsRehash(encoded string) bool
+}
+
+var hashers = map[string]PasswordHasher{
+	HASH_BCRYPT:   &bcryptHasher{},
+	HASH_ARGON2ID: &argon2idHasher{},
+}
+
+// RegisterHasher adds (or replaces) the hasher known as name
+func RegisterHasher(name string, h PasswordHasher) {
+	hashers[name] = h
+}
+
+// Hasher returns the hasher registered as name, nil if none
+func Hasher(name string) PasswordHasher {
+	return hashers[name]
+}
+
+// HashPassword hashes password with the configured PASSWORD_HASH algorithm.
+// Empty password is stored as is, and never matches in CheckPassword
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	return hashers[PASSWORD_HASH].Hash(password)
+}
+
+// CheckPassword verifies password against the stored value. rehash is true
+// when the password matched but the stored value should be replaced by
+// HashPassword(password) - either it is legacy plaintext, or it was hashed
+// with another algorithm or other parameters than the current ones
+func CheckPassword(stored string, password string) (ok bool, rehash bool) {
+	if stored == "" || password == "" {
+		return false, false
+	}
+
+	for name, h := range hashers {
+		if !h.Owns(stored) {
+			continue
+		}
+		if !h.Verify(stored, password) {
+			return false, false
+		}
+		return true, name != PASSWORD_HASH || h.NeedsRehash(stored)
+	}
+
+	// Not hashed, must be a row written before hashing was introduced
+	ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	return ok, ok
+}
+
+// bcrypt - $2a$<cost>$<salt+hash>
+type bcryptHasher struct{}
+
+func (b *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), BCRYPT_COST)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *bcryptHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *bcryptHasher) Verify(encoded string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (b *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != BCRYPT_COST
+}
+
+// argon2id - $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+type argon2idHasher struct{}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (a *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, ARGON2_SALT_LEN)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := argon2Params{
+		memory:  uint32(ARGON2_MEMORY),
+		time:    uint32(ARGON2_TIME),
+		threads: uint8(ARGON2_THREADS),
+		salt:    salt,
+	}
+	p.key = argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, ARGON2_KEY_LEN)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(p.salt),
+		base64.RawStdEncoding.EncodeToString(p.key)), nil
+}
+
+func (a *argon2idHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a *argon2idHasher) Verify(encoded string, password string) bool {
+	p, err := decodeArgon2(encoded)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1
+}
+
+func (a *argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != uint32(ARGON2_MEMORY) ||
+		p.time != uint32(ARGON2_TIME) ||
+		p.threads != uint8(ARGON2_THREADS) ||
+		len(p.key) != ARGON2_KEY_LEN
+}
+
+func decodeArgon2(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("argon2id: bad format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("argon2id: unsupported version %d", version)
+	}
+
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, err
+	}
+
+	var err error
+	p.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, err
+	}
+	p.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheapest parameters, restored when the test ends
+func fastHashing(t *testing.T, hash string) {
+	saved := []int{BCRYPT_COST, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS}
+	savedHash := PASSWORD_HASH
+	t.Cleanup(func() {
+		BCRYPT_COST, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS = saved[0], saved[1], saved[2], saved[3]
+		PASSWORD_HASH = savedHash
+	})
+	BCRYPT_COST, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS = bcrypt.MinCost, 1, 64, 1
+	PASSWORD_HASH = hash
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, hash := range []string{HASH_BCRYPT, HASH_ARGON2ID} {
+		fastHashing(t, hash)
+
+		stored, err := HashPassword("secret")
+		if err != nil {
+			t.Fatalf("%s: HashPassword: %v", hash, err)
+		}
+		if !Hasher(hash).Owns(stored) || strings.Contains(stored, "secret") {
+			t.Errorf("%s: HashPassword = %s", hash, stored)
+		}
+		if again, _ := HashPassword("secret"); again == stored {
+			t.Errorf("%s: same hash twice, salt not random", hash)
+		}
+
+		if ok, rehash := CheckPassword(stored, "secret"); !ok || rehash {
+			t.Errorf("%s: CheckPassword right password = %v, %v, want true, false", hash, ok, rehash)
+		}
+		if ok, rehash := CheckPassword(stored, "Secret"); ok || rehash {
+			t.Errorf("%s: CheckPassword wrong password = %v, %v, want false, false", hash, ok, rehash)
+		}
+		if ok, _ := CheckPassword(stored, ""); ok {
+			t.Errorf("%s: CheckPassword empty password matched", hash)
+		}
+	}
+}
+
+func TestHashPasswordEmpty(t *testing.T) {
+	fastHashing(t, HASH_BCRYPT)
+
+	stored, err := HashPassword("")
+	if err != nil || stored != "" {
+		t.Errorf(`HashPassword("") = %q, %v, want ""`, stored, err)
+	}
+	if ok, _ := CheckPassword("", ""); ok {
+		t.Errorf("CheckPassword empty stored value matched")
+	}
+}
+
+func TestCheckPasswordRehash(t *testing.T) {
+	tests := []struct {
+		name   string
+		hash   string
+		change func()
+	}{
+		{"bcrypt cost", HASH_BCRYPT, func() { BCRYPT_COST++ }},
+		{"argon2id time", HASH_ARGON2ID, func() { ARGON2_TIME++ }},
+		{"argon2id memory", HASH_ARGON2ID, func() { ARGON2_MEMORY *= 2 }},
+		{"argon2id threads", HASH_ARGON2ID, func() { ARGON2_THREADS++ }},
+		{"bcrypt to argon2id", HASH_BCRYPT, func() { PASSWORD_HASH = HASH_ARGON2ID }},
+		{"argon2id to bcrypt", HASH_ARGON2ID, func() { PASSWORD_HASH = HASH_BCRYPT }},
+	}
+	for _, tt := range tests {
+		fastHashing(t, tt.hash)
+		stored, err := HashPassword("secret")
+		if err != nil {
+			t.Fatalf("%s: HashPassword: %v", tt.name, err)
+		}
+
+		tt.change()
+		if ok, rehash := CheckPassword(stored, "secret"); !ok || !rehash {
+			t.Errorf("%s: CheckPassword = %v, %v, want true, true", tt.name, ok, rehash)
+		}
+		if ok, rehash := CheckPassword(stored, "other"); ok || rehash {
+			t.Errorf("%s: CheckPassword wrong password = %v, %v, want false, false", tt.name, ok, rehash)
+		}
+	}
+}
+
+// Rows written before hashing hold the password itself
+func TestCheckPasswordPlaintext(t *testing.T) {
+	fastHashing(t, HASH_BCRYPT)
+
+	tests := []struct {
+		stored, password string
+		ok               bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "secret2", false},
+		{"secret", "Secret", false},
+		{"secret", "", false},
+	}
+	for _, tt := range tests {
+		ok, rehash := CheckPassword(tt.stored, tt.password)
+		if ok != tt.ok || rehash != tt.ok {
+			t.Errorf("CheckPassword(%q, %q) = %v, %v, want %v, %v", tt.stored, tt.password, ok, rehash, tt.ok, tt.ok)
+		}
+	}
+}
+
+func TestCheckPasswordMalformed(t *testing.T) {
+	fastHashing(t, HASH_ARGON2ID)
+
+	stored, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	parts := strings.Split(stored, "$")
+
+	// Owned by a hasher, so never compared as plaintext either
+	tests := []string{
+		"$2a$04$short",
+		"$2b$",
+		"$argon2id$",
+		"$argon2id$v=19$m=64,t=1,p=1$" + parts[4],
+		"$argon2id$v=18$" + strings.Join(parts[3:], "$"),
+		"$argon2id$v=19$m=x,t=1,p=1$" + strings.Join(parts[4:], "$"),
+		"$argon2id$v=19$" + parts[3] + "$!!!$" + parts[5],
+		"$argon2id$v=19$" + parts[3] + "$" + parts[4] + "$!!!",
+	}
+	for _, stored := range tests {
+		if ok, rehash := CheckPassword(stored, stored); ok || rehash {
+			t.Errorf("CheckPassword(%q) = %v, %v, want false, false", stored, ok, rehash)
+		}
+	}
+	if !(&argon2idHasher{}).NeedsRehash("$argon2id$") {
+		t.Errorf("NeedsRehash of a malformed hash = false")
+	}
+}
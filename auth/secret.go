@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Prefix of sealed secrets, for when the scheme changes
+const SEALED_V1 = "v1:"
+
+func secretCipher() (cipher.AEAD, error) {
+	if SECRET_KEY == "" {
+		return nil, fmt.Errorf("SECRET_KEY not configured")
+	}
+	key, err := base64.StdEncoding.DecodeString(SECRET_KEY)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("SECRET_KEY must be base64 of 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts a secret to store in the database with SECRET_KEY
+func Seal(secret string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+	return SEALED_V1 + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unseal decrypts a secret sealed by Seal
+func Unseal(sealed string) (string, error) {
+	if !strings.HasPrefix(sealed, SEALED_V1) {
+		return "", fmt.Errorf("unknown sealed secret")
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed[len(SEALED_V1):])
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed secret")
+	}
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid sealed secret")
+	}
+	secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed secret")
+	}
+	return string(secret), nil
+}
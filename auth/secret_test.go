@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// Random SECRET_KEY, restored when the test ends
+func secretKey(t *testing.T) {
+	saved := SECRET_KEY
+	t.Cleanup(func() { SECRET_KEY = saved })
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	SECRET_KEY = base64.StdEncoding.EncodeToString(key)
+}
+
+func TestSeal(t *testing.T) {
+	secretKey(t)
+
+	sealed, err := Seal("bind password")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !strings.HasPrefix(sealed, SEALED_V1) || strings.Contains(sealed, "bind password") {
+		t.Fatalf("Seal = %q", sealed)
+	}
+	again, _ := Seal("bind password")
+	if again == sealed {
+		t.Errorf("Seal is not randomized")
+	}
+
+	secret, err := Unseal(sealed)
+	if err != nil || secret != "bind password" {
+		t.Errorf("Unseal = %q, %v", secret, err)
+	}
+
+	data, _ := base64.StdEncoding.DecodeString(sealed[len(SEALED_V1):])
+	data[len(data)-1] ^= 1
+	tampered := SEALED_V1 + base64.StdEncoding.EncodeToString(data)
+	for _, s := range []string{tampered, "bind password", SEALED_V1 + "!", SEALED_V1 + "AAAA"} {
+		if _, err := Unseal(s); err == nil {
+			t.Errorf("Unseal(%q): want error", s)
+		}
+	}
+
+	// Another key can not open it
+	secretKey(t)
+	if _, err := Unseal(sealed); err == nil {
+		t.Errorf("Unseal with another key: want error")
+	}
+}
+
+func TestSealKey(t *testing.T) {
+	saved := SECRET_KEY
+	t.Cleanup(func() { SECRET_KEY = saved })
+
+	for _, key := range []string{"", "not base64", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		SECRET_KEY = key
+		if _, err := Seal("secret"); err == nil {
+			t.Errorf("SECRET_KEY=%q: want error", key)
+		}
+	}
+}
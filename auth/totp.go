@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP as in RFC 6238 - HMAC-SHA1, 6 digits, 30 second steps. These are the
+// only parameters all authenticator apps support
+const (
+	TOTP_DIGITS       = 6
+	TOTP_PERIOD       = 30
+	TOTP_SECRET_LEN   = 20
+	RECOVERY_CODES    = 10
+	RECOVERY_CODE_LEN = 10
+)
+
+// Steps before and after the current one accepted, for clock drift
+var TOTP_SKEW = 1
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 encoded secret
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, TOTP_SECRET_LEN)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// TOTPURI is the otpauth:// URI authenticator apps enroll from, usually
+// shown as a QR code
+func TOTPURI(account string, secret string) string {
+	label := url.PathEscape(MFA_ISSUER + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", MFA_ISSUER)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", TOTP_DIGITS))
+	q.Set("period", fmt.Sprintf("%d", TOTP_PERIOD))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPStep is the time step t falls in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD
+}
+
+// TOTPCode computes the code of secret for step (RFC 4226 HOTP)
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, bin%mod), nil
+}
+
+// ValidateTOTP checks code against secret at time now. Steps up to lastStep
+// were already used and are rejected, so a code can not be replayed.
+// Returns the step that matched, to be saved as the new lastStep
+func ValidateTOTP(secret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for step := current - int64(TOTP_SKEW); step <= current+int64(TOTP_SKEW); step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// NewRecoveryCodes returns RECOVERY_CODES single use codes, formatted as
+// xxxxx-xxxxx for reading them out
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, RECOVERY_CODES)
+	for i := range codes {
+		b := make([]byte, RECOVERY_CODE_LEN)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		str := strings.ToLower(b32.EncodeToString(b))[:RECOVERY_CODE_LEN]
+		codes[i] = str[:RECOVERY_CODE_LEN/2] + "-" + str[RECOVERY_CODE_LEN/2:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode is the stored form of a recovery code. The codes are
+// random, so a plain digest is enough. Case and separators are ignored
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
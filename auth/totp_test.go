@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret of the SHA1 test vectors of RFC 6238 appendix B, the ASCII of
+// "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B, SHA1. The RFC lists 8 digits, TOTP_DIGITS are the
+// last ones
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func rfc6238Code(code string) string {
+	return code[len(code)-TOTP_DIGITS:]
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		step := TOTPStep(time.Unix(tt.unix, 0))
+		for _, secret := range []string{rfc6238Secret, strings.ToLower(rfc6238Secret)} {
+			code, err := TOTPCode(secret, step)
+			if err != nil {
+				t.Fatalf("TOTPCode: %v", err)
+			}
+			if want := rfc6238Code(tt.code); code != want {
+				t.Errorf("T=%d: TOTPCode = %s, want %s", tt.unix, code, want)
+			}
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Errorf("TOTPCode of a bad secret: want error")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		now := time.Unix(tt.unix, 0)
+		code := rfc6238Code(tt.code)
+
+		step, ok := ValidateTOTP(rfc6238Secret, " "+code+" ", 0, now)
+		if !ok || step != TOTPStep(now) {
+			t.Errorf("T=%d: ValidateTOTP = %d, %v, want %d, true", tt.unix, step, ok, TOTPStep(now))
+		}
+		// Replay of the step already used
+		if _, ok := ValidateTOTP(rfc6238Secret, code, step, now); ok {
+			t.Errorf("T=%d: code accepted again", tt.unix)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		code, _ := TOTPCode(rfc6238Secret, step+tt.offset)
+		got, ok := ValidateTOTP(rfc6238Secret, code, 0, now)
+		if ok != tt.ok || (ok && got != step+tt.offset) {
+			t.Errorf("step %+d: ValidateTOTP = %d, %v, want ok %v", tt.offset, got, ok, tt.ok)
+		}
+	}
+
+	// A later code used, the earlier one of the window is a replay too
+	code, _ := TOTPCode(rfc6238Secret, step-1)
+	if _, ok := ValidateTOTP(rfc6238Secret, code, step, now); ok {
+		t.Errorf("code of a step before the last used accepted")
+	}
+}
+
+func TestValidateTOTPMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, 0, now); ok {
+			t.Errorf("ValidateTOTP(%q) accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", 0, now); ok {
+		t.Errorf("ValidateTOTP of a bad secret accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+	if len(codes) != RECOVERY_CODES {
+		t.Fatalf("%d codes, want %d", len(codes), RECOVERY_CODES)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != RECOVERY_CODE_LEN+1 || code[RECOVERY_CODE_LEN/2] != '-' || seen[code] {
+			t.Errorf("recovery code %s", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/saroopmathur/rest-api/db"
+	handler "github.com/saroopmathur/rest-api/handlers"
+)
+
+// firewall subcommand, prints the rules of a service to apply them on a
+// node by hand, e.g.
+//
+//	rest-api firewall -domain acme -service gw1 | nft -f -
+func firewallCommand(args []string) {
+	fs := flag.NewFlagSet("firewall", flag.ExitOnError)
+	domain := fs.String("domain", "", "domain of the service")
+	service := fs.String("service", "", "name of the service")
+	format := fs.String("format", "nft", "nft, iptables or ip6tables")
+	fs.Parse(args)
+	if *domain == "" || *service == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	d := db.SelectDomain(0, *domain)
+	if d == nil {
+		log.Fatalf("domain %s unknown\n", *domain)
+	}
+	rules, err := handler.CompileFirewall(d.ID, *service, 0, *format)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	fmt.Print(rules)
+}
@@ -0,0 +1,92 @@
+// Package config reads settings from the environment, loading .env first.
+// Variables already set in the environment are not overridden by .env.
+// Invalid values are logged and the default is kept
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+var loadOnce sync.Once
+
+// Load reads .env, once however often it is called
+func Load() {
+	loadOnce.Do(func() {
+		if err := godotenv.Load(".env"); err != nil {
+			log.Printf("Error loading .env file")
+		}
+	})
+}
+
+func lookup(name string) string {
+	Load()
+	return os.Getenv(name)
+}
+
+func String(name string, val *string) {
+	if str := lookup(name); str != "" {
+		*val = str
+	}
+}
+
+func Int(name string, val *int) {
+	str := lookup(name)
+	if str == "" {
+		return
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil {
+		log.Printf("Invalid %s=%s - using %d\n", name, str, *val)
+		return
+	}
+	*val = n
+}
+
+func Bool(name string, val *bool) {
+	str := lookup(name)
+	if str == "" {
+		return
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		log.Printf("Invalid %s=%s - using %v\n", name, str, *val)
+		return
+	}
+	*val = b
+}
+
+// Duration as time.ParseDuration takes it, e.g. 90s or 8h
+func Duration(name string, val *time.Duration) {
+	str := lookup(name)
+	if str == "" {
+		return
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		log.Printf("Invalid %s=%s - using %v\n", name, str, *val)
+		return
+	}
+	*val = d
+}
+
+// Comma separated list, empty items are left out
+func List(name string, val *[]string) {
+	str := lookup(name)
+	if str == "" {
+		return
+	}
+	var list []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*val = list
+}
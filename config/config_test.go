@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestString(t *testing.T) {
+	val := "default"
+	String("XPRESS_TEST_STRING", &val)
+	if val != "default" {
+		t.Errorf("unset: %q, want default", val)
+	}
+
+	t.Setenv("XPRESS_TEST_STRING", "set")
+	String("XPRESS_TEST_STRING", &val)
+	if val != "set" {
+		t.Errorf("set: %q, want set", val)
+	}
+}
+
+func TestInt(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int
+	}{
+		{"", 7},
+		{"42", 42},
+		{"-1", -1},
+		{"4x", 7},
+	}
+	for _, tt := range tests {
+		t.Setenv("XPRESS_TEST_INT", tt.env)
+		val := 7
+		Int("XPRESS_TEST_INT", &val)
+		if val != tt.want {
+			t.Errorf("%q: %d, want %d", tt.env, val, tt.want)
+		}
+	}
+}
+
+func TestBool(t *testing.T) {
+	tests := []struct {
+		env  string
+		want bool
+	}{
+		{"", true},
+		{"false", false},
+		{"0", false},
+		{"TRUE", true},
+		{"no", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("XPRESS_TEST_BOOL", tt.env)
+		val := true
+		Bool("XPRESS_TEST_BOOL", &val)
+		if val != tt.want {
+			t.Errorf("%q: %v, want %v", tt.env, val, tt.want)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", time.Minute},
+		{"90s", 90 * time.Second},
+		{"8h", 8 * time.Hour},
+		{"60", time.Minute},
+	}
+	for _, tt := range tests {
+		t.Setenv("XPRESS_TEST_DURATION", tt.env)
+		val := time.Minute
+		Duration("XPRESS_TEST_DURATION", &val)
+		if val != tt.want {
+			t.Errorf("%q: %v, want %v", tt.env, val, tt.want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{"", []string{"default"}},
+		{"a", []string{"a"}},
+		{" a, b ,,c ", []string{"a", "b", "c"}},
+		{" , ", nil},
+	}
+	for _, tt := range tests {
+		t.Setenv("XPRESS_TEST_LIST", tt.env)
+		val := []string{"default"}
+		List("XPRESS_TEST_LIST", &val)
+		if fmt.Sprint(val) != fmt.Sprint(tt.want) || len(val) != len(tt.want) {
+			t.Errorf("%q: %q, want %q", tt.env, val, tt.want)
+		}
+	}
+}
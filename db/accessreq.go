@@ -0,0 +1,279 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// States of an access request, only pending ones can change
+const (
+	ACCESS_PENDING   = "pending"
+	ACCESS_APPROVED  = "approved"
+	ACCESS_DENIED    = "denied"
+	ACCESS_CANCELLED = "cancelled"
+)
+
+// An approval refused, a deny row of the user for the app is in place
+var ErrAccessDenied = errors.New("access to the app is denied to the user")
+
+// Insert a pending access request of the user for an app of the domain.
+// Refused while another request of the user for the app is pending
+func InsertAccessRequest(domainId int, actor *model.User2, req *model.AccessRequestReq) (*model.AccessRequest, error) {
+	db := setupDB()
+
+	app := SelectApp(domainId, req.App, req.AppID)
+	if app == nil {
+		return nil, fmt.Errorf("app %s %d unknown", req.App, req.AppID)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var lastInsertID int
+	query := `INSERT INTO access_requests (user_id, app_id, justification, duration_hours, state, create_time, update_time)
+					VALUES ($1, $2, $3, $4, $5, $6, $6) returning id`
+	err = tx.QueryRow(query, actor.ID, app.ID, req.Justification, req.DurationHours, ACCESS_PENDING, now).Scan(&lastInsertID)
+	if err != nil {
+		fmt.Printf("InsertAccessRequest: [%d %d] %v\n", actor.ID, app.ID, err)
+		return nil, err
+	}
+	if err = insertAccessEvent(tx, lastInsertID, ACCESS_PENDING, actor, "", now); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Select the inserted record and return
+	return SelectAccessRequest(domainId, lastInsertID), nil
+}
+
+// Access requests of the domain, of the user unless userId is 0, in the
+// state unless empty. Newest first
+func SelectAccessRequests(domainId int, userId int, state string) []*model.AccessRequest {
+	db := setupDB()
+
+	query := `SELECT r.id, r.user_id, u.name, r.app_id, a.name, r.justification, r.duration_hours, r.state,
+				r.access_id, r.create_time, r.update_time
+				FROM access_requests r
+					JOIN users u ON r.user_id=u.id
+					JOIN apps a ON r.app_id=a.id
+				WHERE u.domain_id=$1 AND ($2=0 OR r.user_id=$2) AND ($3='' OR r.state=$3)
+				ORDER BY r.create_time DESC, r.id DESC`
+	rows, err := db.Query(query, domainId, userId, state)
+	if err != nil {
+		fmt.Printf("SelectAccessRequests: [%d %d %s] %v\n", domainId, userId, state, err)
+		return nil
+	}
+	defer rows.Close()
+
+	requests := []*model.AccessRequest{}
+	for {
+		r := readAccessRequestRow(rows)
+		if r == nil {
+			break
+		}
+		requests = append(requests, r)
+	}
+	return requests
+}
+
+// Select the access request with its history
+func SelectAccessRequest(domainId int, requestId int) *model.AccessRequest {
+	db := setupDB()
+
+	query := `SELECT r.id, r.user_id, u.name, r.app_id, a.name, r.justification, r.duration_hours, r.state,
+				r.access_id, r.create_time, r.update_time
+				FROM access_requests r
+					JOIN users u ON r.user_id=u.id
+					JOIN apps a ON r.app_id=a.id
+				WHERE u.domain_id=$1 AND r.id=$2`
+	rows, err := db.Query(query, domainId, requestId)
+	if err != nil {
+		fmt.Printf("SelectAccessRequest: [%d %d] %v\n", domainId, requestId, err)
+		return nil
+	}
+	r := readAccessRequestRow(rows)
+	rows.Close()
+	if r == nil {
+		return nil
+	}
+
+	query = `SELECT state, actor_role, actor_id, actor_name, comment, event_time
+				FROM access_request_events WHERE request_id=$1
+				ORDER BY event_time, id`
+	rows, err = db.Query(query, r.ID)
+	if err != nil {
+		fmt.Printf("SelectAccessRequest: [%d %d] %v\n", domainId, requestId, err)
+		return r
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := &model.AccessRequestEvent{}
+		var role, name, comment sql.NullString
+		var actorId sql.NullInt32
+		var eventTime sql.NullTime
+		if err = rows.Scan(&e.State, &role, &actorId, &name, &comment, &eventTime); err != nil {
+			fmt.Printf("SelectAccessRequest Scan: %v\n", err)
+			break
+		}
+		e.ActorRole = role.String
+		e.ActorID = int(actorId.Int32)
+		e.ActorName = name.String
+		e.Comment = comment.String
+		if eventTime.Valid {
+			e.EventTime = &eventTime.Time
+		}
+		r.Events = append(r.Events, e)
+	}
+	return r
+}
+
+// Approve a pending access request. The user gets access to the app, for
+// the requested duration unless the decision overrides it. Refused with
+// ErrAccessDenied while the user is denied the app
+func ApproveAccessRequest(domainId int, requestId int, actor *model.User2, decision *model.AccessDecision) (*model.AccessRequest, error) {
+	return decideAccessRequest(domainId, requestId, 0, ACCESS_APPROVED, actor, decision)
+}
+
+// Deny a pending access request
+func DenyAccessRequest(domainId int, requestId int, actor *model.User2, decision *model.AccessDecision) (*model.AccessRequest, error) {
+	return decideAccessRequest(domainId, requestId, 0, ACCESS_DENIED, actor, decision)
+}
+
+// Cancel a pending access request of the user
+func CancelAccessRequest(domainId int, userId int, requestId int, actor *model.User2) (*model.AccessRequest, error) {
+	return decideAccessRequest(domainId, requestId, userId, ACCESS_CANCELLED, actor, &model.AccessDecision{})
+}
+
+// Move a pending access request of the domain (and of the user unless
+// userId is 0) to state, creating the grant on approval
+func decideAccessRequest(domainId int, requestId int, userId int, state string, actor *model.User2, decision *model.AccessDecision) (*model.AccessRequest, error) {
+	db := setupDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var user, app, hours int
+	var current string
+	query := `SELECT r.user_id, r.app_id, r.duration_hours, r.state
+				FROM access_requests r JOIN users u ON r.user_id=u.id
+				WHERE r.id=$1 AND u.domain_id=$2 AND ($3=0 OR r.user_id=$3)
+				FOR UPDATE OF r`
+	err = tx.QueryRow(query, requestId, domainId, userId).Scan(&user, &app, &hours, &current)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("access request %d unknown", requestId)
+	} else if err != nil {
+		return nil, err
+	}
+	if current != ACCESS_PENDING {
+		return nil, fmt.Errorf("access request %d already %s", requestId, current)
+	}
+
+	now := time.Now()
+	var accessId sql.NullInt32
+	if state == ACCESS_APPROVED {
+		if decision.DurationHours != nil {
+			hours = *decision.DurationHours
+		}
+		var validUntil interface{}
+		if hours > 0 {
+			validUntil = now.Add(time.Duration(hours) * time.Hour)
+		}
+
+		// Rows of the user for the app set by an admin are kept: a deny
+		// refuses the approval, a permanent allow already gives the access
+		var denyId, allowId sql.NullInt32
+		query = `SELECT max(id) FILTER (WHERE effect=$3),
+						max(id) FILTER (WHERE effect<>$3 AND valid_from IS NULL AND valid_until IS NULL AND schedule IS NULL)
+					FROM user_access_control
+					WHERE user_id=$1 AND app_id=$2 AND status=$4`
+		err = tx.QueryRow(query, user, app, EFFECT_DENY, STATUS_ACTIVE).Scan(&denyId, &allowId)
+		if err != nil {
+			return nil, err
+		}
+		if denyId.Valid {
+			return nil, fmt.Errorf("%w by access %d, remove it first", ErrAccessDenied, denyId.Int32)
+		}
+
+		if allowId.Valid {
+			accessId = allowId
+		} else {
+			// Earlier temporary allows ending before this one are
+			// superseded, scheduled ones are left alone
+			query = `UPDATE user_access_control SET status=$1
+						WHERE user_id=$2 AND app_id=$3 AND status=$4 AND effect<>$5 AND schedule IS NULL
+							AND valid_until IS NOT NULL AND ($6::timestamptz IS NULL OR valid_until<=$6)`
+			_, err = tx.Exec(query, STATUS_DELETED, user, app, STATUS_ACTIVE, EFFECT_DENY, validUntil)
+			if err != nil {
+				return nil, err
+			}
+			query = `INSERT INTO user_access_control (user_id, app_id, status, valid_until)
+						VALUES ($1, $2, $3, $4) returning id`
+			if err = tx.QueryRow(query, user, app, STATUS_ACTIVE, validUntil).Scan(&accessId); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	query = `UPDATE access_requests SET state=$1, access_id=$2, update_time=$3 WHERE id=$4`
+	if _, err = tx.Exec(query, state, accessId, now, requestId); err != nil {
+		return nil, err
+	}
+	if err = insertAccessEvent(tx, requestId, state, actor, decision.Comment, now); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		fmt.Printf("decideAccessRequest: [%d %s] %v\n", requestId, state, err)
+		return nil, err
+	}
+	return SelectAccessRequest(domainId, requestId), nil
+}
+
+// Record a state change of the access request
+func insertAccessEvent(tx *sql.Tx, requestId int, state string, actor *model.User2, comment string, now time.Time) error {
+	query := `INSERT INTO access_request_events (request_id, state, actor_role, actor_id, actor_name, comment, event_time)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := tx.Exec(query, requestId, state, actor.Role, actor.ID, actor.Name, comment, now)
+	return err
+}
+
+func readAccessRequestRow(rows *sql.Rows) *model.AccessRequest {
+	var justification sql.NullString
+	var accessId sql.NullInt32
+	var createTime sql.NullTime
+	var updateTime sql.NullTime
+
+	if !rows.Next() {
+		return nil
+	}
+
+	r := model.AccessRequest{}
+	err := rows.Scan(&r.ID, &r.UserID, &r.UserName, &r.AppID, &r.AppName, &justification, &r.DurationHours, &r.State,
+		&accessId, &createTime, &updateTime)
+	if err != nil {
+		fmt.Printf("ReadAccessRequest Scan: %v\n", err)
+		return nil
+	}
+	r.Justification = justification.String
+	r.AccessID = int(accessId.Int32)
+	if createTime.Valid {
+		r.CreateTime = &createTime.Time
+	}
+	if updateTime.Valid {
+		r.UpdateTime = &updateTime.Time
+	}
+	return &r
+}
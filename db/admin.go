@@ -4,10 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"math/rand"
 	"strings"
-	"time"
 
+	"github.com/saroopmathur/rest-api/auth"
 	model "github.com/saroopmathur/rest-api/models"
 )
 
@@ -15,13 +14,18 @@ import (
 func InsertAdmin(domainId int, name string, password string) (*model.Admin2, error) {
 	db := setupDB()
 
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
 	// Delete if any record with the same name
-	query := `DELETE FROM admins WHERE name=$1`
-	db.Exec(query, name)
+	query := `DELETE FROM admins WHERE name=$1 AND domain_id=$2`
+	db.Exec(query, name, domainId)
 
 	var lastInsertID int
 	query = "INSERT INTO admins (name, domain_id, password, status) VALUES($1, $2, $3, $4) returning id"
-	err := db.QueryRow(query, name, domainId, password, STATUS_ACTIVE).Scan(&lastInsertID)
+	err = db.QueryRow(query, name, domainId, hash, STATUS_ACTIVE).Scan(&lastInsertID)
 	if err != nil {
 		return nil, err
 	}
@@ -96,7 +100,12 @@ func UpdateAdmin(domainId int, adminName string, adminId int, admin *model.Admin
 		params += "name='" + name + "', "
 	}
 	if pass != "" {
-		params += "password='" + pass + "', "
+		hash, err := auth.HashPassword(pass)
+		if err != nil {
+			fmt.Printf("UpdateAdmin: [%s %d] %v\n", adminName, adminId, err)
+			return nil
+		}
+		params += "password='" + hash + "', "
 	}
 	if params == "" {
 		// Nothing to update
@@ -165,8 +174,25 @@ func GetAdminByName(username string) *model.Admin2 {
 	if admin == nil {
 		fmt.Printf("GetAdminByName: [%s@%s] \"%s\" Not Found\n", name, domain, query)
 	} else {
-		fmt.Printf("GetAdminByName: %s %+v\n", username, *admin)
+		fmt.Printf("GetAdminByName: %s [%d %d]\n", username, admin.ID, admin.Domain.ID)
+	}
+	return admin
+}
+
+// Select an active admin by id, in any domain
+func GetAdminByID(adminId int) *model.Admin2 {
+	db := setupDB()
+
+	query := `SELECT a.id, a.name, a.domain_id, a.password, d.name, d.status
+				FROM admins a LEFT JOIN domains d ON a.domain_id=d.id
+				WHERE a.id=$1 AND a.status=$2`
+	rows, err := db.Query(query, adminId, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("GetAdminByID: %d Failed\n", adminId)
+		return nil
 	}
+	admin := readAdminRow(rows, false)
+	rows.Close()
 	return admin
 }
 
@@ -204,45 +230,12 @@ func readAdminRow(rows *sql.Rows, readRole bool) *model.Admin2 {
 }
 
 func GenerateAndSaveAdminToken(a *model.Admin2) {
-	db := setupDB()
-
-	token := fmt.Sprintf("A%d", rand.Int63())
-
-	query := `INSERT INTO sessions (uid, session_id, domain_id, role, start_time, status)
-				     VALUES ($1, $2, $3, $4, $5, $6)`
-
-	_, err := db.Query(query, a.ID, token, a.Domain.ID, a.Role, time.Now(), STATUS_ACTIVE)
+	token, refresh, err := insertSession(a.ID, a.Domain.ID, a.Role)
 	if err != nil {
 		log.Printf("SaveAdminToken Failed: %s %d %v\n", a.Name, a.ID, err)
 		return
 	}
 	a.SessionID = token
+	a.RefreshToken = refresh
 	//fmt.Printf("SaveAdminToken: role=%s token=%s [%s %d]\n", a.Role, token, a.Name, a.ID)
 }
-
-func GetAdminByToken(token string) *model.Admin2 {
-	if !strings.HasPrefix(token, "A") {
-		return nil
-	}
-	db := setupDB()
-
-	query := `SELECT a.id, a.name, sess.domain_id, a.password, sess.role, d.name, d.status
-				FROM sessions sess, admins a, domains d
-				WHERE sess.session_id=$1
-					AND sess.uid=a.id
-					AND sess.domain_id=d.id
-					AND sess.status=$2
-					AND a.status=$2`
-	//log.Printf("%s: [%s]\n", query, token)
-	rows, err := db.Query(query, token, STATUS_ACTIVE)
-	if err != nil {
-		log.Printf("GetAdminByToken: %s Failed\n", token)
-		return nil
-	}
-
-	admin := readAdminRow(rows, true)
-	rows.Close()
-
-	//fmt.Printf("ReadAdminToken: role=%s token=%s [%s %d]\n", admin.Role, token, admin.Name, admin.ID)
-	return admin
-}
@@ -30,6 +30,9 @@ func InsertApp(domainId int, app *model.AppReq) (*model.App, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = saveAppRules(lastInsertID, app.Rules); err != nil {
+		return nil, err
+	}
 
 	// Select the inserted record and return
 	return SelectApp(domainId, "", lastInsertID), err
@@ -57,6 +60,7 @@ func SelectApps(domainId int) []*model.App {
 		}
 		apps = append(apps, app)
 	}
+	attachAppRules(apps)
 	return apps
 }
 
@@ -87,12 +91,13 @@ func SelectApp(domainId int, appName string, appId int) *model.App {
 		return nil
 	}
 	app = readAppRow(rows)
+	rows.Close()
 	if app != nil {
+		attachAppRules([]*model.App{app})
 		//log.Printf("Select App %s %d in domain %d - %v\n", appName, appId, domainId, *app)
 	} else {
 		log.Printf("Select App %s %d in domain %d - NOT FOUND\n", appName, appId, domainId)
 	}
-	rows.Close()
 	return app
 }
 
@@ -123,12 +128,13 @@ func SelectApp2(domainId int, svcName string, svcId int) *model.App {
 		return nil
 	}
 	app = readAppRow(rows)
+	rows.Close()
 	if app != nil {
+		attachAppRules([]*model.App{app})
 		//log.Printf("Select App %s %d in domain %d - %v\n", appName, appId, domainId, *app)
 	} else {
 		log.Printf("Select App %s %d in domain %d - NOT FOUND\n", svcName, svcId, domainId)
 	}
-	rows.Close()
 	return app
 }
 
@@ -144,11 +150,13 @@ func UpdateApp(domainId int, appName string, appId int, app *model.AppReq) *mode
 	if app.AllowedIPs != "" {
 		params += "allowed_ips='" + app.AllowedIPs + "', "
 	}
-	if params == "" {
+	if params == "" && app.Rules == nil {
 		// Nothing to update
 		return SelectApp(domainId, appName, appId)
 	}
-	params = params[:len(params)-2]
+	if params != "" {
+		params = params[:len(params)-2]
+	}
 
 	var err error
 	var query string
@@ -175,7 +183,9 @@ func UpdateApp(domainId int, appName string, appId int, app *model.AppReq) *mode
 		return nil
 	}
 
-	if appId > 0 {
+	if params == "" {
+		// Only the rules to update
+	} else if appId > 0 {
 		query = fmt.Sprintf("UPDATE apps SET %s WHERE id=$1 AND status=$2", params)
 		_, err = db.Exec(query, appId, STATUS_ACTIVE)
 	} else {
@@ -188,6 +198,20 @@ func UpdateApp(domainId int, appName string, appId int, app *model.AppReq) *mode
 		return nil
 	}
 
+	if app.Rules != nil {
+		if app.Name != "" {
+			appName = app.Name
+		}
+		updated := SelectApp(domainId, appName, appId)
+		if updated == nil {
+			return nil
+		}
+		if err = saveAppRules(updated.ID, app.Rules); err != nil {
+			fmt.Printf("UpdateApp rules: [%s %d] %v\n", appName, appId, err)
+			return nil
+		}
+	}
+
 	// Select the updated record and return
 	return SelectApp(domainId, appName, appId)
 }
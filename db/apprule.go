@@ -0,0 +1,123 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Replace the structured destinations of the app
+func saveAppRules(appId int, rules []model.AppRule) error {
+	db := setupDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM app_rules WHERE app_id=$1`
+	if _, err = tx.Exec(query, appId); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO app_rules (app_id, dest, protocol, port_from, port_to) VALUES ($1, $2, $3, $4, $5)`
+	for _, rule := range rules {
+		portTo := rule.PortEnd
+		if portTo == 0 {
+			portTo = rule.Port
+		}
+		if _, err = tx.Exec(query, appId, rule.Dest, rule.Protocol, rule.Port, portTo); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Structured destinations of the apps, by app id
+func selectAppRules(appIds []int) map[int][]model.AppRule {
+	db := setupDB()
+
+	result := make(map[int][]model.AppRule)
+	if len(appIds) == 0 {
+		return result
+	}
+	var ids []string
+	for _, id := range appIds {
+		ids = append(ids, strconv.Itoa(id))
+	}
+
+	query := `SELECT app_id, dest, protocol, port_from, port_to
+				FROM app_rules
+				WHERE app_id = ANY(string_to_array($1, ',')::int[])
+				ORDER BY app_id, id`
+	rows, err := db.Query(query, strings.Join(ids, ","))
+	if err != nil {
+		fmt.Printf("selectAppRules: %v\n", err)
+		return result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var appId int
+		var dest sql.NullString
+		var protocol sql.NullString
+		var portFrom sql.NullInt32
+		var portTo sql.NullInt32
+
+		err = rows.Scan(&appId, &dest, &protocol, &portFrom, &portTo)
+		if err != nil {
+			fmt.Printf("selectAppRules Scan: %v\n", err)
+			continue
+		}
+		rule := model.AppRule{Dest: dest.String, Protocol: protocol.String, Port: int(portFrom.Int32)}
+		if portTo.Int32 != portFrom.Int32 {
+			rule.PortEnd = int(portTo.Int32)
+		}
+		result[appId] = append(result[appId], rule)
+	}
+	return result
+}
+
+// Fill in Rules of the apps
+func attachAppRules(apps []*model.App) {
+	var ids []int
+	for _, app := range apps {
+		ids = append(ids, app.ID)
+	}
+	rules := selectAppRules(ids)
+	for _, app := range apps {
+		app.Rules = rules[app.ID]
+	}
+}
+
+// Fill in Rules of the apps in the policy
+func attachPolicyRules(policy *model.Policy) {
+	var ids []int
+	for _, s := range policy.ServiceNodes {
+		for _, app := range s.Apps {
+			ids = append(ids, app.ID)
+		}
+	}
+	rules := selectAppRules(ids)
+	for _, s := range policy.ServiceNodes {
+		for _, app := range s.Apps {
+			app.Rules = rules[app.ID]
+		}
+	}
+}
+
+// Fill in Rules of the apps of a service node
+func attachPeerAppRules(apps []*model.PeerApp) {
+	var ids []int
+	for _, app := range apps {
+		ids = append(ids, app.ID)
+	}
+	rules := selectAppRules(ids)
+	for _, app := range apps {
+		app.Rules = rules[app.ID]
+	}
+}
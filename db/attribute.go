@@ -0,0 +1,87 @@
+package db
+
+import (
+	"fmt"
+)
+
+// Attributes of the user, key/value tags the rules of dynamic groups are
+// evaluated over
+func SelectUserAttributes(domainId int, userName string, userId int) (map[string]string, error) {
+	user := SelectUser(domainId, userName, userId)
+	if user == nil {
+		return nil, fmt.Errorf("user %s %d unknown", userName, userId)
+	}
+	attrs, err := selectAttributes(domainId, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return attrs[user.ID], nil
+}
+
+// Replace all attributes of the user
+func SetUserAttributes(domainId int, userName string, userId int, attrs map[string]string) (map[string]string, error) {
+	db := setupDB()
+
+	user := SelectUser(domainId, userName, userId)
+	if user == nil {
+		return nil, fmt.Errorf("user %s %d unknown", userName, userId)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Printf("SetUserAttributes: [%s %d] %v\n", userName, userId, err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM user_attributes WHERE user_id=$1`, user.ID); err != nil {
+		fmt.Printf("SetUserAttributes: [%s %d] %v\n", userName, userId, err)
+		return nil, err
+	}
+	for name, value := range attrs {
+		_, err = tx.Exec(`INSERT INTO user_attributes (user_id, name, value) VALUES ($1, $2, $3)`,
+			user.ID, name, value)
+		if err != nil {
+			fmt.Printf("SetUserAttributes: [%s %d] %s %v\n", userName, userId, name, err)
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("SetUserAttributes: [%s %d] domain %d - %d attributes\n", userName, userId, domainId, len(attrs))
+	return SelectUserAttributes(domainId, "", user.ID)
+}
+
+// Attributes of the active users of the domain, of the user only unless
+// userId is 0. Users without attributes have an empty map
+func selectAttributes(domainId int, userId int) (map[int]map[string]string, error) {
+	db := setupDB()
+
+	query := `SELECT u.id, a.name, a.value
+				FROM users u LEFT JOIN user_attributes a ON a.user_id=u.id
+				WHERE u.domain_id=$1 AND ($2=0 OR u.id=$2) AND u.status=$3`
+	rows, err := db.Query(query, domainId, userId, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("selectAttributes: [%d %d] %v\n", domainId, userId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	attrs := make(map[int]map[string]string)
+	for rows.Next() {
+		var id int
+		var name, value *string
+		if err = rows.Scan(&id, &name, &value); err != nil {
+			fmt.Printf("selectAttributes Scan: %v\n", err)
+			return nil, err
+		}
+		if attrs[id] == nil {
+			attrs[id] = make(map[string]string)
+		}
+		if name != nil && value != nil {
+			attrs[id][*name] = *value
+		}
+	}
+	return attrs, nil
+}
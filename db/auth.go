@@ -3,16 +3,87 @@ package db
 import (
 	"fmt"
 	"time"
+
+	"github.com/saroopmathur/rest-api/auth"
 )
 
 func TokenInvalidate(token string) {
 	db := setupDB()
 
 	query := `UPDATE sessions SET status=$1, end_time=$2 WHERE session_id=$3 AND status=$4`
-	_, err := db.Query(query, STATUS_DELETED, time.Now(), token, STATUS_ACTIVE)
+	sid := sessionKey(token)
+	_, err := db.Exec(query, STATUS_DELETED, time.Now(), sid, STATUS_ACTIVE)
 	if err != nil {
-		fmt.Printf("TokenInvalidate: %s %v\n", token, err)
+		fmt.Printf("TokenInvalidate: %v\n", err)
 	} else {
-		fmt.Printf("TokenInvalidate: %s SUCCESS\n", token)
+		revokeSession(sid)
+		fmt.Printf("TokenInvalidate: SUCCESS\n")
+	}
+}
+
+// Replace the stored password of a user/service/admin with a fresh hash.
+// Called after a successful login with a plaintext or outdated hash
+func rehashPassword(table string, id int, password string) {
+	db := setupDB()
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		fmt.Printf("RehashPassword: %s %d %v\n", table, id, err)
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET password=$1 WHERE id=$2 AND status=$3", table)
+	_, err = db.Exec(query, hash, id, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("RehashPassword: %s %d %v\n", table, id, err)
+	} else {
+		fmt.Printf("RehashPassword: %s %d SUCCESS\n", table, id)
+	}
+}
+
+func RehashUserPassword(userId int, password string) {
+	rehashPassword("users", userId, password)
+}
+
+func RehashServicePassword(serviceId int, password string) {
+	rehashPassword("services", serviceId, password)
+}
+
+func RehashAdminPassword(adminId int, password string) {
+	rehashPassword("admins", adminId, password)
+}
+
+// Set a new password for the user and end its other sessions, the one of
+// token is kept
+func ChangeUserPassword(userId int, password string, token string) error {
+	db := setupDB()
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET password=$1 WHERE id=$2 AND status=$3`
+	_, err = db.Exec(query, hash, userId, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("ChangeUserPassword: %d %v\n", userId, err)
+		return err
+	}
+
+	query = `UPDATE sessions SET status=$1, end_time=$2, refresh_token=NULL
+				WHERE uid=$3 AND role=$4 AND status IN ($5, $6) AND session_id<>$7
+				RETURNING session_id`
+	rows, err := db.Query(query, STATUS_DELETED, time.Now(), userId, ROLE_USER, STATUS_ACTIVE, STATUS_EXPIRED, sessionKey(token))
+	if err != nil {
+		fmt.Printf("ChangeUserPassword: end sessions %d %v\n", userId, err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid string
+		if rows.Scan(&sid) == nil {
+			revokeSession(sid)
+		}
 	}
+	return nil
 }
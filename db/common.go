@@ -5,11 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
-	"strconv"
 
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/saroopmathur/rest-api/config"
 )
 
 const (
@@ -18,6 +16,7 @@ const (
 	STATUS_ACTIVE   = "A"
 	STATUS_DELETED  = "D"
 	STATUS_DISABLED = "S"
+	STATUS_EXPIRED  = "E"
 
 	ROLE_ADMIN      = "A"
 	ROLE_POWERADMIN = "P"
@@ -45,56 +44,41 @@ func checkErr(err error) {
 }
 
 func init() {
-	var str string
-
-	err := godotenv.Load(".env")
-
-	if err != nil {
-		log.Printf("Error loading .env file")
-	}
-
-	str = os.Getenv("DB_HOST")
-	if str != "" {
-		DB_HOST = str
-	}
-
-	str = os.Getenv("DB_PORT")
-	if str != "" {
-		DB_PORT, _ = strconv.Atoi(str)
-	}
-
-	str = os.Getenv("DB_USER")
-	if str != "" {
-		DB_USER = str
-	}
-
-	str = os.Getenv("DB_PASSWORD")
-	if str != "" {
-		DB_PASSWORD = str
-	}
-
-	str = os.Getenv("DB_NAME")
-	if str != "" {
-		DB_NAME = str
-	}
+	config.String("DB_HOST", &DB_HOST)
+	config.Int("DB_PORT", &DB_PORT)
+	config.String("DB_USER", &DB_USER)
+	config.String("DB_PASSWORD", &DB_PASSWORD)
+	config.String("DB_NAME", &DB_NAME)
+	config.Bool("DB_DISABLE_SSL", &DB_DISABLE_SSL)
+	loadSessionConfig()
+	loadMFAConfig()
+	loadLockoutConfig()
+	loadLDAPConfig()
+	loadGrantConfig()
+	loadNotifyConfig()
+}
 
-	str = os.Getenv("DB_DISABLE_SSL")
-	if str != "" {
-		DB_DISABLE_SSL, _ = strconv.ParseBool(str)
-	}
+// Connect to the database at startup. Otherwise it connects on first use
+func Connect() {
 	setupDB()
 }
 
 var dbHandle *sql.DB
 
+// Connection string of the database
+func dbInfo() string {
+	dbinfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
+		DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME)
+	if DB_DISABLE_SSL {
+		dbinfo += " sslmode=disable"
+	}
+	return dbinfo
+}
+
 // DB set up
 func setupDB() *sql.DB {
 	if dbHandle == nil {
-		dbinfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
-			DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME)
-		if DB_DISABLE_SSL {
-			dbinfo += " sslmode=disable"
-		}
+		dbinfo := dbInfo()
 
 		dbHandle, _ = sql.Open("postgres", dbinfo)
 		ctx, stop := context.WithCancel(context.Background())
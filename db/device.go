@@ -0,0 +1,215 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Insert a device of the user, with a virtual IP from the pool of the
+// domain unless one is given
+func InsertDevice(domainId int, userId int, device *model.DeviceReq) (*model.Device, error) {
+	db := setupDB()
+
+	u := SelectUser(domainId, "", userId)
+	if u == nil {
+		return nil, fmt.Errorf("user %d unknown", userId)
+	}
+
+	now := time.Now()
+	var keyTime interface{}
+	if device.WGKey != "" {
+		keyTime = now
+	}
+
+	var lastInsertID int
+	query := `INSERT INTO devices (user_id, name, platform, wg_key, wg_key_time, public_ip, local_ip, create_time, status)
+					VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::inet, NULLIF($7, '')::inet, $8, $9) returning id`
+	err := db.QueryRow(query, u.ID, device.Name, device.Platform, device.WGKey, keyTime,
+		device.PublicIP, device.LocalIP, now, STATUS_ACTIVE).Scan(&lastInsertID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = assignVirtualIP(domainId, "device", lastInsertID, device.VirtualIP); err != nil {
+		db.Exec(`DELETE FROM devices WHERE id=$1`, lastInsertID)
+		return nil, err
+	}
+
+	// Select the inserted record and return
+	return SelectDevice(domainId, u.ID, "", lastInsertID), nil
+}
+
+// Devices of the user, not revoked
+func SelectDevices(domainId int, userId int) []*model.Device {
+	db := setupDB()
+
+	query := `SELECT d.id, d.user_id, d.name, d.platform, d.wg_key, d.virtual_ip, d.public_ip, d.local_ip, d.last_seen, d.create_time
+				FROM devices d JOIN users u ON d.user_id=u.id
+				WHERE u.domain_id=$1 AND d.user_id=$2 AND d.status=$3
+				ORDER BY d.name`
+	rows, err := db.Query(query, domainId, userId, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("SelectDevices: [%d] %v\n", userId, err)
+		return nil
+	}
+	defer rows.Close()
+
+	devices := []*model.Device{}
+	for {
+		device := readDeviceRow(rows)
+		if device == nil {
+			break
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// Select the device of the user with either name or id
+func SelectDevice(domainId int, userId int, deviceName string, deviceId int) *model.Device {
+	db := setupDB()
+
+	var rows *sql.Rows
+	var err error
+	if deviceId > 0 {
+		query := `SELECT d.id, d.user_id, d.name, d.platform, d.wg_key, d.virtual_ip, d.public_ip, d.local_ip, d.last_seen, d.create_time
+					FROM devices d JOIN users u ON d.user_id=u.id
+					WHERE u.domain_id=$1 AND d.user_id=$2 AND d.id=$3 AND d.status=$4`
+		rows, err = db.Query(query, domainId, userId, deviceId, STATUS_ACTIVE)
+	} else {
+		query := `SELECT d.id, d.user_id, d.name, d.platform, d.wg_key, d.virtual_ip, d.public_ip, d.local_ip, d.last_seen, d.create_time
+					FROM devices d JOIN users u ON d.user_id=u.id
+					WHERE u.domain_id=$1 AND d.user_id=$2 AND d.name=$3 AND d.status=$4`
+		rows, err = db.Query(query, domainId, userId, deviceName, STATUS_ACTIVE)
+	}
+	if err != nil {
+		fmt.Printf("SelectDevice: [%d %s %d] %v\n", userId, deviceName, deviceId, err)
+		return nil
+	}
+	defer rows.Close()
+
+	return readDeviceRow(rows)
+}
+
+// Update the device of the user with either name or id
+func UpdateDevice(domainId int, userId int, deviceName string, deviceId int, device *model.DeviceReq) (*model.Device, error) {
+	db := setupDB()
+
+	d := SelectDevice(domainId, userId, deviceName, deviceId)
+	if d == nil {
+		return nil, fmt.Errorf("device %s %d unknown", deviceName, deviceId)
+	}
+
+	if device.VirtualIP != "" {
+		// Refused if another user, device or service of the domain has it
+		if _, err := assignVirtualIP(domainId, "device", d.ID, device.VirtualIP); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `UPDATE devices SET name=COALESCE(NULLIF($1, ''), name),
+					platform=COALESCE(NULLIF($2, ''), platform),
+					public_ip=COALESCE(NULLIF($3, '')::inet, public_ip),
+					local_ip=COALESCE(NULLIF($4, '')::inet, local_ip)
+				WHERE id=$5`
+	_, err := db.Exec(query, device.Name, device.Platform, device.PublicIP, device.LocalIP, d.ID)
+	if err == nil && device.WGKey != "" {
+		query = `UPDATE devices SET wg_key=$1, wg_key_time=$2 WHERE id=$3`
+		_, err = db.Exec(query, device.WGKey, time.Now(), d.ID)
+	}
+	if err != nil {
+		fmt.Printf("UpdateDevice: [%d %s %d] %v\n", userId, deviceName, deviceId, err)
+		return nil, err
+	}
+	notifyPolicyChange(domainId)
+
+	// Select the updated record and return
+	return SelectDevice(domainId, userId, "", d.ID), nil
+}
+
+// Revoke the device, its key and virtual IP are no longer valid. The user
+// and its other devices are not touched
+func DeleteDevice(domainId int, userId int, deviceName string, deviceId int) *model.Device {
+	db := setupDB()
+
+	deleted_device := SelectDevice(domainId, userId, deviceName, deviceId)
+	if deleted_device == nil {
+		fmt.Printf("DeleteDevice: [%d %s %d] domain %d - Invalid Device\n", userId, deviceName, deviceId, domainId)
+		return nil
+	}
+
+	query := "UPDATE devices SET status=$1, virtual_ip=NULL WHERE id=$2"
+	_, err := db.Exec(query, STATUS_DELETED, deleted_device.ID)
+	if err != nil {
+		fmt.Printf("DeleteDevice: [%d %s %d] domain %d - %v\n", userId, deviceName, deviceId, domainId, err)
+		return nil
+	}
+	notifyPolicyChange(domainId)
+	return deleted_device
+}
+
+// Revoke all devices of a deleted user
+func deleteUserDevices(userId int) {
+	db := setupDB()
+
+	query := "UPDATE devices SET status=$1, virtual_ip=NULL WHERE user_id=$2 AND status<>$1"
+	_, err := db.Exec(query, STATUS_DELETED, userId)
+	if err != nil {
+		fmt.Printf("deleteUserDevices: [%d] %v\n", userId, err)
+	}
+}
+
+// The client app on the device checked in
+func DeviceSeen(deviceId int) {
+	db := setupDB()
+
+	query := "UPDATE devices SET last_seen=$1 WHERE id=$2"
+	_, err := db.Exec(query, time.Now(), deviceId)
+	if err != nil {
+		fmt.Printf("DeviceSeen: [%d] %v\n", deviceId, err)
+	}
+}
+
+func readDeviceRow(rows *sql.Rows) *model.Device {
+	var id int
+	var userId int
+	var name sql.NullString
+	var platform sql.NullString
+	var wgKey sql.NullString
+	var virtualIp sql.NullString
+	var publicIp sql.NullString
+	var localIp sql.NullString
+	var lastSeen sql.NullTime
+	var createTime sql.NullTime
+
+	if !rows.Next() {
+		return nil
+	}
+
+	err := rows.Scan(&id, &userId, &name, &platform, &wgKey, &virtualIp, &publicIp, &localIp, &lastSeen, &createTime)
+	if err != nil {
+		fmt.Printf("ReadDevice Scan: %v\n", err)
+		return nil
+	}
+
+	device := model.Device{
+		ID:        id,
+		UserID:    userId,
+		Name:      name.String,
+		Platform:  platform.String,
+		WGKey:     wgKey.String,
+		VirtualIP: virtualIp.String,
+		PublicIP:  publicIp.String,
+		LocalIP:   localIp.String,
+	}
+	if lastSeen.Valid {
+		device.LastSeen = &lastSeen.Time
+	}
+	if createTime.Valid {
+		device.CreateTime = &createTime.Time
+	}
+	return &device
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/saroopmathur/rest-api/auth"
 	model "github.com/saroopmathur/rest-api/models"
 )
 
@@ -27,7 +28,7 @@ func InsertDomain(domain *model.DomainReq) (*model.Domain, error) {
 func SelectDomains() []*model.Domain {
 	db := setupDB()
 
-	query := "SELECT id, name FROM domains WHERE status=$1 ORDER BY name"
+	query := "SELECT id, name, mfa_required, ip_pool, key_rotation_days, timezone FROM domains WHERE status=$1 ORDER BY name"
 	rows, err := db.Query(query, STATUS_ACTIVE)
 	if err != nil {
 		return nil
@@ -39,12 +40,17 @@ func SelectDomains() []*model.Domain {
 	for rows.Next() {
 		var id int
 		var name sql.NullString
+		var mfa bool
+		var pool sql.NullString
+		var days int
+		var timezone sql.NullString
 
-		err = rows.Scan(&id, &name)
+		err = rows.Scan(&id, &name, &mfa, &pool, &days, &timezone)
 		if err != nil {
 			return nil
 		}
-		domains = append(domains, &model.Domain{ID: id, Name: name.String})
+		domains = append(domains, &model.Domain{ID: id, Name: name.String, MFARequired: mfa, IPPool: pool.String,
+			KeyRotationDays: days, Timezone: timezone.String})
 	}
 
 	return domains
@@ -57,9 +63,9 @@ func SelectDomain(domainId int, domainName string) *model.Domain {
 	var err error
 
 	if domainId > 0 {
-		rows, err = db.Query("SELECT id, name FROM domains WHERE id=$1 AND status=$2", domainId, STATUS_ACTIVE)
+		rows, err = db.Query("SELECT id, name, mfa_required, ip_pool, key_rotation_days, timezone FROM domains WHERE id=$1 AND status=$2", domainId, STATUS_ACTIVE)
 	} else {
-		rows, err = db.Query("SELECT id, name FROM domains WHERE name=$1 AND status=$2", domainName, STATUS_ACTIVE)
+		rows, err = db.Query("SELECT id, name, mfa_required, ip_pool, key_rotation_days, timezone FROM domains WHERE name=$1 AND status=$2", domainName, STATUS_ACTIVE)
 	}
 	if err != nil {
 		return nil
@@ -70,11 +76,16 @@ func SelectDomain(domainId int, domainName string) *model.Domain {
 
 	var id int
 	var name sql.NullString
+	var mfa bool
+	var pool sql.NullString
+	var days int
+	var timezone sql.NullString
 
 	if rows.Next() {
-		err = rows.Scan(&id, &name)
+		err = rows.Scan(&id, &name, &mfa, &pool, &days, &timezone)
 		if err == nil {
-			domain = &model.Domain{ID: id, Name: name.String}
+			domain = &model.Domain{ID: id, Name: name.String, MFARequired: mfa, IPPool: pool.String,
+				KeyRotationDays: days, Timezone: timezone.String}
 		}
 	}
 
@@ -87,14 +98,74 @@ func UpdateDomain(domainId int, domainName string, domain *model.DomainReq) *mod
 
 	name := domain.Name
 
+	var err error
+	var query string
+	if domain.MFARequired != nil {
+		if domainId > 0 {
+			query = "UPDATE domains SET mfa_required=$1 WHERE id=$2 AND status=$3"
+			_, err = db.Exec(query, *domain.MFARequired, domainId, STATUS_ACTIVE)
+		} else {
+			query = "UPDATE domains SET mfa_required=$1 WHERE name=$2 AND status=$3"
+			_, err = db.Exec(query, *domain.MFARequired, domainName, STATUS_ACTIVE)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+
+	if domain.IPPool != nil {
+		// Empty to remove the pool
+		var pool interface{}
+		if *domain.IPPool != "" {
+			pool = *domain.IPPool
+		}
+		if domainId > 0 {
+			query = "UPDATE domains SET ip_pool=$1 WHERE id=$2 AND status=$3"
+			_, err = db.Exec(query, pool, domainId, STATUS_ACTIVE)
+		} else {
+			query = "UPDATE domains SET ip_pool=$1 WHERE name=$2 AND status=$3"
+			_, err = db.Exec(query, pool, domainName, STATUS_ACTIVE)
+		}
+		if err != nil {
+			fmt.Printf("%s: [%s %d] %v\n", query, domainName, domainId, err)
+			return nil
+		}
+	}
+
+	if domain.KeyRotationDays != nil {
+		if domainId > 0 {
+			query = "UPDATE domains SET key_rotation_days=$1 WHERE id=$2 AND status=$3"
+			_, err = db.Exec(query, *domain.KeyRotationDays, domainId, STATUS_ACTIVE)
+		} else {
+			query = "UPDATE domains SET key_rotation_days=$1 WHERE name=$2 AND status=$3"
+			_, err = db.Exec(query, *domain.KeyRotationDays, domainName, STATUS_ACTIVE)
+		}
+		if err != nil {
+			fmt.Printf("%s: [%s %d] %v\n", query, domainName, domainId, err)
+			return nil
+		}
+	}
+
+	if domain.Timezone != nil {
+		if domainId > 0 {
+			query = "UPDATE domains SET timezone=$1 WHERE id=$2 AND status=$3"
+			_, err = db.Exec(query, *domain.Timezone, domainId, STATUS_ACTIVE)
+		} else {
+			query = "UPDATE domains SET timezone=$1 WHERE name=$2 AND status=$3"
+			_, err = db.Exec(query, *domain.Timezone, domainName, STATUS_ACTIVE)
+		}
+		if err != nil {
+			fmt.Printf("%s: [%s %d] %v\n", query, domainName, domainId, err)
+			return nil
+		}
+	}
+
 	if name == "" {
-		// Nothing to do
-		return SelectDomain(domainId, name)
+		// Nothing else to do
+		return SelectDomain(domainId, domainName)
 	}
 
 	// Compose SQL query
-	var err error
-	var query string
 	if domainId > 0 {
 		query = "UPDATE domains SET name=$1 WHERE id=$2 AND status=$3"
 		_, err = db.Exec(query, name, domainId, STATUS_ACTIVE)
@@ -110,6 +181,20 @@ func UpdateDomain(domainId int, domainName string, domain *model.DomainReq) *mod
 	return SelectDomain(domainId, name)
 }
 
+// MFA is required for all admins of the domain
+func DomainMFARequired(domainId int) bool {
+	db := setupDB()
+
+	var required bool
+	query := "SELECT mfa_required FROM domains WHERE id=$1"
+	err := db.QueryRow(query, domainId).Scan(&required)
+	if err != nil {
+		fmt.Printf("DomainMFARequired: [%d] %v\n", domainId, err)
+		return false
+	}
+	return required
+}
+
 // Delete the record with the id
 func DeleteDomain(domainId int, domainName string) *model.Domain {
 	db := setupDB()
@@ -143,25 +228,42 @@ func DeleteDomain(domainId int, domainName string) *model.Domain {
 	return deleted_domain
 }
 
-func ChangeDomain(sessionId string, newDomainId int, newDomainName string) error {
+// Switch the session to another domain. Returns the token to use from now
+// on, with its refresh token if it had to be replaced: a JWT carries its
+// domain, so its session is ended and a new one created in the new domain
+func ChangeDomain(sessionId string, newDomainId int, newDomainName string) (string, string, error) {
 	db := setupDB()
 	var err error
 
+	if auth.IsJWT(sessionId) {
+		domain := SelectDomain(newDomainId, newDomainName)
+		if domain == nil {
+			return "", "", fmt.Errorf("domain %s %d unknown", newDomainName, newDomainId)
+		}
+		token, refresh, err := replaceSession(sessionId, domain.ID)
+		if err != nil {
+			log.Printf("ChangeDomain: [%s %d] %v\n", newDomainName, newDomainId, err)
+			return "", "", err
+		}
+		log.Printf("ChangeDomain: [%s %d] SUCCESS - new session\n", newDomainName, newDomainId)
+		return token, refresh, nil
+	}
+
 	if newDomainId > 0 {
 		// Updated based on domain id
 		query := `UPDATE sessions SET domain_id=$1 WHERE session_id=$2 AND status=$3`
-		_, err = db.Query(query, newDomainId, sessionId, STATUS_ACTIVE)
+		_, err = db.Exec(query, newDomainId, hashToken(sessionId), STATUS_ACTIVE)
 	} else {
 		// Updated based on domain name
 		query := `UPDATE sessions SET domain_id=(SELECT id FROM domains WHERE name=$1) WHERE session_id=$2 AND status=$3`
-		_, err = db.Query(query, newDomainName, sessionId, STATUS_ACTIVE)
+		_, err = db.Exec(query, newDomainName, hashToken(sessionId), STATUS_ACTIVE)
 	}
 	if err != nil {
-		log.Printf("ChangeDomain: %s [%s %d] %v\n", sessionId, newDomainName, newDomainId, err)
-	} else {
-		log.Printf("ChangeDomain: %s [%s %d] SUCCESS\n", sessionId, newDomainName, newDomainId)
+		log.Printf("ChangeDomain: [%s %d] %v\n", newDomainName, newDomainId, err)
+		return "", "", err
 	}
-	return nil
+	log.Printf("ChangeDomain: [%s %d] SUCCESS\n", newDomainName, newDomainId)
+	return sessionId, "", nil
 }
 
 // Set admin to any domain, other than powerdomain
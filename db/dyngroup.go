@@ -0,0 +1,139 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/saroopmathur/rest-api/expr"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Dynamic groups have a rule over user attributes instead of members,
+// evaluated on every read. The members of the dynamic groups are passed to
+// the queries of groupMembersAll as a "user:group,..." parameter
+
+// Rules of the dynamic groups of the domain. Rules that no longer parse
+// match nobody
+func selectGroupRules(domainId int) (map[int]expr.Expr, error) {
+	db := setupDB()
+
+	query := `SELECT id, name, rule FROM user_groups
+				WHERE domain_id=$1 AND status=$2 AND rule IS NOT NULL`
+	rows, err := db.Query(query, domainId, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("selectGroupRules: [%d] %v\n", domainId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make(map[int]expr.Expr)
+	for rows.Next() {
+		var id int
+		var name, rule string
+		if err = rows.Scan(&id, &name, &rule); err != nil {
+			fmt.Printf("selectGroupRules Scan: %v\n", err)
+			return nil, err
+		}
+		e, err := expr.Parse(rule)
+		if err != nil {
+			fmt.Printf("selectGroupRules: group [%s %d] %v - ignored\n", name, id, err)
+			continue
+		}
+		rules[id] = e
+	}
+	return rules, nil
+}
+
+// Members of the dynamic groups of the domain, of the user only unless
+// userId is 0, as the parameter of groupMembersAll. On errors dynamic
+// groups have no members
+func dynamicMembers(domainId int, userId int) string {
+	rules, err := selectGroupRules(domainId)
+	if err != nil || len(rules) == 0 {
+		return ""
+	}
+	attrs, err := selectAttributes(domainId, userId)
+	if err != nil {
+		return ""
+	}
+
+	var members []string
+	for user, a := range attrs {
+		for group, e := range rules {
+			if e.Eval(a) {
+				members = append(members, strconv.Itoa(user)+":"+strconv.Itoa(group))
+			}
+		}
+	}
+	return strings.Join(members, ",")
+}
+
+// Users of the domain the rule matches, with their attributes
+func PreviewGroupRule(domainId int, rule string) (*model.GroupPreview, error) {
+	db := setupDB()
+
+	e, err := expr.Parse(rule)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := selectAttributes(domainId, 0)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for user, a := range attrs {
+		if e.Eval(a) {
+			ids = append(ids, strconv.Itoa(user))
+		}
+	}
+
+	preview := &model.GroupPreview{Rule: rule, Users: []*model.User2{}}
+	if len(ids) == 0 {
+		return preview, nil
+	}
+	query := `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status
+				FROM users u LEFT JOIN domains d ON u.domain_id=d.id
+				WHERE u.domain_id=$1 AND u.status=$2 AND u.id=ANY(string_to_array($3, ',')::int[])
+				ORDER BY u.name`
+	rows, err := db.Query(query, domainId, STATUS_ACTIVE, strings.Join(ids, ","))
+	if err != nil {
+		fmt.Printf("PreviewGroupRule: [%d] %v\n", domainId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for {
+		user := readUserRow(rows, false)
+		if user == nil {
+			break
+		}
+		user.Attributes = attrs[user.ID]
+		preview.Users = append(preview.Users, user)
+	}
+	preview.Count = len(preview.Users)
+	return preview, nil
+}
+
+// Count the users the rules of the dynamic groups match
+func dynamicCounts(domainId int, groups []*model.Group2) {
+	dynamic := false
+	for _, group := range groups {
+		dynamic = dynamic || group.Rule != ""
+	}
+	if !dynamic {
+		return
+	}
+	counts := make(map[int]int)
+	for _, member := range strings.Split(dynamicMembers(domainId, 0), ",") {
+		if i := strings.IndexByte(member, ':'); i >= 0 {
+			group, _ := strconv.Atoi(member[i+1:])
+			counts[group]++
+		}
+	}
+	for _, group := range groups {
+		if group.Rule != "" {
+			group.Count = counts[group.ID]
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Effect of an access row. Precedence, for a user and an app, of the rows
+// in effect now:
+//
+//	user deny > user allow > group deny > group allow
+//
+// A deny of any group of the user wins over an allow of another of them
+const (
+	EFFECT_ALLOW = "allow"
+	EFFECT_DENY  = "deny"
+)
+
+// An access row, of a group unless group is 0
+type accessRow struct {
+	id    int
+	group int
+}
+
+// Decision for a user and an app
+type accessDecision struct {
+	allowed bool
+	// Allowed by a user row, else by the groups
+	direct bool
+	groups map[int]bool
+	// Rows deciding a deny, the user deny or the group denies
+	denies []accessRow
+}
+
+// Decisions for the users of a domain, and through which groups members
+// are allowed apps
+type effectiveAccess struct {
+	users  map[[2]int]*accessDecision
+	groups map[[2]int]bool
+}
+
+// The user is allowed the app
+func (e *effectiveAccess) allowed(userId int, appId int) bool {
+	d := e.users[[2]int{userId, appId}]
+	return d != nil && d.allowed
+}
+
+// The user row allowing the app is the one in effect
+func (e *effectiveAccess) userAllowed(userId int, appId int) bool {
+	d := e.users[[2]int{userId, appId}]
+	return d != nil && d.allowed && d.direct
+}
+
+// The group row allowing the app is in effect for some member
+func (e *effectiveAccess) groupAllowed(groupId int, appId int) bool {
+	return e.groups[[2]int{groupId, appId}]
+}
+
+// Resolve the access rows in effect now of the users of the domain, of the
+// user only unless userId is 0
+func selectEffectiveAccess(domainId int, userId int) (*effectiveAccess, error) {
+	db := setupDB()
+
+	query := `SELECT ua.id, ua.user_id, ua.app_id, 0, ua.effect, ua.valid_from, ua.valid_until, ua.schedule
+					FROM user_access_control ua JOIN users u ON ua.user_id=u.id
+					WHERE u.domain_id=$1 AND ($2=0 OR ua.user_id=$2) AND ua.status=$3
+				UNION ALL
+				SELECT ga.id, mem.user_id, ga.app_id, ga.group_id, ga.effect, ga.valid_from, ga.valid_until, ga.schedule
+					FROM group_access_control ga
+						JOIN user_groups ug ON ga.group_id=ug.id
+						JOIN ` + groupMembersAll(4) + ` mem ON ga.group_id=mem.group_id
+					WHERE ug.domain_id=$1 AND ($2=0 OR mem.user_id=$2) AND ga.status=$3
+				ORDER BY 1`
+	rows, err := db.Query(query, domainId, userId, STATUS_ACTIVE, dynamicMembers(domainId, userId))
+	if err != nil {
+		fmt.Printf("selectEffectiveAccess: [%d %d] %v\n", domainId, userId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	type access struct {
+		userAllow bool
+		userDeny  []accessRow
+		groupDeny []accessRow
+		groups    map[int]bool
+	}
+	found := make(map[[2]int]*access)
+	loc := domainLocation(domainId)
+	for rows.Next() {
+		var id, user, app, group int
+		var effect string
+		var from, until sql.NullTime
+		var sched sql.NullString
+		if err = rows.Scan(&id, &user, &app, &group, &effect, &from, &until, &sched); err != nil {
+			fmt.Printf("selectEffectiveAccess Scan: %v\n", err)
+			return nil, err
+		}
+		if !grantActive(from, until, sched, loc) {
+			continue
+		}
+		key := [2]int{user, app}
+		a := found[key]
+		if a == nil {
+			a = &access{groups: make(map[int]bool)}
+			found[key] = a
+		}
+		switch {
+		case group == 0 && effect == EFFECT_DENY:
+			a.userDeny = append(a.userDeny, accessRow{id, 0})
+		case group == 0:
+			a.userAllow = true
+		case effect == EFFECT_DENY:
+			a.groupDeny = append(a.groupDeny, accessRow{id, group})
+		default:
+			a.groups[group] = true
+		}
+	}
+
+	e := &effectiveAccess{users: make(map[[2]int]*accessDecision), groups: make(map[[2]int]bool)}
+	for key, a := range found {
+		d := &accessDecision{}
+		switch {
+		case len(a.userDeny) > 0:
+			d.denies = a.userDeny
+		case a.userAllow:
+			d.allowed, d.direct = true, true
+		case len(a.groupDeny) > 0:
+			d.denies = a.groupDeny
+		case len(a.groups) > 0:
+			d.allowed, d.groups = true, a.groups
+			for group := range a.groups {
+				e.groups[[2]int{group, key[1]}] = true
+			}
+		}
+		e.users[key] = d
+	}
+	return e, nil
+}
+
+// Apps denied to the user by the access rows in effect now, with the rows
+// deciding it, in app name order
+func SelectUserDenials(domainId int, userId int) ([]*model.AccessDenial, error) {
+	db := setupDB()
+
+	access, err := selectEffectiveAccess(domainId, userId)
+	if err != nil {
+		return nil, err
+	}
+	var appIds, groupIds []string
+	for key, d := range access.users {
+		if key[0] != userId || len(d.denies) == 0 {
+			continue
+		}
+		appIds = append(appIds, strconv.Itoa(key[1]))
+		for _, row := range d.denies {
+			if row.group != 0 {
+				groupIds = append(groupIds, strconv.Itoa(row.group))
+			}
+		}
+	}
+	denials := []*model.AccessDenial{}
+	if len(appIds) == 0 {
+		return denials, nil
+	}
+
+	groups := make(map[int]string)
+	if len(groupIds) > 0 {
+		query := `SELECT id, name FROM user_groups WHERE id=ANY(string_to_array($1, ',')::int[])`
+		rows, err := db.Query(query, strings.Join(groupIds, ","))
+		if err != nil {
+			fmt.Printf("SelectUserDenials: [%d %d] %v\n", domainId, userId, err)
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			var name string
+			if rows.Scan(&id, &name) == nil {
+				groups[id] = name
+			}
+		}
+		rows.Close()
+	}
+
+	query := `SELECT a.id, a.name, a.service_id, a.allowed_ips, s.name
+				FROM apps a JOIN services s ON a.service_id=s.id
+				WHERE s.domain_id=$1 AND a.status=$2 AND s.status=$2
+					AND a.id=ANY(string_to_array($3, ',')::int[])
+				ORDER BY a.name`
+	rows, err := db.Query(query, domainId, STATUS_ACTIVE, strings.Join(appIds, ","))
+	if err != nil {
+		fmt.Printf("SelectUserDenials: [%d %d] %v\n", domainId, userId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var apps []*model.App
+	for {
+		app := readAppRow(rows)
+		if app == nil {
+			break
+		}
+		apps = append(apps, app)
+	}
+	attachAppRules(apps)
+
+	for _, app := range apps {
+		rows := access.users[[2]int{userId, app.ID}].denies
+		sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+		for _, row := range rows {
+			denial := &model.AccessDenial{AccessID: row.id, Grant: "user", App: app}
+			if row.group != 0 {
+				denial.Grant, denial.Group = "group", groups[row.group]
+			}
+			denials = append(denials, denial)
+		}
+	}
+	return denials, nil
+}
@@ -7,14 +7,17 @@ import (
 	model "github.com/saroopmathur/rest-api/models"
 )
 
-func SelectGroupAccessAll() *[]model.GroupAccess2 {
+// Apps allowed to each group of the domain. Grants not in effect now are
+// left out unless all
+func SelectGroupAccessAll(domainId int, all bool) *[]model.GroupAccess2 {
 	db := setupDB()
 
+	loc := domainLocation(domainId)
 	query := `SELECT id, name
 				FROM user_groups
-				WHERE status=$1
+				WHERE status=$1 AND domain_id=$2
 				ORDER BY id`
-	rows, err := db.Query(query, STATUS_ACTIVE)
+	rows, err := db.Query(query, STATUS_ACTIVE, domainId)
 	if err != nil {
 		fmt.Printf("SelectGroupAccessAll: %v\n", err)
 		return nil
@@ -29,7 +32,7 @@ func SelectGroupAccessAll() *[]model.GroupAccess2 {
 		_ = rows.Scan(&gid, &gname)
 
 		// Get the list of allowed ip for each users
-		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed
+		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed, ga.effect, ga.valid_from, ga.valid_until, ga.schedule
 					FROM group_access_control ga INNER JOIN apps a ON ga.app_id=a.id
 					WHERE ga.group_id=$1 AND ga.status=$2`
 		rows2, err := db.Query(query, gid, STATUS_ACTIVE)
@@ -44,10 +47,16 @@ func SelectGroupAccessAll() *[]model.GroupAccess2 {
 			var aname string
 			var serviceId int
 			var allowed string
+			var effect string
+			var from, until sql.NullTime
+			var sched sql.NullString
 
-			_ = rows2.Scan(&aid, &aname, &serviceId, &allowed)
+			_ = rows2.Scan(&aid, &aname, &serviceId, &allowed, &effect, &from, &until, &sched)
 
-			apps = append(apps, model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed})
+			app := model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed, Effect: effect}
+			if a := accessApp(app, from, until, sched, loc, all); a != nil {
+				apps = append(apps, *a)
+			}
 		}
 
 		gacs = append(gacs, model.GroupAccess2{ID: gid, Group: gname, Apps: apps})
@@ -59,20 +68,23 @@ func SelectGroupAccessAll() *[]model.GroupAccess2 {
 	return &gacs
 }
 
-func SelectGroupAccess(did int, gname string, gid int) *[]model.App {
+// Apps allowed to the group. Grants not in effect now are left out unless
+// all
+func SelectGroupAccess(did int, gname string, gid int, all bool) *[]model.App {
 	db := setupDB()
 
 	var rows *sql.Rows
 	var err error
+	loc := domainLocation(did)
 
 	// Get the list of allowed ip for each users
 	if gid == 0 {
-		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed
+		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed, ga.effect, ga.valid_from, ga.valid_until, ga.schedule
 					FROM group_access_control ga INNER JOIN apps a ON ga.app_id=a.id
-					WHERE ga.user_id=(SELECT id FROM user_groups WHERE name=$1 AND domain_id=$2) AND ga.status=$3`
+					WHERE ga.group_id=(SELECT id FROM user_groups WHERE name=$1 AND domain_id=$2) AND ga.status=$3`
 		rows, err = db.Query(query, gname, did, STATUS_ACTIVE)
 	} else {
-		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed
+		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed, ga.effect, ga.valid_from, ga.valid_until, ga.schedule
 					FROM group_access_control ga INNER JOIN apps a ON ga.app_id=a.id
 					WHERE ga.group_id=$1 AND ga.status=$2`
 		rows, err = db.Query(query, gid, STATUS_ACTIVE)
@@ -89,10 +101,16 @@ func SelectGroupAccess(did int, gname string, gid int) *[]model.App {
 		var aname string
 		var serviceId int
 		var allowed string
+		var effect string
+		var from, until sql.NullTime
+		var sched sql.NullString
 
-		_ = rows.Scan(&aid, &aname, &serviceId, &allowed)
+		_ = rows.Scan(&aid, &aname, &serviceId, &allowed, &effect, &from, &until, &sched)
 
-		apps = append(apps, model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed})
+		app := model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed, Effect: effect}
+		if a := accessApp(app, from, until, sched, loc, all); a != nil {
+			apps = append(apps, *a)
+		}
 	}
 
 	// close database
@@ -101,41 +119,66 @@ func SelectGroupAccess(did int, gname string, gid int) *[]model.App {
 	return &apps
 }
 
-func InsertGac(domainId int, groupName string, groupId int, appName string, appId int) (*model.GroupAccess, error) {
+// effect allow or deny, grant limits when the access is in effect, nil -
+// permanent
+func InsertGac(domainId int, groupName string, groupId int, appName string, appId int, effect string, grant *model.AccessGrant) (*model.GroupAccess, error) {
 	db := setupDB()
 
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	var query string
-	var err error
 
 	var lastInsertID int
 	if groupId > 0 && appId > 0 {
 		query = `DELETE FROM group_access_control WHERE group_id=$1 AND app_id=$2`
-		db.Exec(query, groupId, appId)
+		_, err = tx.Exec(query, groupId, appId)
 
-		query = `INSERT INTO group_access_control (group_id, app_id, status)
-						VALUES ($1, $2, $3) returning id`
-		err = db.QueryRow(query, groupId, appId, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO group_access_control (group_id, app_id, status, effect, valid_from, valid_until, schedule)
+						VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+		if err == nil {
+			args := append([]interface{}{groupId, appId, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
 	} else if groupId > 0 {
 		query = `DELETE FROM group_access_control WHERE group_id=$1 AND app_id=(SELECT id FROM apps WHERE name=$2)`
-		db.Exec(query, groupId, appName)
+		_, err = tx.Exec(query, groupId, appName)
 
-		query = `INSERT INTO group_access_control (group_id, app_id, status)
-						VALUES ($1, (SELECT id FROM apps WHERE name=$2), $3) returning id`
-		err = db.QueryRow(query, groupId, appName, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO group_access_control (group_id, app_id, status, effect, valid_from, valid_until, schedule)
+						VALUES ($1, (SELECT id FROM apps WHERE name=$2), $3, $4, $5, $6, $7) returning id`
+		if err == nil {
+			args := append([]interface{}{groupId, appName, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
 	} else if appId > 0 {
 		query = `DELETE FROM group_access_control WHERE group_id=(SELECT id FROM user_groups WHERE domain_id=$1 AND name=$2) AND app_id=$3`
-		db.Exec(query, domainId, groupName, appId)
+		_, err = tx.Exec(query, domainId, groupName, appId)
 
-		query = `INSERT INTO group_access_control (group_id, app_id, status)
-						VALUES ((SELECT id FROM user_groups WHERE domain_id=$1 AND name=$2), $3, $4) returning id`
-		err = db.QueryRow(query, domainId, groupName, appId, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO group_access_control (group_id, app_id, status, effect, valid_from, valid_until, schedule)
+						VALUES ((SELECT id FROM user_groups WHERE domain_id=$1 AND name=$2), $3, $4, $5, $6, $7, $8) returning id`
+		if err == nil {
+			args := append([]interface{}{domainId, groupName, appId, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
 	} else {
 		query = `DELETE FROM group_access_control WHERE group_id=(SELECT id FROM user_groups WHERE domain_id=$1 AND name=$2) AND app_id=(SELECT id FROM apps WHERE name=$3)`
-		db.Exec(query, domainId, groupName, appName)
+		_, err = tx.Exec(query, domainId, groupName, appName)
 
-		query = `INSERT INTO group_access_control (group_id, app_id, status)
-						VALUES ((SELECT id FROM user_groups WHERE domain_id=$1 AND name=$2), (SELECT id FROM apps WHERE name=$3), $4) returning id`
-		err = db.QueryRow(query, domainId, groupName, appName, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO group_access_control (group_id, app_id, status, effect, valid_from, valid_until, schedule)
+						VALUES ((SELECT id FROM user_groups WHERE domain_id=$1 AND name=$2), (SELECT id FROM apps WHERE name=$3), $4, $5, $6, $7, $8) returning id`
+		if err == nil {
+			args := append([]interface{}{domainId, groupName, appName, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
 
 	// Select the inserted record and return
@@ -172,20 +215,25 @@ func SelectGacs() []model.GroupAccess {
 func SelectGac(gid int) *model.GroupAccess {
 	db := setupDB()
 
-	query := `SELECT id, group_id, app_id FROM group_access_control
+	query := `SELECT id, group_id, app_id, effect, valid_from, valid_until, schedule FROM group_access_control
 						WHERE status='A' AND id=$1`
 	rows, err := db.Query(query, gid)
 	checkErr(err)
+	defer rows.Close()
 
 	var gac *model.GroupAccess
 
 	var id int
 	var group int
 	var allowed int
+	var effect string
+	var from, until sql.NullTime
+	var sched sql.NullString
 
 	if rows.Next() {
-		_ = rows.Scan(&id, &group, &allowed)
-		gac = &model.GroupAccess{ID: id, Group: group, App: allowed}
+		_ = rows.Scan(&id, &group, &allowed, &effect, &from, &until, &sched)
+		gac = &model.GroupAccess{ID: id, Group: group, App: allowed, Effect: effect}
+		gac.Grant, _ = readGrant(from, until, sched)
 	}
 
 	return gac
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/saroopmathur/rest-api/config"
+	model "github.com/saroopmathur/rest-api/models"
+	"github.com/saroopmathur/rest-api/schedule"
+)
+
+// How often grants past valid_until are marked deleted, 0 - never. They
+// are not in effect from valid_until on either way
+var GRANT_EXPIRY_INTERVAL = time.Minute
+
+func loadGrantConfig() {
+	config.Duration("GRANT_EXPIRY_INTERVAL", &GRANT_EXPIRY_INTERVAL)
+}
+
+// Timezone of the schedules of the grants of the domain
+func domainLocation(domainId int) *time.Location {
+	db := setupDB()
+
+	var timezone sql.NullString
+	err := db.QueryRow("SELECT timezone FROM domains WHERE id=$1", domainId).Scan(&timezone)
+	if err != nil {
+		fmt.Printf("domainLocation: [%d] %v\n", domainId, err)
+	}
+	return schedule.Location(timezone.String)
+}
+
+// Limits of an access row, nil if permanent
+func readGrant(from sql.NullTime, until sql.NullTime, sched sql.NullString) (*model.AccessGrant, error) {
+	if !from.Valid && !until.Valid && !sched.Valid {
+		return nil, nil
+	}
+	grant := &model.AccessGrant{}
+	if from.Valid {
+		grant.ValidFrom = &from.Time
+	}
+	if until.Valid {
+		grant.ValidUntil = &until.Time
+	}
+	var err error
+	grant.Schedule, err = schedule.Decode(sched.String)
+	return grant, err
+}
+
+// The access row is in effect now. A schedule that can not be read keeps
+// it closed
+func grantActive(from sql.NullTime, until sql.NullTime, sched sql.NullString, loc *time.Location) bool {
+	grant, err := readGrant(from, until, sched)
+	if err != nil {
+		fmt.Printf("grantActive: %v\n", err)
+		return false
+	}
+	return schedule.Active(grant, time.Now(), loc)
+}
+
+// App of an access listing with the limits of its grant, nil if the grant
+// is not in effect now unless all
+func accessApp(app model.App, from sql.NullTime, until sql.NullTime, sched sql.NullString, loc *time.Location, all bool) *model.App {
+	grant, err := readGrant(from, until, sched)
+	active := err == nil && schedule.Active(grant, time.Now(), loc)
+	if !active && !all {
+		return nil
+	}
+	app.Grant = grant
+	if grant != nil {
+		app.Active = &active
+	}
+	return &app
+}
+
+// Values of the effect, valid_from, valid_until and schedule columns of a
+// new access row, written with the row so it never exists without them
+func grantColumns(effect string, grant *model.AccessGrant) []interface{} {
+	if effect != EFFECT_DENY {
+		effect = EFFECT_ALLOW
+	}
+	if grant == nil {
+		return []interface{}{effect, nil, nil, nil}
+	}
+	return []interface{}{effect, grant.ValidFrom, grant.ValidUntil, schedule.Encode(grant.Schedule)}
+}
+
+// Domains with user or group grants past valid_until not yet marked
+// deleted
+func SelectExpiredGrantDomains() ([]int, error) {
+	db := setupDB()
+
+	query := `SELECT u.domain_id FROM user_access_control ua JOIN users u ON ua.user_id=u.id
+					WHERE ua.status=$1 AND ua.valid_until<=$2
+				UNION
+				SELECT g.domain_id FROM group_access_control ga JOIN user_groups g ON ga.group_id=g.id
+					WHERE ga.status=$1 AND ga.valid_until<=$2
+				ORDER BY 1`
+	rows, err := db.Query(query, STATUS_ACTIVE, time.Now())
+	if err != nil {
+		fmt.Printf("SelectExpiredGrantDomains: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []int
+	for rows.Next() {
+		var domainId int
+		if err = rows.Scan(&domainId); err != nil {
+			return nil, err
+		}
+		domains = append(domains, domainId)
+	}
+	return domains, nil
+}
+
+// Mark the user and group grants of the domain past valid_until deleted,
+// returns how many
+func ExpireGrants(domainId int) (int, error) {
+	db := setupDB()
+
+	queries := []string{
+		`UPDATE user_access_control ua SET status=$1 FROM users u
+			WHERE ua.user_id=u.id AND u.domain_id=$2 AND ua.status=$3 AND ua.valid_until<=$4`,
+		`UPDATE group_access_control ga SET status=$1 FROM user_groups g
+			WHERE ga.group_id=g.id AND g.domain_id=$2 AND ga.status=$3 AND ga.valid_until<=$4`,
+	}
+	var count int64
+	now := time.Now()
+	for _, query := range queries {
+		result, err := db.Exec(query, STATUS_DELETED, domainId, STATUS_ACTIVE, now)
+		if err != nil {
+			return int(count), err
+		}
+		n, _ := result.RowsAffected()
+		count += n
+	}
+	return int(count), nil
+}
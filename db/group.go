@@ -12,10 +12,14 @@ func InsertGroup(domainId int, group *model.Group) (*model.Group2, error) {
 	db := setupDB()
 
 	var lastInsertID int
-	query := `INSERT INTO user_groups (name, domain_id, status)
-						VALUES($1, $2, $3) returning id;`
+	var rule *string
+	if group.Rule != nil && *group.Rule != "" {
+		rule = group.Rule
+	}
+	query := `INSERT INTO user_groups (name, domain_id, status, rule)
+						VALUES($1, $2, $3, $4) returning id;`
 	fmt.Printf("CreateGroup %s domain=%d\n", group.Name, domainId)
-	err := db.QueryRow(query, group.Name, domainId, STATUS_ACTIVE).Scan(&lastInsertID)
+	err := db.QueryRow(query, group.Name, domainId, STATUS_ACTIVE, rule).Scan(&lastInsertID)
 	if err != nil {
 		fmt.Printf("CreateGroup %s domain=%d %v\n", group.Name, domainId, err)
 		return nil, err
@@ -29,7 +33,7 @@ func InsertGroup(domainId int, group *model.Group) (*model.Group2, error) {
 func SelectGroups(domainId int) []*model.Group2 {
 	db := setupDB()
 
-	query := `SELECT g.id, g.name, COALESCE(c.cnt, 0), g.domain_id, d.name, d.status
+	query := `SELECT g.id, g.name, COALESCE(c.cnt, 0), g.domain_id, d.name, d.status, g.rule
 						FROM user_groups g LEFT JOIN domains d ON g.domain_id=d.id
 						LEFT JOIN (SELECT group_id, COUNT(*) AS cnt FROM group_members GROUP BY group_id) c ON g.id=c.group_id
 						WHERE g.domain_id=$1 AND g.status=$2
@@ -50,6 +54,7 @@ func SelectGroups(domainId int) []*model.Group2 {
 		}
 		groups = append(groups, group)
 	}
+	dynamicCounts(domainId, groups)
 
 	return groups
 }
@@ -62,13 +67,13 @@ func SelectGroup(domainId int, groupName string, groupId int) *model.Group2 {
 	var err error
 	var query string
 	if groupId > 0 {
-		query = `SELECT g.id, g.name, COALESCE(c.cnt, 0), g.domain_id, d.name, d.status
+		query = `SELECT g.id, g.name, COALESCE(c.cnt, 0), g.domain_id, d.name, d.status, g.rule
 						FROM user_groups g LEFT JOIN domains d ON g.domain_id=d.id
 						LEFT JOIN (SELECT group_id, COUNT(*) AS cnt FROM group_members GROUP BY group_id) c ON g.id=c.group_id
 						WHERE g.domain_id=$1 AND g.status=$2 AND g.id=$3`
 		rows, err = db.Query(query, domainId, STATUS_ACTIVE, groupId)
 	} else {
-		query = `SELECT g.id, g.name, COALESCE(c.cnt, 0), g.domain_id, d.name, d.status
+		query = `SELECT g.id, g.name, COALESCE(c.cnt, 0), g.domain_id, d.name, d.status, g.rule
 						FROM user_groups g LEFT JOIN domains d ON g.domain_id=d.id
 						LEFT JOIN (SELECT group_id, COUNT(*) AS cnt FROM group_members GROUP BY group_id) c ON g.id=c.group_id
 						WHERE g.domain_id=$1 AND g.status=$2 AND g.name=$3`
@@ -85,6 +90,9 @@ func SelectGroup(domainId int, groupName string, groupId int) *model.Group2 {
 
 	rows.Close()
 
+	if group != nil && group.Rule != "" {
+		dynamicCounts(domainId, []*model.Group2{group})
+	}
 	return group
 }
 
@@ -99,11 +107,21 @@ func UpdateGroup(domainId int, groupName string, groupId int, group *model.Group
 	var result sql.Result
 	var params string
 
+	if group.Rule != nil {
+		// Before a rename, the group is still found by groupName
+		if err = updateGroupRule(domainId, groupName, groupId, *group.Rule); err != nil {
+			return nil
+		}
+	}
+
 	if group.Name != "" {
 		params += "name='" + group.Name + "', "
 	}
 	if params == "" {
-		// Nothing to update
+		// Nothing else to update
+		if group.Rule != nil {
+			return SelectGroup(domainId, groupName, groupId)
+		}
 		return nil
 	}
 	params = params[:len(params)-2]
@@ -132,7 +150,29 @@ func UpdateGroup(domainId int, groupName string, groupId int, group *model.Group
 	return SelectGroup(domainId, groupName, groupId)
 }
 
-// Return all users of the specified group
+// Save the rule of the group, "" makes it static. Static members of a
+// group that becomes dynamic are removed, the rule alone defines them
+func updateGroupRule(domainId int, groupName string, groupId int, rule string) error {
+	db := setupDB()
+
+	group := SelectGroup(domainId, groupName, groupId)
+	if group == nil {
+		return fmt.Errorf("group %s %d unknown", groupName, groupId)
+	}
+	_, err := db.Exec("UPDATE user_groups SET rule=NULLIF($1, '') WHERE id=$2 AND domain_id=$3",
+		rule, group.ID, domainId)
+	if err != nil {
+		fmt.Printf("Update Group rule %s %d domain %d - %v\n", groupName, groupId, domainId, err)
+		return err
+	}
+	if rule != "" {
+		RemoveAllMembers(group.ID)
+	}
+	return nil
+}
+
+// Return all users of the specified group, including those of its child
+// groups
 func GetGroupUsers(domainId int, groupName string, groupId int) []*model.User2 {
 	db := setupDB()
 
@@ -142,35 +182,43 @@ func GetGroupUsers(domainId int, groupName string, groupId int) []*model.User2 {
 	var resp []*model.User2
 
 	if groupId > 0 {
-		query = `SELECT u.id, u.name, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status
-						FROM user_groups g, users u, group_members members
-						LEFT JOIN domains d ON g.domain_id=d.id
+		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status, mg.id, mg.name
+						FROM users u, user_groups mg, ` + groupMembersAll(4) + ` members,
+							user_groups g LEFT JOIN domains d ON g.domain_id=d.id
 						WHERE u.id=members.user_id
 							AND g.domain_id=$1
 							AND g.status=$2
 							AND members.group_id=g.id
-							AND g.id=$3`
-		rows, err = db.Query(query, domainId, STATUS_ACTIVE, groupId)
+							AND members.member_of=mg.id
+							AND g.id=$3
+						ORDER BY u.name, mg.name`
+		rows, err = db.Query(query, domainId, STATUS_ACTIVE, groupId, dynamicMembers(domainId, 0))
 	} else {
-		query = `SELECT u.id, u.name, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status
-						FROM user_groups g, users u, group_members members
-						LEFT JOIN domains d ON g.domain_id=d.id
+		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status, mg.id, mg.name
+						FROM users u, user_groups mg, ` + groupMembersAll(4) + ` members,
+							user_groups g LEFT JOIN domains d ON g.domain_id=d.id
 						WHERE u.id=members.user_id
 							AND g.domain_id=$1
 							AND g.status=$2
 							AND members.group_id=g.id
-							AND g.name=$3`
-		rows, err = db.Query(query, domainId, STATUS_ACTIVE, groupName)
+							AND members.member_of=mg.id
+							AND g.name=$3
+						ORDER BY u.name, mg.name`
+		rows, err = db.Query(query, domainId, STATUS_ACTIVE, groupName, dynamicMembers(domainId, 0))
 	}
 	if err != nil {
 		return nil
 	}
+	seen := make(map[int]bool)
 	for {
 		user := readUserRow(rows, true)
 		if user == nil {
 			break
 		}
-		resp = append(resp, user)
+		if !seen[user.ID] {
+			seen[user.ID] = true
+			resp = append(resp, user)
+		}
 	}
 	rows.Close()
 	return resp
@@ -205,6 +253,7 @@ func DeleteGroup(domainId int, groupName string, groupId int) (*model.Group2, in
 	// Delete all group memebers
 	//
 	rowsAffected := RemoveAllMembers(groupId)
+	removeGroupEdges(groupId)
 
 	return deleted_group, rowsAffected
 }
@@ -216,17 +265,18 @@ func readGroupRow(rows *sql.Rows) *model.Group2 {
 	var cnt int
 	var dname sql.NullString
 	var dstatus sql.NullString
+	var rule sql.NullString
 
 	if !rows.Next() {
 		return nil
 	}
 
-	err := rows.Scan(&id, &name, &cnt, &domainId, &dname, &dstatus)
+	err := rows.Scan(&id, &name, &cnt, &domainId, &dname, &dstatus, &rule)
 	if err != nil {
 		return nil
 	}
 
 	domain := model.Domain{ID: domainId, Name: dname.String, Status: dstatus.String}
-	group := model.Group2{ID: id, Name: name.String, Count: cnt, Domain: domain}
+	group := model.Group2{ID: id, Name: name.String, Count: cnt, Domain: domain, Rule: rule.String}
 	return &group
 }
@@ -2,11 +2,33 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
 	model "github.com/saroopmathur/rest-api/models"
 )
 
+// Advisory lock serializing the child group edits of a domain, with the
+// domain id as second key
+const GROUP_LOCK = 2
+
+var ErrGroupCycle = errors.New("group membership cycle")
+
+// Users of each group, directly, by the rule of a dynamic group or through
+// its child groups at any depth, with the group they are a direct member
+// of. A subquery of (user_id, group_id, member_of) rows, UNION stops on
+// cycles. Query parameter $param is dynamicMembers of the domain
+func groupMembersAll(param int) string {
+	return fmt.Sprintf(`(WITH RECURSIVE m(user_id, group_id, member_of) AS (
+					SELECT user_id, group_id, group_id FROM group_members
+					UNION
+					SELECT split_part(dm, ':', 1)::integer, split_part(dm, ':', 2)::integer, split_part(dm, ':', 2)::integer
+						FROM unnest(string_to_array($%d::text, ',')) dm
+					UNION
+					SELECT m.user_id, c.group_id, m.member_of FROM group_children c JOIN m ON c.child_id=m.group_id)
+				SELECT user_id, group_id, member_of FROM m)`, param)
+}
+
 func addGroupMember(domainId int, groupName string, groupId int, username string) error {
 	db := setupDB()
 
@@ -18,14 +40,14 @@ func addGroupMember(domainId int, groupName string, groupId int, username string
 				SELECT g.id, u.id from user_groups g, users u
 					WHERE u.name=$1 AND g.id=$2
 						AND g.domain_id=$3 AND u.domain_id=$3
-						AND u.status=$4 AND g.status=$4 returning id`
+						AND u.status=$4 AND g.status=$4 AND g.rule IS NULL returning id`
 		err = db.QueryRow(query, username, groupId, domainId, STATUS_ACTIVE).Scan(&lastInsertID)
 	} else {
 		query = `INSERT INTO group_members (group_id, user_id)
 				SELECT g.id, u.id from user_groups g, users u
 					WHERE u.name=$1 AND g.name=$2
 						AND g.domain_id=$3 AND u.domain_id=$3
-						AND u.status=$4 AND g.status=$4 returning id`
+						AND u.status=$4 AND g.status=$4 AND g.rule IS NULL returning id`
 		err = db.QueryRow(query, username, groupName, domainId, STATUS_ACTIVE).Scan(&lastInsertID)
 	}
 	fmt.Printf("addGroupMember [%s %d %d] %s %v\n", groupName, groupId, domainId, username, err)
@@ -89,6 +111,7 @@ func RemoveGroupMembers(domainId int, groupName string, groupId int, users []str
 	return rowsAffected
 }
 
+// Users of the group, including those of its child groups
 func SelectGroupMembers(domainId int, groupName string, groupId int) []*model.User2 {
 	var rows *sql.Rows
 	var err error
@@ -97,22 +120,26 @@ func SelectGroupMembers(domainId int, groupName string, groupId int) []*model.Us
 	db := setupDB()
 	if groupId == 0 {
 		// Select by group name
-		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status, g.id, g.name
-				FROM user_groups g, group_members mem, users u LEFT JOIN domains d ON u.domain_id=d.id
-				WHERE g.name=$1
-					AND mem.group_id=g.id 
+		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status, mg.id, mg.name
+				FROM user_groups g, ` + groupMembersAll(4) + ` mem, user_groups mg, users u LEFT JOIN domains d ON u.domain_id=d.id
+				WHERE g.name=$1 AND g.domain_id=$2
+					AND mem.group_id=g.id
+					AND mem.member_of=mg.id
 					AND mem.user_id=u.id
-					AND u.domain_id=$2 AND u.status=$3`
-		rows, err = db.Query(query, groupName, domainId, STATUS_ACTIVE)
+					AND u.domain_id=$2 AND u.status=$3
+				ORDER BY u.name, mg.name`
+		rows, err = db.Query(query, groupName, domainId, STATUS_ACTIVE, dynamicMembers(domainId, 0))
 	} else {
 		// Select by group id
-		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status, g.id, g.name
-				FROM user_groups g, group_members mem, users u LEFT JOIN domains d ON u.domain_id=d.id
-				WHERE g.id=$1
-					AND mem.group_id=g.id 
+		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status, mg.id, mg.name
+				FROM user_groups g, ` + groupMembersAll(4) + ` mem, user_groups mg, users u LEFT JOIN domains d ON u.domain_id=d.id
+				WHERE g.id=$1 AND g.domain_id=$2
+					AND mem.group_id=g.id
+					AND mem.member_of=mg.id
 					AND mem.user_id=u.id
-					AND u.domain_id=$2 AND u.status=$3`
-		rows, err = db.Query(query, groupId, domainId, STATUS_ACTIVE)
+					AND u.domain_id=$2 AND u.status=$3
+				ORDER BY u.name, mg.name`
+		rows, err = db.Query(query, groupId, domainId, STATUS_ACTIVE, dynamicMembers(domainId, 0))
 	}
 	if err != nil {
 		fmt.Printf("SelectGroupMembers: [%s %d %d] %v\n", groupName, groupId, domainId, err)
@@ -120,18 +147,24 @@ func SelectGroupMembers(domainId int, groupName string, groupId int) []*model.Us
 	}
 	defer rows.Close()
 
+	// A user in several child groups is listed once, with the first of them
 	var users []*model.User2
+	seen := make(map[int]bool)
 	for {
 		user := readUserRow(rows, true)
 		if user == nil {
 			break
 		}
-		users = append(users, user)
+		if !seen[user.ID] {
+			seen[user.ID] = true
+			users = append(users, user)
+		}
 	}
 	fmt.Printf("SelectGroupMembers: [%s %d %d] Read %d members\n", groupName, groupId, domainId, len(users))
 	return users
 }
 
+// Groups of the user, including the parent groups of its groups
 func GetUserGroups(domainId int, userName string, userId int) []*model.Group2 {
 	var rows *sql.Rows
 	var err error
@@ -141,25 +174,23 @@ func GetUserGroups(domainId int, userName string, userId int) []*model.Group2 {
 
 	if userId == 0 {
 		// Lookup by userName
-		query = `SELECT g.id, g.name, 0, g.domain_id, d.name, d.status
-				FROM user_groups g, group_members member, users u LEFT JOIN domains d ON u.domain_id=d.id
-				WHERE u.name=$1
-					AND member.user_id=u.id
-					AND g.id=member.group_id
+		query = `SELECT g.id, g.name, 0, g.domain_id, d.name, d.status, g.rule
+				FROM user_groups g LEFT JOIN domains d ON g.domain_id=d.id
+				WHERE g.id IN (SELECT mem.group_id FROM ` + groupMembersAll(4) + ` mem, users u
+							WHERE u.name=$1 AND u.domain_id=$2 AND mem.user_id=u.id)
 					AND g.domain_id=$2
 					AND g.status=$3
 				ORDER BY g.name`
-		rows, err = db.Query(query, userName, domainId, STATUS_ACTIVE)
+		rows, err = db.Query(query, userName, domainId, STATUS_ACTIVE, dynamicMembers(domainId, 0))
 	} else {
 		// Lookup by userId
-		query = `SELECT g.id, g.name, 0, g.domain_id, d.name, d.status
-				FROM group_members member, user_groups g LEFT JOIN domains d ON g.domain_id=d.id
-				WHERE member.user_id=$1
-					AND g.id=member.group_id
+		query = `SELECT g.id, g.name, 0, g.domain_id, d.name, d.status, g.rule
+				FROM user_groups g LEFT JOIN domains d ON g.domain_id=d.id
+				WHERE g.id IN (SELECT mem.group_id FROM ` + groupMembersAll(4) + ` mem WHERE mem.user_id=$1)
 					AND g.domain_id=$2
 					AND g.status=$3
 				ORDER BY g.name`
-		rows, err = db.Query(query, userId, domainId, STATUS_ACTIVE)
+		rows, err = db.Query(query, userId, domainId, STATUS_ACTIVE, dynamicMembers(domainId, userId))
 	}
 	if err != nil {
 		fmt.Printf("GetUserGroups: [%s %d %d] %v\n", userName, userId, domainId, err)
@@ -193,3 +224,128 @@ func RemoveAllMembers(groupId int) int {
 	fmt.Printf("Deleted all members of group %d - %d rows affected\n", groupId, rowsAffected)
 	return int(rowsAffected)
 }
+
+// Add the child groups to the group, their members become members of it.
+// All or none are added, refused if one would create a cycle
+func AddGroupChildren(domainId int, groupName string, groupId int, children []string) (int, error) {
+	db := setupDB()
+
+	parent := SelectGroup(domainId, groupName, groupId)
+	if parent == nil {
+		return 0, fmt.Errorf("group %s %d unknown", groupName, groupId)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, GROUP_LOCK, domainId); err != nil {
+		return 0, err
+	}
+
+	var addCount int
+	for _, childName := range children {
+		child := SelectGroup(domainId, childName, 0)
+		if child == nil {
+			return 0, fmt.Errorf("group %s unknown", childName)
+		}
+
+		// A cycle if the parent is the child or one of its descendants
+		var cycle bool
+		query := `WITH RECURSIVE d(id) AS (
+						SELECT $1::integer
+						UNION
+						SELECT c.child_id FROM group_children c JOIN d ON c.group_id=d.id)
+					SELECT EXISTS (SELECT 1 FROM d WHERE id=$2)`
+		if err = tx.QueryRow(query, child.ID, parent.ID).Scan(&cycle); err != nil {
+			return 0, err
+		}
+		if cycle {
+			return 0, fmt.Errorf("%w: group %s contains %s", ErrGroupCycle, child.Name, parent.Name)
+		}
+
+		query = `INSERT INTO group_children (group_id, child_id) VALUES ($1, $2)
+					ON CONFLICT DO NOTHING`
+		result, err := tx.Exec(query, parent.ID, child.ID)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		addCount += int(n)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	fmt.Printf("AddGroupChildren [%s %d %d] %v - %d added\n", groupName, groupId, domainId, children, addCount)
+	return addCount, nil
+}
+
+// Remove the child groups from the group
+func RemoveGroupChildren(domainId int, groupName string, groupId int, children []string) int {
+	db := setupDB()
+
+	parent := SelectGroup(domainId, groupName, groupId)
+	if parent == nil {
+		return 0
+	}
+
+	var rowsAffected int
+	for _, childName := range children {
+		query := `DELETE FROM group_children WHERE group_id=$1
+					AND child_id=(SELECT id FROM user_groups WHERE name=$2 AND domain_id=$3 AND status=$4)`
+		result, err := db.Exec(query, parent.ID, childName, domainId, STATUS_ACTIVE)
+		if err != nil {
+			fmt.Printf("Remove group %s from group [%s %d] Domain:%d - %v\n",
+				childName, groupName, groupId, domainId, err)
+			continue
+		}
+		n, _ := result.RowsAffected()
+		rowsAffected += int(n)
+	}
+	return rowsAffected
+}
+
+// Child groups of the group
+func SelectGroupChildren(domainId int, groupName string, groupId int) []*model.Group2 {
+	db := setupDB()
+
+	parent := SelectGroup(domainId, groupName, groupId)
+	if parent == nil {
+		return nil
+	}
+
+	query := `SELECT g.id, g.name, COALESCE(cnt.cnt, 0), g.domain_id, d.name, d.status, g.rule
+				FROM group_children c JOIN user_groups g ON c.child_id=g.id
+					LEFT JOIN domains d ON g.domain_id=d.id
+					LEFT JOIN (SELECT group_id, COUNT(*) AS cnt FROM group_members GROUP BY group_id) cnt ON g.id=cnt.group_id
+				WHERE c.group_id=$1 AND g.status=$2
+				ORDER BY g.name`
+	rows, err := db.Query(query, parent.ID, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("SelectGroupChildren: [%s %d %d] %v\n", groupName, groupId, domainId, err)
+		return nil
+	}
+	defer rows.Close()
+
+	groups := []*model.Group2{}
+	for {
+		group := readGroupRow(rows)
+		if group == nil {
+			break
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Detach a deleted group from its parent and child groups
+func removeGroupEdges(groupId int) {
+	db := setupDB()
+
+	_, err := db.Exec("DELETE FROM group_children WHERE group_id=$1 OR child_id=$1", groupId)
+	if err != nil {
+		fmt.Printf("removeGroupEdges: [%d] %v\n", groupId, err)
+	}
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/saroopmathur/rest-api/ipam"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Advisory lock serializing the virtual IP assignments of a domain, with
+// the domain id as second key
+const IPAM_LOCK = 1
+
+var ErrVirtualIPConflict = errors.New("virtual ip already assigned")
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Virtual IPs of the users, devices and services of the domain. Deleted
+// ones have released theirs
+func selectVirtualIPs(q querier, domainId int) ([]*model.IPAssignment, error) {
+	query := `SELECT host(virtual_ip), 'user', id, name FROM users
+					WHERE domain_id=$1 AND status<>$2 AND virtual_ip IS NOT NULL
+				UNION ALL
+				SELECT host(virtual_ip), 'service', id, name FROM services
+					WHERE domain_id=$1 AND status<>$2 AND virtual_ip IS NOT NULL
+				UNION ALL
+				SELECT host(d.virtual_ip), 'device', d.id, u.name || '/' || d.name
+					FROM devices d JOIN users u ON d.user_id=u.id
+					WHERE u.domain_id=$1 AND d.status<>$2 AND d.virtual_ip IS NOT NULL
+				ORDER BY 1`
+	rows, err := q.Query(query, domainId, STATUS_DELETED)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assigned []*model.IPAssignment
+	for rows.Next() {
+		a := &model.IPAssignment{}
+		if err = rows.Scan(&a.VirtualIP, &a.Kind, &a.ID, &a.Name); err != nil {
+			return nil, err
+		}
+		assigned = append(assigned, a)
+	}
+	return assigned, rows.Err()
+}
+
+// Give the user, device or service (kind) the virtual IP vip, or the next
+// free one of the pool of the domain if vip is empty. Without a pool
+// nothing is handed out
+func assignVirtualIP(domainId int, kind string, id int, vip string) (string, error) {
+	db := setupDB()
+
+	var ip net.IP
+	if vip != "" {
+		if i := strings.IndexByte(vip, '/'); i >= 0 {
+			vip = vip[:i]
+		}
+		if ip = net.ParseIP(vip); ip == nil {
+			return "", fmt.Errorf("invalid virtual ip %s", vip)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, IPAM_LOCK, domainId); err != nil {
+		return "", err
+	}
+
+	var pool sql.NullString
+	err = tx.QueryRow(`SELECT ip_pool FROM domains WHERE id=$1`, domainId).Scan(&pool)
+	if err != nil {
+		return "", err
+	}
+	if ip == nil && !pool.Valid {
+		return "", nil
+	}
+
+	assigned, err := selectVirtualIPs(tx, domainId)
+	if err != nil {
+		return "", err
+	}
+
+	if ip == nil {
+		p, err := ipam.ParsePool(pool.String)
+		if err != nil {
+			return "", err
+		}
+		var used []net.IP
+		for _, a := range assigned {
+			used = append(used, net.ParseIP(a.VirtualIP))
+		}
+		if ip, err = ipam.Next(p, used); err != nil {
+			return "", fmt.Errorf("domain %d: %v", domainId, err)
+		}
+	} else {
+		for _, a := range assigned {
+			if (a.Kind != kind || a.ID != id) && ip.Equal(net.ParseIP(a.VirtualIP)) {
+				return "", fmt.Errorf("%w: %s is the virtual ip of %s %s", ErrVirtualIPConflict, ip, a.Kind, a.Name)
+			}
+		}
+	}
+
+	query := fmt.Sprintf("UPDATE %ss SET virtual_ip=$1 WHERE id=$2", kind)
+	if _, err = tx.Exec(query, ip.String(), id); err != nil {
+		return "", err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	notifyPolicyChange(domainId)
+	return ip.String(), nil
+}
+
+// Manually assign the virtual IP of the user, refused if another user,
+// device or service of the domain has it
+func SetUserVirtualIP(domainId int, userName string, userId int, vip string) error {
+	u := SelectUser(domainId, userName, userId)
+	if u == nil {
+		return fmt.Errorf("username '%s' invalid", userName)
+	}
+	_, err := assignVirtualIP(domainId, "user", u.ID, vip)
+	return err
+}
+
+// Manually assign the virtual IP of the service, refused if another user,
+// device or service of the domain has it
+func SetServiceVirtualIP(domainId int, serviceName string, serviceId int, vip string) error {
+	s := SelectService(domainId, serviceName, serviceId)
+	if s == nil {
+		return fmt.Errorf("service '%s' invalid", serviceName)
+	}
+	_, err := assignVirtualIP(domainId, "service", s.ID, vip)
+	return err
+}
+
+// Utilization of the virtual IP pool of the domain
+func SelectIPPool(domainId int) (*model.IPPool, error) {
+	db := setupDB()
+
+	domain := SelectDomain(domainId, "")
+	if domain == nil {
+		return nil, fmt.Errorf("domain %d unknown", domainId)
+	}
+	if domain.IPPool == "" {
+		return nil, fmt.Errorf("domain %s has no ip pool", domain.Name)
+	}
+	pool, err := ipam.ParsePool(domain.IPPool)
+	if err != nil {
+		return nil, err
+	}
+
+	assigned, err := selectVirtualIPs(db, domainId)
+	if err != nil {
+		fmt.Printf("SelectIPPool: [%d] %v\n", domainId, err)
+		return nil, err
+	}
+
+	resp := &model.IPPool{Pool: pool.String(), Size: ipam.Size(pool), Addresses: assigned}
+	if resp.Addresses == nil {
+		resp.Addresses = []*model.IPAssignment{}
+	}
+	for _, a := range assigned {
+		if ipam.Contains(pool, net.ParseIP(a.VirtualIP)) {
+			resp.Used++
+		} else {
+			resp.Outside++
+		}
+	}
+	resp.Free = new(big.Int).Sub(resp.Size, big.NewInt(int64(resp.Used)))
+	ratio, _ := new(big.Float).Quo(big.NewFloat(float64(resp.Used)), new(big.Float).SetInt(resp.Size)).Float64()
+	resp.Utilization = float64(int64(ratio*10000+0.5)) / 100
+	return resp, nil
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/saroopmathur/rest-api/auth"
+	"github.com/saroopmathur/rest-api/config"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+var LDAP_SYNC_INTERVAL = 15 * time.Minute
+
+func loadLDAPConfig() {
+	config.Duration("LDAP_SYNC_INTERVAL", &LDAP_SYNC_INTERVAL)
+}
+
+// LDAP directory of the domain, nil if none. The bind password is stored
+// sealed with SECRET_KEY, one saved in plaintext before is sealed here
+func GetLDAPConfig(domainId int) *model.LDAPConfig {
+	db := setupDB()
+
+	var cfg model.LDAPConfig
+	var groupBase, groupFilter, groupAttr, memberAttr sql.NullString
+	query := `SELECT domain_id, url, start_tls, bind_dn, bind_password, base_dn, user_filter, user_attr,
+					group_base_dn, group_filter, group_attr, member_attr, sync
+				FROM domain_ldap WHERE domain_id=$1`
+	err := db.QueryRow(query, domainId).Scan(&cfg.DomainID, &cfg.URL, &cfg.StartTLS, &cfg.BindDN, &cfg.BindPassword,
+		&cfg.BaseDN, &cfg.UserFilter, &cfg.UserAttr, &groupBase, &groupFilter, &groupAttr, &memberAttr, &cfg.Sync)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("GetLDAPConfig: domain %d %v\n", domainId, err)
+		}
+		return nil
+	}
+	cfg.GroupBaseDN = groupBase.String
+	cfg.GroupFilter = groupFilter.String
+	cfg.GroupAttr = groupAttr.String
+	cfg.MemberAttr = memberAttr.String
+
+	if !strings.HasPrefix(cfg.BindPassword, auth.SEALED_V1) {
+		sealLDAPPassword(domainId, cfg.BindPassword)
+		return &cfg
+	}
+	cfg.BindPassword, err = auth.Unseal(cfg.BindPassword)
+	if err != nil {
+		log.Printf("GetLDAPConfig: domain %d bind password %v\n", domainId, err)
+		return nil
+	}
+	return &cfg
+}
+
+// Seal a bind password saved in plaintext, if it is still the same
+func sealLDAPPassword(domainId int, password string) {
+	db := setupDB()
+
+	sealed, err := auth.Seal(password)
+	if err != nil {
+		log.Printf("sealLDAPPassword: domain %d %v\n", domainId, err)
+		return
+	}
+	query := `UPDATE domain_ldap SET bind_password=$1 WHERE domain_id=$2 AND bind_password=$3`
+	_, err = db.Exec(query, sealed, domainId, password)
+	if err != nil {
+		log.Printf("sealLDAPPassword: domain %d %v\n", domainId, err)
+	}
+}
+
+// Create or replace the LDAP directory of the domain. An empty bind
+// password keeps the one saved
+func SaveLDAPConfig(domainId int, cfg *model.LDAPConfig) error {
+	db := setupDB()
+
+	password := ""
+	if cfg.BindPassword != "" {
+		var err error
+		password, err = auth.Seal(cfg.BindPassword)
+		if err != nil {
+			log.Printf("SaveLDAPConfig: domain %d %v\n", domainId, err)
+			return fmt.Errorf("bind password can not be stored: %v", err)
+		}
+	}
+
+	query := `INSERT INTO domain_ldap (domain_id, url, start_tls, bind_dn, bind_password, base_dn, user_filter, user_attr,
+					group_base_dn, group_filter, group_attr, member_attr, sync)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+				ON CONFLICT (domain_id) DO UPDATE SET
+					url=$2, start_tls=$3, bind_dn=$4,
+					bind_password=CASE WHEN $5='' THEN domain_ldap.bind_password ELSE $5 END,
+					base_dn=$6, user_filter=$7, user_attr=$8,
+					group_base_dn=$9, group_filter=$10, group_attr=$11, member_attr=$12, sync=$13`
+	_, err := db.Exec(query, domainId, cfg.URL, cfg.StartTLS, cfg.BindDN, password, cfg.BaseDN,
+		cfg.UserFilter, cfg.UserAttr, cfg.GroupBaseDN, cfg.GroupFilter, cfg.GroupAttr, cfg.MemberAttr, cfg.Sync)
+	if err != nil {
+		log.Printf("SaveLDAPConfig: domain %d %v\n", domainId, err)
+	}
+	return err
+}
+
+func DeleteLDAPConfig(domainId int) error {
+	db := setupDB()
+
+	query := `DELETE FROM domain_ldap WHERE domain_id=$1`
+	result, err := db.Exec(query, domainId)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("domain %d has no LDAP directory", domainId)
+	}
+	return nil
+}
+
+// Domains with a directory to sync
+func SelectLDAPDomains() []int {
+	db := setupDB()
+
+	query := `SELECT l.domain_id FROM domain_ldap l JOIN domains d ON l.domain_id=d.id
+				WHERE l.sync AND d.status=$1 ORDER BY l.domain_id`
+	rows, err := db.Query(query, STATUS_ACTIVE)
+	if err != nil {
+		log.Printf("SelectLDAPDomains: %v\n", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var domains []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			domains = append(domains, id)
+		}
+	}
+	return domains
+}
+
+func LDAPSyncDone(domainId int) {
+	db := setupDB()
+
+	query := `UPDATE domain_ldap SET last_sync=$1 WHERE domain_id=$2`
+	_, err := db.Exec(query, time.Now(), domainId)
+	if err != nil {
+		log.Printf("LDAPSyncDone: domain %d %v\n", domainId, err)
+	}
+}
+
+// Status of all users of the domain by name, deleted ones included.
+// external tells which are managed by the directory
+func SelectUserStatus(domainId int) (status map[string]string, external map[string]bool) {
+	db := setupDB()
+
+	status = map[string]string{}
+	external = map[string]bool{}
+	query := `SELECT name, status, external FROM users WHERE domain_id=$1`
+	rows, err := db.Query(query, domainId)
+	if err != nil {
+		log.Printf("SelectUserStatus: domain %d %v\n", domainId, err)
+		return nil, nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, st string
+		var ext bool
+		if err := rows.Scan(&name, &st, &ext); err != nil {
+			continue
+		}
+		// A deleted row does not hide a live one of the same name
+		if _, ok := status[name]; !ok || st != STATUS_DELETED {
+			status[name] = st
+			external[name] = ext
+		}
+	}
+	return status, external
+}
+
+// Enable or disable an external user as it appears in or leaves the
+// directory. A user locked out by failed logins stays disabled, one that
+// leaves the directory while locked out is no longer unlocked by an admin.
+// Returns whether the status changed
+func SetExternalUserStatus(domainId int, name string, status string) (bool, error) {
+	db := setupDB()
+
+	var result sql.Result
+	var err error
+	var query string
+	if status == STATUS_ACTIVE {
+		query = `UPDATE users u SET status=$1
+					WHERE u.domain_id=$2 AND u.name=$3 AND u.external AND u.status=$4
+						AND NOT EXISTS (SELECT 1 FROM login_failures f, domains d
+							WHERE d.id=u.domain_id AND f.kind=$5 AND f.key=$6 || u.name || '@' || d.name AND f.locked)`
+		result, err = db.Exec(query, STATUS_ACTIVE, domainId, name, STATUS_DISABLED, LOGIN_KEY_PRINCIPAL, principalKey(ROLE_USER, ""))
+	} else {
+		query = `UPDATE login_failures f SET locked=false FROM users u, domains d
+					WHERE u.domain_id=$1 AND u.name=$2 AND u.external AND d.id=u.domain_id
+						AND f.kind=$3 AND f.key=$4 || u.name || '@' || d.name AND f.locked`
+		_, err = db.Exec(query, domainId, name, LOGIN_KEY_PRINCIPAL, principalKey(ROLE_USER, ""))
+		if err == nil {
+			query = `UPDATE users SET status=$1 WHERE domain_id=$2 AND name=$3 AND external AND status=$4`
+			result, err = db.Exec(query, STATUS_DISABLED, domainId, name, STATUS_ACTIVE)
+		}
+	}
+	if err != nil {
+		log.Printf("SetExternalUserStatus: %s domain %d %v\n", name, domainId, err)
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/saroopmathur/rest-api/config"
+)
+
+// Failed login attempts are counted per principal (role and name@domain as
+// entered) and per source IP. Past the backoff thresholds further attempts
+// are refused for an exponentially growing delay. At LOCKOUT_THRESHOLD the
+// user, service or admin is disabled for LOCKOUT_DURATION (0 - until
+// unlocked by an admin)
+var LOGIN_BACKOFF_AFTER = 3
+var LOGIN_IP_BACKOFF_AFTER = 20
+var LOGIN_BACKOFF_BASE = time.Second
+var LOGIN_BACKOFF_MAX = 15 * time.Minute
+var LOGIN_FAILURE_WINDOW = time.Hour
+var LOCKOUT_THRESHOLD = 10
+var LOCKOUT_DURATION = 30 * time.Minute
+
+const (
+	LOGIN_KEY_PRINCIPAL = "P"
+	LOGIN_KEY_IP        = "I"
+)
+
+// Returned while login is refused
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	if e.RetryAfter <= 0 {
+		return "account locked. Contact your administrator"
+	}
+	return fmt.Sprintf("too many failed logins. Retry in %d seconds", int(e.RetryAfter.Seconds()+0.5))
+}
+
+func loadLockoutConfig() {
+	config.Int("LOGIN_BACKOFF_AFTER", &LOGIN_BACKOFF_AFTER)
+	config.Int("LOGIN_IP_BACKOFF_AFTER", &LOGIN_IP_BACKOFF_AFTER)
+	config.Duration("LOGIN_BACKOFF_BASE", &LOGIN_BACKOFF_BASE)
+	config.Duration("LOGIN_BACKOFF_MAX", &LOGIN_BACKOFF_MAX)
+	config.Duration("LOGIN_FAILURE_WINDOW", &LOGIN_FAILURE_WINDOW)
+	config.Int("LOCKOUT_THRESHOLD", &LOCKOUT_THRESHOLD)
+	config.Duration("LOCKOUT_DURATION", &LOCKOUT_DURATION)
+}
+
+func principalKey(role string, username string) string {
+	return role + ":" + username
+}
+
+// Delay before the next attempt after failures, none below after
+func loginBackoff(failures int, after int) time.Duration {
+	if after <= 0 || failures < after {
+		return 0
+	}
+	delay := LOGIN_BACKOFF_BASE
+	for i := after; i < failures && delay < LOGIN_BACKOFF_MAX; i++ {
+		delay *= 2
+	}
+	if delay > LOGIN_BACKOFF_MAX {
+		delay = LOGIN_BACKOFF_MAX
+	}
+	return delay
+}
+
+// Table of the principals of role
+func principalTable(role string) string {
+	switch role {
+	case ROLE_USER:
+		return "users"
+	case ROLE_SERVICE:
+		return "services"
+	}
+	return "admins"
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Change the status of the principal username (name@domain) of role.
+// Returns whether it had status from
+func setPrincipalStatus(ex execer, role string, username string, from string, to string) (bool, error) {
+	parts := strings.Split(username, "@")
+	if len(parts) != 2 {
+		return false, fmt.Errorf("invalid name %s", username)
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET status=$1
+				WHERE name=$2 AND domain_id=(SELECT id FROM domains WHERE name=$3) AND status=$4`, principalTable(role))
+	result, err := ex.Exec(query, to, parts[0], parts[1], from)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// CheckLoginAllowed is called before checking the password. A lockout that
+// has run out is lifted here
+func CheckLoginAllowed(role string, username string, ip string) error {
+	db := setupDB()
+
+	now := time.Now()
+	var retry time.Duration
+
+	query := `SELECT kind, locked, locked_until FROM login_failures
+				WHERE (kind=$1 AND key=$2) OR (kind=$3 AND key=$4)`
+	rows, err := db.Query(query, LOGIN_KEY_PRINCIPAL, principalKey(role, username), LOGIN_KEY_IP, ip)
+	if err != nil {
+		log.Printf("CheckLoginAllowed: %v\n", err)
+		return nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var locked bool
+		var until sql.NullTime
+		if err := rows.Scan(&kind, &locked, &until); err != nil {
+			continue
+		}
+
+		if locked && !until.Valid {
+			// Only an admin can unlock
+			return &LoginLockedError{}
+		}
+		if !until.Valid {
+			continue
+		}
+		if until.Time.After(now) {
+			if d := until.Time.Sub(now); d > retry {
+				retry = d
+			}
+		} else if locked {
+			log.Printf("Lockout of %s %s expired\n", role, username)
+			unlockLogin(role, username)
+		}
+	}
+
+	if retry > 0 {
+		return &LoginLockedError{RetryAfter: retry}
+	}
+	return nil
+}
+
+// LoginFailed counts a wrong password or code for the principal and ip
+func LoginFailed(role string, username string, ip string) {
+	now := time.Now()
+
+	failures := countLoginFailure(LOGIN_KEY_PRINCIPAL, principalKey(role, username), now)
+	if failures >= LOCKOUT_THRESHOLD && LOCKOUT_THRESHOLD > 0 {
+		var until interface{}
+		if LOCKOUT_DURATION > 0 {
+			until = now.Add(LOCKOUT_DURATION)
+		}
+		log.Printf("Locking out %s %s after %d failed logins\n", role, username, failures)
+		disabled, err := setPrincipalStatus(setupDB(), role, username, STATUS_ACTIVE, STATUS_DISABLED)
+		if err != nil {
+			log.Printf("LoginFailed: disable %s %s %v\n", role, username, err)
+		}
+		// Locked only if the lockout disabled it, unlocking must not enable
+		// a principal an admin or the LDAP sync disabled
+		lockLogin(LOGIN_KEY_PRINCIPAL, principalKey(role, username), disabled, until)
+	} else if delay := loginBackoff(failures, LOGIN_BACKOFF_AFTER); delay > 0 {
+		lockLogin(LOGIN_KEY_PRINCIPAL, principalKey(role, username), false, now.Add(delay))
+	}
+
+	if ip == "" {
+		return
+	}
+	failures = countLoginFailure(LOGIN_KEY_IP, ip, now)
+	if delay := loginBackoff(failures, LOGIN_IP_BACKOFF_AFTER); delay > 0 {
+		lockLogin(LOGIN_KEY_IP, ip, false, now.Add(delay))
+	}
+}
+
+// LoginSucceeded clears the failures of the principal. Those of the IP are
+// kept, one valid account must not allow guessing the others
+func LoginSucceeded(role string, username string) {
+	db := setupDB()
+
+	query := `DELETE FROM login_failures WHERE kind=$1 AND key=$2`
+	_, err := db.Exec(query, LOGIN_KEY_PRINCIPAL, principalKey(role, username))
+	if err != nil {
+		log.Printf("LoginSucceeded: %v\n", err)
+	}
+}
+
+// Failures so far including this one, restarting after LOGIN_FAILURE_WINDOW
+func countLoginFailure(kind string, key string, now time.Time) int {
+	db := setupDB()
+
+	var failures int
+	query := `INSERT INTO login_failures (kind, key, failures, last_failure, locked)
+				VALUES ($1, $2, 1, $3, false)
+				ON CONFLICT (kind, key) DO UPDATE SET
+					failures=CASE WHEN login_failures.last_failure < $4 THEN 1 ELSE login_failures.failures+1 END,
+					last_failure=$3
+				RETURNING failures`
+	err := db.QueryRow(query, kind, key, now, now.Add(-LOGIN_FAILURE_WINDOW)).Scan(&failures)
+	if err != nil {
+		log.Printf("countLoginFailure: %v\n", err)
+	}
+	return failures
+}
+
+func lockLogin(kind string, key string, locked bool, until interface{}) {
+	db := setupDB()
+
+	query := `UPDATE login_failures SET locked=$1, locked_until=$2 WHERE kind=$3 AND key=$4`
+	_, err := db.Exec(query, locked, until, kind, key)
+	if err != nil {
+		log.Printf("lockLogin: %v\n", err)
+	}
+}
+
+// Re-enable a principal locked out by failed logins and clear its
+// failures. False if it is not locked out, then it is left as it is - a
+// principal disabled by an admin or the LDAP sync stays disabled
+func unlockLogin(role string, username string) (bool, error) {
+	db := setupDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM login_failures WHERE kind=$1 AND key=$2 AND locked`
+	res, err := tx.Exec(query, LOGIN_KEY_PRINCIPAL, principalKey(role, username))
+	if err != nil {
+		log.Printf("unlockLogin: %s %s %v\n", role, username, err)
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	_, err = setPrincipalStatus(tx, role, username, STATUS_DISABLED, STATUS_ACTIVE)
+	if err != nil {
+		log.Printf("unlockLogin: %s %s %v\n", role, username, err)
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Unlock a user, service or admin of the domain, locked out by failed logins
+func UnlockLogin(role string, domainId int, name string, id int) error {
+	db := setupDB()
+
+	var uname string
+	var dname string
+	query := fmt.Sprintf(`SELECT t.name, d.name FROM %s t JOIN domains d ON t.domain_id=d.id
+				WHERE (t.id=$1 OR ($1=0 AND t.name=$2)) AND t.domain_id=$3 AND t.status IN ($4, $5)`, principalTable(role))
+	err := db.QueryRow(query, id, name, domainId, STATUS_ACTIVE, STATUS_DISABLED).Scan(&uname, &dname)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s %d unknown", name, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	unlocked, err := unlockLogin(role, uname+"@"+dname)
+	if err == nil && !unlocked {
+		err = fmt.Errorf("%s is not locked out", uname)
+	}
+	return err
+}
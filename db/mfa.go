@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/saroopmathur/rest-api/config"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// How long the challenge of the first login step can be answered, and how
+// many wrong codes are accepted before it is dropped
+var MFA_CHALLENGE_LIFETIME = 5 * time.Minute
+var MFA_MAX_ATTEMPTS = 5
+
+var ErrChallengeInvalid = errors.New("MFA challenge invalid or expired. Login again")
+
+func loadMFAConfig() {
+	config.Duration("MFA_CHALLENGE_LIFETIME", &MFA_CHALLENGE_LIFETIME)
+	config.Int("MFA_MAX_ATTEMPTS", &MFA_MAX_ATTEMPTS)
+}
+
+func GetAdminMFA(adminId int) *model.AdminMFA {
+	db := setupDB()
+
+	var secret sql.NullString
+	mfa := &model.AdminMFA{AdminID: adminId}
+	query := `SELECT totp_secret, totp_enabled, totp_last_step FROM admins WHERE id=$1 AND status=$2`
+	err := db.QueryRow(query, adminId, STATUS_ACTIVE).Scan(&secret, &mfa.Enabled, &mfa.LastStep)
+	if err != nil {
+		fmt.Printf("GetAdminMFA: [%d] %v\n", adminId, err)
+		return nil
+	}
+	mfa.Secret = secret.String
+	return mfa
+}
+
+// Save a new secret, not enabled until a code generated from it is verified
+func SetAdminTOTPSecret(adminId int, secret string) error {
+	db := setupDB()
+
+	query := `UPDATE admins SET totp_secret=$1, totp_enabled=false, totp_last_step=0 WHERE id=$2 AND status=$3`
+	_, err := db.Exec(query, secret, adminId, STATUS_ACTIVE)
+	return err
+}
+
+// Enable TOTP, or record the step of the last code used when already enabled
+func EnableAdminTOTP(adminId int, step int64) error {
+	db := setupDB()
+
+	query := `UPDATE admins SET totp_enabled=true, totp_last_step=$1 WHERE id=$2 AND status=$3`
+	_, err := db.Exec(query, step, adminId, STATUS_ACTIVE)
+	return err
+}
+
+// Turn TOTP off, dropping the secret and the recovery codes
+func DisableAdminTOTP(adminId int) error {
+	db := setupDB()
+
+	query := `UPDATE admins SET totp_secret=NULL, totp_enabled=false, totp_last_step=0 WHERE id=$1`
+	_, err := db.Exec(query, adminId)
+	if err != nil {
+		return err
+	}
+	return SetRecoveryCodes(adminId, nil)
+}
+
+// Replace the recovery codes of the admin by hashes
+func SetRecoveryCodes(adminId int, hashes []string) error {
+	db := setupDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM admin_recovery_codes WHERE admin_id=$1`
+	if _, err = tx.Exec(query, adminId); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	query = `INSERT INTO admin_recovery_codes (admin_id, code_hash, create_time, status) VALUES ($1, $2, $3, $4)`
+	for _, hash := range hashes {
+		if _, err = tx.Exec(query, adminId, hash, now, STATUS_ACTIVE); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Use up a recovery code, false if unknown or already used
+func UseRecoveryCode(adminId int, hash string) bool {
+	db := setupDB()
+
+	query := `UPDATE admin_recovery_codes SET status=$1, used_time=$2
+				WHERE admin_id=$3 AND code_hash=$4 AND status=$5`
+	res, err := db.Exec(query, STATUS_DELETED, time.Now(), adminId, hash, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("UseRecoveryCode: [%d] %v\n", adminId, err)
+		return false
+	}
+	n, err := res.RowsAffected()
+	return err == nil && n == 1
+}
+
+// Number of recovery codes not used yet
+func CountRecoveryCodes(adminId int) int {
+	db := setupDB()
+
+	var count int
+	query := `SELECT COUNT(*) FROM admin_recovery_codes WHERE admin_id=$1 AND status=$2`
+	err := db.QueryRow(query, adminId, STATUS_ACTIVE).Scan(&count)
+	if err != nil {
+		fmt.Printf("CountRecoveryCodes: [%d] %v\n", adminId, err)
+	}
+	return count
+}
+
+// Start the second login step of an admin whose password was checked.
+// Returns the challenge token to send back with the code
+func CreateMFAChallenge(adminId int, domainId int, role string) (string, error) {
+	db := setupDB()
+
+	challenge, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO mfa_challenges (admin_id, challenge, domain_id, role, attempts, start_time, status)
+				VALUES ($1, $2, $3, $4, 0, $5, $6)`
+	_, err = db.Exec(query, adminId, hashToken(challenge), domainId, role, time.Now(), STATUS_ACTIVE)
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// Lookup a challenge still open
+func GetMFAChallenge(challenge string) (*model.MFAChallenge, error) {
+	db := setupDB()
+
+	c := &model.MFAChallenge{}
+	query := `SELECT id, admin_id, domain_id, role, attempts, status FROM mfa_challenges
+				WHERE challenge=$1 AND status=$2 AND start_time > $3 AND attempts < $4`
+	err := db.QueryRow(query, hashToken(challenge), STATUS_ACTIVE, time.Now().Add(-MFA_CHALLENGE_LIFETIME), MFA_MAX_ATTEMPTS).
+		Scan(&c.ID, &c.AdminID, &c.DomainID, &c.Role, &c.Attempts, &c.Status)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("GetMFAChallenge: %v\n", err)
+		}
+		return nil, ErrChallengeInvalid
+	}
+	return c, nil
+}
+
+// Count a wrong code, the challenge is dropped after MFA_MAX_ATTEMPTS
+func FailMFAChallenge(id int) {
+	db := setupDB()
+
+	query := `UPDATE mfa_challenges SET attempts=attempts+1,
+				status=CASE WHEN attempts+1 >= $1 THEN $2 ELSE status END
+				WHERE id=$3`
+	_, err := db.Exec(query, MFA_MAX_ATTEMPTS, STATUS_DELETED, id)
+	if err != nil {
+		log.Printf("FailMFAChallenge: [%d] %v\n", id, err)
+	}
+}
+
+// Close the challenge once answered. Fails if it was already used, so one
+// challenge can not open two sessions
+func CompleteMFAChallenge(id int) error {
+	db := setupDB()
+
+	query := `UPDATE mfa_challenges SET status=$1 WHERE id=$2 AND status=$3`
+	res, err := db.Exec(query, STATUS_DELETED, id, STATUS_ACTIVE)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return ErrChallengeInvalid
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/saroopmathur/rest-api/config"
+)
+
+// Channel of the NOTIFY sent on every change that may affect the policies
+// of a domain, the payload is the domain id. Every API instance LISTENs
+// and tells its subscribers
+const POLICY_CHANNEL = "policy_changed"
+
+// How often subscribers read their policy again without a notification,
+// for grants opening and closing on their schedules. 0 - never
+var POLICY_RECHECK_INTERVAL = time.Minute
+
+func loadNotifyConfig() {
+	config.Duration("POLICY_RECHECK_INTERVAL", &POLICY_RECHECK_INTERVAL)
+}
+
+// Tell all API instances the policies of the domain may have changed
+func notifyPolicyChange(domainId int) {
+	db := setupDB()
+
+	_, err := db.Exec(`SELECT pg_notify($1, $2)`, POLICY_CHANNEL, strconv.Itoa(domainId))
+	if err != nil {
+		fmt.Printf("notifyPolicyChange: [%d] %v\n", domainId, err)
+	}
+}
+
+// Subscribers of this instance, by domain
+var policySubscribers = struct {
+	sync.Mutex
+	listen  sync.Once
+	domains map[int]map[chan struct{}]bool
+}{domains: make(map[int]map[chan struct{}]bool)}
+
+// SubscribePolicyChanges signals the channel whenever the policies of the
+// domain may have changed, until cancel is called. Signals are not queued,
+// one pending signal stands for any number of changes
+func SubscribePolicyChanges(domainId int) (<-chan struct{}, func()) {
+	policySubscribers.listen.Do(listenPolicyChanges)
+
+	ch := make(chan struct{}, 1)
+	policySubscribers.Lock()
+	if policySubscribers.domains[domainId] == nil {
+		policySubscribers.domains[domainId] = make(map[chan struct{}]bool)
+	}
+	policySubscribers.domains[domainId][ch] = true
+	policySubscribers.Unlock()
+
+	cancel := func() {
+		policySubscribers.Lock()
+		delete(policySubscribers.domains[domainId], ch)
+		if len(policySubscribers.domains[domainId]) == 0 {
+			delete(policySubscribers.domains, domainId)
+		}
+		policySubscribers.Unlock()
+	}
+	return ch, cancel
+}
+
+// Signal the subscribers of the domain, of all domains if 0
+func signalPolicyChange(domainId int) {
+	policySubscribers.Lock()
+	defer policySubscribers.Unlock()
+	for id, subscribers := range policySubscribers.domains {
+		if domainId != 0 && id != domainId {
+			continue
+		}
+		for ch := range subscribers {
+			select {
+			case ch <- struct{}{}:
+			default:
+				// Already signalled
+			}
+		}
+	}
+}
+
+// LISTEN on a connection of its own, reconnecting when it is lost
+func listenPolicyChanges() {
+	listener := pq.NewListener(dbInfo(), 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("listenPolicyChanges: %v\n", err)
+		}
+	})
+	if err := listener.Listen(POLICY_CHANNEL); err != nil {
+		log.Printf("listenPolicyChanges: LISTEN %s %v\n", POLICY_CHANNEL, err)
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				if n == nil {
+					// Reconnected, notifications may have been missed
+					signalPolicyChange(0)
+					continue
+				}
+				domainId, err := strconv.Atoi(n.Extra)
+				if err != nil || domainId == 0 {
+					fmt.Printf("listenPolicyChanges: invalid payload %q\n", n.Extra)
+					continue
+				}
+				signalPolicyChange(domainId)
+			case <-time.After(90 * time.Second):
+				// Notice a dead connection
+				go listener.Ping()
+			}
+		}
+	}()
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// How long the login at the identity provider may take
+var OIDC_STATE_LIFETIME = 10 * time.Minute
+
+var ErrOIDCStateInvalid = errors.New("login state invalid or expired. Login again")
+
+// Remember the state of a login sent to the identity provider
+func SaveOIDCState(state string, st *model.OIDCState) error {
+	db := setupDB()
+
+	query := `INSERT INTO oidc_states (state, nonce, verifier, role, start_time, status)
+				VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := db.Exec(query, hashToken(state), st.Nonce, st.Verifier, st.Role, time.Now(), STATUS_ACTIVE)
+	return err
+}
+
+// Take the state back on the callback. It can only be used once
+func TakeOIDCState(state string) (*model.OIDCState, error) {
+	db := setupDB()
+
+	st := &model.OIDCState{}
+	query := `UPDATE oidc_states SET status=$1
+				WHERE state=$2 AND status=$3 AND start_time > $4
+				RETURNING nonce, verifier, role`
+	err := db.QueryRow(query, STATUS_DELETED, hashToken(state), STATUS_ACTIVE, time.Now().Add(-OIDC_STATE_LIFETIME)).
+		Scan(&st.Nonce, &st.Verifier, &st.Role)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("TakeOIDCState: %v\n", err)
+		}
+		return nil, ErrOIDCStateInvalid
+	}
+	return st, nil
+}
+
+// A row for name exists in the domain, whatever its status
+func principalExists(role string, name string, domainId int) bool {
+	db := setupDB()
+
+	var count int
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE name=$1 AND domain_id=$2`, principalTable(role))
+	err := db.QueryRow(query, name, domainId).Scan(&count)
+	return err != nil || count > 0
+}
+
+// Account, as name@domain, the identity provider account is bound to. Only
+// an active account of the domain counts, "" when there is none
+func OIDCPrincipal(role string, issuer string, subject string, domainName string) (string, error) {
+	db := setupDB()
+
+	var name string
+	query := fmt.Sprintf(`SELECT p.name FROM oidc_identities o
+				JOIN %s p ON o.principal_id=p.id AND o.domain_id=p.domain_id
+				JOIN domains d ON p.domain_id=d.id
+				WHERE o.issuer=$1 AND o.subject=$2 AND o.role=$3 AND d.name=$4 AND p.status=$5`, principalTable(role))
+	err := db.QueryRow(query, issuer, subject, role, domainName, STATUS_ACTIVE).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		log.Printf("OIDCPrincipal: %v\n", err)
+		return "", err
+	}
+	return name + "@" + domainName, nil
+}
+
+// Bind the identity provider account to a user or admin. Logins through the
+// provider only reach accounts bound this way, never one of the same name.
+// A binding to an account no longer active is replaced
+func BindOIDCIdentity(role string, issuer string, subject string, principalId int, domainId int) error {
+	db := setupDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`DELETE FROM oidc_identities o WHERE issuer=$1 AND subject=$2 AND role=$3
+				AND NOT EXISTS (SELECT 1 FROM %s p WHERE p.id=o.principal_id AND p.status=$4)`, principalTable(role))
+	if _, err = tx.Exec(query, issuer, subject, role, STATUS_ACTIVE); err != nil {
+		return err
+	}
+	query = `INSERT INTO oidc_identities (issuer, subject, role, principal_id, domain_id, create_time)
+				VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err = tx.Exec(query, issuer, subject, role, principalId, domainId, time.Now()); err != nil {
+		log.Printf("BindOIDCIdentity: %v\n", err)
+		return fmt.Errorf("subject %s is already bound", subject)
+	}
+	return tx.Commit()
+}
+
+// The identity provider account is bound to an account, active or not
+func oidcBound(role string, issuer string, subject string) bool {
+	db := setupDB()
+
+	var count int
+	query := `SELECT COUNT(*) FROM oidc_identities WHERE issuer=$1 AND subject=$2 AND role=$3`
+	err := db.QueryRow(query, issuer, subject, role).Scan(&count)
+	return err != nil || count > 0
+}
+
+// Remove the identity provider accounts bound to a user or admin
+func UnbindOIDCIdentity(role string, principalId int, domainId int) error {
+	db := setupDB()
+
+	query := `DELETE FROM oidc_identities WHERE role=$1 AND principal_id=$2 AND domain_id=$3`
+	_, err := db.Exec(query, role, principalId, domainId)
+	return err
+}
+
+// Create the user on its first login through the identity provider and bind
+// it to the provider account. It has no password, so it can only login that
+// way. An existing user of the same name, active or not, is never taken
+// over, and a deleted or locked out user is not created again
+func ProvisionUser(issuer string, subject string, name string, domainName string) (*model.User2, error) {
+	domain := SelectDomain(0, domainName)
+	if domain == nil {
+		return nil, fmt.Errorf("domain %s unknown", domainName)
+	}
+	if oidcBound(ROLE_USER, issuer, subject) {
+		return nil, fmt.Errorf("user bound to %s is not active", subject)
+	}
+	if principalExists(ROLE_USER, name, domain.ID) {
+		return nil, fmt.Errorf("user %s@%s exists and is not bound to this identity", name, domainName)
+	}
+	log.Printf("ProvisionUser: %s@%s\n", name, domainName)
+	u, err := InsertUser(domain.ID, &model.User{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if err = BindOIDCIdentity(ROLE_USER, issuer, subject, u.ID, domain.ID); err != nil {
+		db := setupDB()
+		db.Exec(`DELETE FROM users WHERE id=$1`, u.ID)
+		return nil, err
+	}
+	return u, nil
+}
+
+// Same as ProvisionUser, for admins
+func ProvisionAdmin(issuer string, subject string, name string, domainName string) (*model.Admin2, error) {
+	domain := SelectDomain(0, domainName)
+	if domain == nil {
+		return nil, fmt.Errorf("domain %s unknown", domainName)
+	}
+	if oidcBound(ROLE_ADMIN, issuer, subject) {
+		return nil, fmt.Errorf("admin bound to %s is not active", subject)
+	}
+	if principalExists(ROLE_ADMIN, name, domain.ID) {
+		return nil, fmt.Errorf("admin %s@%s exists and is not bound to this identity", name, domainName)
+	}
+	log.Printf("ProvisionAdmin: %s@%s\n", name, domainName)
+	a, err := InsertAdmin(domain.ID, name, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = BindOIDCIdentity(ROLE_ADMIN, issuer, subject, a.ID, domain.ID); err != nil {
+		db := setupDB()
+		db.Exec(`DELETE FROM admins WHERE id=$1`, a.ID)
+		return nil, err
+	}
+	return a, nil
+}
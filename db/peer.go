@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Users allowed to reach each app of the service, through
+// user_access_control or group_access_control, a peer for the key of the
+// user and one per device. Keys not set can not connect and are left out,
+// as are users whose access is not in effect now or denied
+func SelectServicePeers(domainId int, serviceId int) []*model.PeerApp {
+	db := setupDB()
+
+	access, err := selectEffectiveAccess(domainId, 0)
+	if err != nil {
+		return nil
+	}
+
+	query := `SELECT a.id, a.name, a.allowed_ips, p.user_id, p.name, p.device, p.wg_key, p.virtual_ip
+				FROM apps a
+					LEFT JOIN (SELECT ua.app_id, ua.user_id FROM user_access_control ua
+							WHERE ua.status=$2
+						UNION
+						SELECT ga.app_id, mem.user_id FROM group_access_control ga
+							JOIN ` + groupMembersAll(4) + ` mem ON ga.group_id=mem.group_id
+							WHERE ga.status=$2) acc ON acc.app_id=a.id
+					LEFT JOIN (SELECT u.id AS user_id, u.name, '' AS device, u.wg_key, u.virtual_ip FROM users u
+							WHERE u.domain_id=$1 AND u.status=$2
+						UNION ALL
+						SELECT u.id, u.name, d.name, d.wg_key, d.virtual_ip FROM devices d
+							JOIN users u ON d.user_id=u.id
+							WHERE u.domain_id=$1 AND u.status=$2 AND d.status=$2) p
+						ON p.user_id=acc.user_id AND p.wg_key IS NOT NULL AND p.wg_key<>''
+				WHERE a.service_id=$3 AND a.status=$2
+				ORDER BY a.name, a.id, p.name, p.device`
+	rows, err := db.Query(query, domainId, STATUS_ACTIVE, serviceId, dynamicMembers(domainId, 0))
+	if err != nil {
+		fmt.Printf("SelectServicePeers: service %d %v\n", serviceId, err)
+		return nil
+	}
+	defer rows.Close()
+
+	var apps []*model.PeerApp
+	var app *model.PeerApp
+	for rows.Next() {
+		var appId int
+		var appName sql.NullString
+		var allowedIPs sql.NullString
+		var userId sql.NullInt32
+		var userName sql.NullString
+		var device sql.NullString
+		var wgKey sql.NullString
+		var virtualIp sql.NullString
+
+		err = rows.Scan(&appId, &appName, &allowedIPs, &userId, &userName, &device, &wgKey, &virtualIp)
+		if err != nil {
+			fmt.Printf("SelectServicePeers Scan: %v\n", err)
+			return nil
+		}
+		if app == nil || app.ID != appId {
+			app = &model.PeerApp{ID: appId, Name: appName.String, AllowedIPs: allowedIPs.String, Peers: []*model.Peer{}}
+			apps = append(apps, app)
+		}
+		if userId.Valid && access.allowed(int(userId.Int32), appId) {
+			app.Peers = append(app.Peers, &model.Peer{
+				ID:        int(userId.Int32),
+				Name:      userName.String,
+				Device:    device.String,
+				WGKey:     wgKey.String,
+				VirtualIP: virtualIp.String,
+			})
+		}
+	}
+	attachPeerAppRules(apps)
+	return apps
+}
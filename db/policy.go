@@ -23,19 +23,28 @@ func GetUserPolicy(domainId int, userName string, userId int) (*m.Policy, error)
 	}
 	userId = u.ID
 
+	// Access rows not in effect now or overruled by a deny are left out
+	access, err := selectEffectiveAccess(domainId, userId)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get user specific policies
 	query := `SELECT services.name, services.wg_key, services.virtual_ip, services.public_ip, services.local_ip,
-			apps.name, apps.apps
+			apps.id, apps.name, apps.allowed_ips, ua.user_id, ''
 				FROM services, apps, user_access_control ua
 				WHERE ua.user_id=$1
 					AND ua.app_id=apps.id
-					AND apps.service_id=services.id`
+					AND apps.service_id=services.id
+					AND ua.status=$2
+					AND apps.status=$2
+					AND services.status=$2`
 
-	rows, err := db.Query(query, userId)
+	rows, err := db.Query(query, userId, STATUS_ACTIVE)
 	if err != nil {
 		return nil, err
 	}
-	readPolicyRows(rows, policy)
+	readPolicyRows(rows, policy, access.userAllowed)
 	rows.Close()
 
 	for _, s := range policy.ServiceNodes {
@@ -46,19 +55,27 @@ func GetUserPolicy(domainId int, userName string, userId int) (*m.Policy, error)
 
 	// Get group policies
 	query = `SELECT services.name, services.wg_key, services.virtual_ip, services.public_ip, services.local_ip,
-			apps.name, apps.apps
-				FROM services, apps, group_access_control ga
-				WHERE ga.group_id IN (SELECT DISTINCT members.group_id FROM group_members members
+			apps.id, apps.name, apps.allowed_ips, ga.group_id, ug.name
+				FROM services, apps, group_access_control ga, user_groups ug
+				WHERE ga.group_id IN (SELECT DISTINCT members.group_id FROM ` + groupMembersAll(3) + ` members
 							WHERE members.user_id=$1)
+					AND ga.group_id=ug.id
 					AND ga.app_id=apps.id
-					AND apps.service_id=services.id`
+					AND apps.service_id=services.id
+					AND ga.status=$2
+					AND apps.status=$2
+					AND services.status=$2`
 
-	rows, err = db.Query(query, userId)
+	rows, err = db.Query(query, userId, STATUS_ACTIVE, dynamicMembers(domainId, userId))
 	if err != nil {
 		return nil, err
 	}
-	readPolicyRows(rows, policy)
+	readPolicyRows(rows, policy, access.groupAllowed)
 	rows.Close()
+	attachPolicyRules(policy)
+
+	// Devices of the user, each connects with its own key
+	policy.Devices = SelectDevices(domainId, userId)
 	return policy, nil
 }
 
@@ -69,11 +86,18 @@ func GetAllPolicies(domainId int) (*m.Policy, error) {
 	policy := &m.Policy{}
 	policy.ServiceNodes = make(map[string]*m.ServiceNode)
 
+	// Access rows not in effect now or overruled by a deny are left out
+	access, err := selectEffectiveAccess(domainId, 0)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get user specific policies
 	query := `SELECT services.name, services.wg_key, services.virtual_ip, services.public_ip, services.local_ip,
-			apps.name, apps.apps
+			apps.id, apps.name, apps.allowed_ips, ua.user_id, ''
 				FROM services, apps, user_access_control ua
 				WHERE ua.app_id=apps.id
+					AND ua.status=$1
 					AND apps.service_id=services.id
 					AND apps.status=$1
 					AND services.status=$1
@@ -83,7 +107,7 @@ func GetAllPolicies(domainId int) (*m.Policy, error) {
 	if err != nil {
 		return nil, err
 	}
-	readPolicyRows(rows, policy)
+	readPolicyRows(rows, policy, access.userAllowed)
 	rows.Close()
 
 	for _, s := range policy.ServiceNodes {
@@ -94,9 +118,11 @@ func GetAllPolicies(domainId int) (*m.Policy, error) {
 
 	// Get group policies
 	query = `SELECT services.name, services.wg_key, services.virtual_ip, services.public_ip, services.local_ip,
-			apps.name, apps.apps
-				FROM services, apps, group_access_control ga
+			apps.id, apps.name, apps.allowed_ips, ga.group_id, ug.name
+				FROM services, apps, group_access_control ga, user_groups ug
 				WHERE ga.app_id=apps.id
+					AND ga.group_id=ug.id
+					AND ga.status=$1
 					AND apps.service_id=services.id
 					AND apps.status=$1
 					AND services.status=$1
@@ -106,27 +132,42 @@ func GetAllPolicies(domainId int) (*m.Policy, error) {
 	if err != nil {
 		return nil, err
 	}
-	readPolicyRows(rows, policy)
+	readPolicyRows(rows, policy, access.groupAllowed)
 	rows.Close()
+	attachPolicyRules(policy)
 	return policy, nil
 }
 
-func readPolicyRows(rows *sql.Rows, policy *m.Policy) {
+// Add the apps of the access rows to the policy, of the user or group
+// owning the row, skipping those not in effect
+func readPolicyRows(rows *sql.Rows, policy *m.Policy, inEffect func(ownerId int, appId int) bool) {
 	var serviceName sql.NullString
 	var wgKey sql.NullString
 	var vip sql.NullString
 	var public_ip sql.NullString
 	var local_ip sql.NullString
+	var appId int
 	var appName sql.NullString
 	var allowedIPs sql.NullString
-
-	if rows.Next() {
-		err := rows.Scan(&serviceName, &wgKey, &vip, &public_ip, &local_ip, &appName, &allowedIPs)
-		checkErr(err)
+	var ownerId int
+	var groupName sql.NullString
+
+	for rows.Next() {
+		err := rows.Scan(&serviceName, &wgKey, &vip, &public_ip, &local_ip, &appId, &appName, &allowedIPs,
+			&ownerId, &groupName)
+		if err != nil {
+			fmt.Printf("readPolicyRows: %v\n", err)
+			continue
+		}
+		if !inEffect(ownerId, appId) {
+			continue
+		}
 
 		app := &m.PolicyApp{}
+		app.ID = appId
 		app.Name = appName.String
 		app.AllowedIPs = allowedIPs.String
+		app.Group = groupName.String
 
 		service := policy.ServiceNodes[serviceName.String]
 		if service == nil {
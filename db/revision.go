@@ -0,0 +1,434 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Advisory lock serializing the policy revisions of a domain, with the
+// domain id as second key
+const REVISION_LOCK = 3
+
+// Access rows of the domain and the grants in effect now
+func selectPolicySnapshot(domainId int) (*model.PolicySnapshot, error) {
+	db := setupDB()
+
+	snapshot := &model.PolicySnapshot{Users: []*model.RevisionAccess{}, Groups: []*model.RevisionAccess{}}
+	queries := []struct {
+		rows  *[]*model.RevisionAccess
+		query string
+	}{
+		{&snapshot.Users, `SELECT ua.user_id, u.name, ua.app_id, a.name, ua.effect, ua.valid_from, ua.valid_until, ua.schedule
+					FROM user_access_control ua JOIN users u ON ua.user_id=u.id JOIN apps a ON ua.app_id=a.id
+					WHERE u.domain_id=$1 AND ua.status=$2
+					ORDER BY ua.user_id, ua.app_id, ua.id`},
+		{&snapshot.Groups, `SELECT ga.group_id, g.name, ga.app_id, a.name, ga.effect, ga.valid_from, ga.valid_until, ga.schedule
+					FROM group_access_control ga JOIN user_groups g ON ga.group_id=g.id JOIN apps a ON ga.app_id=a.id
+					WHERE g.domain_id=$1 AND ga.status=$2
+					ORDER BY ga.group_id, ga.app_id, ga.id`},
+	}
+	for _, q := range queries {
+		rows, err := db.Query(q.query, domainId, STATUS_ACTIVE)
+		if err != nil {
+			fmt.Printf("selectPolicySnapshot: [%d] %v\n", domainId, err)
+			return nil, err
+		}
+		for rows.Next() {
+			var from, until sql.NullTime
+			var sched sql.NullString
+			a := &model.RevisionAccess{}
+			if err = rows.Scan(&a.OwnerID, &a.Owner, &a.AppID, &a.App, &a.Effect, &from, &until, &sched); err != nil {
+				fmt.Printf("selectPolicySnapshot Scan: %v\n", err)
+				rows.Close()
+				return nil, err
+			}
+			if from.Valid {
+				a.ValidFrom = &from.Time
+			}
+			if until.Valid {
+				a.ValidUntil = &until.Time
+			}
+			a.Schedule = sched.String
+			*q.rows = append(*q.rows, a)
+		}
+		rows.Close()
+	}
+
+	grants, err := selectPolicyGrants(domainId)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Grants = grants
+	return snapshot, nil
+}
+
+// Apps each user of the domain is allowed now, by user, service and app
+func selectPolicyGrants(domainId int) ([]*model.RevisionGrant, error) {
+	db := setupDB()
+
+	access, err := selectEffectiveAccess(domainId, 0)
+	if err != nil {
+		return nil, err
+	}
+	apps := make(map[int]*model.App)
+	for _, app := range SelectApps(domainId) {
+		apps[app.ID] = app
+	}
+
+	rows, err := db.Query(`SELECT id, name FROM users WHERE domain_id=$1 AND status=$2`, domainId, STATUS_ACTIVE)
+	if err != nil {
+		fmt.Printf("selectPolicyGrants: [%d] %v\n", domainId, err)
+		return nil, err
+	}
+	defer rows.Close()
+	users := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		users[id] = name
+	}
+
+	grants := []*model.RevisionGrant{}
+	for key, d := range access.users {
+		app, user := apps[key[1]], users[key[0]]
+		if !d.allowed || app == nil || user == "" {
+			continue
+		}
+		grants = append(grants, &model.RevisionGrant{UserID: key[0], User: user, Service: app.ServiceName,
+			AppID: app.ID, App: app.Name, AllowedIPs: app.AllowedIPs, Rules: app.Rules})
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		a, b := grants[i], grants[j]
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.App < b.App
+	})
+	return grants, nil
+}
+
+// Change of the first revision of a domain, its policy before any change
+const POLICY_BASELINE = "baseline"
+
+// Domains known to have a revision
+var policyBaselines sync.Map
+
+// EnsurePolicyBaseline records the policy of the domain as its first
+// revision, if it has none. Called before a change
+func EnsurePolicyBaseline(domainId int) {
+	if _, ok := policyBaselines.Load(domainId); ok {
+		return
+	}
+	recordPolicyRevision(domainId, nil, POLICY_BASELINE, true)
+}
+
+// Record a revision of the policy of the domain after a change, unless
+// the access rows and grants are those of the latest revision. Returns the
+// new revision, nil if there is none
+func RecordPolicyRevision(domainId int, actor *model.User2, change string) *model.PolicyRevision {
+	return recordPolicyRevision(domainId, actor, change, false)
+}
+
+// Only if the domain has no revision if baseline
+func recordPolicyRevision(domainId int, actor *model.User2, change string, baseline bool) *model.PolicyRevision {
+	db := setupDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Printf("RecordPolicyRevision: [%d] %v\n", domainId, err)
+		return nil
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, REVISION_LOCK, domainId); err != nil {
+		fmt.Printf("RecordPolicyRevision: [%d] %v\n", domainId, err)
+		return nil
+	}
+
+	snapshot, err := selectPolicySnapshot(domainId)
+	if err != nil {
+		return nil
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		fmt.Printf("RecordPolicyRevision: [%d] %v\n", domainId, err)
+		return nil
+	}
+
+	var revision int
+	var last sql.NullString
+	query := `SELECT revision, snapshot FROM policy_revisions
+				WHERE domain_id=$1 ORDER BY revision DESC LIMIT 1`
+	err = tx.QueryRow(query, domainId).Scan(&revision, &last)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Printf("RecordPolicyRevision: [%d] %v\n", domainId, err)
+		return nil
+	}
+	if err == nil {
+		policyBaselines.Store(domainId, true)
+		if baseline {
+			return nil
+		}
+	}
+	if last.Valid && last.String == string(data) {
+		// Policy did not change
+		return nil
+	}
+
+	var role string
+	var actorId int
+	var actorName string
+	if actor != nil {
+		role, actorId, actorName = actor.Role, actor.ID, actor.Name
+	}
+	revision++
+	query = `INSERT INTO policy_revisions (domain_id, revision, change, actor_role, actor_id, actor_name, grants, snapshot, create_time)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.Exec(query, domainId, revision, change, role, actorId, actorName, len(snapshot.Grants), string(data), time.Now())
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Printf("RecordPolicyRevision: [%d] %s %v\n", domainId, change, err)
+		return nil
+	}
+	fmt.Printf("RecordPolicyRevision: [%d] revision %d %s - %d grants\n", domainId, revision, change, len(snapshot.Grants))
+	policyBaselines.Store(domainId, true)
+	if !baseline {
+		notifyPolicyChange(domainId)
+	}
+	return SelectPolicyRevision(domainId, revision)
+}
+
+// Revisions of the policy of the domain, newest first, without snapshots
+func SelectPolicyRevisions(domainId int) []*model.PolicyRevision {
+	db := setupDB()
+
+	query := `SELECT revision, change, actor_role, actor_id, actor_name, grants, create_time, NULL
+				FROM policy_revisions
+				WHERE domain_id=$1
+				ORDER BY revision DESC`
+	rows, err := db.Query(query, domainId)
+	if err != nil {
+		fmt.Printf("SelectPolicyRevisions: [%d] %v\n", domainId, err)
+		return nil
+	}
+	defer rows.Close()
+
+	revisions := []*model.PolicyRevision{}
+	for {
+		revision := readPolicyRevisionRow(rows)
+		if revision == nil {
+			break
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions
+}
+
+// Revision of the policy of the domain with its snapshot
+func SelectPolicyRevision(domainId int, revision int) *model.PolicyRevision {
+	db := setupDB()
+
+	query := `SELECT revision, change, actor_role, actor_id, actor_name, grants, create_time, snapshot
+				FROM policy_revisions
+				WHERE domain_id=$1 AND revision=$2`
+	rows, err := db.Query(query, domainId, revision)
+	if err != nil {
+		fmt.Printf("SelectPolicyRevision: [%d %d] %v\n", domainId, revision, err)
+		return nil
+	}
+	defer rows.Close()
+	return readPolicyRevisionRow(rows)
+}
+
+// Grants added and removed from revision from to revision to, by user and
+// service
+func DiffPolicyRevisions(domainId int, from int, to int) (*model.PolicyDiff, error) {
+	a := SelectPolicyRevision(domainId, from)
+	if a == nil || a.Snapshot == nil {
+		return nil, fmt.Errorf("revision %d unknown", from)
+	}
+	b := SelectPolicyRevision(domainId, to)
+	if b == nil || b.Snapshot == nil {
+		return nil, fmt.Errorf("revision %d unknown", to)
+	}
+
+	// A grant is the same if the app still has the same destinations
+	key := func(g *model.RevisionGrant) string {
+		rules, _ := json.Marshal(g.Rules)
+		return fmt.Sprintf("%d|%d|%s|%s", g.UserID, g.AppID, g.AllowedIPs, rules)
+	}
+	inA := make(map[string]bool)
+	for _, g := range a.Snapshot.Grants {
+		inA[key(g)] = true
+	}
+	inB := make(map[string]bool)
+	for _, g := range b.Snapshot.Grants {
+		inB[key(g)] = true
+	}
+
+	diff := &model.PolicyDiff{From: from, To: to, Users: []*model.UserPolicyDiff{}}
+	users := make(map[string]*model.UserPolicyDiff)
+	services := make(map[[2]string]*model.ServicePolicyDiff)
+	serviceDiff := func(g *model.RevisionGrant) *model.ServicePolicyDiff {
+		u := users[g.User]
+		if u == nil {
+			u = &model.UserPolicyDiff{User: g.User}
+			users[g.User] = u
+			diff.Users = append(diff.Users, u)
+		}
+		s := services[[2]string{g.User, g.Service}]
+		if s == nil {
+			s = &model.ServicePolicyDiff{Service: g.Service}
+			services[[2]string{g.User, g.Service}] = s
+			u.Services = append(u.Services, s)
+		}
+		return s
+	}
+	for _, g := range a.Snapshot.Grants {
+		if !inB[key(g)] {
+			s := serviceDiff(g)
+			s.Removed = append(s.Removed, g)
+			diff.Removed++
+		}
+	}
+	for _, g := range b.Snapshot.Grants {
+		if !inA[key(g)] {
+			s := serviceDiff(g)
+			s.Added = append(s.Added, g)
+			diff.Added++
+		}
+	}
+
+	sort.Slice(diff.Users, func(i, j int) bool { return diff.Users[i].User < diff.Users[j].User })
+	for _, u := range diff.Users {
+		sort.Slice(u.Services, func(i, j int) bool { return u.Services[i].Service < u.Services[j].Service })
+	}
+	return diff, nil
+}
+
+// Restore the access rows of the domain to those of the revision, and
+// record the result as a new revision. Rows of users, groups or apps
+// deleted since are left out. Group members and apps are not restored
+func RollbackPolicy(domainId int, revision int, actor *model.User2) (*model.PolicyRevision, error) {
+	db := setupDB()
+
+	target := SelectPolicyRevision(domainId, revision)
+	if target == nil || target.Snapshot == nil {
+		return nil, fmt.Errorf("revision %d unknown", revision)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, REVISION_LOCK, domainId); err != nil {
+		fmt.Printf("RollbackPolicy: [%d %d] %v\n", domainId, revision, err)
+		return nil, err
+	}
+
+	query := `UPDATE user_access_control SET status=$1
+				WHERE status=$2 AND user_id IN (SELECT id FROM users WHERE domain_id=$3)`
+	if _, err = tx.Exec(query, STATUS_DELETED, STATUS_ACTIVE, domainId); err != nil {
+		fmt.Printf("RollbackPolicy: [%d %d] %v\n", domainId, revision, err)
+		return nil, err
+	}
+	query = `UPDATE group_access_control SET status=$1
+				WHERE status=$2 AND group_id IN (SELECT id FROM user_groups WHERE domain_id=$3)`
+	if _, err = tx.Exec(query, STATUS_DELETED, STATUS_ACTIVE, domainId); err != nil {
+		fmt.Printf("RollbackPolicy: [%d %d] %v\n", domainId, revision, err)
+		return nil, err
+	}
+
+	restore := []struct {
+		rows  []*model.RevisionAccess
+		query string
+	}{
+		{target.Snapshot.Users, `INSERT INTO user_access_control (user_id, app_id, status, effect, valid_from, valid_until, schedule)
+					SELECT u.id, a.id, $3, $4, $5, $6, NULLIF($7, '')
+						FROM users u, apps a, services s
+						WHERE u.id=$1 AND u.domain_id=$8 AND u.status=$3
+							AND a.id=$2 AND a.status=$3 AND a.service_id=s.id AND s.domain_id=$8`},
+		{target.Snapshot.Groups, `INSERT INTO group_access_control (group_id, app_id, status, effect, valid_from, valid_until, schedule)
+					SELECT g.id, a.id, $3, $4, $5, $6, NULLIF($7, '')
+						FROM user_groups g, apps a, services s
+						WHERE g.id=$1 AND g.domain_id=$8 AND g.status=$3
+							AND a.id=$2 AND a.status=$3 AND a.service_id=s.id AND s.domain_id=$8`},
+	}
+	var restored, skipped int
+	for _, r := range restore {
+		for _, row := range r.rows {
+			result, err := tx.Exec(r.query, row.OwnerID, row.AppID, STATUS_ACTIVE, row.Effect,
+				row.ValidFrom, row.ValidUntil, row.Schedule, domainId)
+			if err != nil {
+				fmt.Printf("RollbackPolicy: [%d %d] %s %s %v\n", domainId, revision, row.Owner, row.App, err)
+				return nil, err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				skipped++
+				continue
+			}
+			restored++
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("RollbackPolicy: [%d] to revision %d - %d rows restored, %d skipped\n", domainId, revision, restored, skipped)
+
+	result := RecordPolicyRevision(domainId, actor, fmt.Sprintf("rollback to revision %d", revision))
+	if result == nil {
+		// Nothing changed, the latest revision is in effect
+		if revisions := SelectPolicyRevisions(domainId); len(revisions) > 0 {
+			result = revisions[0]
+		}
+	}
+	return result, nil
+}
+
+func readPolicyRevisionRow(rows *sql.Rows) *model.PolicyRevision {
+	var change sql.NullString
+	var role sql.NullString
+	var actorId sql.NullInt32
+	var actorName sql.NullString
+	var createTime sql.NullTime
+	var snapshot sql.NullString
+
+	if !rows.Next() {
+		return nil
+	}
+
+	r := model.PolicyRevision{}
+	err := rows.Scan(&r.Revision, &change, &role, &actorId, &actorName, &r.Grants, &createTime, &snapshot)
+	if err != nil {
+		fmt.Printf("readPolicyRevisionRow Scan: %v\n", err)
+		return nil
+	}
+	r.Change, r.ActorRole, r.ActorID, r.ActorName = change.String, role.String, int(actorId.Int32), actorName.String
+	if createTime.Valid {
+		r.CreateTime = &createTime.Time
+	}
+	if snapshot.Valid {
+		r.Snapshot = &model.PolicySnapshot{}
+		if err = json.Unmarshal([]byte(snapshot.String), r.Snapshot); err != nil {
+			fmt.Printf("readPolicyRevisionRow: revision %d %v\n", r.Revision, err)
+			r.Snapshot = nil
+		}
+	}
+	return &r
+}
@@ -3,10 +3,10 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"math/rand"
 	"strings"
 	"time"
 
+	"github.com/saroopmathur/rest-api/auth"
 	model "github.com/saroopmathur/rest-api/models"
 )
 
@@ -14,18 +14,36 @@ import (
 func InsertService(domainId int, service *model.Service) (*model.Service2, error) {
 	db := setupDB()
 
+	hash, err := auth.HashPassword(service.Password)
+	if err != nil {
+		return nil, err
+	}
+
 	// Delete if any record with the same name
 	query := `DELETE FROM services WHERE domain_id=$1 AND name=$2`
 	db.Exec(query, domainId, service.Name)
 
 	var lastInsertID int
-	query = `INSERT INTO services (domain_id, name, password, wg_key, status)
-						VALUES ($1, $2, $3, $4, $5) returning id`
-	err := db.QueryRow(query, domainId, service.Name, service.Password, service.WGKey, STATUS_ACTIVE).Scan(&lastInsertID)
+	var keyTime, sealedKey interface{}
+	if service.WGKey != "" {
+		keyTime = time.Now()
+	}
+	if service.SealedKey != "" {
+		sealedKey = service.SealedKey
+	}
+	query = `INSERT INTO services (domain_id, name, password, wg_key, wg_key_time, wg_private_key, status)
+						VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+	err = db.QueryRow(query, domainId, service.Name, hash, service.WGKey, keyTime, sealedKey, STATUS_ACTIVE).Scan(&lastInsertID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Virtual IP from the pool of the domain, unless one is given
+	if _, err = assignVirtualIP(domainId, "service", lastInsertID, service.VirtualIP); err != nil {
+		db.Exec(`DELETE FROM services WHERE id=$1`, lastInsertID)
+		return nil, err
+	}
+
 	// Create an entry in apps table
 	query = `INSERT INTO apps (name, service_id, status)
 						VALUES ($1, $2, $3)`
@@ -95,10 +113,21 @@ func UpdateService(domainId int, serviceName string, serviceId int, service *mod
 		params += "name='" + service.Name + "', "
 	}
 	if service.Password != "" {
-		params += "password='" + service.Password + "', "
+		hash, err := auth.HashPassword(service.Password)
+		if err != nil {
+			fmt.Printf("UpdateService: [%s %d] %v\n", serviceName, serviceId, err)
+			return nil
+		}
+		params += "password='" + hash + "', "
 	}
 	if service.WGKey != "" {
-		params += "wg_key='" + service.WGKey + "', "
+		// A key set by the agent or admin replaces the generated one
+		params += "wg_key='" + service.WGKey + "', wg_key_time=now(), "
+		if service.SealedKey != "" {
+			params += "wg_private_key='" + service.SealedKey + "', "
+		} else {
+			params += "wg_private_key=NULL, "
+		}
 	}
 	if service.LocalIP != "" {
 		params += "local_ip='" + service.LocalIP + "', "
@@ -106,9 +135,6 @@ func UpdateService(domainId int, serviceName string, serviceId int, service *mod
 	if service.PublicIP != "" {
 		params += "public_ip='" + service.PublicIP + "', "
 	}
-	if service.VirtualIP != "" {
-		params += "virtual_ip='" + service.VirtualIP + "', "
-	}
 	if params == "" {
 		// Nothing to update
 		return nil
@@ -130,6 +156,7 @@ func UpdateService(domainId int, serviceName string, serviceId int, service *mod
 		fmt.Printf("%s: [%s %d] %v\n", query, serviceName, serviceId, err)
 		return nil
 	}
+	notifyPolicyChange(domainId)
 
 	// Select the updated record and return
 	return SelectService(domainId, serviceName, serviceId)
@@ -152,7 +179,7 @@ func DeleteService(domainId int, serviceName string, serviceId int) *model.Servi
 		return nil
 	}
 
-	query = "UPDATE services SET status=$1 WHERE id=$2 AND domain_id=$3"
+	query = "UPDATE services SET status=$1, virtual_ip=NULL WHERE id=$2 AND domain_id=$3"
 	_, err = db.Exec(query, STATUS_DELETED, deleted_service.ID, domainId)
 
 	fmt.Printf("%s domainId=%d serviceId=%d serviceName=%s err=%v\n", query, domainId, serviceId, serviceName, err)
@@ -160,50 +187,19 @@ func DeleteService(domainId int, serviceName string, serviceId int) *model.Servi
 		fmt.Printf("%s: [%s %d] %v\n", query, serviceName, serviceId, err)
 		return nil
 	}
+	notifyPolicyChange(domainId)
 
 	return deleted_service
 }
 
 func GenerateAndSaveServiceToken(s *model.Service2) {
-	db := setupDB()
-
-	token := fmt.Sprintf("S%d", rand.Int63())
-
-	query := `INSERT INTO sessions (uid, session_id, domain_id, role, start_time, status)
-				     VALUES ($1, $2, $3, $4, $5, $6)`
-
-	_, err := db.Query(query, s.ID, token, s.Domain.ID, ROLE_SERVICE, time.Now(), STATUS_ACTIVE)
+	token, refresh, err := insertSession(s.ID, s.Domain.ID, ROLE_SERVICE)
 	if err != nil {
-		fmt.Printf("%d: %s\n", s.ID, token)
+		fmt.Printf("GenerateToken: %d %v\n", s.ID, err)
 		return
 	}
 	s.SessionID = token
-}
-
-func GetServiceByToken(token string) *model.Service2 {
-	if !strings.HasPrefix(token, "S") {
-		return nil
-	}
-	fmt.Printf("GetServiceByToken: %s\n", token)
-	db := setupDB()
-
-	query := `SELECT s.id, s.name, s.password, s.icon, s.wg_key, s.local_ip, s.public_ip, s.virtual_ip, d.id, d.name, d.status
-				FROM sessions sess, services s, domains d
-				WHERE sess.session_id=$1
-					AND s.domain_id=d.id
-					AND s.id=sess.uid
-					AND sess.role=$2
-					AND sess.status=$3`
-
-	//fmt.Printf("%s: [%s]\n", query, token)
-	rows, err := db.Query(query, token, ROLE_SERVICE, STATUS_ACTIVE)
-	if err != nil {
-		fmt.Printf("%s\n", token)
-		return nil
-	}
-	defer rows.Close()
-
-	return readServiceRow(rows)
+	s.RefreshToken = refresh
 }
 
 func GetServiceByName(servicename string) *model.Service2 {
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/saroopmathur/rest-api/auth"
+	"github.com/saroopmathur/rest-api/config"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Absolute session lifetime and idle timeout per role, 0 means no limit
+var SESSION_LIFETIME = map[string]time.Duration{
+	ROLE_USER:       24 * time.Hour,
+	ROLE_SERVICE:    30 * 24 * time.Hour,
+	ROLE_ADMIN:      8 * time.Hour,
+	ROLE_POWERADMIN: 8 * time.Hour,
+}
+
+var SESSION_IDLE = map[string]time.Duration{
+	ROLE_USER:       2 * time.Hour,
+	ROLE_SERVICE:    24 * time.Hour,
+	ROLE_ADMIN:      30 * time.Minute,
+	ROLE_POWERADMIN: 30 * time.Minute,
+}
+
+// How long a refresh token can be exchanged for a new session
+var REFRESH_LIFETIME = 30 * 24 * time.Hour
+
+// How often the JWT revocation list is reloaded, to see logouts on other instances
+var REVOCATION_REFRESH = 30 * time.Second
+
+var ErrSessionInvalid = errors.New("session invalid. Login again")
+var ErrSessionExpired = errors.New("session expired. Refresh or login again")
+
+func loadSessionConfig() {
+	roles := map[string]string{
+		"USER":    ROLE_USER,
+		"SERVICE": ROLE_SERVICE,
+		"ADMIN":   ROLE_ADMIN,
+	}
+	for name, role := range roles {
+		lifetime := SESSION_LIFETIME[role]
+		idle := SESSION_IDLE[role]
+		config.Duration("SESSION_LIFETIME_"+name, &lifetime)
+		config.Duration("SESSION_IDLE_"+name, &idle)
+		SESSION_LIFETIME[role] = lifetime
+		SESSION_IDLE[role] = idle
+	}
+	// Power admins follow the admin settings
+	SESSION_LIFETIME[ROLE_POWERADMIN] = SESSION_LIFETIME[ROLE_ADMIN]
+	SESSION_IDLE[ROLE_POWERADMIN] = SESSION_IDLE[ROLE_ADMIN]
+
+	config.Duration("REFRESH_LIFETIME", &REFRESH_LIFETIME)
+	config.Duration("REVOCATION_REFRESH", &REVOCATION_REFRESH)
+	config.Duration("OIDC_STATE_LIFETIME", &OIDC_STATE_LIFETIME)
+}
+
+// Absolute lifetime of a session of the role
+func SessionLifetime(role string) time.Duration {
+	return SESSION_LIFETIME[role]
+}
+
+// Random 256 bit token, hex encoded
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Tokens are only stored as their SHA-256 digest
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SessionDigest is the session_id stored for token, also used as "sid" of JWTs
+func SessionDigest(token string) string {
+	return hashToken(token)
+}
+
+// The session_id of the session token was issued for, either an opaque
+// session token or a JWT carrying it
+func sessionKey(token string) string {
+	if auth.IsJWT(token) {
+		claims, err := auth.VerifyJWT(token)
+		if claims == nil || (err != nil && err != auth.ErrTokenExpired) {
+			return ""
+		}
+		return claims.SessionID
+	}
+	return hashToken(token)
+}
+
+// Create a new session for uid, returns the session token and its refresh token
+func insertSession(uid int, domainId int, role string) (string, string, error) {
+	db := setupDB()
+
+	token, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+	refresh, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+
+	query := `INSERT INTO sessions (uid, session_id, refresh_token, domain_id, role, start_time, last_seen, refresh_expire_time, status)
+				     VALUES ($1, $2, $3, $4, $5, $6, $6, $7, $8)`
+	_, err = db.Exec(query, uid, hashToken(token), hashToken(refresh), domainId, role, now, now.Add(REFRESH_LIFETIME), STATUS_ACTIVE)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refresh, nil
+}
+
+// Lookup the session of token along with the user, service or admin it
+// belongs to. Logged out sessions and sessions of deleted principals return
+// ErrSessionInvalid. Sessions past their lifetime or idle timeout are marked
+// expired and return ErrSessionExpired, the client may then use its refresh
+// token. Otherwise the session is recorded as seen now
+func LookupSession(token string) (*model.Session, error) {
+	db := setupDB()
+
+	var name sql.NullString
+	var dname sql.NullString
+	var age int64
+	var idle int64
+
+	sess := &model.Session{}
+	now := time.Now()
+	query := `SELECT sess.id, sess.uid, sess.role, sess.status, sess.domain_id, d.name,
+				COALESCE(u.name, s.name, a.name),
+				EXTRACT(EPOCH FROM ($2::timestamp - sess.start_time))::bigint,
+				EXTRACT(EPOCH FROM ($2::timestamp - COALESCE(sess.last_seen, sess.start_time)))::bigint
+				FROM sessions sess
+					LEFT JOIN domains d ON sess.domain_id=d.id
+					LEFT JOIN users u ON sess.role=$3 AND u.id=sess.uid AND u.status=$6
+					LEFT JOIN services s ON sess.role=$4 AND s.id=sess.uid AND s.status=$6
+					LEFT JOIN admins a ON sess.role IN ($5, $7) AND a.id=sess.uid AND a.status=$6
+				WHERE sess.session_id=$1`
+	err := db.QueryRow(query, hashToken(token), now, ROLE_USER, ROLE_SERVICE, ROLE_ADMIN, STATUS_ACTIVE, ROLE_POWERADMIN).
+		Scan(&sess.ID, &sess.UID, &sess.Role, &sess.Status, &sess.Domain.ID, &dname, &name, &age, &idle)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionInvalid
+	}
+	if err != nil {
+		log.Printf("LookupSession: %v\n", err)
+		return nil, ErrSessionInvalid
+	}
+	sess.Name = name.String
+	sess.Domain.Name = dname.String
+
+	switch sess.Status {
+	case STATUS_ACTIVE:
+	case STATUS_EXPIRED:
+		return nil, ErrSessionExpired
+	default:
+		return nil, ErrSessionInvalid
+	}
+
+	if !name.Valid {
+		// user, service or admin no longer active
+		return nil, ErrSessionInvalid
+	}
+
+	lifetime := SESSION_LIFETIME[sess.Role]
+	idleTimeout := SESSION_IDLE[sess.Role]
+	if (lifetime > 0 && time.Duration(age)*time.Second > lifetime) ||
+		(idleTimeout > 0 && time.Duration(idle)*time.Second > idleTimeout) {
+		query = `UPDATE sessions SET status=$1, end_time=$2 WHERE id=$3 AND status=$4`
+		_, err = db.Exec(query, STATUS_EXPIRED, now, sess.ID, STATUS_ACTIVE)
+		if err != nil {
+			log.Printf("LookupSession: expire %v\n", err)
+		}
+		return nil, ErrSessionExpired
+	}
+
+	query = `UPDATE sessions SET last_seen=$1 WHERE id=$2`
+	_, err = db.Exec(query, now, sess.ID)
+	if err != nil {
+		log.Printf("LookupSession: last_seen %v\n", err)
+	}
+	return sess, nil
+}
+
+// Exchange a refresh token for a new session. The old session is ended and
+// its refresh token can not be used again. Returns the new session token
+// and refresh token
+func RefreshSession(refresh string) (string, string, error) {
+	db := setupDB()
+
+	var uid int
+	var domainId int
+	var role string
+
+	// Refresh tokens are single use, end the old session in the same statement
+	query := `UPDATE sessions SET status=$1, end_time=$2, refresh_token=NULL
+				WHERE refresh_token=$3
+					AND refresh_expire_time > $2
+					AND status IN ($4, $5)
+				RETURNING uid, domain_id, role`
+	err := db.QueryRow(query, STATUS_DELETED, time.Now(), hashToken(refresh), STATUS_ACTIVE, STATUS_EXPIRED).Scan(&uid, &domainId, &role)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("RefreshSession: %v\n", err)
+		}
+		return "", "", ErrSessionInvalid
+	}
+
+	return insertSession(uid, domainId, role)
+}
+
+// JWT revocation list - session_id of sessions ended (logout, refresh,
+// domain change) before the JWTs issued for them expire. Reloaded by one
+// caller at a time without holding the lock, the others keep checking the
+// list being replaced
+var revokedMutex sync.RWMutex
+var revokedSessions = map[string]bool{}
+var revokedLoaded time.Time
+
+// Closed when the reload in progress is done, nil when there is none
+var revokedLoading chan struct{}
+
+// Revoked on this instance while a reload is in progress, the reloaded
+// list may have missed them
+var revokedDuringLoad map[string]bool
+
+func maxSessionLifetime() time.Duration {
+	var max time.Duration
+	for _, d := range SESSION_LIFETIME {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+func loadRevokedSessions() (map[string]bool, error) {
+	db := setupDB()
+
+	query := `SELECT session_id FROM sessions WHERE status=$1 AND end_time > $2`
+	rows, err := db.Query(query, STATUS_DELETED, time.Now().Add(-maxSessionLifetime()))
+	if err != nil {
+		log.Printf("loadRevokedSessions: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := map[string]bool{}
+	for rows.Next() {
+		var sid string
+		if rows.Scan(&sid) == nil {
+			revoked[sid] = true
+		}
+	}
+	return revoked, rows.Err()
+}
+
+// Reload the revocation list if it is stale. Until the first load is done
+// everyone waits for it, later only the caller doing the reload does
+func refreshRevokedSessions() {
+	revokedMutex.Lock()
+	if time.Since(revokedLoaded) <= REVOCATION_REFRESH {
+		revokedMutex.Unlock()
+		return
+	}
+	if loading := revokedLoading; loading != nil {
+		first := revokedLoaded.IsZero()
+		revokedMutex.Unlock()
+		if first {
+			<-loading
+		}
+		return
+	}
+	loading := make(chan struct{})
+	revokedLoading = loading
+	revokedDuringLoad = map[string]bool{}
+	revokedMutex.Unlock()
+
+	revoked, err := loadRevokedSessions()
+
+	revokedMutex.Lock()
+	if err == nil {
+		for sid := range revokedDuringLoad {
+			revoked[sid] = true
+		}
+		revokedSessions = revoked
+		revokedLoaded = time.Now()
+	}
+	revokedLoading = nil
+	revokedDuringLoad = nil
+	revokedMutex.Unlock()
+	close(loading)
+}
+
+// IsSessionRevoked checks the sid of a JWT against the revocation list
+func IsSessionRevoked(sid string) bool {
+	refreshRevokedSessions()
+
+	revokedMutex.RLock()
+	defer revokedMutex.RUnlock()
+	return revokedSessions[sid]
+}
+
+// Revoke on this instance right away, others see it on their next reload
+func revokeSession(sid string) {
+	revokedMutex.Lock()
+	revokedSessions[sid] = true
+	if revokedDuringLoad != nil {
+		revokedDuringLoad[sid] = true
+	}
+	revokedMutex.Unlock()
+}
+
+// Replace the session of token by a new one in domainId, for a JWT whose
+// domain claim can not be changed. Returns the new session and refresh tokens
+func replaceSession(token string, domainId int) (string, string, error) {
+	db := setupDB()
+
+	var uid int
+	var role string
+
+	sid := sessionKey(token)
+	query := `UPDATE sessions SET status=$1, end_time=$2, refresh_token=NULL
+				WHERE session_id=$3 AND status=$4
+				RETURNING uid, role`
+	err := db.QueryRow(query, STATUS_DELETED, time.Now(), sid, STATUS_ACTIVE).Scan(&uid, &role)
+	if err != nil {
+		return "", "", err
+	}
+	revokeSession(sid)
+
+	return insertSession(uid, domainId, role)
+}
@@ -7,15 +7,17 @@ import (
 	model "github.com/saroopmathur/rest-api/models"
 )
 
-// Select returns the whole database
-func SelectUserAccessAll() *[]model.UserAccess2 {
+// Apps allowed to each user of the domain. Grants not in effect now are
+// left out unless all
+func SelectUserAccessAll(domainId int, all bool) *[]model.UserAccess2 {
 	db := setupDB()
 
+	loc := domainLocation(domainId)
 	query := `SELECT id, name
 				FROM users
-				WHERE status=$1
+				WHERE status=$1 AND domain_id=$2
 				ORDER BY id`
-	rows, err := db.Query(query, STATUS_ACTIVE)
+	rows, err := db.Query(query, STATUS_ACTIVE, domainId)
 	if err != nil {
 		fmt.Printf("SelectUserAccessAll: %v\n", err)
 		return nil
@@ -30,7 +32,7 @@ func SelectUserAccessAll() *[]model.UserAccess2 {
 		_ = rows.Scan(&uid, &uname)
 
 		// Get the list of allowed ip for each users
-		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed
+		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed, ua.effect, ua.valid_from, ua.valid_until, ua.schedule
 					FROM user_access_control ua INNER JOIN apps a ON ua.app_id=a.id
 					WHERE ua.user_id=$1 AND ua.status=$2`
 		rows2, err := db.Query(query, uid, STATUS_ACTIVE)
@@ -45,10 +47,16 @@ func SelectUserAccessAll() *[]model.UserAccess2 {
 			var aname string
 			var serviceId int
 			var allowed string
+			var effect string
+			var from, until sql.NullTime
+			var sched sql.NullString
 
-			_ = rows2.Scan(&aid, &aname, &serviceId, &allowed)
+			_ = rows2.Scan(&aid, &aname, &serviceId, &allowed, &effect, &from, &until, &sched)
 
-			apps = append(apps, model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed})
+			app := model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed, Effect: effect}
+			if a := accessApp(app, from, until, sched, loc, all); a != nil {
+				apps = append(apps, *a)
+			}
 		}
 
 		uacs = append(uacs, model.UserAccess2{ID: uid, User: uname, Apps: apps})
@@ -60,20 +68,23 @@ func SelectUserAccessAll() *[]model.UserAccess2 {
 	return &uacs
 }
 
-func SelectUserAccess(domainId int, uname string, uid int) *[]model.App {
+// Apps allowed to the user. Grants not in effect now are left out unless
+// all
+func SelectUserAccess(domainId int, uname string, uid int, all bool) *[]model.App {
 	db := setupDB()
 
 	var rows *sql.Rows
 	var err error
+	loc := domainLocation(domainId)
 
 	// Get the list of allowed ip for each users
 	if uid == 0 {
-		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed
+		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed, ua.effect, ua.valid_from, ua.valid_until, ua.schedule
 					FROM user_access_control ua INNER JOIN apps a ON ua.app_id=a.id
 					WHERE ua.user_id=(SELECT id FROM users WHERE name=$1 AND domain_id=$2) AND ua.status=$3`
-		rows, err = db.Query(query, uname, STATUS_ACTIVE)
+		rows, err = db.Query(query, uname, domainId, STATUS_ACTIVE)
 	} else {
-		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed
+		query := `SELECT a.id, a.name, a.service_id, a.allowed_ips AS allowed, ua.effect, ua.valid_from, ua.valid_until, ua.schedule
 					FROM user_access_control ua INNER JOIN apps a ON ua.app_id=a.id
 					WHERE ua.user_id=$1 AND ua.status=$2`
 		rows, err = db.Query(query, uid, STATUS_ACTIVE)
@@ -90,10 +101,16 @@ func SelectUserAccess(domainId int, uname string, uid int) *[]model.App {
 		var aname string
 		var serviceId int
 		var allowed string
+		var effect string
+		var from, until sql.NullTime
+		var sched sql.NullString
 
-		_ = rows.Scan(&aid, &aname, &serviceId, &allowed)
+		_ = rows.Scan(&aid, &aname, &serviceId, &allowed, &effect, &from, &until, &sched)
 
-		apps = append(apps, model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed})
+		app := model.App{ID: aid, Name: aname, ServiceId: serviceId, AllowedIPs: allowed, Effect: effect}
+		if a := accessApp(app, from, until, sched, loc, all); a != nil {
+			apps = append(apps, *a)
+		}
 	}
 
 	// close database
@@ -102,43 +119,106 @@ func SelectUserAccess(domainId int, uname string, uid int) *[]model.App {
 	return &apps
 }
 
-// Insert allows populating database
-func InsertUac(domainId int, userName string, userId int, appName string, appId int) (*model.UserAccess, error) {
+// Apps the user can reach, allowed to the user or to one of its groups
+// and not denied
+func SelectUserApps(domainId int, userId int) []*model.App {
 	db := setupDB()
 
+	query := `SELECT a.id, a.name, a.service_id, a.allowed_ips, s.name
+				FROM apps a JOIN services s ON a.service_id=s.id
+				WHERE s.domain_id=$1 AND a.status=$2 AND s.status=$2
+					AND (a.id IN (SELECT ua.app_id FROM user_access_control ua
+							WHERE ua.user_id=$3 AND ua.status=$2)
+						OR a.id IN (SELECT ga.app_id FROM group_access_control ga, ` + groupMembersAll(4) + ` mem
+							WHERE ga.group_id=mem.group_id AND mem.user_id=$3 AND ga.status=$2))
+				ORDER BY s.name, a.name`
+	rows, err := db.Query(query, domainId, STATUS_ACTIVE, userId, dynamicMembers(domainId, userId))
+	if err != nil {
+		fmt.Printf("SelectUserApps: %d %v\n", userId, err)
+		return nil
+	}
+	defer rows.Close()
+
+	access, err := selectEffectiveAccess(domainId, userId)
+	if err != nil {
+		return nil
+	}
+
+	var apps []*model.App
+	for {
+		app := readAppRow(rows)
+		if app == nil {
+			break
+		}
+		if access.allowed(userId, app.ID) {
+			apps = append(apps, app)
+		}
+	}
+	attachAppRules(apps)
+	return apps
+}
+
+// Insert allows populating database. effect allow or deny, grant limits
+// when the access is in effect, nil - permanent
+func InsertUac(domainId int, userName string, userId int, appName string, appId int, effect string, grant *model.AccessGrant) (*model.UserAccess, error) {
+	db := setupDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	var query string
-	var err error
 
 	var lastInsertID int
 	if userId > 0 && appId > 0 {
 		query = `DELETE FROM user_access_control WHERE user_id=$1 AND app_id=$2`
-		db.Exec(query, userId, appId)
+		_, err = tx.Exec(query, userId, appId)
 
-		query = `INSERT INTO user_access_control (user_id, app_id, status)
-					VALUES ($1, $2, $3) returning id`
-		err = db.QueryRow(query, userId, appId, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO user_access_control (user_id, app_id, status, effect, valid_from, valid_until, schedule)
+					VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+		if err == nil {
+			args := append([]interface{}{userId, appId, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
 	} else if userId > 0 {
 		query = `DELETE FROM user_access_control WHERE user_id=$1 AND app_id=(SELECT id FROM apps WHERE name=$2)`
-		db.Exec(query, userId, appName)
+		_, err = tx.Exec(query, userId, appName)
 
-		query = `INSERT INTO user_access_control (user_id, app_id, status)
-					VALUES ($1, (SELECT id FROM apps WHERE name=$2), $3) returning id`
-		err = db.QueryRow(query, userId, appName, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO user_access_control (user_id, app_id, status, effect, valid_from, valid_until, schedule)
+					VALUES ($1, (SELECT id FROM apps WHERE name=$2), $3, $4, $5, $6, $7) returning id`
+		if err == nil {
+			args := append([]interface{}{userId, appName, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
 	} else if appId > 0 {
 		query = `DELETE FROM user_access_control WHERE user_id=(SELECT id FROM users WHERE domain_id=$1 AND name=$2) AND app_id=$3`
-		db.Exec(query, domainId, userName, appId)
+		_, err = tx.Exec(query, domainId, userName, appId)
 
-		query = `INSERT INTO user_access_control (user_id, app_id, status)
-					VALUES ((SELECT id FROM users WHERE domain_id=$1 AND name=$2), $3, $4) returning id`
-		err = db.QueryRow(query, domainId, userName, appId, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO user_access_control (user_id, app_id, status, effect, valid_from, valid_until, schedule)
+					VALUES ((SELECT id FROM users WHERE domain_id=$1 AND name=$2), $3, $4, $5, $6, $7, $8) returning id`
+		if err == nil {
+			args := append([]interface{}{domainId, userName, appId, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
 
 	} else {
 		query = `DELETE FROM user_access_control WHERE user_id=(SELECT id FROM users WHERE domain_id=$1 AND name=$2) AND app_id=(SELECT id FROM apps WHERE name=$3)`
-		db.Exec(query, domainId, userName, appName)
+		_, err = tx.Exec(query, domainId, userName, appName)
 
-		query = `INSERT INTO user_access_control (user_id, app_id, status)
-					VALUES ((SELECT id FROM users WHERE domain_id=$1 AND name=$2), (SELECT id FROM apps WHERE name=$3), $4) returning id`
-		err = db.QueryRow(query, domainId, userName, appName, STATUS_ACTIVE).Scan(&lastInsertID)
+		query = `INSERT INTO user_access_control (user_id, app_id, status, effect, valid_from, valid_until, schedule)
+					VALUES ((SELECT id FROM users WHERE domain_id=$1 AND name=$2), (SELECT id FROM apps WHERE name=$3), $4, $5, $6, $7, $8) returning id`
+		if err == nil {
+			args := append([]interface{}{domainId, userName, appName, STATUS_ACTIVE}, grantColumns(effect, grant)...)
+			err = tx.QueryRow(query, args...).Scan(&lastInsertID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
 
 	// Select the inserted record and return
@@ -175,21 +255,26 @@ func DeleteUac(domainId int, userName string, userId int, appName string, appId
 func SelectUac(uaid int) *model.UserAccess {
 	db := setupDB()
 
-	query := `SELECT id, user_id, app_id
+	query := `SELECT id, user_id, app_id, effect, valid_from, valid_until, schedule
 				FROM user_access_control
 				WHERE id=$1 AND status=$2`
 	rows, err := db.Query(query, uaid, STATUS_ACTIVE)
 	checkErr(err)
+	defer rows.Close()
 
 	var uac *model.UserAccess
 
 	var id int
 	var user int
 	var app int
+	var effect string
+	var from, until sql.NullTime
+	var sched sql.NullString
 
 	if rows.Next() {
-		_ = rows.Scan(&id, &user, &app)
-		uac = &model.UserAccess{ID: id, User: user, App: app}
+		_ = rows.Scan(&id, &user, &app, &effect, &from, &until, &sched)
+		uac = &model.UserAccess{ID: id, User: user, App: app, Effect: effect}
+		uac.Grant, _ = readGrant(from, until, sched)
 	}
 
 	return uac
@@ -3,10 +3,10 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"math/rand"
 	"strings"
 	"time"
 
+	"github.com/saroopmathur/rest-api/auth"
 	model "github.com/saroopmathur/rest-api/models"
 )
 
@@ -14,17 +14,38 @@ import (
 func InsertUser(domainId int, user *model.User) (*model.User2, error) {
 	db := setupDB()
 
+	// External users authenticate with the LDAP directory of the domain
+	external := user.External != nil && *user.External
+	var hash string
+	var err error
+	if !external || user.Password != "" {
+		hash, err = auth.HashPassword(user.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Delete if any record with the same name
 	query := `DELETE FROM users WHERE domain_id=$1 AND name=$2`
 	db.Exec(query, domainId, user.Name)
 
 	var lastInsertID int
-	query = `INSERT INTO users (domain_id, name, password, wg_key, status)
-						VALUES ($1, $2, $3, $4, $5) returning id`
-	err := db.QueryRow(query, domainId, user.Name, user.Password, user.WGKey, STATUS_ACTIVE).Scan(&lastInsertID)
+	var keyTime interface{}
+	if user.WGKey != "" {
+		keyTime = time.Now()
+	}
+	query = `INSERT INTO users (domain_id, name, password, wg_key, wg_key_time, external, status)
+						VALUES ($1, $2, $3, $4, $5, $6, $7) returning id`
+	err = db.QueryRow(query, domainId, user.Name, hash, user.WGKey, keyTime, external, STATUS_ACTIVE).Scan(&lastInsertID)
 	if err != nil {
 		return nil, err
 	}
+
+	// Virtual IP from the pool of the domain, unless one is given
+	if _, err = assignVirtualIP(domainId, "user", lastInsertID, user.VirtualIP); err != nil {
+		db.Exec(`DELETE FROM users WHERE id=$1`, lastInsertID)
+		return nil, err
+	}
 	//fmt.Printf("Created New User %s in domain %d\n", user.Name, domainId)
 
 	// Select the inserted record and return
@@ -35,7 +56,7 @@ func InsertUser(domainId int, user *model.User) (*model.User2, error) {
 func SelectUsers(domainId int, offset int, limit int, search string) []*model.User2 {
 	db := setupDB()
 
-	query := `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id AS did, d.name AS dname, d.status
+	query := `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id AS did, d.name AS dname, d.status
 				FROM users u LEFT JOIN domains d ON u.domain_id=d.id
 				WHERE u.domain_id=$1 AND u.status=$2 AND u.name LIKE $3 ORDER BY u.name OFFSET $4 LIMIT $5`
 	rows, err := db.Query(query, domainId, STATUS_ACTIVE, search+"%", offset, limit)
@@ -66,12 +87,12 @@ func SelectUser(domainId int, userName string, userId int) *model.User2 {
 	var user *model.User2
 
 	if userId == 0 {
-		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status
+		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status
 				FROM users u LEFT JOIN domains d ON u.domain_id=d.id
 				WHERE u.domain_id=$1 AND u.name=$2 AND u.status=$3`
 		rows, err = db.Query(query, domainId, userName, STATUS_ACTIVE)
 	} else {
-		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status
+		query = `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status
 				FROM users u LEFT JOIN domains d ON u.domain_id=d.id
 				WHERE u.domain_id=$1 AND u.id=$2 AND u.status=$3`
 		rows, err = db.Query(query, domainId, userId, STATUS_ACTIVE)
@@ -100,10 +121,15 @@ func UpdateUser(domainId int, userName string, userId int, user *model.User) *mo
 		params += "name='" + user.Name + "', "
 	}
 	if user.Password != "" {
-		params += "password='" + user.Password + "', "
+		hash, err := auth.HashPassword(user.Password)
+		if err != nil {
+			fmt.Printf("UpdateUser: [%s %d] %v\n", userName, userId, err)
+			return nil
+		}
+		params += "password='" + hash + "', "
 	}
 	if user.WGKey != "" {
-		params += "wg_key='" + user.WGKey + "', "
+		params += "wg_key='" + user.WGKey + "', wg_key_time=now(), "
 	}
 	if user.LocalIP != "" {
 		params += "local_ip='" + user.LocalIP + "', "
@@ -111,8 +137,8 @@ func UpdateUser(domainId int, userName string, userId int, user *model.User) *mo
 	if user.PublicIP != "" {
 		params += "public_ip='" + user.PublicIP + "', "
 	}
-	if user.VirtualIP != "" {
-		params += "virtual_ip='" + user.VirtualIP + "', "
+	if user.External != nil {
+		params += fmt.Sprintf("external=%t, ", *user.External)
 	}
 	if params == "" {
 		// Nothing to update
@@ -135,6 +161,7 @@ func UpdateUser(domainId int, userName string, userId int, user *model.User) *mo
 		fmt.Printf("%s: [%s %d] %v\n", query, userName, userId, err)
 		return nil
 	}
+	notifyPolicyChange(domainId)
 
 	// Select the updated record and return
 	return SelectUser(domainId, userName, userId)
@@ -154,59 +181,30 @@ func DeleteUser(domainId int, userName string, userId int) *model.User2 {
 	var err error
 	var query string
 	if userId > 0 {
-		query = "UPDATE users SET status=$1 WHERE id=$2 AND domain_id=$3"
+		query = "UPDATE users SET status=$1, virtual_ip=NULL WHERE id=$2 AND domain_id=$3"
 		_, err = db.Exec(query, STATUS_DELETED, userId, domainId)
 	} else {
-		query = "UPDATE users SET status=$1 WHERE name=$2 AND domain_id=$3"
+		query = "UPDATE users SET status=$1, virtual_ip=NULL WHERE name=$2 AND domain_id=$3"
 		_, err = db.Exec(query, STATUS_DELETED, userName, domainId)
 	}
 	if err != nil {
 		fmt.Printf("DeleteUser: [%s %d] domain %d - %v\n", userName, userId, domainId, err)
 		return nil
 	}
+	deleteUserDevices(deleted_user.ID)
+	notifyPolicyChange(domainId)
 
 	return deleted_user
 }
 
 func GenerateAndSaveUserToken(u *model.User2) {
-	db := setupDB()
-
-	token := fmt.Sprintf("U%d", rand.Int63())
-
-	query := `INSERT INTO sessions (uid, session_id, domain_id, role, start_time, status)
-				     VALUES ($1, $2, $3, $4, $5, $6)`
-
-	_, err := db.Query(query, u.ID, token, u.Domain.ID, ROLE_USER, time.Now(), STATUS_ACTIVE)
+	token, refresh, err := insertSession(u.ID, u.Domain.ID, ROLE_USER)
 	if err != nil {
-		fmt.Printf("GenerateToken: %s %v\n", token, err)
+		fmt.Printf("GenerateToken: %d %v\n", u.ID, err)
 		return
 	}
 	u.SessionID = token
-}
-
-func GetUserByToken(token string) *model.User2 {
-	if !strings.HasPrefix(token, "U") {
-		return nil
-	}
-	//fmt.Printf("GetUserByToken: %s\n", token)
-	db := setupDB()
-
-	query := `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status
-				FROM sessions sess, users u, domains d
-				WHERE sess.session_id=$1
-					AND sess.uid=u.id
-					AND u.domain_id=d.id
-					AND sess.status=$2`
-
-	//fmt.Printf("%s: [%s]\n", query, token)
-	rows, err := db.Query(query, token, STATUS_ACTIVE)
-	if err != nil {
-		fmt.Printf("%s\n", token)
-		return nil
-	}
-	defer rows.Close()
-
-	return readUserRow(rows, false)
+	u.RefreshToken = refresh
 }
 
 func GetUserByName(username string) *model.User2 {
@@ -221,7 +219,7 @@ func GetUserByName(username string) *model.User2 {
 
 	db := setupDB()
 
-	query := `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, d.id, d.name, d.status
+	query := `SELECT u.id, u.name, u.password, u.wg_key, u.local_ip, u.public_ip, u.virtual_ip, u.external, d.id, d.name, d.status
 				FROM users u LEFT JOIN domains d ON u.domain_id=d.id
 				WHERE u.name=$1 AND d.name=$2 AND u.status=$3`
 	//fmt.Printf("%s: [%s@%s]\n", query, name, domain)
@@ -248,6 +246,7 @@ func readUserRow(rows *sql.Rows, readGroup bool) *model.User2 {
 	var localIp sql.NullString
 	var publicIp sql.NullString
 	var virtualIp sql.NullString
+	var external bool
 
 	if !rows.Next() {
 		return nil
@@ -255,9 +254,9 @@ func readUserRow(rows *sql.Rows, readGroup bool) *model.User2 {
 
 	var err error
 	if readGroup {
-		err = rows.Scan(&userId, &name, &pass, &wgKey, &localIp, &publicIp, &virtualIp, &domainId, &dname, &dstatus, &groupId, &gname)
+		err = rows.Scan(&userId, &name, &pass, &wgKey, &localIp, &publicIp, &virtualIp, &external, &domainId, &dname, &dstatus, &groupId, &gname)
 	} else {
-		err = rows.Scan(&userId, &name, &pass, &wgKey, &localIp, &publicIp, &virtualIp, &domainId, &dname, &dstatus)
+		err = rows.Scan(&userId, &name, &pass, &wgKey, &localIp, &publicIp, &virtualIp, &external, &domainId, &dname, &dstatus)
 	}
 	if err != nil {
 		fmt.Printf("ReadUser Scan: %v\n", err)
@@ -276,6 +275,7 @@ func readUserRow(rows *sql.Rows, readGroup bool) *model.User2 {
 		LocalIP:   localIp.String,
 		PublicIP:  publicIp.String,
 		VirtualIP: virtualIp.String,
+		External:  external,
 	}
 	//fmt.Printf("ReadUser: %s\n", user.Name)
 	return &user
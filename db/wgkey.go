@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/saroopmathur/rest-api/auth"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Keys of the domain are due for rotation when set before this time, zero
+// time if the domain has no rotation policy
+func keyRotationCutoff(domainId int) (int, time.Time) {
+	domain := SelectDomain(domainId, "")
+	if domain == nil || domain.KeyRotationDays <= 0 {
+		return 0, time.Time{}
+	}
+	days := domain.KeyRotationDays
+	return days, time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+// WireGuard keypair of the service. The private key is only known if it
+// was generated by the server
+func GetServiceKeyPair(domainId int, serviceId int) (*model.WGKeyPair, error) {
+	db := setupDB()
+
+	var wgKey sql.NullString
+	var keyTime sql.NullTime
+	var sealedKey sql.NullString
+	query := `SELECT wg_key, wg_key_time, wg_private_key FROM services
+				WHERE id=$1 AND domain_id=$2 AND status=$3`
+	err := db.QueryRow(query, serviceId, domainId, STATUS_ACTIVE).Scan(&wgKey, &keyTime, &sealedKey)
+	if err != nil {
+		fmt.Printf("GetServiceKeyPair: [%d] %v\n", serviceId, err)
+		return nil, err
+	}
+
+	pair := &model.WGKeyPair{WGKey: wgKey.String}
+	if sealedKey.Valid {
+		pair.PrivateKey, err = auth.Unseal(sealedKey.String)
+		if err != nil {
+			fmt.Printf("GetServiceKeyPair: [%d] %v\n", serviceId, err)
+			return nil, err
+		}
+	}
+	if keyTime.Valid {
+		pair.KeyTime = &keyTime.Time
+	}
+	if days, cutoff := keyRotationCutoff(domainId); days > 0 && pair.WGKey != "" {
+		pair.RotateDue = pair.KeyTime == nil || pair.KeyTime.Before(cutoff)
+	}
+	return pair, nil
+}
+
+// Keys of active users, devices and services older than the rotation
+// policy of the domain, oldest first
+func SelectStaleKeys(domainId int) (*model.KeyRotationReport, error) {
+	db := setupDB()
+
+	days, cutoff := keyRotationCutoff(domainId)
+	if days == 0 {
+		return nil, fmt.Errorf("domain %d has no key rotation policy", domainId)
+	}
+
+	query := `SELECT 'user', id, name, wg_key, wg_key_time FROM users
+					WHERE domain_id=$1 AND status=$2 AND wg_key IS NOT NULL AND wg_key<>''
+						AND (wg_key_time IS NULL OR wg_key_time<$3)
+				UNION ALL
+				SELECT 'service', id, name, wg_key, wg_key_time FROM services
+					WHERE domain_id=$1 AND status=$2 AND wg_key IS NOT NULL AND wg_key<>''
+						AND (wg_key_time IS NULL OR wg_key_time<$3)
+				UNION ALL
+				SELECT 'device', d.id, u.name || '/' || d.name, d.wg_key, d.wg_key_time
+					FROM devices d JOIN users u ON d.user_id=u.id
+					WHERE u.domain_id=$1 AND u.status=$2 AND d.status=$2 AND d.wg_key IS NOT NULL AND d.wg_key<>''
+						AND (d.wg_key_time IS NULL OR d.wg_key_time<$3)
+				ORDER BY 5 NULLS FIRST, 3`
+	rows, err := db.Query(query, domainId, STATUS_ACTIVE, cutoff)
+	if err != nil {
+		fmt.Printf("SelectStaleKeys: [%d] %v\n", domainId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &model.KeyRotationReport{Days: days, Keys: []*model.StaleKey{}}
+	for rows.Next() {
+		key := &model.StaleKey{}
+		var keyTime sql.NullTime
+		if err = rows.Scan(&key.Kind, &key.ID, &key.Name, &key.WGKey, &keyTime); err != nil {
+			fmt.Printf("SelectStaleKeys Scan: %v\n", err)
+			return nil, err
+		}
+		if keyTime.Valid {
+			key.KeyTime = &keyTime.Time
+			key.AgeDays = int(time.Since(keyTime.Time) / (24 * time.Hour))
+		}
+		report.Keys = append(report.Keys, key)
+	}
+	return report, nil
+}
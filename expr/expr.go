@@ -0,0 +1,298 @@
+// Package expr parses and evaluates the membership rules of dynamic groups,
+// expressions over the attributes of a user. E.g.
+//
+//	department == "eng" && location in ["BLR", "SFO"]
+//
+// Operators, loosest first: ||, &&, !, and the comparisons ==, !=, in and
+// not in. A bare attribute name is true if the user has it set. Attributes
+// the user does not have compare as ""
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Longest rule accepted
+const MAX_LEN = 4096
+
+// Expr is a parsed rule
+type Expr interface {
+	Eval(attrs map[string]string) bool
+}
+
+type orExpr struct{ left, right Expr }
+type andExpr struct{ left, right Expr }
+type notExpr struct{ e Expr }
+type hasExpr struct{ name string }
+
+type cmpExpr struct {
+	name   string
+	op     string
+	values []string
+}
+
+func (e *orExpr) Eval(attrs map[string]string) bool {
+	return e.left.Eval(attrs) || e.right.Eval(attrs)
+}
+
+func (e *andExpr) Eval(attrs map[string]string) bool {
+	return e.left.Eval(attrs) && e.right.Eval(attrs)
+}
+
+func (e *notExpr) Eval(attrs map[string]string) bool {
+	return !e.e.Eval(attrs)
+}
+
+func (e *hasExpr) Eval(attrs map[string]string) bool {
+	return attrs[e.name] != ""
+}
+
+func (e *cmpExpr) Eval(attrs map[string]string) bool {
+	value := attrs[e.name]
+	found := false
+	for _, v := range e.values {
+		if v == value {
+			found = true
+			break
+		}
+	}
+	switch e.op {
+	case "==", "in":
+		return found
+	default:
+		return !found
+	}
+}
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// IsName reports whether s can be the name of an attribute
+func IsName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !isNameChar(c, i == 0) {
+			return false
+		}
+	}
+	return s != "in" && s != "not"
+}
+
+func isNameChar(c rune, first bool) bool {
+	if c == '_' || unicode.IsLetter(c) {
+		return true
+	}
+	return !first && (unicode.IsDigit(c) || c == '.' || c == '-')
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != c; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				b.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, b.String(), i})
+			i = j + 1
+		case isNameChar(c, true):
+			j := i
+			for j < len(runes) && isNameChar(runes[j], j == i) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[i:j]), i})
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(runes) {
+				if two := string(runes[i : i+2]); two == "==" || two == "!=" || two == "&&" || two == "||" {
+					op = two
+				}
+			}
+			switch op {
+			case "==", "!=", "&&", "||", "!", "(", ")", "[", "]", ",":
+			default:
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(kind int, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind int, text string) error {
+	if !p.accept(kind, text) {
+		return p.unexpected(text)
+	}
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return fmt.Errorf("expected %s at end of rule", want)
+	}
+	return fmt.Errorf("expected %s at %d, found %q", want, t.pos, t.text)
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept(tokOp, "||") {
+		var right Expr
+		if right, err = p.parseAnd(); err == nil {
+			left = &orExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept(tokOp, "&&") {
+		var right Expr
+		if right, err = p.parseUnary(); err == nil {
+			left = &andExpr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.accept(tokOp, "!") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e}, nil
+	}
+	if p.accept(tokOp, "(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(tokOp, ")")
+	}
+	return p.parseCmp()
+}
+
+func (p *parser) parseCmp() (Expr, error) {
+	t := p.peek()
+	if t.kind != tokIdent || !IsName(t.text) {
+		return nil, p.unexpected("attribute name")
+	}
+	p.next()
+	switch {
+	case p.accept(tokOp, "=="), p.accept(tokOp, "!="):
+		op := p.tokens[p.pos-1].text
+		v := p.peek()
+		if v.kind != tokString {
+			return nil, p.unexpected("string")
+		}
+		p.next()
+		return &cmpExpr{t.text, op, []string{v.text}}, nil
+	case p.accept(tokIdent, "in"):
+		values, err := p.parseList()
+		return &cmpExpr{t.text, "in", values}, err
+	case p.accept(tokIdent, "not"):
+		if err := p.expect(tokIdent, "in"); err != nil {
+			return nil, err
+		}
+		values, err := p.parseList()
+		return &cmpExpr{t.text, "not in", values}, err
+	}
+	return &hasExpr{t.text}, nil
+}
+
+func (p *parser) parseList() ([]string, error) {
+	if err := p.expect(tokOp, "["); err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		v := p.peek()
+		if v.kind != tokString {
+			return nil, p.unexpected("string")
+		}
+		p.next()
+		values = append(values, v.text)
+		if p.accept(tokOp, "]") {
+			return values, nil
+		}
+		if err := p.expect(tokOp, ","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parse parses a rule
+func Parse(rule string) (Expr, error) {
+	if len(rule) > MAX_LEN {
+		return nil, fmt.Errorf("rule longer than %d", MAX_LEN)
+	}
+	tokens, err := tokenize(rule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule: %v", err)
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = p.unexpected("&& or ||")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule: %v", err)
+	}
+	return e, nil
+}
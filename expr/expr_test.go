@@ -0,0 +1,138 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	attrs := map[string]string{
+		"department": "eng",
+		"location":   "BLR",
+		"title":      `say "hi"`,
+		"team.name":  "it's",
+	}
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		// Comparisons
+		{`department == "eng"`, true},
+		{`department == 'eng'`, true},
+		{`department != "eng"`, false},
+		{`department == "ENG"`, false},
+		{`missing == ""`, true},
+		{`missing != "x"`, true},
+
+		// Attribute set
+		{`department`, true},
+		{`missing`, false},
+		{`!missing`, true},
+
+		// in and not in
+		{`location in ["BLR", "SFO"]`, true},
+		{`location in ["SFO"]`, false},
+		{`location not in ["SFO", "NYC"]`, true},
+		{`location not in ["BLR"]`, false},
+		{`missing not in ["BLR"]`, true},
+		{`missing in [""]`, true},
+
+		// && binds tighter than ||
+		{`department == "ops" && location == "BLR" || department == "eng"`, true},
+		{`department == "eng" || department == "ops" && location == "SFO"`, true},
+		{`(department == "eng" || department == "ops") && location == "SFO"`, false},
+		{`department == "ops" || location == "SFO" && department == "eng"`, false},
+
+		// ! binds tighter than && and ||
+		{`!department == "ops"`, true},
+		{`!department == "eng" || location == "BLR"`, true},
+		{`!(department == "eng" || location == "SFO")`, false},
+		{`!!department`, true},
+		{`!department && location`, false},
+
+		// Quoting
+		{`title == "say \"hi\""`, true},
+		{`title == 'say "hi"'`, true},
+		{`team.name == 'it\'s'`, true},
+		{`team.name == "it's"`, true},
+		{`department in ["a,b", "eng"]`, true},
+		{`department == "eng "`, false},
+
+		// Whitespace is not significant
+		{"department==\"eng\"&&location in[\"BLR\"]", true},
+		{"\tdepartment == \"eng\"\n", true},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", tt.rule, err)
+			continue
+		}
+		if got := e.Eval(attrs); got != tt.want {
+			t.Errorf("Parse(%s).Eval = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`   `,
+		`department ==`,
+		`department == eng`,
+		`== "eng"`,
+		`department = "eng"`,
+		`department == "eng`,
+		`department == 'eng"`,
+		`department in "eng"`,
+		`department in []`,
+		`department in ["eng"`,
+		`department in ["eng",]`,
+		`department not ["eng"]`,
+		`department not in`,
+		`(department == "eng"`,
+		`department == "eng")`,
+		`department == "eng" location == "BLR"`,
+		`department == "eng" &&`,
+		`|| department`,
+		`!`,
+		`in == "x"`,
+		`not`,
+		`1department`,
+		`department & location`,
+		`department | location`,
+		strings.Repeat("a", MAX_LEN+1),
+	}
+	for _, rule := range tests {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", rule)
+		}
+	}
+}
+
+func TestIsName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"department", true},
+		{"_x", true},
+		{"team.name", true},
+		{"cost-center", true},
+		{"x1", true},
+		{"", false},
+		{"1x", false},
+		{".x", false},
+		{"-x", false},
+		{"a b", false},
+		{"a=b", false},
+		{"in", false},
+		{"not", false},
+		{"inside", true},
+	}
+	for _, tt := range tests {
+		if got := IsName(tt.name); got != tt.want {
+			t.Errorf("IsName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
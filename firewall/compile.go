@@ -0,0 +1,138 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/saroopmathur/rest-api/wg"
+)
+
+// Table of the nftables ruleset and chain of the iptables rules
+const (
+	NFT_TABLE      = "xpress"
+	IPTABLES_CHAIN = "XPRESS"
+)
+
+// NFTables compiles the apps of service into an nft -f script. It replaces
+// the xpress table atomically: traffic coming in on WG_INTERFACE, to the
+// node or forwarded, is dropped unless the user is granted the destination
+func NFTables(service string, apps []*App) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/usr/sbin/nft -f\n# Generated for service %s\n\n", service)
+	fmt.Fprintf(&b, "table inet %s\ndelete table inet %s\n\n", NFT_TABLE, NFT_TABLE)
+	fmt.Fprintf(&b, "table inet %s {\n", NFT_TABLE)
+	for _, hook := range []string{"input", "forward"} {
+		fmt.Fprintf(&b, "\tchain %s {\n", hook)
+		fmt.Fprintf(&b, "\t\ttype filter hook %s priority 0; policy accept;\n", hook)
+		fmt.Fprintf(&b, "\t\tiifname %q jump users\n", wg.WG_INTERFACE)
+		b.WriteString("\t}\n\n")
+	}
+
+	b.WriteString("\tchain users {\n")
+	b.WriteString("\t\tct state established,related accept\n")
+	for _, app := range apps {
+		fmt.Fprintf(&b, "\t\t# %s\n", app.Name)
+		for _, r := range app.Rules {
+			family := "ip"
+			icmp := "icmp"
+			if !r.ipv4() {
+				family = "ip6"
+				icmp = "ipv6-icmp"
+			}
+			users := usersOf(app, r.ipv4())
+			if len(users) == 0 {
+				continue
+			}
+
+			var match string
+			switch {
+			case r.Proto == PROTO_ICMP:
+				match = " meta l4proto " + icmp
+			case r.PortFrom > 0 && r.Proto == PROTO_ANY:
+				match = " meta l4proto { tcp, udp } th dport " + nftPorts(&r)
+			case r.PortFrom > 0:
+				match = " " + r.Proto + " dport " + nftPorts(&r)
+			case r.Proto != PROTO_ANY:
+				match = " meta l4proto " + r.Proto
+			}
+			fmt.Fprintf(&b, "\t\t%s saddr { %s } %s daddr %s%s accept\n",
+				family, strings.Join(users, ", "), family, r.Dest, match)
+		}
+	}
+	b.WriteString("\t\tdrop\n\t}\n}\n")
+	return b.String()
+}
+
+func nftPorts(r *Rule) string {
+	if r.PortFrom == r.PortTo {
+		return fmt.Sprint(r.PortFrom)
+	}
+	return fmt.Sprintf("%d-%d", r.PortFrom, r.PortTo)
+}
+
+// IPTables compiles the IPv4 (or IPv6) rules of the apps of service for
+// iptables-restore --noflush (ip6tables-restore). The XPRESS chain is
+// replaced, the agent jumps to it once from INPUT and FORWARD for
+// WG_INTERFACE as the header of the output says
+func IPTables(service string, apps []*App, ipv6 bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated for service %s\n", service)
+	fmt.Fprintf(&b, "# Hook up with: -I INPUT -i %s -j %s, -I FORWARD -i %s -j %s\n",
+		wg.WG_INTERFACE, IPTABLES_CHAIN, wg.WG_INTERFACE, IPTABLES_CHAIN)
+	b.WriteString("*filter\n")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", IPTABLES_CHAIN)
+	fmt.Fprintf(&b, "-A %s -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n", IPTABLES_CHAIN)
+	for _, app := range apps {
+		fmt.Fprintf(&b, "# %s\n", app.Name)
+		for _, r := range app.Rules {
+			if r.ipv4() == ipv6 {
+				continue
+			}
+			users := usersOf(app, !ipv6)
+			if len(users) == 0 {
+				continue
+			}
+
+			protos := []string{""}
+			switch {
+			case r.Proto == PROTO_ICMP && ipv6:
+				protos = []string{"ipv6-icmp"}
+			case r.Proto == PROTO_ICMP:
+				protos = []string{"icmp"}
+			case r.PortFrom > 0 && r.Proto == PROTO_ANY:
+				protos = []string{PROTO_TCP, PROTO_UDP}
+			case r.Proto != PROTO_ANY:
+				protos = []string{r.Proto}
+			}
+			for _, proto := range protos {
+				fmt.Fprintf(&b, "-A %s -s %s -d %s", IPTABLES_CHAIN, strings.Join(users, ","), r.Dest)
+				if proto != "" {
+					fmt.Fprintf(&b, " -p %s", proto)
+				}
+				if r.PortFrom > 0 {
+					if r.PortFrom == r.PortTo {
+						fmt.Fprintf(&b, " --dport %d", r.PortFrom)
+					} else {
+						fmt.Fprintf(&b, " --dport %d:%d", r.PortFrom, r.PortTo)
+					}
+				}
+				b.WriteString(" -j ACCEPT\n")
+			}
+		}
+	}
+	fmt.Fprintf(&b, "-A %s -j DROP\nCOMMIT\n", IPTABLES_CHAIN)
+	return b.String()
+}
+
+// Compile in format nft (default), iptables or ip6tables
+func Compile(service string, apps []*App, format string) (string, error) {
+	switch format {
+	case "", "nft":
+		return NFTables(service, apps), nil
+	case "iptables":
+		return IPTables(service, apps, false), nil
+	case "ip6tables":
+		return IPTables(service, apps, true), nil
+	}
+	return "", fmt.Errorf("unknown firewall format %s", format)
+}
@@ -0,0 +1,170 @@
+package firewall
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/saroopmathur/rest-api/wg"
+)
+
+// Apps of a service: IPv4 and IPv6 destinations and users, any protocol
+// with a port, ICMP, and an app nobody is granted
+func compileApps(t *testing.T) []*App {
+	rules := func(dests ...string) []Rule {
+		var list []Rule
+		for _, dest := range dests {
+			r, err := ParseRule(dest)
+			if err != nil {
+				t.Fatalf("ParseRule(%s): %v", dest, err)
+			}
+			list = append(list, r)
+		}
+		return list
+	}
+	users := func(addrs ...string) []net.IP {
+		var ips []net.IP
+		for _, addr := range addrs {
+			ips = append(ips, net.ParseIP(addr))
+		}
+		return ips
+	}
+
+	saved := wg.WG_INTERFACE
+	t.Cleanup(func() { wg.WG_INTERFACE = saved })
+	wg.WG_INTERFACE = "wg0"
+
+	return []*App{
+		{Name: "web", Rules: rules("tcp:10.0.0.5:443", "10.0.0.5:80", "tcp:[fd00::5]:8000-8080", "[fd00::5]:22"),
+			Users: users("100.64.0.2", "fd7a::2", "100.64.0.3")},
+		{Name: "dns", Rules: rules("udp:10.0.0.53:53", "[fd00::53]:53"), Users: users("100.64.0.2")},
+		{Name: "ping", Rules: rules("icmp:10.0.0.0/24", "icmp:[fd00::1]"), Users: users("100.64.0.5", "fd7a::3")},
+		{Name: "lan", Rules: rules("10.1.0.0/16", "fd01::/64"), Users: users("100.64.0.4", "fd7a::4")},
+		{Name: "nobody", Rules: rules("10.9.0.1", "fd09::1")},
+	}
+}
+
+const wantNFTables = `#!/usr/sbin/nft -f
+# Generated for service gw1
+
+table inet xpress
+delete table inet xpress
+
+table inet xpress {
+	chain input {
+		type filter hook input priority 0; policy accept;
+		iifname "wg0" jump users
+	}
+
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		iifname "wg0" jump users
+	}
+
+	chain users {
+		ct state established,related accept
+		# web
+		ip saddr { 100.64.0.2, 100.64.0.3 } ip daddr 10.0.0.5/32 tcp dport 443 accept
+		ip saddr { 100.64.0.2, 100.64.0.3 } ip daddr 10.0.0.5/32 meta l4proto { tcp, udp } th dport 80 accept
+		ip6 saddr { fd7a::2 } ip6 daddr fd00::5/128 tcp dport 8000-8080 accept
+		ip6 saddr { fd7a::2 } ip6 daddr fd00::5/128 meta l4proto { tcp, udp } th dport 22 accept
+		# dns
+		ip saddr { 100.64.0.2 } ip daddr 10.0.0.53/32 udp dport 53 accept
+		# ping
+		ip saddr { 100.64.0.5 } ip daddr 10.0.0.0/24 meta l4proto icmp accept
+		ip6 saddr { fd7a::3 } ip6 daddr fd00::1/128 meta l4proto ipv6-icmp accept
+		# lan
+		ip saddr { 100.64.0.4 } ip daddr 10.1.0.0/16 accept
+		ip6 saddr { fd7a::4 } ip6 daddr fd01::/64 accept
+		# nobody
+		drop
+	}
+}
+`
+
+const wantIPTables = `# Generated for service gw1
+# Hook up with: -I INPUT -i wg0 -j XPRESS, -I FORWARD -i wg0 -j XPRESS
+*filter
+:XPRESS - [0:0]
+-A XPRESS -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+# web
+-A XPRESS -s 100.64.0.2,100.64.0.3 -d 10.0.0.5/32 -p tcp --dport 443 -j ACCEPT
+-A XPRESS -s 100.64.0.2,100.64.0.3 -d 10.0.0.5/32 -p tcp --dport 80 -j ACCEPT
+-A XPRESS -s 100.64.0.2,100.64.0.3 -d 10.0.0.5/32 -p udp --dport 80 -j ACCEPT
+# dns
+-A XPRESS -s 100.64.0.2 -d 10.0.0.53/32 -p udp --dport 53 -j ACCEPT
+# ping
+-A XPRESS -s 100.64.0.5 -d 10.0.0.0/24 -p icmp -j ACCEPT
+# lan
+-A XPRESS -s 100.64.0.4 -d 10.1.0.0/16 -j ACCEPT
+# nobody
+-A XPRESS -j DROP
+COMMIT
+`
+
+const wantIP6Tables = `# Generated for service gw1
+# Hook up with: -I INPUT -i wg0 -j XPRESS, -I FORWARD -i wg0 -j XPRESS
+*filter
+:XPRESS - [0:0]
+-A XPRESS -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+# web
+-A XPRESS -s fd7a::2 -d fd00::5/128 -p tcp --dport 8000:8080 -j ACCEPT
+-A XPRESS -s fd7a::2 -d fd00::5/128 -p tcp --dport 22 -j ACCEPT
+-A XPRESS -s fd7a::2 -d fd00::5/128 -p udp --dport 22 -j ACCEPT
+# dns
+# ping
+-A XPRESS -s fd7a::3 -d fd00::1/128 -p ipv6-icmp -j ACCEPT
+# lan
+-A XPRESS -s fd7a::4 -d fd01::/64 -j ACCEPT
+# nobody
+-A XPRESS -j DROP
+COMMIT
+`
+
+func TestCompile(t *testing.T) {
+	apps := compileApps(t)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", wantNFTables},
+		{"nft", wantNFTables},
+		{"iptables", wantIPTables},
+		{"ip6tables", wantIP6Tables},
+	}
+	for _, tt := range tests {
+		got, err := Compile("gw1", apps, tt.format)
+		if err != nil {
+			t.Errorf("Compile %q: %v", tt.format, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Compile %q =\n%s\nwant\n%s", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := Compile("gw1", apps, "pf"); err == nil {
+		t.Errorf("Compile pf: want error")
+	}
+}
+
+// An app nobody is granted leaves only its comment, all traffic is dropped
+func TestCompileNoUsers(t *testing.T) {
+	apps := compileApps(t)
+	nobody := apps[len(apps)-1:]
+
+	nft := NFTables("gw1", nobody)
+	want := wantNFTables[:strings.Index(wantNFTables, "\t\t# web")] + "\t\t# nobody\n\t\tdrop\n\t}\n}\n"
+	if nft != want {
+		t.Errorf("NFTables =\n%s\nwant\n%s", nft, want)
+	}
+
+	for _, ipv6 := range []bool{false, true} {
+		ipt := IPTables("gw1", nobody, ipv6)
+		want := wantIPTables[:strings.Index(wantIPTables, "# web")] + "# nobody\n-A XPRESS -j DROP\nCOMMIT\n"
+		if ipt != want {
+			t.Errorf("IPTables ipv6 %v =\n%s\nwant\n%s", ipv6, ipt, want)
+		}
+	}
+}
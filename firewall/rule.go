@@ -0,0 +1,292 @@
+// Package firewall compiles the policy of a service node into nftables or
+// iptables rules, so users only reach the destinations of their apps
+package firewall
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	model "github.com/saroopmathur/rest-api/models"
+	"github.com/saroopmathur/rest-api/wg"
+)
+
+const (
+	PROTO_ANY  = "any"
+	PROTO_TCP  = "tcp"
+	PROTO_UDP  = "udp"
+	PROTO_ICMP = "icmp"
+)
+
+// Rule is a destination of an app. Ports only apply to tcp and udp, with
+// any they match both. PortFrom 0 - all ports
+type Rule struct {
+	Dest     *net.IPNet
+	Proto    string
+	PortFrom int
+	PortTo   int
+}
+
+// App of the service with the virtual IPs of the users granted it
+type App struct {
+	Name  string
+	Rules []Rule
+	Users []net.IP
+}
+
+// ParseRule parses a destination of the free form allowed_ips of an app:
+// [proto:]host[/prefix][:port[-port]], IPv6 in brackets when followed by a
+// port. E.g. 10.0.0.0/24, tcp:10.0.0.5:443, udp:[fd00::53]:53, icmp:10.0.0.1
+func ParseRule(s string) (Rule, error) {
+	r := Rule{Proto: PROTO_ANY}
+	rest := s
+	for _, proto := range []string{PROTO_TCP, PROTO_UDP, PROTO_ICMP, PROTO_ANY} {
+		if strings.HasPrefix(strings.ToLower(rest), proto+":") {
+			r.Proto = proto
+			rest = rest[len(proto)+1:]
+			break
+		}
+	}
+
+	host, ports := rest, ""
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return r, fmt.Errorf("invalid destination %s", s)
+		}
+		host = rest[1:end]
+		if tail := rest[end+1:]; tail != "" {
+			if !strings.HasPrefix(tail, ":") || tail == ":" {
+				return r, fmt.Errorf("invalid destination %s", s)
+			}
+			ports = tail[1:]
+		}
+	} else if strings.Count(rest, ":") == 1 {
+		i := strings.Index(rest, ":")
+		host, ports = rest[:i], rest[i+1:]
+		if ports == "" {
+			return r, fmt.Errorf("invalid port in %s", s)
+		}
+	}
+
+	if !strings.Contains(host, "/") {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return r, fmt.Errorf("invalid address in %s", s)
+		}
+		if ip.To4() != nil {
+			host += "/32"
+		} else {
+			host += "/128"
+		}
+	}
+	_, dest, err := net.ParseCIDR(host)
+	if err != nil {
+		return r, fmt.Errorf("invalid address in %s", s)
+	}
+	r.Dest = dest
+
+	if ports != "" {
+		if r.Proto == PROTO_ICMP {
+			return r, fmt.Errorf("icmp has no ports in %s", s)
+		}
+		from, to := ports, ports
+		if i := strings.Index(ports, "-"); i >= 0 {
+			from, to = ports[:i], ports[i+1:]
+		}
+		r.PortFrom, err = strconv.Atoi(from)
+		if err == nil {
+			r.PortTo, err = strconv.Atoi(to)
+		}
+		if err != nil || r.PortFrom < 1 || r.PortTo > 65535 || r.PortFrom > r.PortTo {
+			return r, fmt.Errorf("invalid port in %s", s)
+		}
+	}
+	return r, nil
+}
+
+// ParseRules parses all destinations of allowed_ips, invalid ones are
+// logged and left out - nothing is allowed for them
+func ParseRules(allowedIPs string) []Rule {
+	var rules []Rule
+	for _, item := range wg.SplitAllowedIPs(allowedIPs) {
+		r, err := ParseRule(item)
+		if err != nil {
+			log.Printf("firewall: %v - ignored\n", err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// FromAppRule validates a structured destination of an app
+func FromAppRule(ar model.AppRule) (Rule, error) {
+	r := Rule{Proto: strings.ToLower(ar.Protocol)}
+	switch r.Proto {
+	case "":
+		r.Proto = PROTO_ANY
+	case PROTO_ANY, PROTO_TCP, PROTO_UDP, PROTO_ICMP:
+	default:
+		return r, fmt.Errorf("invalid protocol %s of %s", ar.Protocol, ar.Dest)
+	}
+
+	dest := ar.Dest
+	if !strings.Contains(dest, "/") {
+		ip := net.ParseIP(dest)
+		if ip == nil {
+			return r, fmt.Errorf("invalid dest %s", ar.Dest)
+		}
+		if ip.To4() != nil {
+			dest += "/32"
+		} else {
+			dest += "/128"
+		}
+	}
+	_, n, err := net.ParseCIDR(dest)
+	if err != nil {
+		return r, fmt.Errorf("invalid dest %s", ar.Dest)
+	}
+	r.Dest = n
+
+	r.PortFrom, r.PortTo = ar.Port, ar.PortEnd
+	if r.PortTo == 0 {
+		r.PortTo = r.PortFrom
+	}
+	if r.PortFrom == 0 && r.PortTo == 0 {
+		return r, nil
+	}
+	if r.Proto == PROTO_ICMP {
+		return r, fmt.Errorf("icmp has no ports, %s", ar.Dest)
+	}
+	if r.PortFrom < 1 || r.PortTo > 65535 || r.PortFrom > r.PortTo {
+		return r, fmt.Errorf("invalid port %d-%d of %s", ar.Port, ar.PortEnd, ar.Dest)
+	}
+	return r, nil
+}
+
+// AppRule is the normalized structured form of r
+func (r *Rule) AppRule() model.AppRule {
+	ar := model.AppRule{Dest: r.Dest.String(), Protocol: r.Proto, Port: r.PortFrom}
+	if r.PortTo != r.PortFrom {
+		ar.PortEnd = r.PortTo
+	}
+	return ar
+}
+
+// NormalizeAppRules validates and normalizes the destinations of an app
+func NormalizeAppRules(rules []model.AppRule) ([]model.AppRule, error) {
+	result := []model.AppRule{}
+	for _, ar := range rules {
+		r, err := FromAppRule(ar)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r.AppRule())
+	}
+	return result, nil
+}
+
+// ParseAppRules parses the free form allowed_ips of an app into
+// structured destinations, unlike ParseRules any invalid one is an error
+func ParseAppRules(allowedIPs string) ([]model.AppRule, error) {
+	result := []model.AppRule{}
+	for _, item := range wg.SplitAllowedIPs(allowedIPs) {
+		r, err := ParseRule(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r.AppRule())
+	}
+	return result, nil
+}
+
+// LegacyAllowedIPs is allowed_ips of an app with rules, as clients of
+// GetPolicies know it - the destinations without protocols and ports
+func LegacyAllowedIPs(rules []model.AppRule) string {
+	var dests []string
+	seen := map[string]bool{}
+	for _, ar := range rules {
+		if !seen[ar.Dest] {
+			seen[ar.Dest] = true
+			dests = append(dests, ar.Dest)
+		}
+	}
+	return strings.Join(dests, ", ")
+}
+
+// AppRules are the destinations of an app, from its rules or for apps
+// saved before they had rules from allowed_ips. Invalid ones are left out
+func AppRules(appRules []model.AppRule, allowedIPs string) []Rule {
+	if len(appRules) == 0 {
+		return ParseRules(allowedIPs)
+	}
+	var rules []Rule
+	for _, ar := range appRules {
+		r, err := FromAppRule(ar)
+		if err != nil {
+			log.Printf("firewall: %v - ignored\n", err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// FromPeerApps converts the apps and peers of a service node
+func FromPeerApps(peerApps []*model.PeerApp) []*App {
+	var apps []*App
+	for _, pa := range peerApps {
+		app := &App{Name: pa.Name, Rules: AppRules(pa.Rules, pa.AllowedIPs)}
+		for _, p := range pa.Peers {
+			addr := p.VirtualIP
+			if i := strings.IndexByte(addr, '/'); i >= 0 {
+				addr = addr[:i]
+			}
+			if ip := net.ParseIP(addr); ip != nil {
+				app.Users = append(app.Users, ip)
+			}
+		}
+		apps = append(apps, app)
+	}
+	return apps
+}
+
+func (r *Rule) ipv4() bool {
+	return r.Dest.IP.To4() != nil
+}
+
+// Users of the same family as the destination
+func usersOf(app *App, ipv4 bool) []string {
+	var users []string
+	for _, ip := range app.Users {
+		if (ip.To4() != nil) == ipv4 {
+			users = append(users, ip.String())
+		}
+	}
+	return users
+}
+
+// Matches reports whether traffic to ip with proto (tcp, udp or icmp) and
+// port is allowed by r. Port 0 - any port, only allowed by rules without
+// ports
+func (r *Rule) Matches(ip net.IP, proto string, port int) bool {
+	if !r.Dest.Contains(ip) {
+		return false
+	}
+	switch r.Proto {
+	case PROTO_ANY:
+		if r.PortFrom > 0 && proto == PROTO_ICMP {
+			return false
+		}
+	case proto:
+	default:
+		return false
+	}
+	if r.PortFrom == 0 {
+		return true
+	}
+	return port >= r.PortFrom && port <= r.PortTo
+}
@@ -0,0 +1,121 @@
+package firewall
+
+import (
+	"testing"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in       string
+		dest     string
+		proto    string
+		from, to int
+	}{
+		{"10.0.0.0/24", "10.0.0.0/24", PROTO_ANY, 0, 0},
+		{"10.0.0.77/24", "10.0.0.0/24", PROTO_ANY, 0, 0},
+		{"10.0.0.5", "10.0.0.5/32", PROTO_ANY, 0, 0},
+		{"10.0.0.5:443", "10.0.0.5/32", PROTO_ANY, 443, 443},
+		{"tcp:10.0.0.5:443", "10.0.0.5/32", PROTO_TCP, 443, 443},
+		{"TCP:10.0.0.5:443", "10.0.0.5/32", PROTO_TCP, 443, 443},
+		{"udp:10.0.0.0/24:53", "10.0.0.0/24", PROTO_UDP, 53, 53},
+		{"tcp:10.0.0.5:8000-8080", "10.0.0.5/32", PROTO_TCP, 8000, 8080},
+		{"tcp:10.0.0.5:1-65535", "10.0.0.5/32", PROTO_TCP, 1, 65535},
+		{"any:10.0.0.5:22", "10.0.0.5/32", PROTO_ANY, 22, 22},
+		{"icmp:10.0.0.1", "10.0.0.1/32", PROTO_ICMP, 0, 0},
+
+		// IPv6, in brackets when followed by a port
+		{"fd00::53", "fd00::53/128", PROTO_ANY, 0, 0},
+		{"fd00::/64", "fd00::/64", PROTO_ANY, 0, 0},
+		{"[fd00::53]", "fd00::53/128", PROTO_ANY, 0, 0},
+		{"udp:[fd00::53]:53", "fd00::53/128", PROTO_UDP, 53, 53},
+		{"tcp:[fd00::/64]:443", "fd00::/64", PROTO_TCP, 443, 443},
+		{"tcp:[fd00::1]:8000-8080", "fd00::1/128", PROTO_TCP, 8000, 8080},
+		{"[::1]:22", "::1/128", PROTO_ANY, 22, 22},
+		{"icmp:[fd00::1]", "fd00::1/128", PROTO_ICMP, 0, 0},
+	}
+	for _, tt := range tests {
+		r, err := ParseRule(tt.in)
+		if err != nil {
+			t.Errorf("ParseRule(%s): %v", tt.in, err)
+			continue
+		}
+		if r.Dest.String() != tt.dest || r.Proto != tt.proto || r.PortFrom != tt.from || r.PortTo != tt.to {
+			t.Errorf("ParseRule(%s) = %s %s %d-%d, want %s %s %d-%d", tt.in,
+				r.Dest, r.Proto, r.PortFrom, r.PortTo, tt.dest, tt.proto, tt.from, tt.to)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"host.example.com",
+		"10.0.0.256",
+		"10.0.0.0/33",
+		"10.0.0.5:",
+		"10.0.0.5:0",
+		"10.0.0.5:65536",
+		"10.0.0.5:http",
+		"10.0.0.5:443-",
+		"10.0.0.5:-443",
+		"tcp:10.0.0.5:8080-8000",
+		"icmp:10.0.0.5:80",
+		"sctp:10.0.0.5:80",
+
+		// IPv6 with a port needs brackets
+		"fd00::53:53x",
+		"[fd00::53",
+		"[fd00::53]53",
+		"[fd00::53]:",
+		"[fd00::53]:0",
+		"[fd00::53]:70000",
+		"udp:[fd00::53]:53-52",
+		"icmp:[fd00::1]:1",
+		"[10.0.0.300]:22",
+	}
+	for _, in := range tests {
+		if r, err := ParseRule(in); err == nil {
+			t.Errorf("ParseRule(%q) = %s %s %d-%d, want error", in, r.Dest, r.Proto, r.PortFrom, r.PortTo)
+		}
+	}
+}
+
+func TestFromAppRule(t *testing.T) {
+	tests := []struct {
+		in    model.AppRule
+		want  model.AppRule
+		valid bool
+	}{
+		{model.AppRule{Dest: "10.0.0.5"}, model.AppRule{Dest: "10.0.0.5/32", Protocol: PROTO_ANY}, true},
+		{model.AppRule{Dest: "10.0.0.5", Protocol: "TCP", Port: 443}, model.AppRule{Dest: "10.0.0.5/32", Protocol: PROTO_TCP, Port: 443}, true},
+		{model.AppRule{Dest: "10.0.0.0/24", Protocol: "udp", Port: 53, PortEnd: 53}, model.AppRule{Dest: "10.0.0.0/24", Protocol: PROTO_UDP, Port: 53}, true},
+		{model.AppRule{Dest: "fd00::1", Protocol: "tcp", Port: 8000, PortEnd: 8080}, model.AppRule{Dest: "fd00::1/128", Protocol: PROTO_TCP, Port: 8000, PortEnd: 8080}, true},
+		{model.AppRule{Dest: "[fd00::1]"}, model.AppRule{}, false},
+		{model.AppRule{Dest: "10.0.0.5", Protocol: "icmp", Port: 1}, model.AppRule{}, false},
+		{model.AppRule{Dest: "10.0.0.5", Protocol: "tcp", Port: 8080, PortEnd: 8000}, model.AppRule{}, false},
+		{model.AppRule{Dest: "10.0.0.5", Protocol: "gre"}, model.AppRule{}, false},
+	}
+	for _, tt := range tests {
+		r, err := FromAppRule(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("FromAppRule(%+v) = %v, want valid %v", tt.in, err, tt.valid)
+			continue
+		}
+		if got := r.AppRule(); tt.valid && got != tt.want {
+			t.Errorf("FromAppRule(%+v) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLegacyAllowedIPs(t *testing.T) {
+	rules, err := ParseAppRules("tcp:10.0.0.5:443, udp:10.0.0.5:53, [fd00::1]:22, 10.1.0.0/16")
+	if err != nil {
+		t.Fatalf("ParseAppRules: %v", err)
+	}
+	want := "10.0.0.5/32, fd00::1/128, 10.1.0.0/16"
+	if got := LegacyAllowedIPs(rules); got != want {
+		t.Errorf("LegacyAllowedIPs = %q, want %q", got, want)
+	}
+}
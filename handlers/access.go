@@ -7,6 +7,7 @@ import (
 
 	db "github.com/saroopmathur/rest-api/db"
 	model "github.com/saroopmathur/rest-api/models"
+	"github.com/saroopmathur/rest-api/schedule"
 )
 
 type AccessResp struct {
@@ -15,6 +16,42 @@ type AccessResp struct {
 
 // Access Control
 
+// Optional effect and limits of a new access row in the request body,
+// allow and nil limits if there is no body
+func reqAccess(w http.ResponseWriter, r *http.Request) (string, *model.AccessGrant, error) {
+	if r.ContentLength == 0 {
+		return db.EFFECT_ALLOW, nil, nil
+	}
+	var req model.AccessReq
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		return "", nil, err
+	}
+	switch req.Effect {
+	case "":
+		req.Effect = db.EFFECT_ALLOW
+	case db.EFFECT_ALLOW, db.EFFECT_DENY:
+	default:
+		return "", nil, fmt.Errorf("effect %s invalid, allow or deny", req.Effect)
+	}
+	grant := &req.AccessGrant
+	if grant.ValidFrom != nil && grant.ValidUntil != nil && !grant.ValidUntil.After(*grant.ValidFrom) {
+		return "", nil, fmt.Errorf("valid_until must be after valid_from")
+	}
+	if err = schedule.Validate(grant.Schedule); err != nil {
+		return "", nil, err
+	}
+	if grant.ValidFrom == nil && grant.ValidUntil == nil && len(grant.Schedule) == 0 {
+		grant = nil
+	}
+	return req.Effect, grant, nil
+}
+
+// Listings include grants not in effect now with ?all=true
+func reqAll(r *http.Request) bool {
+	return r.URL.Query().Get("all") == "true"
+}
+
 // "UserAccess", "GET", "/users/access"
 // List allowed applications for all users
 func UserAccessAll(w http.ResponseWriter, r *http.Request) {
@@ -28,7 +65,7 @@ func UserAccessAll(w http.ResponseWriter, r *http.Request) {
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
 		log.Printf("UserAccessAll: Domain:[%s %d]\n", domainName, domainId)
-		resp = db.SelectUserAccessAll()
+		resp = db.SelectUserAccessAll(domainId, reqAll(r))
 	}
 
 	httpSendResponse(w, 0, resp, err)
@@ -48,14 +85,15 @@ func UserAccess(w http.ResponseWriter, r *http.Request) {
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
 		log.Printf("UserAccess: Domain:[%s %d]\n", domainName, domainId)
-		resp = db.SelectUserAccess(domainId, userName, userId)
+		resp = db.SelectUserAccess(domainId, userName, userId, reqAll(r))
 	}
 
 	httpSendResponse(w, 0, resp, err)
 }
 
 // "UserAddAccess", "POST", "/users/access/{id}/{id2}"
-// Allow application {id2} to be accessible by user {id}
+// Allow application {id2} to be accessible by user {id}, or deny it with
+// effect deny
 func UserAddAccess(w http.ResponseWriter, r *http.Request) {
 	log.Printf("============== User Add Access ===============\n")
 	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
@@ -71,7 +109,16 @@ func UserAddAccess(w http.ResponseWriter, r *http.Request) {
 	} else {
 		log.Printf("UserAddAccess: Domain:[%s %d] User:[%s %d] App:[%s %d]\n",
 			domainName, domainId, userName, userId, appName, appId)
-		resp, err = db.InsertUac(domainId, userName, userId, appName, appId)
+		var effect string
+		var grant *model.AccessGrant
+		effect, grant, err = reqAccess(w, r)
+		if err == nil {
+			policyChanging(domainId)
+			resp, err = db.InsertUac(domainId, userName, userId, appName, appId, effect, grant)
+		}
+		if err == nil {
+			policyChanged(r, domainId)
+		}
 	}
 
 	httpSendResponse(w, 0, resp, err)
@@ -96,7 +143,9 @@ func UserDelAccess(w http.ResponseWriter, r *http.Request) {
 		log.Printf("UserDelAccess: Domain:[%s %d] User:[%s %d] App:[%s %d]\n",
 			domainName, domainId, userName, userId, appName, appId)
 
+		policyChanging(domainId)
 		count = db.DeleteUac(domainId, userName, userId, appName, appId)
+		policyChanged(r, domainId)
 	}
 
 	if err == nil {
@@ -123,7 +172,7 @@ func GroupAccessAll(w http.ResponseWriter, r *http.Request) {
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
 		log.Printf("SelectUserAccessAll: Domain:[%s %d]\n", domainName, domainId)
-		resp = db.SelectGroupAccessAll()
+		resp = db.SelectGroupAccessAll(domainId, reqAll(r))
 	}
 
 	httpSendResponse(w, 0, resp, err)
@@ -144,14 +193,15 @@ func GroupAccess(w http.ResponseWriter, r *http.Request) {
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
 		log.Printf("SelectGroupAccess: Domain:[%s %d]\n", domainName, domainId)
-		resp = db.SelectGroupAccess(domainId, groupName, grouprId)
+		resp = db.SelectGroupAccess(domainId, groupName, grouprId, reqAll(r))
 	}
 
 	httpSendResponse(w, 0, resp, err)
 }
 
 // "GroupAddAccess", "POST", "/groups/access/{id}/{id2}"
-// Allow application {id2} to be accessible by group {id}
+// Allow application {id2} to be accessible by group {id}, or deny it with
+// effect deny
 func GroupAddAccess(w http.ResponseWriter, r *http.Request) {
 	log.Printf("============== Group Add Access ===============\n")
 	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
@@ -167,7 +217,16 @@ func GroupAddAccess(w http.ResponseWriter, r *http.Request) {
 	} else {
 		log.Printf("GroupAddAccess: Domain:[%s %d] User:[%s %d] App:[%s %d]\n",
 			domainName, domainId, groupName, groupId, appName, appId)
-		resp, err = db.InsertGac(domainId, groupName, groupId, appName, appId)
+		var effect string
+		var grant *model.AccessGrant
+		effect, grant, err = reqAccess(w, r)
+		if err == nil {
+			policyChanging(domainId)
+			resp, err = db.InsertGac(domainId, groupName, groupId, appName, appId, effect, grant)
+		}
+		if err == nil {
+			policyChanged(r, domainId)
+		}
 	}
 
 	httpSendResponse(w, 0, resp, err)
@@ -192,7 +251,9 @@ func GroupDelAccess(w http.ResponseWriter, r *http.Request) {
 	} else {
 		log.Printf("GroupDelAccess: Domain:[%s %d] User:[%s %d] App:[%s %d]\n",
 			domainName, domainId, groupName, groupId, appName, appId)
+		policyChanging(domainId)
 		count = db.DeleteGac(domainId, groupName, groupId, appName, appId)
+		policyChanged(r, domainId)
 	}
 
 	if err == nil {
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Access requests, users ask for access to an app through /userapi and
+// admins approve or deny them
+
+func validateAccessRequestReq(req *model.AccessRequestReq) error {
+	if req.App == "" && req.AppID == 0 {
+		return fmt.Errorf("app required")
+	}
+	if req.Justification == "" {
+		return fmt.Errorf("justification required")
+	}
+	if req.DurationHours < 0 {
+		return fmt.Errorf("duration_hours invalid")
+	}
+	return nil
+}
+
+// Optional decision in the request body, empty if there is no body
+func reqAccessDecision(w http.ResponseWriter, r *http.Request) (*model.AccessDecision, error) {
+	decision := &model.AccessDecision{}
+	if r.ContentLength == 0 {
+		return decision, nil
+	}
+	err := decodeJSONBody(w, r, decision)
+	if err == nil && decision.DurationHours != nil && *decision.DurationHours < 0 {
+		err = fmt.Errorf("duration_hours invalid")
+	}
+	return decision, err
+}
+
+// ?state= filter of the listings, all states if not given
+func reqAccessState(r *http.Request) (string, error) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", db.ACCESS_PENDING, db.ACCESS_APPROVED, db.ACCESS_DENIED, db.ACCESS_CANCELLED:
+		return state, nil
+	}
+	return "", fmt.Errorf("state %s invalid", state)
+}
+
+// "ReadAccessRequests", "GET", "/accessrequests"
+// List the access requests of the domain, ?state=pending for those to decide
+func ReadAccessRequests(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get Access Requests ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp []*model.AccessRequest
+	domainName, domainId := reqDomain(r)
+	state, err := reqAccessState(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	}
+	if err == nil {
+		resp = db.SelectAccessRequests(domainId, 0, state)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "ReadAccessRequest", "GET", "/accessrequests/{id}"
+// Access request {id} with its history
+func ReadAccessRequest(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get Access Request ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var code int
+	var resp *model.AccessRequest
+	domainName, domainId := reqDomain(r)
+	_, requestId := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		resp = db.SelectAccessRequest(domainId, requestId)
+		if resp == nil {
+			code = http.StatusNotFound
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// "ApproveAccessRequest", "POST", "/accessrequests/approve/{id}"
+// Approve access request {id}, the user gets access to the app
+func ApproveAccessRequest(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Approve Access Request ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.AccessRequest
+	domainName, domainId := reqDomain(r)
+	_, requestId := reqNameOrId(r)
+	decision, err := reqAccessDecision(w, r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	}
+	if err == nil {
+		log.Printf("ApproveAccessRequest: Domain:[%s %d] Request:[%d]\n", domainName, domainId, requestId)
+		policyChanging(domainId)
+		resp, err = db.ApproveAccessRequest(domainId, requestId, reqUser(r), decision)
+	}
+	if err == nil {
+		policyChanged(r, domainId)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "DenyAccessRequest", "POST", "/accessrequests/deny/{id}"
+// Deny access request {id}
+func DenyAccessRequest(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Deny Access Request ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.AccessRequest
+	domainName, domainId := reqDomain(r)
+	_, requestId := reqNameOrId(r)
+	decision, err := reqAccessDecision(w, r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	}
+	if err == nil {
+		log.Printf("DenyAccessRequest: Domain:[%s %d] Request:[%d]\n", domainName, domainId, requestId)
+		resp, err = db.DenyAccessRequest(domainId, requestId, reqUser(r), decision)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserGetAccessRequests", "GET", "/userapi/accessrequests"
+// Access requests of the caller
+func UserGetAccessRequests(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Get Access Requests ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp []*model.AccessRequest
+	u := reqUser(r)
+	state, err := reqAccessState(r)
+	if err == nil {
+		resp = db.SelectAccessRequests(u.Domain.ID, u.ID, state)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserAddAccessRequest", "POST", "/userapi/accessrequests"
+// Ask for access to an app
+func UserAddAccessRequest(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Add Access Request ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.AccessRequestReq
+	var resp *model.AccessRequest
+	u := reqUser(r)
+	err := decodeJSONBody(w, r, &req)
+	if err == nil {
+		err = validateAccessRequestReq(&req)
+	}
+	if err == nil {
+		resp, err = db.InsertAccessRequest(u.Domain.ID, u, &req)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserCancelAccessRequest", "DELETE", "/userapi/accessrequests/{id}"
+// Withdraw a pending access request of the caller
+func UserCancelAccessRequest(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Cancel Access Request ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	u := reqUser(r)
+	_, requestId := reqNameOrId(r)
+	resp, err := db.CancelAccessRequest(u.Domain.ID, u.ID, requestId, u)
+	httpSendResponse(w, 0, resp, err)
+}
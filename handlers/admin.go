@@ -154,3 +154,21 @@ func DeleteAdmin1(r *http.Request) error {
 	adminName, adminId := reqNameOrId(r)
 	return db.DeleteAdmin(domainId, adminName, adminId)
 }
+
+// UnlockAdmin is an httpHandler for route POST /admins/unlock/{id}
+// Lifts a lockout after too many failed logins
+func UnlockAdmin(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Unlock Admin ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	adminName, adminId := reqNameOrId(r)
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		err = db.UnlockLogin(db.ROLE_ADMIN, domainId, adminName, adminId)
+	}
+	httpSendResponse(w, 0, nil, err)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 
 	db "github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/firewall"
 	model "github.com/saroopmathur/rest-api/models"
 )
 
@@ -27,6 +28,9 @@ func CreateApp(w http.ResponseWriter, r *http.Request) {
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
 		err = decodeJSONBody(w, r, &app)
+		if err == nil {
+			err = validateAppReq(&app)
+		}
 		if err == nil {
 			resp, err = db.InsertApp(domainId, &app)
 		}
@@ -35,6 +39,29 @@ func CreateApp(w http.ResponseWriter, r *http.Request) {
 	httpSendResponse(w, 0, resp, err)
 }
 
+// The destinations of an app are either rules or the legacy allowed_ips,
+// each is derived from the other so both stay in step
+func validateAppReq(app *model.AppReq) error {
+	var err error
+	if app.Rules != nil {
+		if len(app.Rules) == 0 {
+			return fmt.Errorf("app must have at least one rule")
+		}
+		app.Rules, err = firewall.NormalizeAppRules(app.Rules)
+		if err != nil {
+			return err
+		}
+		app.AllowedIPs = firewall.LegacyAllowedIPs(app.Rules)
+	} else if app.AllowedIPs != "" {
+		app.Rules, err = firewall.ParseAppRules(app.AllowedIPs)
+		if err != nil {
+			return err
+		}
+		app.AllowedIPs = firewall.LegacyAllowedIPs(app.Rules)
+	}
+	return nil
+}
+
 // ReadApps is an httpHandler for route GET /apps
 func ReadApps(w http.ResponseWriter, r *http.Request) {
 	log.Printf("============== Get All Apps ===============\n")
@@ -116,8 +143,15 @@ func UpdateApp(w http.ResponseWriter, r *http.Request) {
 		var app model.AppReq
 		err = decodeJSONBody(w, r, &app)
 		if err == nil {
+			err = validateAppReq(&app)
+		}
+		if err == nil {
+			policyChanging(domainId)
 			resp = db.UpdateApp(domainId, appName, appId, &app)
 		}
+		if resp != nil {
+			policyChanged(r, domainId)
+		}
 		fmt.Printf("Update App %s %d Domain %s %v\n", appName, appId, domainName, resp)
 	}
 
@@ -138,9 +172,12 @@ func DeleteApp(w http.ResponseWriter, r *http.Request) {
 		// Unknown Domain
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
+		policyChanging(domainId)
 		resp := db.DeleteApp(domainId, appName, appId)
 		if resp == nil {
 			err = fmt.Errorf("unknown App")
+		} else {
+			policyChanged(r, domainId)
 		}
 		// resp is the app obejct for the deleted app
 		fmt.Printf("Delete App %s %d Domain %s %v\n", appName, appId, domainName, resp)
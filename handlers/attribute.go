@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	db "github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/expr"
+)
+
+// Limits of the user_attributes columns
+const (
+	MAX_ATTR_NAME  = 64
+	MAX_ATTR_VALUE = 256
+)
+
+// Attribute names are those rules can refer to
+func validateAttributes(attrs map[string]string) error {
+	for name, value := range attrs {
+		if !expr.IsName(name) || len(name) > MAX_ATTR_NAME {
+			return fmt.Errorf("attribute name %q invalid", name)
+		}
+		if len(value) > MAX_ATTR_VALUE {
+			return fmt.Errorf("attribute %s longer than %d", name, MAX_ATTR_VALUE)
+		}
+	}
+	return nil
+}
+
+// ReadUserAttributes is an httpHandler for route GET /users/{id}/attributes
+func ReadUserAttributes(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get User Attributes ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var resp map[string]string
+
+	userName, userId := reqNameOrId(r)
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		resp, err = db.SelectUserAttributes(domainId, userName, userId)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// UpdateUserAttributes is an httpHandler for route PUT /users/{id}/attributes
+// The attributes in the body replace all attributes of the user
+func UpdateUserAttributes(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Update User Attributes ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var resp map[string]string
+
+	userName, userId := reqNameOrId(r)
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		attrs := map[string]string{}
+		err = decodeJSONBody(w, r, &attrs)
+		if err == nil {
+			err = validateAttributes(attrs)
+		}
+		if err == nil {
+			policyChanging(domainId)
+			resp, err = db.SetUserAttributes(domainId, userName, userId, attrs)
+		}
+		if err == nil {
+			policyChanged(r, domainId)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
@@ -2,19 +2,29 @@ package handler
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
+	"github.com/saroopmathur/rest-api/auth"
 	"github.com/saroopmathur/rest-api/db"
 	m "github.com/saroopmathur/rest-api/models"
 )
 
 type LoginResp struct {
-	Token    string `json:"Token"`
-	Role     string `json:"role,omitempty"`
-	Domain   string `json:"domain,omitempty"`
-	LogoFile string `json:"logo_file,omitempty"`
-	IconFile string `json:"icon_file,omitempty"`
+	Token        string `json:"Token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	Role         string `json:"role,omitempty"`
+	Domain       string `json:"domain,omitempty"`
+	LogoFile     string `json:"logo_file,omitempty"`
+	IconFile     string `json:"icon_file,omitempty"`
+
+	// Admin MFA - the challenge to send back with the code, and the
+	// recovery codes when the code enabled an issued secret
+	MFARequired   bool     `json:"mfa_required,omitempty"`
+	Challenge     string   `json:"challenge,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
 func GetLogoFile(domainName string, domainId int) string {
@@ -25,33 +35,101 @@ func GetIconFile(domainName string, domainId int, iconId string) string {
 	return fmt.Sprintf("images/icons/%s.png", iconId)
 }
 
+// In JWT mode, the token returned for a new session is a JWT carrying it
+func issueJWT(r *http.Request, sessionToken string) (string, error) {
+	u := reqUser(r)
+	claims := &auth.Claims{
+		UserID:     u.ID,
+		Name:       u.Name,
+		Role:       u.Role,
+		DomainID:   u.Domain.ID,
+		DomainName: u.Domain.Name,
+		SessionID:  db.SessionDigest(sessionToken),
+	}
+	return auth.SignJWT(claims, db.SessionLifetime(u.Role))
+}
+
 func Login(w http.ResponseWriter, r *http.Request) {
+	if challenge := r.Header.Get("Xpress-MFAChallenge"); challenge != "" {
+		// Password checked, the OTP is still needed
+		resp := &LoginResp{}
+		resp.MFARequired = true
+		resp.Challenge = challenge
+		resp.ExpiresIn = int(db.MFA_CHALLENGE_LIFETIME.Seconds())
+		httpSendResponse(w, 0, resp, nil)
+		return
+	}
+
 	token := r.Header.Get("Xpress-SessionId")
 	role := r.Header.Get("Xpress-Role")
 	iconId := r.Header.Get("Xpress-IconId")
 	domainName, domainId := reqDomain(r)
 
+	if auth.JWTEnabled() && !auth.IsJWT(token) {
+		var err error
+		token, err = issueJWT(r, token)
+		if err != nil {
+			httpSendResponse(w, http.StatusInternalServerError, nil, err)
+			return
+		}
+	}
+
 	// Send the token as reponse
 	resp := &LoginResp{}
 	resp.Token = token
+	resp.RefreshToken = r.Header.Get("Xpress-RefreshToken")
+	resp.ExpiresIn = int(db.SessionLifetime(role).Seconds())
 	resp.Role = role
 	resp.Domain = domainName
 	resp.LogoFile = GetLogoFile(domainName, domainId)
 	resp.IconFile = GetIconFile(domainName, domainId, iconId)
+	if codes := r.Header.Get("Xpress-RecoveryCodes"); codes != "" {
+		// MFA enrolled during this login
+		resp.RecoveryCodes = strings.Split(codes, ",")
+	}
 	httpSendResponse(w, 0, resp, nil)
 }
 
+// Check passwords against the stored hash
+// Plaintext or outdated hashes are upgraded on the first successful login
 func UserCheckPassword(u *m.User2, pass string) bool {
-	fmt.Printf("Stored=%s, Input=%s\n", u.Password, pass)
-	return strings.Compare(u.Password, pass) == 0
+	ok, rehash := auth.CheckPassword(u.Password, pass)
+	if ok && rehash {
+		db.RehashUserPassword(u.ID, pass)
+	}
+	return ok
+}
+
+// External users bind to the LDAP directory of their domain. Returns
+// auth.ErrLDAPInvalidCredentials for a wrong password, other errors when
+// the directory can not be reached
+func UserCheckLDAP(u *m.User2, pass string) error {
+	cfg := db.GetLDAPConfig(u.Domain.ID)
+	if cfg == nil {
+		log.Printf("UserCheckLDAP: %s@%s - domain has no LDAP directory\n", u.Name, u.Domain.Name)
+		return auth.ErrLDAPInvalidCredentials
+	}
+	err := auth.LDAPAuthenticate(cfg, u.Name, pass)
+	if err != nil && err != auth.ErrLDAPInvalidCredentials {
+		log.Printf("UserCheckLDAP: %s@%s %v\n", u.Name, u.Domain.Name, err)
+	}
+	return err
 }
 
 func ServiceCheckPassword(s *m.Service2, pass string) bool {
-	return strings.Compare(s.Password, pass) == 0
+	ok, rehash := auth.CheckPassword(s.Password, pass)
+	if ok && rehash {
+		db.RehashServicePassword(s.ID, pass)
+	}
+	return ok
 }
 
 func AdminCheckPassword(a *m.Admin2, pass string) bool {
-	return strings.Compare(a.Password, pass) == 0
+	ok, rehash := auth.CheckPassword(a.Password, pass)
+	if ok && rehash {
+		db.RehashAdminPassword(a.ID, pass)
+	}
+	return ok
 }
 
 func Logout(w http.ResponseWriter, r *http.Request) {
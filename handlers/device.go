@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+	"github.com/saroopmathur/rest-api/wg"
+)
+
+// Devices of a user, /users/{id}/devices for admins and /userapi/devices
+// for the user itself
+
+func validateDeviceReq(req *model.DeviceReq, create bool) error {
+	if create && req.Name == "" {
+		return fmt.Errorf("device name required")
+	}
+	if req.Name != "" && !IsValidName(req.Name) {
+		return fmt.Errorf("device name %s invalid", req.Name)
+	}
+	if req.GenerateKey && req.WGKey != "" {
+		return fmt.Errorf("wg_key and generate_key are exclusive")
+	}
+	if req.WGKey != "" && !IsValidWGKey(req.WGKey) {
+		return fmt.Errorf("wg_key invalid")
+	}
+	for _, addr := range []string{req.PublicIP, req.LocalIP} {
+		if addr != "" && net.ParseIP(addr) == nil {
+			return fmt.Errorf("ip address %s invalid", addr)
+		}
+	}
+	return nil
+}
+
+// Keypair generated for generate_key, the private key is only returned
+// in the response
+func generateDeviceKey(req *model.DeviceReq) (string, error) {
+	if !req.GenerateKey {
+		return "", nil
+	}
+	private, public, err := wg.GenerateKeyPair()
+	if err != nil {
+		return "", err
+	}
+	req.WGKey = public
+	return private, nil
+}
+
+func createDevice1(domainId int, userId int, req *model.DeviceReq) (*model.Device, error) {
+	err := validateDeviceReq(req, true)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := generateDeviceKey(req)
+	if err != nil {
+		return nil, err
+	}
+	d, err := db.InsertDevice(domainId, userId, req)
+	if d != nil {
+		d.PrivateKey = privateKey
+	}
+	return d, err
+}
+
+func updateDevice1(domainId int, userId int, deviceName string, deviceId int, req *model.DeviceReq) (*model.Device, error) {
+	err := validateDeviceReq(req, false)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := generateDeviceKey(req)
+	if err != nil {
+		return nil, err
+	}
+	d, err := db.UpdateDevice(domainId, userId, deviceName, deviceId, req)
+	if d != nil {
+		d.PrivateKey = privateKey
+	}
+	return d, err
+}
+
+// Domain and user of /users/{id}/devices
+func deviceOwner(r *http.Request) (int, *model.User2, error) {
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		return 0, nil, fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	}
+	userName, userId := reqNameOrId(r)
+	u := db.SelectUser(domainId, userName, userId)
+	if u == nil {
+		return 0, nil, fmt.Errorf("user %s %d unknown", userName, userId)
+	}
+	return domainId, u, nil
+}
+
+// Device of ?device=name or id of the user, nil if not given
+func reqDevice(r *http.Request, domainId int, userId int) (*model.Device, error) {
+	str := r.URL.Query().Get("device")
+	if str == "" {
+		return nil, nil
+	}
+	id, _ := strconv.Atoi(str)
+	d := db.SelectDevice(domainId, userId, str, id)
+	if d == nil {
+		return nil, fmt.Errorf("device %s unknown", str)
+	}
+	return d, nil
+}
+
+// ReadDevices is an httpHandler for route GET /users/{id}/devices
+func ReadDevices(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get User Devices ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp []*model.Device
+	domainId, u, err := deviceOwner(r)
+	if err == nil {
+		resp = db.SelectDevices(domainId, u.ID)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// CreateDevice is an httpHandler for route POST /users/{id}/devices
+func CreateDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Add User Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.Device
+	domainId, u, err := deviceOwner(r)
+	if err == nil {
+		var req model.DeviceReq
+		err = decodeJSONBody(w, r, &req)
+		if err == nil {
+			resp, err = createDevice1(domainId, u.ID, &req)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// ReadDevice is an httpHandler for route GET /users/{id}/devices/{id2}
+func ReadDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get User Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.Device
+	var code int
+	domainId, u, err := deviceOwner(r)
+	if err == nil {
+		deviceName, deviceId := reqNameOrId2(r)
+		resp = db.SelectDevice(domainId, u.ID, deviceName, deviceId)
+		if resp == nil {
+			code = http.StatusNotFound
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// UpdateDevice is an httpHandler for route PUT /users/{id}/devices/{id2}
+func UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Update User Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.Device
+	domainId, u, err := deviceOwner(r)
+	if err == nil {
+		var req model.DeviceReq
+		err = decodeJSONBody(w, r, &req)
+		if err == nil {
+			deviceName, deviceId := reqNameOrId2(r)
+			resp, err = updateDevice1(domainId, u.ID, deviceName, deviceId, &req)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// DeleteDevice is an httpHandler for route DELETE /users/{id}/devices/{id2}
+// Revoke a lost device, the user and its other devices keep working
+func DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Revoke User Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.Device
+	domainId, u, err := deviceOwner(r)
+	if err == nil {
+		deviceName, deviceId := reqNameOrId2(r)
+		resp = db.DeleteDevice(domainId, u.ID, deviceName, deviceId)
+		if resp == nil {
+			err = fmt.Errorf("unknown Device")
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserGetDevices", "GET", "/userapi/devices"
+func UserGetDevices(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Get Devices ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	u := reqUser(r)
+	resp := db.SelectDevices(u.Domain.ID, u.ID)
+	httpSendResponse(w, 0, resp, nil)
+}
+
+// "UserAddDevice", "POST", "/userapi/devices"
+// Register a device of the caller
+func UserAddDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Add Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.DeviceReq
+	var resp *model.Device
+	u := reqUser(r)
+	err := decodeJSONBody(w, r, &req)
+	if err == nil {
+		// Addresses are handed out by the server
+		req.VirtualIP = ""
+		resp, err = createDevice1(u.Domain.ID, u.ID, &req)
+	}
+	if resp != nil {
+		db.DeviceSeen(resp.ID)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserUpdateDevice", "PUT", "/userapi/devices/{id}"
+// Rotate the key or update the endpoints of a device of the caller
+func UserUpdateDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Update Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.DeviceReq
+	var resp *model.Device
+	u := reqUser(r)
+	err := decodeJSONBody(w, r, &req)
+	if err == nil {
+		req.VirtualIP = ""
+		deviceName, deviceId := reqNameOrId(r)
+		resp, err = updateDevice1(u.Domain.ID, u.ID, deviceName, deviceId, &req)
+	}
+	if resp != nil {
+		db.DeviceSeen(resp.ID)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserDeleteDevice", "DELETE", "/userapi/devices/{id}"
+func UserDeleteDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Delete Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	u := reqUser(r)
+	deviceName, deviceId := reqNameOrId(r)
+	resp := db.DeleteDevice(u.Domain.ID, u.ID, deviceName, deviceId)
+	if resp == nil {
+		err = fmt.Errorf("unknown Device")
+	}
+	httpSendResponse(w, 0, resp, err)
+}
@@ -3,9 +3,12 @@ package handler
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"time"
 
 	db "github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/ipam"
 	model "github.com/saroopmathur/rest-api/models"
 )
 
@@ -126,6 +129,20 @@ func UpdateDomain(w http.ResponseWriter, r *http.Request) {
 		// Decode the request body
 		var domain model.DomainReq
 		err = decodeJSONBody(w, r, &domain)
+		if err == nil && domain.IPPool != nil && *domain.IPPool != "" {
+			var pool *net.IPNet
+			if pool, err = ipam.ParsePool(*domain.IPPool); err == nil {
+				*domain.IPPool = pool.String()
+			}
+		}
+		if err == nil && domain.KeyRotationDays != nil && *domain.KeyRotationDays < 0 {
+			err = fmt.Errorf("key_rotation_days must not be negative")
+		}
+		if err == nil && domain.Timezone != nil {
+			if _, err = time.LoadLocation(*domain.Timezone); err != nil || *domain.Timezone == "" {
+				err = fmt.Errorf("timezone %s unknown", *domain.Timezone)
+			}
+		}
 		if err == nil {
 			resp = db.UpdateDomain(domainId, domainName, &domain)
 		}
@@ -160,15 +177,18 @@ func ChangeDomain(w http.ResponseWriter, r *http.Request) {
 				newDomainName, newDomainId)
 		} else {
 			// Change to this domain
+			var token, refresh string
 			sessionId := r.Header.Get("Xpress-SessionId")
-			err = db.ChangeDomain(sessionId, newDomainId, newDomainName)
-			fmt.Printf("ChangeDomain from [%s %d] to [%s %d] %s err=%v\n",
+			token, refresh, err = db.ChangeDomain(sessionId, newDomainId, newDomainName)
+			fmt.Printf("ChangeDomain from [%s %d] to [%s %d] err=%v\n",
 				currDomainName, currDomainId,
-				newDomainName, newDomainId, sessionId, err)
+				newDomainName, newDomainId, err)
 			if err == nil {
 				//
 				// Send response same as Login response
 				//
+				r.Header.Set("Xpress-SessionId", token)
+				r.Header.Set("Xpress-RefreshToken", refresh)
 				resp := db.SelectDomain(newDomainId, newDomainName)
 				r.Header.Set("Xpress-IconId", "23") // TODO - get actual value from database
 				r.Header.Set("Xpress-Domain", resp.Name)
@@ -8,6 +8,7 @@ import (
 	"net/http"
 
 	db "github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/expr"
 	model "github.com/saroopmathur/rest-api/models"
 )
 
@@ -34,10 +35,22 @@ func CreateGroup1(r *http.Request, group *model.Group) (*model.Group2, error) {
 		err := fmt.Errorf("domain %s %d unknown", domainName, domainId)
 		return nil, err
 	}
+	if err := validateGroupRule(group); err != nil {
+		return nil, err
+	}
 
 	return db.InsertGroup(domainId, group)
 }
 
+// The rule of a dynamic group must parse
+func validateGroupRule(group *model.Group) error {
+	if group.Rule == nil || *group.Rule == "" {
+		return nil
+	}
+	_, err := expr.Parse(*group.Rule)
+	return err
+}
+
 // ReadGroups is an httpHandler for route GET /groups
 func ReadGroups(w http.ResponseWriter, r *http.Request) {
 	log.Printf("============== Get All Groups ===============\n")
@@ -116,8 +129,15 @@ func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		var group model.Group
 		err = decodeJSONBody(w, r, &group)
 		if err == nil {
+			err = validateGroupRule(&group)
+		}
+		if err == nil {
+			policyChanging(domainId)
 			resp = db.UpdateGroup(domainId, groupName, groupId, &group)
 		}
+		if resp != nil {
+			policyChanged(r, domainId)
+		}
 	}
 
 	httpSendResponse(w, 0, resp, err)
@@ -137,14 +157,42 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		// Unknown Domain
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
+		policyChanging(domainId)
 		resp, rowsAffected := db.DeleteGroup(domainId, groupName, groupId)
 		if resp == nil {
 			err = fmt.Errorf("unknown Group")
 		} else {
 			fmt.Printf("Delete Group %s %d domain %s, deleted %d members\n",
 				groupName, groupId, domainName, rowsAffected)
+			policyChanged(r, domainId)
 		}
 		// resp is the group obejct for the deleted group
 	}
 	httpSendResponse(w, 0, resp, err)
 }
+
+// PreviewGroupRule is an httpHandler for route POST /groups/preview
+// Users a rule of a dynamic group would match, before saving it
+func PreviewGroupRule(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Preview Group Rule ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var resp *model.GroupPreview
+
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		var req model.GroupRuleReq
+		err = decodeJSONBody(w, r, &req)
+		if err == nil && req.Rule == "" {
+			err = fmt.Errorf("rule required")
+		}
+		if err == nil {
+			resp, err = db.PreviewGroupRule(domainId, req.Rule)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
@@ -39,7 +39,9 @@ func AddGroupMembers(w http.ResponseWriter, r *http.Request) {
 
 		fmt.Printf("AddGroupMembers{%s %d %s %d] %v\n", groupName, groupId, domainName, domainId, users1)
 		if err == nil {
+			policyChanging(domainId)
 			addCount = db.AddGroupMembers(domainId, groupName, groupId, users1)
+			policyChanged(r, domainId)
 		}
 	}
 
@@ -104,7 +106,100 @@ func RemoveGroupMembers(w http.ResponseWriter, r *http.Request) {
 		err = decodeJSONBody(w, r, &users)
 		fmt.Printf("DeleteGroupMembers{%s %d %d] %v\n", groupName, groupId, domainId, users)
 		if err == nil {
+			policyChanging(domainId)
 			count = db.RemoveGroupMembers(domainId, groupName, groupId, users)
+			policyChanged(r, domainId)
+		}
+	}
+
+	if err == nil {
+		resp = &MemberResp{}
+		resp.RowsAffected = count
+	}
+
+	httpSendResponse(w, 0, resp, err)
+}
+
+// AddGroupChildren is an httpHandler for route POST /groupmembers/addgroups/{id}
+// Add child groups, their members become members of group {id}
+func AddGroupChildren(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Add Groups to Group ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var addCount int
+	var resp *MemberResp
+
+	domainName, domainId := reqDomain(r)
+	groupName, groupId := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		var groups []string
+		err = decodeJSONBody(w, r, &groups)
+		fmt.Printf("AddGroupChildren{%s %d %s %d] %v\n", groupName, groupId, domainName, domainId, groups)
+		if err == nil {
+			policyChanging(domainId)
+			addCount, err = db.AddGroupChildren(domainId, groupName, groupId, RemoveDuplicateValues(groups))
+		}
+		if err == nil {
+			policyChanged(r, domainId)
+		}
+	}
+
+	if err == nil {
+		resp = &MemberResp{}
+		resp.RowsAffected = addCount
+	}
+
+	httpSendResponse(w, 0, resp, err)
+}
+
+// ReadGroupChildren is an httpHandler for route GET /groupmembers/groups/{id}
+func ReadGroupChildren(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get Group Child Groups ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var resp []*model.Group2
+
+	domainName, domainId := reqDomain(r)
+	groupName, groupId := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		resp = db.SelectGroupChildren(domainId, groupName, groupId)
+		if resp == nil {
+			err = fmt.Errorf("group %s %d unknown", groupName, groupId)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// RemoveGroupChildren is an httpHandler for route POST /groupmembers/removegroups/{id}
+func RemoveGroupChildren(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Remove Groups from Group ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var count int
+	var resp *MemberResp
+
+	domainName, domainId := reqDomain(r)
+	groupName, groupId := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		var groups []string
+		err = decodeJSONBody(w, r, &groups)
+		fmt.Printf("RemoveGroupChildren{%s %d %d] %v\n", groupName, groupId, domainId, groups)
+		if err == nil {
+			policyChanging(domainId)
+			count = db.RemoveGroupChildren(domainId, groupName, groupId, groups)
+			policyChanged(r, domainId)
 		}
 	}
 
@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"reflect"
 	"regexp"
@@ -118,6 +120,26 @@ func IsValidName(s string) bool {
 	return NameRegexp.Match([]byte(s))
 }
 
+// A WireGuard public key is 32 bytes in base64
+func IsValidWGKey(s string) bool {
+	key, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(key) == 32
+}
+
+// Validate the key and endpoint IPs registered by a user or service
+func validateWGKeyReq(req *model.WGKeyReq) error {
+	if !IsValidWGKey(req.WGKey) {
+		return fmt.Errorf("invalid wg_key")
+	}
+	if req.PublicIP != "" && net.ParseIP(req.PublicIP) == nil {
+		return fmt.Errorf("invalid public_ip %s", req.PublicIP)
+	}
+	if req.LocalIP != "" && net.ParseIP(req.LocalIP) == nil {
+		return fmt.Errorf("invalid local_ip %s", req.LocalIP)
+	}
+	return nil
+}
+
 // func reqRole(r *http.Request) string {
 // 	role := r.Header.Get("Xpress-Role")
 // 	return role
@@ -218,13 +240,42 @@ func isNil(i interface{}) bool {
 	return false
 }
 
+// Domain of /domains/.../{id}. An admin can only configure its own domain
+func adminDomain(r *http.Request) (int, int, error) {
+	domainName, domainId := reqNameOrId(r)
+	currDomainName, currDomainId := reqDomain(r)
+
+	if currDomainId == 0 && currDomainName == "" {
+		// Unknown Domain
+		return 0, 0, fmt.Errorf("domain %s %d unknown", currDomainName, currDomainId)
+	}
+	if reqIsSuperuser(r) {
+		if domainId == 0 {
+			domain := db.SelectDomain(0, domainName)
+			if domain == nil {
+				return 0, http.StatusNotFound, fmt.Errorf("domain %s %d unknown", domainName, domainId)
+			}
+			domainId = domain.ID
+		}
+		return domainId, 0, nil
+	}
+	if currDomainId == domainId || currDomainName == domainName {
+		return currDomainId, 0, nil
+	}
+	// Not Authorized
+	fmt.Printf("adminDomain: Cant configure [%s %d]. Admin is of domain [%s %d]\n",
+		domainName, domainId, currDomainName, currDomainId)
+	return 0, http.StatusUnauthorized, fmt.Errorf("Unauthorized")
+}
+
 func httpSendResponse(w http.ResponseWriter, code int, resp interface{}, err error) {
 	if code == 0 {
 		// code not specified, determine based on error
 		if err == nil {
 			code = http.StatusOK
 		} else {
-			if strings.Contains(err.Error(), "unique constraint") {
+			if strings.Contains(err.Error(), "unique constraint") || errors.Is(err, db.ErrVirtualIPConflict) ||
+				errors.Is(err, db.ErrGroupCycle) || errors.Is(err, db.ErrAccessDenied) {
 				code = http.StatusConflict
 			} else if strings.Contains(err.Error(), "Unauthorized") {
 				code = http.StatusUnauthorized
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// ReadIPPool is an httpHandler for route GET /domains/ippool/{id}
+// Utilization of the virtual IP pool of the domain
+func ReadIPPool(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Read IP Pool ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.IPPool
+	domainId, code, err := adminDomain(r)
+	if err == nil {
+		resp, err = db.SelectIPPool(domainId)
+	}
+	httpSendResponse(w, code, resp, err)
+}
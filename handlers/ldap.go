@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	db "github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/jobs"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+func validateLDAPConfig(cfg *model.LDAPConfig) error {
+	if cfg.URL == "" || cfg.BaseDN == "" || cfg.UserFilter == "" || cfg.UserAttr == "" {
+		return fmt.Errorf("url, base_dn, user_filter and user_attr are required")
+	}
+	if cfg.GroupFilter != "" && (cfg.GroupAttr == "" || cfg.MemberAttr == "") {
+		return fmt.Errorf("group_attr and member_attr are required with group_filter")
+	}
+	return nil
+}
+
+// ReadLDAPConfig is an httpHandler for route GET /domains/ldap/{id}
+func ReadLDAPConfig(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Read LDAP Config ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.LDAPConfig
+	domainId, code, err := adminDomain(r)
+	if err == nil {
+		resp = db.GetLDAPConfig(domainId)
+		if resp == nil {
+			code = http.StatusNotFound
+		} else {
+			// Write only
+			resp.BindPassword = ""
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// UpdateLDAPConfig is an httpHandler for route PUT /domains/ldap/{id}
+func UpdateLDAPConfig(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Update LDAP Config ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.LDAPConfig
+	domainId, code, err := adminDomain(r)
+	if err == nil {
+		var cfg model.LDAPConfig
+		err = decodeJSONBody(w, r, &cfg)
+		if err == nil {
+			err = validateLDAPConfig(&cfg)
+		}
+		if err == nil {
+			err = db.SaveLDAPConfig(domainId, &cfg)
+		}
+		if err == nil {
+			resp = db.GetLDAPConfig(domainId)
+			if resp != nil {
+				resp.BindPassword = ""
+			}
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// DeleteLDAPConfig is an httpHandler for route DELETE /domains/ldap/{id}
+// External users of the domain can no longer login
+func DeleteLDAPConfig(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Delete LDAP Config ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	domainId, code, err := adminDomain(r)
+	if err == nil {
+		err = db.DeleteLDAPConfig(domainId)
+	}
+	httpSendResponse(w, code, nil, err)
+}
+
+// SyncLDAP is an httpHandler for route POST /domains/ldap/sync/{id}
+// Syncs users and group members with the directory now
+func SyncLDAP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Sync LDAP ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.LDAPSyncResult
+	domainId, code, err := adminDomain(r)
+	if err == nil {
+		resp, err = jobs.LDAPSyncDomain(domainId)
+	}
+	httpSendResponse(w, code, resp, err)
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/saroopmathur/rest-api/auth"
+	"github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Admin must pass the TOTP step to login - enrolled, or the domain requires
+// it. pending is set when a secret was issued that is not enabled yet
+func AdminMFARequired(a *model.Admin2) (required bool, enrolled bool, pending bool) {
+	mfa := db.GetAdminMFA(a.ID)
+	enrolled = mfa != nil && mfa.Enabled
+	pending = mfa != nil && !mfa.Enabled && mfa.Secret != ""
+	return enrolled || db.DomainMFARequired(a.Domain.ID), enrolled, pending
+}
+
+// Start TOTP enrollment of an admin with a new secret, replacing one not
+// enabled yet. The secret is enabled by the first code checked
+func AdminStartEnroll(a *model.Admin2) (*model.MFAEnrollResp, error) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = db.SetAdminTOTPSecret(a.ID, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &model.MFAEnrollResp{Secret: secret, OTPAuthURI: auth.TOTPURI(a.Name+"@"+a.Domain.Name, secret)}, nil
+}
+
+// Check the code of the second login step, a TOTP code or a recovery code.
+// A TOTP code for a secret not enabled yet completes enrollment, the new
+// recovery codes are then returned
+func AdminCheckOTP(adminId int, code string) (bool, []string) {
+	mfa := db.GetAdminMFA(adminId)
+	if mfa == nil || mfa.Secret == "" {
+		return false, nil
+	}
+
+	step, ok := auth.ValidateTOTP(mfa.Secret, code, mfa.LastStep, time.Now())
+	if ok {
+		if err := db.EnableAdminTOTP(adminId, step); err != nil {
+			log.Printf("AdminCheckOTP: [%d] %v\n", adminId, err)
+			return false, nil
+		}
+		if mfa.Enabled {
+			return true, nil
+		}
+		codes, err := newRecoveryCodes(adminId)
+		if err != nil {
+			log.Printf("AdminCheckOTP: [%d] recovery codes %v\n", adminId, err)
+		}
+		return true, codes
+	}
+
+	if mfa.Enabled && db.UseRecoveryCode(adminId, auth.HashRecoveryCode(code)) {
+		log.Printf("AdminCheckOTP: [%d] recovery code used\n", adminId)
+		return true, nil
+	}
+	return false, nil
+}
+
+func newRecoveryCodes(adminId int) ([]string, error) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	err = db.SetRecoveryCodes(adminId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// The logged in admin, in its own domain (power admins work in another one)
+func reqAdmin(r *http.Request) (*model.Admin2, error) {
+	u := reqUser(r)
+	a := db.GetAdminByID(u.ID)
+	if a == nil {
+		return nil, fmt.Errorf("admin %s %d unknown", u.Name, u.ID)
+	}
+	return a, nil
+}
+
+// GetMFA is an httpHandler for route GET /mfa
+func GetMFA(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get MFA ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.MFAStatus
+	a, err := reqAdmin(r)
+	if err == nil {
+		mfa := db.GetAdminMFA(a.ID)
+		resp = &model.MFAStatus{}
+		resp.Enabled = mfa != nil && mfa.Enabled
+		resp.Required = db.DomainMFARequired(a.Domain.ID)
+		if resp.Enabled {
+			resp.RecoveryCodes = db.CountRecoveryCodes(a.ID)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// EnrollMFA is an httpHandler for route POST /mfa/enroll
+func EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Enroll MFA ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.MFAEnrollResp
+	var code int
+	a, err := reqAdmin(r)
+	if err == nil {
+		mfa := db.GetAdminMFA(a.ID)
+		if mfa != nil && mfa.Enabled {
+			code = http.StatusConflict
+			err = fmt.Errorf("MFA already enabled. Disable it first")
+		} else {
+			resp, err = AdminStartEnroll(a)
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// VerifyMFA is an httpHandler for route POST /mfa/verify
+// Enables the secret from /mfa/enroll and returns the recovery codes
+func VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Verify MFA ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.MFACodeReq
+	var resp *model.MFARecoveryCodes
+	var code int
+	a, err := reqAdmin(r)
+	if err == nil {
+		err = decodeJSONBody(w, r, &req)
+	}
+	if err == nil {
+		mfa := db.GetAdminMFA(a.ID)
+		if mfa == nil || mfa.Secret == "" {
+			err = fmt.Errorf("MFA enrollment not started")
+		} else if mfa.Enabled {
+			code = http.StatusConflict
+			err = fmt.Errorf("MFA already enabled")
+		} else if ok, codes := AdminCheckOTP(a.ID, req.Code); !ok {
+			code = http.StatusForbidden
+			err = fmt.Errorf("invalid code")
+		} else {
+			resp = &model.MFARecoveryCodes{RecoveryCodes: codes}
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// DisableMFA is an httpHandler for route POST /mfa/disable
+func DisableMFA(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Disable MFA ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.MFACodeReq
+	var code int
+	a, err := reqAdmin(r)
+	if err == nil {
+		err = decodeJSONBody(w, r, &req)
+	}
+	if err == nil {
+		mfa := db.GetAdminMFA(a.ID)
+		if mfa == nil || !mfa.Enabled {
+			err = fmt.Errorf("MFA not enabled")
+		} else if db.DomainMFARequired(a.Domain.ID) {
+			code = http.StatusForbidden
+			err = fmt.Errorf("MFA is required in domain %s", a.Domain.Name)
+		} else if ok, _ := AdminCheckOTP(a.ID, req.Code); !ok {
+			code = http.StatusForbidden
+			err = fmt.Errorf("invalid code")
+		} else {
+			err = db.DisableAdminTOTP(a.ID)
+		}
+	}
+	httpSendResponse(w, code, nil, err)
+}
+
+// RegenerateRecoveryCodes is an httpHandler for route POST /mfa/recoverycodes
+// The previous codes stop working
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Regenerate Recovery Codes ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.MFACodeReq
+	var resp *model.MFARecoveryCodes
+	var code int
+	a, err := reqAdmin(r)
+	if err == nil {
+		err = decodeJSONBody(w, r, &req)
+	}
+	if err == nil {
+		mfa := db.GetAdminMFA(a.ID)
+		if mfa == nil || !mfa.Enabled {
+			err = fmt.Errorf("MFA not enabled")
+		} else if ok, _ := AdminCheckOTP(a.ID, req.Code); !ok {
+			code = http.StatusForbidden
+			err = fmt.Errorf("invalid code")
+		} else {
+			var codes []string
+			codes, err = newRecoveryCodes(a.ID)
+			if err == nil {
+				resp = &model.MFARecoveryCodes{RecoveryCodes: codes}
+			}
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// ResetAdminMFA is an httpHandler for route DELETE /admins/mfa/{id}
+// For an admin who lost the authenticator and the recovery codes
+func ResetAdminMFA(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Reset Admin MFA ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	domainName, domainId := reqDomain(r)
+	adminName, adminId := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else if a := db.SelectAdmin(domainId, adminName, adminId); a == nil {
+		err = fmt.Errorf("admin %s %d unknown", adminName, adminId)
+	} else if a.ID == reqUser(r).ID {
+		// Own MFA is only turned off with a valid code
+		err = fmt.Errorf("use /mfa/disable to disable your own MFA")
+	} else {
+		err = db.DisableAdminTOTP(a.ID)
+	}
+	httpSendResponse(w, 0, nil, err)
+}
+
+// IssueAdminMFA is an httpHandler for route POST /admins/mfa/{id}
+// For an admin who has to enroll before logging in to a domain requiring
+// MFA. The secret is handed over out of band, its first code enables it
+func IssueAdminMFA(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Issue Admin MFA ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.MFAEnrollResp
+	var code int
+	var err error
+	domainName, domainId := reqDomain(r)
+	adminName, adminId := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else if a := db.SelectAdmin(domainId, adminName, adminId); a == nil {
+		err = fmt.Errorf("admin %s %d unknown", adminName, adminId)
+	} else if a.ID == reqUser(r).ID {
+		err = fmt.Errorf("use /mfa/enroll to enroll yourself")
+	} else if mfa := db.GetAdminMFA(a.ID); mfa != nil && mfa.Enabled {
+		code = http.StatusConflict
+		err = fmt.Errorf("MFA already enabled. Reset it first")
+	} else {
+		resp, err = AdminStartEnroll(a)
+	}
+	httpSendResponse(w, code, resp, err)
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/saroopmathur/rest-api/auth"
+	"github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// OIDCLogin is an httpHandler for route GET /oidc/login?role=user|admin
+// Redirects the browser to the identity provider, which comes back to
+// /oidc/callback
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Login with Identity Provider ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.URL.Path)
+
+	if !auth.OIDCEnabled() {
+		httpSendResponse(w, http.StatusNotFound, nil, fmt.Errorf("OpenID Connect login not configured"))
+		return
+	}
+
+	st := &model.OIDCState{Role: db.ROLE_USER}
+	if r.URL.Query().Get("role") == "admin" {
+		st.Role = db.ROLE_ADMIN
+	}
+
+	state, nonce, err := auth.NewOIDCState()
+	if err != nil {
+		httpSendResponse(w, http.StatusInternalServerError, nil, err)
+		return
+	}
+	verifier, challenge, err := auth.NewPKCE()
+	if err != nil {
+		httpSendResponse(w, http.StatusInternalServerError, nil, err)
+		return
+	}
+	st.Nonce = nonce
+	st.Verifier = verifier
+
+	err = db.SaveOIDCState(state, st)
+	if err != nil {
+		httpSendResponse(w, http.StatusInternalServerError, nil, err)
+		return
+	}
+	url, err := auth.OIDC.AuthURL(state, nonce, challenge)
+	if err != nil {
+		log.Printf("OIDCLogin: %v\n", err)
+		httpSendResponse(w, http.StatusBadGateway, nil, fmt.Errorf("identity provider unavailable"))
+		return
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// OIDCAuthenticate completes the login on the callback from the identity
+// provider. Returns the local identity and the role it logs in as
+func OIDCAuthenticate(code string, state string) (*auth.OIDCIdentity, string, error) {
+	if !auth.OIDCEnabled() {
+		return nil, "", fmt.Errorf("OpenID Connect login not configured")
+	}
+	if code == "" || state == "" {
+		return nil, "", fmt.Errorf("missing code or state")
+	}
+
+	st, err := db.TakeOIDCState(state)
+	if err != nil {
+		return nil, "", err
+	}
+	claims, err := auth.OIDC.Exchange(code, st.Verifier, st.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := auth.MapOIDCClaims(claims)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if (st.Role == db.ROLE_ADMIN && !id.Admin) || (st.Role == db.ROLE_USER && !id.User) {
+		return nil, "", auth.ErrOIDCDenied
+	}
+	return id, st.Role, nil
+}
+
+// Id and domain of the user or admin of the request
+func oidcPrincipalId(r *http.Request, role string) (int, int, error) {
+	domainName, domainId := reqDomain(r)
+	name, id := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		return 0, 0, fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	}
+	if role == db.ROLE_ADMIN {
+		if a := db.SelectAdmin(domainId, name, id); a != nil {
+			return a.ID, domainId, nil
+		}
+		return 0, 0, fmt.Errorf("admin %s %d unknown", name, id)
+	}
+	if u := db.SelectUser(domainId, name, id); u != nil {
+		return u.ID, domainId, nil
+	}
+	return 0, 0, fmt.Errorf("user %s %d unknown", name, id)
+}
+
+func linkOIDC(w http.ResponseWriter, r *http.Request, role string) {
+	var req model.OIDCLinkReq
+	id, domainId, err := oidcPrincipalId(r, role)
+	if err == nil {
+		err = decodeJSONBody(w, r, &req)
+	}
+	if err == nil && (!auth.OIDCEnabled() || req.Subject == "") {
+		err = fmt.Errorf("subject and OIDC_ISSUER are required")
+	}
+	if err == nil {
+		err = db.BindOIDCIdentity(role, auth.OIDC_ISSUER, req.Subject, id, domainId)
+	}
+	httpSendResponse(w, 0, nil, err)
+}
+
+func unlinkOIDC(w http.ResponseWriter, r *http.Request, role string) {
+	id, domainId, err := oidcPrincipalId(r, role)
+	if err == nil {
+		err = db.UnbindOIDCIdentity(role, id, domainId)
+	}
+	httpSendResponse(w, 0, nil, err)
+}
+
+// LinkUserOIDC is an httpHandler for route POST /users/oidc/{id}
+// {"subject": "..."} lets the account with that sub at OIDC_ISSUER login as
+// the user. Logins through the identity provider only reach bound accounts
+func LinkUserOIDC(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Link User to Identity Provider ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	linkOIDC(w, r, db.ROLE_USER)
+}
+
+// UnlinkUserOIDC is an httpHandler for route DELETE /users/oidc/{id}
+func UnlinkUserOIDC(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Unlink User from Identity Provider ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	unlinkOIDC(w, r, db.ROLE_USER)
+}
+
+// LinkAdminOIDC is an httpHandler for route POST /admins/oidc/{id}
+// Same as LinkUserOIDC, for admins
+func LinkAdminOIDC(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Link Admin to Identity Provider ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	linkOIDC(w, r, db.ROLE_ADMIN)
+}
+
+// UnlinkAdminOIDC is an httpHandler for route DELETE /admins/oidc/{id}
+func UnlinkAdminOIDC(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Unlink Admin from Identity Provider ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	unlinkOIDC(w, r, db.ROLE_ADMIN)
+}
@@ -2,9 +2,14 @@ package handler
 
 import (
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 
 	db "github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/firewall"
 	m "github.com/saroopmathur/rest-api/models"
 )
 
@@ -22,13 +27,16 @@ func GetPolicies(w http.ResponseWriter, r *http.Request) {
 		switch u.Role {
 		case db.ROLE_USER:
 			policy, err = db.GetUserPolicy(u.Domain.ID, u.Name, u.ID)
+			// The client app names its device, ?device=
+			if device, _ := reqDevice(r, u.Domain.ID, u.ID); device != nil {
+				db.DeviceSeen(device.ID)
+			}
 		case db.ROLE_ADMIN, db.ROLE_POWERADMIN:
 			domainName, domainId := reqDomain(r)
 			policy, err = db.GetAllPolicies(domainId)
 			fmt.Printf("GetAllPolicies: domain [%s %d] %v\n", domainName, domainId, policy)
 		case db.ROLE_SERVICE:
-			//policy, err = db.GetUserPolicy(u.Domain.ID, u.Name, u.ID)
-			//fmt.Printf("GetPolicies: domain [%s %d] %v\n", domainName, domainId, policies)
+			// Services get their peers from /serviceapi/peers
 		}
 	}
 	httpSendResponse(w, 0, policy, err)
@@ -59,3 +67,141 @@ func GetPolicy(w http.ResponseWriter, r *http.Request) {
 	}
 	httpSendResponse(w, 0, policy, err)
 }
+
+func validateSimulateReq(req *m.SimulateReq) (net.IP, error) {
+	if req.User == "" && req.UserID == 0 {
+		return nil, fmt.Errorf("user required")
+	}
+	ip := net.ParseIP(req.Dest)
+	if ip == nil {
+		return nil, fmt.Errorf("dest %s invalid", req.Dest)
+	}
+	req.Protocol = strings.ToLower(req.Protocol)
+	switch req.Protocol {
+	case "":
+		req.Protocol = firewall.PROTO_TCP
+	case firewall.PROTO_TCP, firewall.PROTO_UDP, firewall.PROTO_ICMP:
+	default:
+		return nil, fmt.Errorf("protocol %s invalid, tcp, udp or icmp", req.Protocol)
+	}
+	if req.Port < 0 || req.Port > 65535 || (req.Port > 0 && req.Protocol == firewall.PROTO_ICMP) {
+		return nil, fmt.Errorf("port %d invalid", req.Port)
+	}
+	return ip, nil
+}
+
+// Grants of the policy allowing the traffic, the first matching rule of
+// each app. Services in name order
+func simulatePolicy(policy *m.Policy, ip net.IP, proto string, port int) []*m.SimulateMatch {
+	var names []string
+	for name := range policy.ServiceNodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	matches := []*m.SimulateMatch{}
+	for _, name := range names {
+		for _, app := range policy.ServiceNodes[name].Apps {
+			for _, rule := range firewall.AppRules(app.Rules, app.AllowedIPs) {
+				if !rule.Matches(ip, proto, port) {
+					continue
+				}
+				match := &m.SimulateMatch{Grant: "user", AppID: app.ID, App: app.Name, Service: name, Rule: rule.AppRule()}
+				if app.Group != "" {
+					match.Grant, match.Group = "group", app.Group
+				}
+				matches = append(matches, match)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// Deny rows of apps covering the traffic, the first matching rule of each
+func simulateDenials(denials []*m.AccessDenial, ip net.IP, proto string, port int) []*m.SimulateMatch {
+	denied := []*m.SimulateMatch{}
+	for _, d := range denials {
+		for _, rule := range firewall.AppRules(d.App.Rules, d.App.AllowedIPs) {
+			if !rule.Matches(ip, proto, port) {
+				continue
+			}
+			denied = append(denied, &m.SimulateMatch{Grant: d.Grant, AccessID: d.AccessID, Group: d.Group,
+				AppID: d.App.ID, App: d.App.Name, Service: d.App.ServiceName, Rule: rule.AppRule()})
+			break
+		}
+	}
+	return denied
+}
+
+// "SimulatePolicy", "POST", "/policies/simulate",
+// Can the user reach the destination, and through which grants, computed
+// from the policy the user gets. Must be Admin to call this API
+func SimulatePolicy(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Simulate Policy ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	var resp *m.SimulateResult
+
+	u := reqUser(r)
+	switch u.Role {
+	case db.ROLE_ADMIN, db.ROLE_POWERADMIN:
+	default:
+		httpSendResponse(w, http.StatusUnauthorized, nil, fmt.Errorf("Unauthorized"))
+		return
+	}
+
+	var req m.SimulateReq
+	var ip net.IP
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else if err = decodeJSONBody(w, r, &req); err == nil {
+		ip, err = validateSimulateReq(&req)
+	}
+	if err != nil {
+		httpSendResponse(w, 0, resp, err)
+		return
+	}
+
+	user := db.SelectUser(domainId, req.User, req.UserID)
+	if user == nil {
+		httpSendResponse(w, http.StatusNotFound, nil, fmt.Errorf("user %s %d unknown", req.User, req.UserID))
+		return
+	}
+	policy, err := db.GetUserPolicy(domainId, "", user.ID)
+	var denials []*m.AccessDenial
+	if err == nil {
+		denials, err = db.SelectUserDenials(domainId, user.ID)
+	}
+	if err == nil {
+		resp = &m.SimulateResult{User: user.Name, Dest: ip.String(), Port: req.Port, Protocol: req.Protocol}
+		resp.Matches = simulatePolicy(policy, ip, req.Protocol, req.Port)
+		resp.Denied = simulateDenials(denials, ip, req.Protocol, req.Port)
+		resp.Allowed = len(resp.Matches) > 0
+		switch {
+		case resp.Allowed:
+			resp.Decision = "allow"
+			resp.Reason = fmt.Sprintf("allowed by %d grant(s)", len(resp.Matches))
+		case len(resp.Denied) > 0 && resp.Denied[0].Group != "":
+			d := resp.Denied[0]
+			resp.Decision = "deny"
+			resp.Reason = fmt.Sprintf("denied by group %s deny %d of app %s", d.Group, d.AccessID, d.App)
+		case len(resp.Denied) > 0:
+			d := resp.Denied[0]
+			resp.Decision = "deny"
+			resp.Reason = fmt.Sprintf("denied by user deny %d of app %s", d.AccessID, d.App)
+		case len(policy.ServiceNodes) == 0:
+			resp.Decision = "deny"
+			resp.Reason = "user has no grants in effect"
+		default:
+			resp.Decision = "deny"
+			resp.Reason = "no app granted to the user covers the destination"
+		}
+		log.Printf("SimulatePolicy: Domain:[%s %d] User:[%s] %s %s:%d - %s\n",
+			domainName, domainId, user.Name, req.Protocol, ip, req.Port, resp.Decision)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
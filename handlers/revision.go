@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Policy revisions, every change to the access rows of a domain or to who
+// they reach is numbered so it can be diffed and rolled back
+
+// Before a request changes the policy, record it as it is if the domain
+// has no revision yet, so the first change can be diffed and rolled back
+func policyChanging(domainId int) {
+	db.EnsurePolicyBaseline(domainId)
+}
+
+// Record the change the request made, if any, as a policy revision
+func policyChanged(r *http.Request, domainId int) {
+	db.RecordPolicyRevision(domainId, reqUser(r), r.Method+" "+r.URL.Path)
+}
+
+// /policies is also called by users, revisions are for admins only
+func reqPolicyAdmin(w http.ResponseWriter, r *http.Request) bool {
+	switch reqUser(r).Role {
+	case db.ROLE_ADMIN, db.ROLE_POWERADMIN:
+		return true
+	}
+	httpSendResponse(w, http.StatusUnauthorized, nil, fmt.Errorf("Unauthorized"))
+	return false
+}
+
+// "ReadPolicyRevisions", "GET", "/policies/revisions"
+// Revisions of the policy of the domain, newest first
+func ReadPolicyRevisions(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get Policy Revisions ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+	if !reqPolicyAdmin(w, r) {
+		return
+	}
+
+	var err error
+	var resp []*model.PolicyRevision
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		resp = db.SelectPolicyRevisions(domainId)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "ReadPolicyRevision", "GET", "/policies/revisions/{id}"
+// Revision {id} with its access rows and grants
+func ReadPolicyRevision(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Get Policy Revision ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+	if !reqPolicyAdmin(w, r) {
+		return
+	}
+
+	var err error
+	var code int
+	var resp *model.PolicyRevision
+	domainName, domainId := reqDomain(r)
+	_, revision := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		resp = db.SelectPolicyRevision(domainId, revision)
+		if resp == nil {
+			code = http.StatusNotFound
+		}
+	}
+	httpSendResponse(w, code, resp, err)
+}
+
+// "DiffPolicyRevisions", "GET", "/policies/revisions/{id}/diff/{id2}"
+// Grants added and removed from revision {id} to {id2}, by user and service
+func DiffPolicyRevisions(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Diff Policy Revisions ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+	if !reqPolicyAdmin(w, r) {
+		return
+	}
+
+	var err error
+	var resp *model.PolicyDiff
+	domainName, domainId := reqDomain(r)
+	_, from := reqNameOrId(r)
+	_, to := reqNameOrId2(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		resp, err = db.DiffPolicyRevisions(domainId, from, to)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "RollbackPolicy", "POST", "/policies/rollback/{id}"
+// Restore the access rows of the domain to those of revision {id}
+func RollbackPolicy(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Rollback Policy ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+	if !reqPolicyAdmin(w, r) {
+		return
+	}
+
+	var err error
+	var resp *model.PolicyRevision
+	domainName, domainId := reqDomain(r)
+	_, revision := reqNameOrId(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		log.Printf("RollbackPolicy: Domain:[%s %d] Revision:[%d]\n", domainName, domainId, revision)
+		resp, err = db.RollbackPolicy(domainId, revision, reqUser(r))
+	}
+	httpSendResponse(w, 0, resp, err)
+}
@@ -35,7 +35,17 @@ func CreateService1(r *http.Request, service *model.Service) (*model.Service2, e
 		return nil, err
 	}
 
-	return db.InsertService(domainId, service)
+	if service.GenerateKey {
+		if err := generateServiceKey(service); err != nil {
+			return nil, err
+		}
+	}
+	policyChanging(domainId)
+	s, err := db.InsertService(domainId, service)
+	if err == nil {
+		policyChanged(r, domainId)
+	}
+	return s, err
 }
 
 // ReadServices is an httpHandler for route GET /services
@@ -96,8 +106,21 @@ func UpdateService(w http.ResponseWriter, r *http.Request) {
 		// Decode the request body
 		var service model.Service
 		err = decodeJSONBody(w, r, &service)
+		if err == nil && service.GenerateKey {
+			err = generateServiceKey(&service)
+		}
+		vip := service.VirtualIP
+		if err == nil && vip != "" {
+			// Refused if another user or service of the domain has it
+			err = db.SetServiceVirtualIP(domainId, serviceName, serviceId, vip)
+			service.VirtualIP = ""
+		}
 		if err == nil {
 			resp = db.UpdateService(domainId, serviceName, serviceId, &service)
+			if resp == nil && vip != "" {
+				// Nothing else to update
+				resp = db.SelectService(domainId, serviceName, serviceId)
+			}
 		}
 	}
 
@@ -118,11 +141,32 @@ func DeleteService(w http.ResponseWriter, r *http.Request) {
 		// Unknown Domain
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
+		policyChanging(domainId)
 		resp := db.DeleteService(domainId, serviceName, serviceId)
 		if resp == nil {
 			err = fmt.Errorf("unknown Service")
+		} else {
+			policyChanged(r, domainId)
 		}
 		// resp is the service obejct for the deleted service
 	}
 	httpSendResponse(w, 0, resp, err)
 }
+
+// UnlockService is an httpHandler for route POST /services/unlock/{id}
+// Lifts a lockout after too many failed logins
+func UnlockService(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Unlock Service ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	serviceName, serviceId := reqNameOrId(r)
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		err = db.UnlockLogin(db.ROLE_SERVICE, domainId, serviceName, serviceId)
+	}
+	httpSendResponse(w, 0, nil, err)
+}
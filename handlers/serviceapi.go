@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	db "github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/firewall"
+	model "github.com/saroopmathur/rest-api/models"
+	"github.com/saroopmathur/rest-api/wg"
+)
+
+// API of the agent on service (gateway) nodes, under /serviceapi/. The
+// service is always the caller
+
+// "ServiceGetPeers", "GET", "/serviceapi/peers"
+// Users allowed to connect, per app of the service
+func ServiceGetPeers(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Service Get Peers ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	s := reqUser(r)
+	resp := &model.ServicePeers{Service: s.Name, Apps: db.SelectServicePeers(s.Domain.ID, s.ID)}
+	if resp.Apps == nil {
+		resp.Apps = []*model.PeerApp{}
+	}
+	httpSendResponse(w, 0, resp, nil)
+}
+
+// "ServiceSetWGKey", "PUT", "/serviceapi/wgkey"
+// Register or rotate the WireGuard public key and endpoints of the service
+func ServiceSetWGKey(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Service Set WireGuard Key ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.WGKeyReq
+	var resp *model.Service2
+	s := reqUser(r)
+	err := decodeJSONBody(w, r, &req)
+	if err == nil {
+		err = validateWGKeyReq(&req)
+	}
+	if err == nil {
+		service := model.Service{WGKey: req.WGKey, PublicIP: req.PublicIP, LocalIP: req.LocalIP}
+		resp = db.UpdateService(s.Domain.ID, "", s.ID, &service)
+		if resp == nil {
+			err = fmt.Errorf("service %s %d not updated", s.Name, s.ID)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "ServiceWireGuardConf", "GET", "/serviceapi/wireguard.conf"
+// WireGuard config of the caller with a peer per user allowed to reach its
+// apps. wg-quick format, ?format=setconf for wg setconf / syncconf. The
+// agent sends the ETag back in If-None-Match and gets 304 while unchanged
+func ServiceWireGuardConf(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Service WireGuard Config ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	caller := reqUser(r)
+	s := db.SelectService(caller.Domain.ID, "", caller.ID)
+	if s == nil {
+		httpSendResponse(w, http.StatusNotFound, nil, fmt.Errorf("service %s %d unknown", caller.Name, caller.ID))
+		return
+	}
+	conf := wg.ServiceConfig(s, db.SelectServicePeers(caller.Domain.ID, caller.ID))
+
+	var body string
+	if r.URL.Query().Get("format") == "setconf" {
+		body = conf.SetConf()
+	} else {
+		body = conf.WGQuick()
+	}
+	sendAgentConfig(w, r, body)
+}
+
+// "ServiceFirewall", "GET", "/serviceapi/firewall"
+// nftables ruleset restricting users to the apps they are granted,
+// ?format=iptables or ip6tables for iptables-restore. With an ETag as
+// /serviceapi/wireguard.conf
+func ServiceFirewall(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Service Firewall ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	caller := reqUser(r)
+	body, err := CompileFirewall(caller.Domain.ID, "", caller.ID, r.URL.Query().Get("format"))
+	if err != nil {
+		httpSendResponse(w, 0, nil, err)
+		return
+	}
+	sendAgentConfig(w, r, body)
+}
+
+// CompileFirewall compiles the rules of a service of the domain in format
+// nft, iptables or ip6tables
+func CompileFirewall(domainId int, serviceName string, serviceId int, format string) (string, error) {
+	s := db.SelectService(domainId, serviceName, serviceId)
+	if s == nil {
+		return "", fmt.Errorf("service %s %d unknown", serviceName, serviceId)
+	}
+	apps := firewall.FromPeerApps(db.SelectServicePeers(domainId, s.ID))
+	return firewall.Compile(s.Name, apps, format)
+}
+
+// Send a config to an agent, or 304 when its If-None-Match is the ETag
+// of body
+func sendAgentConfig(w http.ResponseWriter, r *http.Request, body string) {
+	sum := sha256.Sum256([]byte(body))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(body))
+}
+
+// If-None-Match holds etag, or *
+func etagMatch(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/saroopmathur/rest-api/auth"
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Policies pushed to clients and service agents as Server-Sent Events, so
+// they need not poll. A change anywhere in the domain makes every stream
+// of the domain read its policy again, only a changed one is sent
+
+// Comment sent on idle streams, so proxies keep them open
+const STREAM_KEEPALIVE = 30 * time.Second
+
+// The session of the stream was not logged out or expired since it began
+func streamSessionValid(r *http.Request) bool {
+	token := reqUser(r).SessionID
+	if token == "" {
+		return true
+	}
+	if auth.JWTEnabled() && auth.IsJWT(token) {
+		claims, err := auth.VerifyJWT(token)
+		return err == nil && !db.IsSessionRevoked(claims.SessionID)
+	}
+	_, err := db.LookupSession(token)
+	return err == nil
+}
+
+// Stream what load reads as a "policy" event on connect and whenever it
+// changes, with ?notify=true only a "changed" event without it. Ends when
+// the client goes away, the session ends or load fails
+func streamPolicy(w http.ResponseWriter, r *http.Request, domainId int, load func() (interface{}, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpSendResponse(w, http.StatusInternalServerError, nil, fmt.Errorf("streaming unsupported"))
+		return
+	}
+	notifyOnly := r.URL.Query().Get("notify") == "true"
+
+	// Subscribe before the first read, a change in between is not missed
+	changes, cancel := db.SubscribePolicyChanges(domainId)
+	defer cancel()
+
+	policy, err := load()
+	if err != nil {
+		httpSendResponse(w, 0, nil, err)
+		return
+	}
+	last, _ := json.Marshal(policy)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	id := 0
+	send := func(event string, data []byte) error {
+		id++
+		_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+		flusher.Flush()
+		return err
+	}
+	if notifyOnly {
+		err = send("ready", []byte("{}"))
+	} else {
+		err = send("policy", last)
+	}
+	if err != nil {
+		return
+	}
+
+	var recheck <-chan time.Time
+	if db.POLICY_RECHECK_INTERVAL > 0 {
+		ticker := time.NewTicker(db.POLICY_RECHECK_INTERVAL)
+		defer ticker.Stop()
+		recheck = ticker.C
+	}
+	keepalive := time.NewTicker(STREAM_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err = fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		case <-changes:
+		case <-recheck:
+		}
+
+		if !streamSessionValid(r) {
+			send("error", []byte(`{"message":"Unauthorized"}`))
+			return
+		}
+		policy, err = load()
+		if err != nil {
+			log.Printf("streamPolicy: %s %v\n", r.URL.Path, err)
+			data, _ := json.Marshal(&Response{Message: err.Error()})
+			send("error", data)
+			return
+		}
+		data, _ := json.Marshal(policy)
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		if notifyOnly {
+			err = send("changed", []byte("{}"))
+		} else {
+			err = send("policy", data)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// "UserStreamPolicies", "GET", "/userapi/policies/stream"
+// Policy of the caller as GET /policies gives it, pushed when it changes
+func UserStreamPolicies(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Stream Policies ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	u := reqUser(r)
+	streamPolicy(w, r, u.Domain.ID, func() (interface{}, error) {
+		return db.GetUserPolicy(u.Domain.ID, "", u.ID)
+	})
+}
+
+// "ServiceStreamPeers", "GET", "/serviceapi/peers/stream"
+// Peers of the caller as GET /serviceapi/peers gives them, pushed when
+// they change
+func ServiceStreamPeers(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Service Stream Peers ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	s := reqUser(r)
+	streamPolicy(w, r, s.Domain.ID, func() (interface{}, error) {
+		if db.SelectService(s.Domain.ID, "", s.ID) == nil {
+			return nil, fmt.Errorf("service %s %d unknown", s.Name, s.ID)
+		}
+		resp := &model.ServicePeers{Service: s.Name, Apps: db.SelectServicePeers(s.Domain.ID, s.ID)}
+		if resp.Apps == nil {
+			resp.Apps = []*model.PeerApp{}
+		}
+		return resp, nil
+	})
+}
@@ -35,7 +35,19 @@ func CreateUser1(r *http.Request, user *model.User) (*model.User2, error) {
 		return nil, err
 	}
 
-	return db.InsertUser(domainId, user)
+	var privateKey string
+	if user.GenerateKey {
+		var err error
+		if privateKey, err = generateUserKey(user); err != nil {
+			return nil, err
+		}
+	}
+	u, err := db.InsertUser(domainId, user)
+	if u != nil {
+		// Only ever returned here
+		u.PrivateKey = privateKey
+	}
+	return u, err
 }
 
 // ReadUsers is an httpHandler for route GET /users
@@ -122,10 +134,28 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		// Decode the request body
 		var user model.User
 		err = decodeJSONBody(w, r, &user)
+		var privateKey string
+		if err == nil && user.GenerateKey {
+			privateKey, err = generateUserKey(&user)
+		}
+		vip := user.VirtualIP
+		if err == nil && vip != "" {
+			// Refused if another user or service of the domain has it
+			err = db.SetUserVirtualIP(domainId, userName, userId, vip)
+			user.VirtualIP = ""
+		}
 		if err == nil {
 			resp = db.UpdateUser(domainId, userName, userId, &user)
+			if resp == nil && vip != "" {
+				// Nothing else to update
+				resp = db.SelectUser(domainId, userName, userId)
+			}
 		}
 		fmt.Printf("Update User %s %d Domain %s %v\n", userName, userId, domainName, resp)
+		if resp != nil {
+			// Only ever returned here, never logged
+			resp.PrivateKey = privateKey
+		}
 	}
 
 	httpSendResponse(w, 0, resp, err)
@@ -145,12 +175,33 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 		// Unknown Domain
 		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
 	} else {
+		policyChanging(domainId)
 		resp := db.DeleteUser(domainId, userName, userId)
 		if resp == nil {
 			err = fmt.Errorf("unknown User")
+		} else {
+			policyChanged(r, domainId)
 		}
 		// resp is the user obejct for the deleted user
 		fmt.Printf("Delete User %s %d Domain %s %v\n", userName, userId, domainName, resp)
 	}
 	httpSendResponse(w, 0, resp, err)
 }
+
+// UnlockUser is an httpHandler for route POST /users/unlock/{id}
+// Lifts a lockout after too many failed logins
+func UnlockUser(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Unlock User ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	userName, userId := reqNameOrId(r)
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err = fmt.Errorf("domain %s %d unknown", domainName, domainId)
+	} else {
+		err = db.UnlockLogin(db.ROLE_USER, domainId, userName, userId)
+	}
+	httpSendResponse(w, 0, nil, err)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Self-service API of the client app, under /userapi/. The user is always
+// the caller, never taken from the path
+
+const MIN_PASSWORD_LEN = 8
+
+// "UserGetProfile", "GET", "/userapi/profile"
+func UserGetProfile(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Get Profile ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var err error
+	u := reqUser(r)
+	resp := db.SelectUser(u.Domain.ID, "", u.ID)
+	if resp == nil {
+		err = fmt.Errorf("user %s %d unknown", u.Name, u.ID)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserChangePassword", "PUT", "/userapi/password"
+// The other sessions of the user are ended
+func UserChangePassword(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Change Password ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.PasswordChangeReq
+	u := reqUser(r)
+	err := decodeJSONBody(w, r, &req)
+	if err == nil {
+		err = UserChangePassword1(u, &req)
+	}
+	httpSendResponse(w, 0, nil, err)
+}
+
+func UserChangePassword1(u *model.User2, req *model.PasswordChangeReq) error {
+	user := db.SelectUser(u.Domain.ID, "", u.ID)
+	if user == nil {
+		return fmt.Errorf("user %s %d unknown", u.Name, u.ID)
+	}
+	if user.External {
+		return fmt.Errorf("password of %s is managed by the directory", u.Name)
+	}
+	if len(req.NewPassword) < MIN_PASSWORD_LEN {
+		return fmt.Errorf("new password must have at least %d characters", MIN_PASSWORD_LEN)
+	}
+
+	username := u.Name + "@" + u.Domain.Name
+	if err := db.CheckLoginAllowed(db.ROLE_USER, username, ""); err != nil {
+		return err
+	}
+	if !UserCheckPassword(user, req.CurrentPassword) {
+		// Counts as a failed login, a stolen session must not guess the password
+		db.LoginFailed(db.ROLE_USER, username, "")
+		return fmt.Errorf("current password invalid")
+	}
+	return db.ChangeUserPassword(u.ID, req.NewPassword, u.SessionID)
+}
+
+// "UserSetWGKey", "PUT", "/userapi/wgkey"
+// Register or rotate the WireGuard public key and endpoints of the user
+func UserSetWGKey(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Set WireGuard Key ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.WGKeyReq
+	var resp *model.User2
+	u := reqUser(r)
+	err := decodeJSONBody(w, r, &req)
+	if err == nil {
+		err = validateWGKeyReq(&req)
+	}
+	if err == nil {
+		user := model.User{WGKey: req.WGKey, PublicIP: req.PublicIP, LocalIP: req.LocalIP}
+		resp = db.UpdateUser(u.Domain.ID, "", u.ID, &user)
+		if resp == nil {
+			err = fmt.Errorf("user %s %d not updated", u.Name, u.ID)
+		}
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "UserGetGroups", "GET", "/userapi/groups"
+func UserGetGroups(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Get Groups ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	u := reqUser(r)
+	resp := db.GetUserGroups(u.Domain.ID, "", u.ID)
+	httpSendResponse(w, 0, resp, nil)
+}
+
+// "UserGetApps", "GET", "/userapi/apps"
+// Apps allowed to the user directly or through its groups
+func UserGetApps(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Get Apps ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	u := reqUser(r)
+	resp := db.SelectUserApps(u.Domain.ID, u.ID)
+	httpSendResponse(w, 0, resp, nil)
+}
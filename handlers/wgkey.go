@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/saroopmathur/rest-api/auth"
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+	"github.com/saroopmathur/rest-api/wg"
+)
+
+// Keypair generated for generate_key. The private key of a user is not
+// kept, it is only returned in the response
+func generateUserKey(user *model.User) (string, error) {
+	if user.WGKey != "" {
+		return "", fmt.Errorf("wg_key and generate_key are exclusive")
+	}
+	private, public, err := wg.GenerateKeyPair()
+	if err != nil {
+		return "", err
+	}
+	user.WGKey = public
+	return private, nil
+}
+
+// Keypair generated for generate_key. The private key of a service is
+// kept sealed for its agent to fetch
+func generateServiceKey(service *model.Service) error {
+	if service.WGKey != "" {
+		return fmt.Errorf("wg_key and generate_key are exclusive")
+	}
+	private, public, err := wg.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	sealed, err := auth.Seal(private)
+	if err != nil {
+		log.Printf("generateServiceKey: %v\n", err)
+		return fmt.Errorf("private keys can not be stored: %v", err)
+	}
+	service.WGKey = public
+	service.SealedKey = sealed
+	return nil
+}
+
+// "ServiceGetWGKey", "GET", "/serviceapi/wgkey"
+// WireGuard keypair of the service, and whether the rotation policy of the
+// domain wants it replaced
+func ServiceGetWGKey(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Service Get WireGuard Key ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	s := reqUser(r)
+	resp, err := db.GetServiceKeyPair(s.Domain.ID, s.ID)
+	httpSendResponse(w, 0, resp, err)
+}
+
+// "ServiceRotateWGKey", "POST", "/serviceapi/wgkey/rotate"
+// Replace the WireGuard keypair of the service by one generated on the
+// server. The agent applies the returned private key
+func ServiceRotateWGKey(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Service Rotate WireGuard Key ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.WGKeyPair
+	s := reqUser(r)
+	var service model.Service
+	err := generateServiceKey(&service)
+	if err == nil {
+		if db.UpdateService(s.Domain.ID, "", s.ID, &service) == nil {
+			err = fmt.Errorf("service %s %d not updated", s.Name, s.ID)
+		}
+	}
+	if err == nil {
+		resp, err = db.GetServiceKeyPair(s.Domain.ID, s.ID)
+	}
+	httpSendResponse(w, 0, resp, err)
+}
+
+// ReadKeyRotation is an httpHandler for route GET /domains/keyrotation/{id}
+// Keys of the domain older than its rotation policy
+func ReadKeyRotation(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Read Key Rotation ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var resp *model.KeyRotationReport
+	domainId, code, err := adminDomain(r)
+	if err == nil {
+		resp, err = db.SelectStaleKeys(domainId)
+	}
+	httpSendResponse(w, code, resp, err)
+}
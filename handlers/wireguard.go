@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	db "github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+	"github.com/saroopmathur/rest-api/qr"
+	"github.com/saroopmathur/rest-api/wg"
+)
+
+// Pixels per module of QR codes
+const QR_SCALE = 8
+
+// "UserWireGuardConf", "GET", "/userapi/wireguard.conf"
+// wg-quick config of the caller, ?format=qr for a QR code PNG, ?device= for
+// one of its devices. The private key is left to fill in, see
+// UserOnboardDevice for a config to scan as it is
+func UserWireGuardConf(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User WireGuard Config ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	u := reqUser(r)
+	device, err := reqDevice(r, u.Domain.ID, u.ID)
+	if err != nil {
+		httpSendResponse(w, http.StatusNotFound, nil, err)
+		return
+	}
+	if device != nil {
+		db.DeviceSeen(device.ID)
+	}
+	sendWireGuardConf(w, r, u.Domain.ID, "", u.ID, device)
+}
+
+// "ReadUserWireGuardConf", "GET", "/users/wireguard/{id}"
+// wg-quick config of user {id}, ?format=qr for a QR code PNG, ?device= for
+// one of its devices
+func ReadUserWireGuardConf(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== Read User WireGuard Config ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	domainName, domainId := reqDomain(r)
+	if domainId == 0 {
+		// Unknown Domain
+		err := fmt.Errorf("domain %s %d unknown", domainName, domainId)
+		httpSendResponse(w, 0, nil, err)
+		return
+	}
+	userName, userId := reqNameOrId(r)
+	u := db.SelectUser(domainId, userName, userId)
+	if u == nil {
+		httpSendResponse(w, http.StatusNotFound, nil, fmt.Errorf("user %s %d unknown", userName, userId))
+		return
+	}
+	device, err := reqDevice(r, domainId, u.ID)
+	if err != nil {
+		httpSendResponse(w, http.StatusNotFound, nil, err)
+		return
+	}
+	sendWireGuardConf(w, r, domainId, "", u.ID, device)
+}
+
+// "UserOnboardDevice", "POST", "/userapi/devices/onboard"
+// Register a new device of the caller with a keypair generated here and
+// return its wg-quick config, ?format=qr for a QR code PNG the WireGuard app
+// scans as it is. The keys of the other devices are left alone, and the
+// private key is only ever in this response
+func UserOnboardDevice(w http.ResponseWriter, r *http.Request) {
+	log.Printf("============== User Onboard Device ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	var req model.DeviceReq
+	var device *model.Device
+	u := reqUser(r)
+	err := decodeJSONBody(w, r, &req)
+	if err == nil {
+		// Addresses and keys are handed out by the server
+		req.VirtualIP = ""
+		req.WGKey = ""
+		req.GenerateKey = true
+		device, err = createDevice1(u.Domain.ID, u.ID, &req)
+	}
+	if err != nil {
+		httpSendResponse(w, 0, nil, err)
+		return
+	}
+	db.DeviceSeen(device.ID)
+	sendWireGuardConf(w, r, u.Domain.ID, "", u.ID, device)
+}
+
+// The config of a device has its virtual IP, and its private key when it
+// was just generated
+func sendWireGuardConf(w http.ResponseWriter, r *http.Request, domainId int, userName string, userId int, device *model.Device) {
+	u := db.SelectUser(domainId, userName, userId)
+	if u == nil {
+		httpSendResponse(w, http.StatusNotFound, nil, fmt.Errorf("user %s %d unknown", userName, userId))
+		return
+	}
+	filename := u.Name
+	if device != nil {
+		u.VirtualIP = device.VirtualIP
+		filename += "-" + device.Name
+	}
+	policy, err := db.GetUserPolicy(domainId, "", u.ID)
+	if err != nil {
+		httpSendResponse(w, 0, nil, err)
+		return
+	}
+	c := wg.UserConfig(u, policy)
+	if device != nil && device.PrivateKey != "" {
+		c.Interface.PrivateKey = device.PrivateKey
+	}
+	conf := c.WGQuick()
+
+	if r.URL.Query().Get("format") == "qr" {
+		png, err := qr.PNG(conf, QR_SCALE)
+		if err != nil {
+			httpSendResponse(w, 0, nil, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(png)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.conf\"", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(conf))
+}
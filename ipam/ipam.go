@@ -0,0 +1,102 @@
+// Package ipam hands out the virtual IPs of users and services from the
+// IPv4 or IPv6 pool of their domain
+package ipam
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+)
+
+// Smallest pools, a network with at least two addresses to hand out
+const (
+	MAX_PREFIX_V4 = 30
+	MAX_PREFIX_V6 = 126
+)
+
+// ParsePool parses the CIDR of a pool, the host bits are cleared
+func ParsePool(cidr string) (*net.IPNet, error) {
+	ip, pool, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pool %s", cidr)
+	}
+	ones, bits := pool.Mask.Size()
+	if ip.To4() != nil {
+		pool.IP = pool.IP.To4()
+		if ones > MAX_PREFIX_V4 {
+			return nil, fmt.Errorf("pool %s too small, at most /%d", cidr, MAX_PREFIX_V4)
+		}
+	} else if bits == 128 && ones > MAX_PREFIX_V6 {
+		return nil, fmt.Errorf("pool %s too small, at most /%d", cidr, MAX_PREFIX_V6)
+	}
+	return pool, nil
+}
+
+// Range of the addresses of the pool that can be handed out. The network
+// address is never used, nor the broadcast address of IPv4
+func Range(pool *net.IPNet) (*big.Int, *big.Int) {
+	first := toInt(pool.IP)
+	ones, bits := pool.Mask.Size()
+	last := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last.Add(last, first)
+	last.Sub(last, big.NewInt(1))
+	if bits == 32 {
+		last.Sub(last, big.NewInt(1))
+	}
+	return first.Add(first, big.NewInt(1)), last
+}
+
+// Size is the number of addresses of the pool that can be handed out
+func Size(pool *net.IPNet) *big.Int {
+	first, last := Range(pool)
+	size := new(big.Int).Sub(last, first)
+	return size.Add(size, big.NewInt(1))
+}
+
+// Contains reports whether ip can be handed out from the pool
+func Contains(pool *net.IPNet, ip net.IP) bool {
+	if !pool.Contains(ip) {
+		return false
+	}
+	first, last := Range(pool)
+	n := toInt(ip)
+	return n.Cmp(first) >= 0 && n.Cmp(last) <= 0
+}
+
+// Next is the lowest address of the pool not in used
+func Next(pool *net.IPNet, used []net.IP) (net.IP, error) {
+	var taken []*big.Int
+	for _, ip := range used {
+		if pool.Contains(ip) {
+			taken = append(taken, toInt(ip))
+		}
+	}
+	sort.Slice(taken, func(i, j int) bool { return taken[i].Cmp(taken[j]) < 0 })
+
+	next, last := Range(pool)
+	for _, n := range taken {
+		if c := n.Cmp(next); c == 0 {
+			next.Add(next, big.NewInt(1))
+		} else if c > 0 {
+			break
+		}
+	}
+	if next.Cmp(last) > 0 {
+		return nil, fmt.Errorf("pool %s exhausted", pool)
+	}
+	return toIP(next, len(pool.IP)), nil
+}
+
+func toInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return new(big.Int).SetBytes(ip)
+}
+
+func toIP(n *big.Int, size int) net.IP {
+	ip := make(net.IP, size)
+	n.FillBytes(ip)
+	return ip
+}
@@ -0,0 +1,150 @@
+package ipam
+
+import (
+	"net"
+	"testing"
+)
+
+func mustPool(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	pool, err := ParsePool(cidr)
+	if err != nil {
+		t.Fatalf("ParsePool(%s): %v", cidr, err)
+	}
+	return pool
+}
+
+func ips(addrs ...string) []net.IP {
+	var result []net.IP
+	for _, a := range addrs {
+		result = append(result, net.ParseIP(a))
+	}
+	return result
+}
+
+func TestParsePool(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"10.0.0.0/24", "10.0.0.0/24"},
+		{"10.0.0.77/24", "10.0.0.0/24"},
+		{"10.0.0.4/30", "10.0.0.4/30"},
+		{"fd00::/64", "fd00::/64"},
+		{"fd00::1234/120", "fd00::1200/120"},
+		{"fd00::/126", "fd00::/126"},
+		{"10.0.0.0/31", ""},
+		{"10.0.0.0/32", ""},
+		{"fd00::/127", ""},
+		{"fd00::/128", ""},
+		{"10.0.0.0", ""},
+		{"10.0.0.256/24", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		pool, err := ParsePool(tt.cidr)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParsePool(%s) = %s, want error", tt.cidr, pool)
+			}
+			continue
+		}
+		if err != nil || pool.String() != tt.want {
+			t.Errorf("ParsePool(%s) = %v %v, want %s", tt.cidr, pool, err, tt.want)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	// IPv4 leaves out the network and broadcast addresses, IPv6 only the
+	// network address
+	tests := []struct {
+		cidr        string
+		first, last string
+		size        int64
+	}{
+		{"10.0.0.0/24", "10.0.0.1", "10.0.0.254", 254},
+		{"10.0.0.4/30", "10.0.0.5", "10.0.0.6", 2},
+		{"192.168.0.0/16", "192.168.0.1", "192.168.255.254", 65534},
+		{"fd00::/120", "fd00::1", "fd00::ff", 255},
+		{"fd00::4/126", "fd00::5", "fd00::7", 3},
+		{"fd00:1::/64", "fd00:1::1", "fd00:1::ffff:ffff:ffff:ffff", 0},
+	}
+	for _, tt := range tests {
+		pool := mustPool(t, tt.cidr)
+		first, last := Range(pool)
+		if got := toIP(first, len(pool.IP)).String(); got != tt.first {
+			t.Errorf("Range(%s) first = %s, want %s", tt.cidr, got, tt.first)
+		}
+		if got := toIP(last, len(pool.IP)).String(); got != tt.last {
+			t.Errorf("Range(%s) last = %s, want %s", tt.cidr, got, tt.last)
+		}
+		if tt.size != 0 && Size(pool).Int64() != tt.size {
+			t.Errorf("Size(%s) = %s, want %d", tt.cidr, Size(pool), tt.size)
+		}
+	}
+	if got := Size(mustPool(t, "fd00:1::/64")).String(); got != "18446744073709551615" {
+		t.Errorf("Size(fd00:1::/64) = %s", got)
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		cidr string
+		ip   string
+		want bool
+	}{
+		{"10.0.0.0/24", "10.0.0.0", false},
+		{"10.0.0.0/24", "10.0.0.1", true},
+		{"10.0.0.0/24", "10.0.0.254", true},
+		{"10.0.0.0/24", "10.0.0.255", false},
+		{"10.0.0.0/24", "10.0.1.1", false},
+		{"10.0.0.0/24", "::ffff:10.0.0.1", true},
+		{"fd00::/120", "fd00::", false},
+		{"fd00::/120", "fd00::1", true},
+		{"fd00::/120", "fd00::ff", true},
+		{"fd00::/120", "fd00::100", false},
+		{"fd00::/120", "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := Contains(mustPool(t, tt.cidr), net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Contains(%s, %s) = %v, want %v", tt.cidr, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		cidr string
+		used []net.IP
+		want string
+	}{
+		{"10.0.0.0/24", nil, "10.0.0.1"},
+		{"10.0.0.0/24", ips("10.0.0.1"), "10.0.0.2"},
+		{"10.0.0.0/24", ips("10.0.0.2", "10.0.0.1", "10.0.0.4"), "10.0.0.3"},
+		{"10.0.0.0/24", ips("10.0.0.0", "10.0.0.255"), "10.0.0.1"},
+		{"10.0.0.0/24", ips("10.0.1.1", "192.168.0.1"), "10.0.0.1"},
+		{"10.0.0.0/24", ips("::ffff:10.0.0.1"), "10.0.0.2"},
+		{"10.0.0.0/24", ips("10.0.0.1", "10.0.0.1"), "10.0.0.2"},
+		{"10.0.0.4/30", ips("10.0.0.5"), "10.0.0.6"},
+		{"10.0.0.4/30", ips("10.0.0.5", "10.0.0.6"), ""},
+		{"10.0.0.252/30", ips("10.0.0.253", "10.0.0.254"), ""},
+		{"fd00::/120", nil, "fd00::1"},
+		{"fd00::/120", ips("fd00::1", "fd00::3"), "fd00::2"},
+		{"fd00::4/126", ips("fd00::5", "fd00::6"), "fd00::7"},
+		{"fd00::4/126", ips("fd00::5", "fd00::6", "fd00::7"), ""},
+		{"fd00::/120", ips("10.0.0.1"), "fd00::1"},
+	}
+	for _, tt := range tests {
+		ip, err := Next(mustPool(t, tt.cidr), tt.used)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Next(%s, %v) = %s, want exhausted", tt.cidr, tt.used, ip)
+			}
+			continue
+		}
+		if err != nil || ip.String() != tt.want {
+			t.Errorf("Next(%s, %v) = %v %v, want %s", tt.cidr, tt.used, ip, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/saroopmathur/rest-api/db"
+)
+
+// StartGrantExpiry marks expired time-bound grants deleted every
+// db.GRANT_EXPIRY_INTERVAL (0 - never), so contractors lose access
+// for good
+func StartGrantExpiry() {
+	if db.GRANT_EXPIRY_INTERVAL <= 0 {
+		log.Printf("Grant expiry disabled\n")
+		return
+	}
+	go func() {
+		for {
+			expireGrants()
+			time.Sleep(db.GRANT_EXPIRY_INTERVAL)
+		}
+	}()
+}
+
+// Expire the grants of each domain, as a policy revision
+func expireGrants() {
+	domains, err := db.SelectExpiredGrantDomains()
+	if err != nil {
+		log.Printf("Grant expiry: %v\n", err)
+		return
+	}
+	for _, domainId := range domains {
+		db.EnsurePolicyBaseline(domainId)
+		n, err := db.ExpireGrants(domainId)
+		if err != nil {
+			log.Printf("Grant expiry: domain %d %v\n", domainId, err)
+		}
+		if n > 0 {
+			log.Printf("Grant expiry: domain %d %d grants expired\n", domainId, n)
+			db.RecordPolicyRevision(domainId, nil, "grant expiry")
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/saroopmathur/rest-api/auth"
+	"github.com/saroopmathur/rest-api/db"
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// StartLDAPSync syncs the domains with an LDAP directory every
+// db.LDAP_SYNC_INTERVAL (0 - never)
+func StartLDAPSync() {
+	if db.LDAP_SYNC_INTERVAL <= 0 {
+		log.Printf("LDAP sync disabled\n")
+		return
+	}
+	go func() {
+		for {
+			for _, domainId := range db.SelectLDAPDomains() {
+				if _, err := LDAPSyncDomain(domainId); err != nil {
+					log.Printf("LDAP sync of domain %d: %v\n", domainId, err)
+				}
+			}
+			time.Sleep(db.LDAP_SYNC_INTERVAL)
+		}
+	}()
+}
+
+// LDAPSyncDomain creates the users found in the directory of the domain,
+// disables the external users no longer found, and reconciles the members
+// of the groups that exist in both. Users not marked external are never
+// changed
+func LDAPSyncDomain(domainId int) (*model.LDAPSyncResult, error) {
+	cfg := db.GetLDAPConfig(domainId)
+	if cfg == nil {
+		return nil, fmt.Errorf("domain %d has no LDAP directory", domainId)
+	}
+	dir, err := auth.LDAPRead(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db.EnsurePolicyBaseline(domainId)
+	status, external := db.SelectUserStatus(domainId)
+	if status == nil {
+		return nil, fmt.Errorf("domain %d users unavailable", domainId)
+	}
+
+	var res model.LDAPSyncResult
+	found := map[string]bool{}
+	for _, name := range dir.Users {
+		found[name] = true
+		st, ok := status[name]
+		if !ok {
+			// An empty password, login binds to the directory
+			yes := true
+			_, err := db.InsertUser(domainId, &model.User{Name: name, External: &yes})
+			if err != nil {
+				log.Printf("LDAP sync: create %s domain %d %v\n", name, domainId, err)
+				continue
+			}
+			status[name] = db.STATUS_ACTIVE
+			external[name] = true
+			res.Created++
+		} else if external[name] && st == db.STATUS_DISABLED {
+			if ok, _ := db.SetExternalUserStatus(domainId, name, db.STATUS_ACTIVE); ok {
+				res.Enabled++
+			}
+		}
+	}
+	for name, st := range status {
+		// Also a locked out user, so unlocking does not enable it
+		if external[name] && st != db.STATUS_DELETED && !found[name] {
+			if ok, _ := db.SetExternalUserStatus(domainId, name, db.STATUS_DISABLED); ok {
+				res.Disabled++
+			}
+		}
+	}
+
+	for groupName, members := range dir.Groups {
+		group := db.SelectGroup(domainId, groupName, 0)
+		if group == nil {
+			// Only groups created by an admin are synced
+			continue
+		}
+		want := map[string]bool{}
+		for _, name := range members {
+			want[name] = true
+		}
+
+		have := map[string]bool{}
+		var remove []string
+		for _, u := range db.SelectGroupMembers(domainId, "", group.ID) {
+			have[u.Name] = true
+			if u.External && !want[u.Name] {
+				remove = append(remove, u.Name)
+			}
+		}
+		var add []string
+		for name := range want {
+			if !have[name] && external[name] {
+				add = append(add, name)
+			}
+		}
+
+		if len(add) > 0 {
+			res.MembersAdded += db.AddGroupMembers(domainId, "", group.ID, add)
+		}
+		if len(remove) > 0 {
+			res.MembersRemoved += db.RemoveGroupMembers(domainId, "", group.ID, remove)
+		}
+	}
+
+	db.LDAPSyncDone(domainId)
+	// Users and members the directory changed are a change of the policy
+	db.RecordPolicyRevision(domainId, nil, "ldap sync")
+	log.Printf("LDAP sync of domain %d: %+v\n", domainId, res)
+	return &res, nil
+}
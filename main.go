@@ -1,17 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"log"
-
-	crand "crypto/rand"
-	"encoding/binary"
-	mrand "math/rand"
 	"net/http"
+	"os"
 
 	"github.com/rs/cors"
 
+	"github.com/saroopmathur/rest-api/db"
+	"github.com/saroopmathur/rest-api/jobs"
 	"github.com/saroopmathur/rest-api/router"
 )
 
@@ -29,8 +27,10 @@ func setupGlobalMiddleware(handler http.Handler) http.Handler {
 
 // our main function
 func main() {
-	// Seed random generator
-	randSeed()
+	if len(os.Args) > 1 && os.Args[1] == "firewall" {
+		firewallCommand(os.Args[2:])
+		return
+	}
 
 	log.Printf("Listening on :8000\n")
 
@@ -38,6 +38,10 @@ func main() {
 	directory := flag.String("d", "./images", "folder containing images")
 	flag.Parse()
 
+	db.Connect()
+	jobs.StartLDAPSync()
+	jobs.StartGrantExpiry()
+
 	// Create router and start listen on port 8000
 	router := router.NewRouter()
 
@@ -49,14 +53,3 @@ func main() {
 
 	log.Fatal(http.ListenAndServe(":"+*port, setupGlobalMiddleware(router)))
 }
-
-// Use crypto rand to seed math rand
-func randSeed() {
-	var seed int64
-
-	r := make([]byte, 8)
-	_, _ = crand.Read(r)
-	buf := bytes.NewBuffer(r)
-	binary.Read(buf, binary.LittleEndian, &seed)
-	mrand.Seed(seed)
-}
@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// Request of a user for access to an app, approved or denied by an admin
+type AccessRequest struct {
+	ID            int    `json:"id,omitempty"`
+	UserID        int    `json:"user_id,omitempty"`
+	UserName      string `json:"user_name,omitempty"`
+	AppID         int    `json:"app_id,omitempty"`
+	AppName       string `json:"app_name,omitempty"`
+	Justification string `json:"justification,omitempty"`
+	// Desired duration of the access, 0 - permanent
+	DurationHours int    `json:"duration_hours,omitempty"`
+	State         string `json:"state,omitempty"`
+	// user_access_control row created on approval
+	AccessID   int                   `json:"access_id,omitempty"`
+	CreateTime *time.Time            `json:"create_time,omitempty"`
+	UpdateTime *time.Time            `json:"update_time,omitempty"`
+	Events     []*AccessRequestEvent `json:"events,omitempty"`
+}
+
+// A state change of an access request, by whom and when
+type AccessRequestEvent struct {
+	State     string     `json:"state"`
+	ActorRole string     `json:"actor_role,omitempty"`
+	ActorID   int        `json:"actor_id,omitempty"`
+	ActorName string     `json:"actor_name,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	EventTime *time.Time `json:"event_time,omitempty"`
+}
+
+// Access request as sent by the client app, the app by name or id
+type AccessRequestReq struct {
+	App           string `json:"app,omitempty"`
+	AppID         int    `json:"app_id,omitempty"`
+	Justification string `json:"justification,omitempty"`
+	DurationHours int    `json:"duration_hours,omitempty"`
+}
+
+// Decision of an admin on an access request. DurationHours overrides the
+// requested duration on approval
+type AccessDecision struct {
+	Comment       string `json:"comment,omitempty"`
+	DurationHours *int   `json:"duration_hours,omitempty"`
+}
@@ -6,11 +6,12 @@ type Admin struct {
 }
 
 type Admin2 struct {
-	ID        int    `json:"id,omitempty"`
-	Name      string `json:"name,omitempty"`
-	Domain    Domain `json:"-"`
-	Password  string `json:"-"`
-	Role      string `json:"-"`
-	Status    string `json:"-"`
-	SessionID string `json:"-"`
+	ID           int    `json:"id,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Domain       Domain `json:"-"`
+	Password     string `json:"-"`
+	Role         string `json:"-"`
+	Status       string `json:"-"`
+	SessionID    string `json:"-"`
+	RefreshToken string `json:"-"`
 }
@@ -6,6 +6,7 @@ type AppReq struct {
 	ServiceName string `json:"service_name,omitempty"`
 	ServiceId int `json:"service_id,omitempty"`
 	AllowedIPs string `json:"allowed_ips,omitempty"`
+	Rules []AppRule `json:"rules,omitempty"`
 }
 
 type App struct {
@@ -14,4 +15,18 @@ type App struct {
 	ServiceName string `json:"service_name,omitempty"`
 	ServiceId int `json:"service_id,omitempty"`
 	AllowedIPs string `json:"allowed_ips,omitempty"`
+	Rules []AppRule `json:"rules,omitempty"`
+	// Effect and limits of the grant, in access listings
+	Effect string `json:"effect,omitempty"`
+	Grant *AccessGrant `json:"grant,omitempty"`
+	Active *bool `json:"active,omitempty"`
+}
+
+// A destination of an app: an address or CIDR, protocol tcp, udp, icmp or
+// any, and for tcp and udp a port or range Port-PortEnd (0 - all ports)
+type AppRule struct {
+	Dest string `json:"dest"`
+	Protocol string `json:"protocol,omitempty"`
+	Port int `json:"port,omitempty"`
+	PortEnd int `json:"port_end,omitempty"`
 }
@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// A device of a user, with its own WireGuard key and virtual IP
+type Device struct {
+	ID         int        `json:"id,omitempty"`
+	UserID     int        `json:"user_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	Platform   string     `json:"platform,omitempty"`
+	WGKey      string     `json:"wg_key,omitempty"`
+	VirtualIP  string     `json:"virtual_ip,omitempty"`
+	PublicIP   string     `json:"public_ip,omitempty"`
+	LocalIP    string     `json:"local_ip,omitempty"`
+	PrivateKey string     `json:"private_key,omitempty"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+	CreateTime *time.Time `json:"create_time,omitempty"`
+}
+
+// Device data as sent by UI or the client app
+type DeviceReq struct {
+	Name      string `json:"name,omitempty"`
+	Platform  string `json:"platform,omitempty"`
+	WGKey     string `json:"wg_key,omitempty"`
+	VirtualIP string `json:"virtual_ip,omitempty"`
+	PublicIP  string `json:"public_ip,omitempty"`
+	LocalIP   string `json:"local_ip,omitempty"`
+	// Generate the WireGuard keypair on the server instead of WGKey
+	GenerateKey bool `json:"generate_key,omitempty"`
+}
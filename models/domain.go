@@ -1,11 +1,21 @@
 package model
 
 type DomainReq struct {
-	Name string `json:"name,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	MFARequired *bool   `json:"mfa_required,omitempty"`
+	IPPool      *string `json:"ip_pool,omitempty"`
+	// Flag WireGuard keys older than this many days, 0 - never
+	KeyRotationDays *int `json:"key_rotation_days,omitempty"`
+	// Timezone of the schedules of access grants, e.g. Europe/Berlin
+	Timezone *string `json:"timezone,omitempty"`
 }
 
 type Domain struct {
 	ID  int `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
 	Status string `json:"-"`
+	MFARequired bool `json:"mfa_required"`
+	IPPool string `json:"ip_pool,omitempty"`
+	KeyRotationDays int `json:"key_rotation_days,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
 }
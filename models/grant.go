@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Limits of an access grant of a user or group, none - permanent
+type AccessGrant struct {
+	ValidFrom  *time.Time     `json:"valid_from,omitempty"`
+	ValidUntil *time.Time     `json:"valid_until,omitempty"`
+	Schedule   []AccessWindow `json:"schedule,omitempty"`
+}
+
+// Access row as sent by UI, allow unless effect is deny, with optional
+// limits
+type AccessReq struct {
+	Effect string `json:"effect,omitempty"`
+	AccessGrant
+}
+
+// A recurring window in the timezone of the domain, e.g. weekdays
+// 08:00-18:00. A window ending before it starts runs past midnight
+type AccessWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
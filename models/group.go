@@ -3,6 +3,9 @@ package model
 type Group struct {
 	ID   int    `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
+	// Membership rule over user attributes of a dynamic group, "" on
+	// update makes the group static again
+	Rule *string `json:"rule,omitempty"`
 }
 
 type Group2 struct {
@@ -10,15 +13,29 @@ type Group2 struct {
 	Name   string `json:"name,omitempty"`
 	Domain Domain `json:"-"`
 	Count  int    `json:"count,omitempty"`
+	Rule   string `json:"rule,omitempty"`
 	Role   string `json:"-"`
 	Status string `json:"-"`
 }
 
+// Sent by the UI to see who a rule matches before saving it
+type GroupRuleReq struct {
+	Rule string `json:"rule"`
+}
+
+type GroupPreview struct {
+	Rule  string   `json:"rule"`
+	Count int      `json:"count"`
+	Users []*User2 `json:"users"`
+}
+
 type GroupAccess struct {
-	ID     int    `json:"id,omitempty"`
-	Group  int    `json:"group,omitempty"`
-	App    int    `json:"allowed,omitempty"`
-	Status string `json:"status,omitempty"`
+	ID     int          `json:"id,omitempty"`
+	Group  int          `json:"group,omitempty"`
+	App    int          `json:"allowed,omitempty"`
+	Status string       `json:"status,omitempty"`
+	Effect string       `json:"effect,omitempty"`
+	Grant  *AccessGrant `json:"grant,omitempty"`
 }
 
 type GroupAccess2 struct {
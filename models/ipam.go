@@ -0,0 +1,22 @@
+package model
+
+import "math/big"
+
+// Virtual IP pool of a domain and the addresses handed out
+type IPPool struct {
+	Pool        string          `json:"pool"`
+	Size        *big.Int        `json:"size"`
+	Used        int             `json:"used"`
+	Free        *big.Int        `json:"free"`
+	Utilization float64         `json:"utilization"`
+	Outside     int             `json:"outside,omitempty"`
+	Addresses   []*IPAssignment `json:"addresses"`
+}
+
+// Virtual IP of a user or service
+type IPAssignment struct {
+	VirtualIP string `json:"virtual_ip"`
+	Kind      string `json:"kind"`
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+}
@@ -0,0 +1,28 @@
+package model
+
+// LDAP directory of a domain. Users marked external login by binding to
+// it, and are kept in sync with it along with their group memberships
+type LDAPConfig struct {
+	DomainID     int    `json:"-"`
+	URL          string `json:"url"`
+	StartTLS     bool   `json:"start_tls"`
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password,omitempty"`
+	BaseDN       string `json:"base_dn"`
+	UserFilter   string `json:"user_filter"`
+	UserAttr     string `json:"user_attr"`
+	GroupBaseDN  string `json:"group_base_dn,omitempty"`
+	GroupFilter  string `json:"group_filter"`
+	GroupAttr    string `json:"group_attr"`
+	MemberAttr   string `json:"member_attr"`
+	Sync         bool   `json:"sync"`
+}
+
+// Result of a sync of a domain with its directory
+type LDAPSyncResult struct {
+	Created        int `json:"created"`
+	Enabled        int `json:"enabled"`
+	Disabled       int `json:"disabled"`
+	MembersAdded   int `json:"members_added"`
+	MembersRemoved int `json:"members_removed"`
+}
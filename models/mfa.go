@@ -0,0 +1,38 @@
+package model
+
+// TOTP state of an admin
+type AdminMFA struct {
+	AdminID  int    `json:"-"`
+	Secret   string `json:"-"`
+	Enabled  bool   `json:"-"`
+	LastStep int64  `json:"-"`
+}
+
+// Pending second login step of an admin
+type MFAChallenge struct {
+	ID       int    `json:"-"`
+	AdminID  int    `json:"-"`
+	DomainID int    `json:"-"`
+	Role     string `json:"-"`
+	Attempts int    `json:"-"`
+	Status   string `json:"-"`
+}
+
+type MFACodeReq struct {
+	Code string `json:"code"`
+}
+
+type MFAStatus struct {
+	Enabled       bool `json:"enabled"`
+	Required      bool `json:"required"`
+	RecoveryCodes int  `json:"recovery_codes_left"`
+}
+
+type MFAEnrollResp struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type MFARecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
@@ -0,0 +1,14 @@
+package model
+
+// Pending OIDC login, between the redirect to the provider and its callback
+type OIDCState struct {
+	Nonce    string `json:"-"`
+	Verifier string `json:"-"`
+	Role     string `json:"-"`
+}
+
+// Account at the identity provider bound to a user or admin, by the sub
+// claim of its ID token
+type OIDCLinkReq struct {
+	Subject string `json:"subject"`
+}
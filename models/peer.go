@@ -0,0 +1,25 @@
+package model
+
+// A user allowed to connect to a service node, or one of its devices
+type Peer struct {
+	ID        int    `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Device    string `json:"device,omitempty"`
+	WGKey     string `json:"wg_key,omitempty"`
+	VirtualIP string `json:"virtual_ip,omitempty"`
+}
+
+// An app of the service and the users allowed to reach it
+type PeerApp struct {
+	ID         int       `json:"id,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	AllowedIPs string    `json:"allowed_ips,omitempty"`
+	Rules      []AppRule `json:"rules,omitempty"`
+	Peers      []*Peer   `json:"peers"`
+}
+
+// Sent to a service node, the peers of all its apps
+type ServicePeers struct {
+	Service string     `json:"service,omitempty"`
+	Apps    []*PeerApp `json:"apps"`
+}
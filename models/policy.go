@@ -1,9 +1,12 @@
 package model
 
 type PolicyApp struct {
+	ID        int          `json:"id,omitempty"`
 	Name      string       `json:"name,omitempty"`
 	AllowedIPs string       `json:"allowed_ips,omitempty"`
+	Rules     []AppRule    `json:"rules,omitempty"`
 	IsUserPolicy bool      `json:"is_user_policy,omitempty"`
+	Group     string       `json:"group,omitempty"`       // Granted through this group
 }
 
 type ServiceNode struct {
@@ -17,4 +20,5 @@ type ServiceNode struct {
 
 type Policy struct {
 	ServiceNodes   map[string]*ServiceNode  `json:"services,omitempty"`
+	Devices        []*Device                `json:"devices,omitempty"`
 }
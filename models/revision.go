@@ -0,0 +1,68 @@
+package model
+
+import "time"
+
+// A numbered revision of the policy of a domain, made by every change to
+// the access rows or to who they reach
+type PolicyRevision struct {
+	Revision   int        `json:"revision"`
+	Change     string     `json:"change,omitempty"`
+	ActorRole  string     `json:"actor_role,omitempty"`
+	ActorID    int        `json:"actor_id,omitempty"`
+	ActorName  string     `json:"actor_name,omitempty"`
+	CreateTime *time.Time `json:"create_time,omitempty"`
+	// Grants in effect when the revision was made
+	Grants   int             `json:"grants"`
+	Snapshot *PolicySnapshot `json:"snapshot,omitempty"`
+}
+
+// Access rows of the domain, and the grants they resolved to
+type PolicySnapshot struct {
+	Users  []*RevisionAccess `json:"users"`
+	Groups []*RevisionAccess `json:"groups"`
+	Grants []*RevisionGrant  `json:"grants"`
+}
+
+// An active user_access_control or group_access_control row. Schedule as
+// saved
+type RevisionAccess struct {
+	OwnerID    int        `json:"owner_id"`
+	Owner      string     `json:"owner"`
+	AppID      int        `json:"app_id"`
+	App        string     `json:"app"`
+	Effect     string     `json:"effect"`
+	ValidFrom  *time.Time `json:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	Schedule   string     `json:"schedule,omitempty"`
+}
+
+// An app of a service a user is allowed
+type RevisionGrant struct {
+	UserID     int       `json:"user_id"`
+	User       string    `json:"user"`
+	Service    string    `json:"service"`
+	AppID      int       `json:"app_id"`
+	App        string    `json:"app"`
+	AllowedIPs string    `json:"allowed_ips,omitempty"`
+	Rules      []AppRule `json:"rules,omitempty"`
+}
+
+// Grants added and removed from revision From to To
+type PolicyDiff struct {
+	From    int               `json:"from"`
+	To      int               `json:"to"`
+	Added   int               `json:"added"`
+	Removed int               `json:"removed"`
+	Users   []*UserPolicyDiff `json:"users"`
+}
+
+type UserPolicyDiff struct {
+	User     string               `json:"user"`
+	Services []*ServicePolicyDiff `json:"services"`
+}
+
+type ServicePolicyDiff struct {
+	Service string           `json:"service"`
+	Added   []*RevisionGrant `json:"added,omitempty"`
+	Removed []*RevisionGrant `json:"removed,omitempty"`
+}
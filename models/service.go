@@ -7,18 +7,23 @@ type Service struct {
 	PublicIP  string `json:"public_ip,omitempty"`
 	VirtualIP string `json:"virtual_ip,omitempty"`
 	LocalIP   string `json:"local_ip,omitempty"`
+	// Generate the WireGuard keypair on the server instead of WGKey
+	GenerateKey bool `json:"generate_key,omitempty"`
+	// Generated private key, sealed with SECRET_KEY
+	SealedKey string `json:"-"`
 }
 
 type Service2 struct {
-	ID        int    `json:"id,omitempty"`
-	Name      string `json:"name,omitempty"`
-	Domain    Domain `json:"-"`
-	Password  string `json:"-"`
-	Icon      int    `json:"-"`
-	Status    string `json:"-"`
-	WGKey     string `json:"wg_key,omitempty"`
-	PublicIP  string `json:"public_ip,omitempty"`
-	VirtualIP string `json:"virtual_ip,omitempty"`
-	LocalIP   string `json:"local_ip,omitempty"`
-	SessionID string `json:"-"`
+	ID           int    `json:"id,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Domain       Domain `json:"-"`
+	Password     string `json:"-"`
+	Icon         int    `json:"-"`
+	Status       string `json:"-"`
+	WGKey        string `json:"wg_key,omitempty"`
+	PublicIP     string `json:"public_ip,omitempty"`
+	VirtualIP    string `json:"virtual_ip,omitempty"`
+	LocalIP      string `json:"local_ip,omitempty"`
+	SessionID    string `json:"-"`
+	RefreshToken string `json:"-"`
 }
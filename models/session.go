@@ -0,0 +1,11 @@
+package model
+
+// Session as read from the sessions table, with the principal it belongs to
+type Session struct {
+	ID     int    `json:"-"`
+	UID    int    `json:"-"`
+	Name   string `json:"-"`
+	Domain Domain `json:"-"`
+	Role   string `json:"-"`
+	Status string `json:"-"`
+}
@@ -0,0 +1,47 @@
+package model
+
+// Can the user reach the destination, as asked by an admin
+type SimulateReq struct {
+	User     string `json:"user,omitempty"`
+	UserID   int    `json:"user_id,omitempty"`
+	Dest     string `json:"dest"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// Answer of a policy simulation. Matches are the grants allowing it, none
+// if denied. Denied are the deny rows in effect for apps covering the
+// destination
+type SimulateResult struct {
+	Allowed  bool             `json:"allowed"`
+	Decision string           `json:"decision"`
+	Reason   string           `json:"reason"`
+	User     string           `json:"user"`
+	Dest     string           `json:"dest"`
+	Port     int              `json:"port,omitempty"`
+	Protocol string           `json:"protocol"`
+	Matches  []*SimulateMatch `json:"matches"`
+	Denied   []*SimulateMatch `json:"denied"`
+}
+
+// A path allowing or denying the traffic: the grant, direct (user) or
+// through a group, its app and the service it is on. AccessID is the access
+// row of a deny
+type SimulateMatch struct {
+	Grant    string  `json:"grant"`
+	AccessID int     `json:"access_id,omitempty"`
+	Group    string  `json:"group,omitempty"`
+	AppID    int     `json:"app_id"`
+	App      string  `json:"app"`
+	Service  string  `json:"service"`
+	Rule     AppRule `json:"rule"`
+}
+
+// An access row denying a user an app, user or group level, the one
+// deciding the user is not allowed it
+type AccessDenial struct {
+	AccessID int    `json:"access_id"`
+	Grant    string `json:"grant"`
+	Group    string `json:"group,omitempty"`
+	App      *App   `json:"app"`
+}
@@ -8,28 +8,51 @@ type User struct {
 	PublicIP  string `json:"public_ip,omitempty"`
 	VirtualIP string `json:"virtual_ip,omitempty"`
 	LocalIP   string `json:"local_ip,omitempty"`
+	External  *bool  `json:"external,omitempty"`
+	// Generate the WireGuard keypair on the server instead of WGKey
+	GenerateKey bool `json:"generate_key,omitempty"`
 }
 
 type User2 struct {
-	ID        int    `json:"id,omitempty"`
-	Name      string `json:"name,omitempty"`
-	Domain    Domain `json:"-"`
-	Group     Group  `json:"-"`
-	Password  string `json:"-"`
-	WGKey     string `json:"wg_key,omitempty"`
-	PublicIP  string `json:"public_ip,omitempty"`
-	VirtualIP string `json:"virtual_ip,omitempty"`
-	LocalIP   string `json:"local_ip,omitempty"`
-	Role      string `json:"-"`
-	Status    string `json:"-"`
-	SessionID string `json:"-"`
+	ID           int    `json:"id,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Domain       Domain `json:"-"`
+	Group        Group  `json:"-"`
+	Password     string `json:"-"`
+	WGKey        string `json:"wg_key,omitempty"`
+	PublicIP     string `json:"public_ip,omitempty"`
+	VirtualIP    string `json:"virtual_ip,omitempty"`
+	LocalIP      string `json:"local_ip,omitempty"`
+	External     bool   `json:"external,omitempty"`
+	PrivateKey   string `json:"private_key,omitempty"`
+	Role         string `json:"-"`
+	Status       string `json:"-"`
+	SessionID    string `json:"-"`
+	RefreshToken string `json:"-"`
+	// Only listed by the rule preview of dynamic groups
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Sent by the client app to change its own password
+type PasswordChangeReq struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Sent by the client app to register its WireGuard key and endpoints
+type WGKeyReq struct {
+	WGKey    string `json:"wg_key"`
+	PublicIP string `json:"public_ip,omitempty"`
+	LocalIP  string `json:"local_ip,omitempty"`
 }
 
 type UserAccess struct {
-	ID     int    `json:"id,omitempty"`
-	User   int    `json:"user,omitempty"`
-	App    int    `json:"allowed,omitempty"`
-	Status string `json:"status,omitempty"`
+	ID     int          `json:"id,omitempty"`
+	User   int          `json:"user,omitempty"`
+	App    int          `json:"allowed,omitempty"`
+	Status string       `json:"status,omitempty"`
+	Effect string       `json:"effect,omitempty"`
+	Grant  *AccessGrant `json:"grant,omitempty"`
 }
 
 type UserAccess2 struct {
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// WireGuard keypair of a service node, for its agent
+type WGKeyPair struct {
+	WGKey      string     `json:"wg_key"`
+	PrivateKey string     `json:"private_key,omitempty"`
+	KeyTime    *time.Time `json:"key_time,omitempty"`
+	RotateDue  bool       `json:"rotate_due"`
+}
+
+// WireGuard keys older than the rotation policy of the domain
+type KeyRotationReport struct {
+	Days int         `json:"key_rotation_days"`
+	Keys []*StaleKey `json:"keys"`
+}
+
+// A key of unknown age is set before keys were timestamped
+type StaleKey struct {
+	Kind    string     `json:"kind"`
+	ID      int        `json:"id"`
+	Name    string     `json:"name"`
+	WGKey   string     `json:"wg_key"`
+	KeyTime *time.Time `json:"key_time,omitempty"`
+	AgeDays int        `json:"age_days,omitempty"`
+}
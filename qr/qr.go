@@ -0,0 +1,524 @@
+// Package qr encodes data as a QR code (ISO/IEC 18004) in byte mode, for
+// scanning configuration into mobile apps
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Error correction level
+type Level int
+
+const (
+	L Level = iota // 7% of the codewords can be restored
+	M              // 15%
+)
+
+// Format information bits of each level
+var levelBits = [...]int{L: 1, M: 0}
+
+// Per version 1 to 40
+var eccPerBlock = [...][41]int{
+	L: {-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	M: {-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+}
+
+var eccBlocks = [...][41]int{
+	L: {-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	M: {-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+}
+
+// Code is the module matrix, true is dark
+type Code struct {
+	Size    int
+	version int
+	level   Level
+	modules [][]bool
+	isFunc  [][]bool
+}
+
+// Dark reports whether the module at column x, row y is dark
+func (c *Code) Dark(x int, y int) bool {
+	return c.modules[y][x]
+}
+
+// Encode data in the smallest version that holds it, with the mask of
+// lowest penalty
+func Encode(data []byte, level Level) (*Code, error) {
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+countBits(version)+8*len(data) <= dataCodewords(version, level)*8 {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, fmt.Errorf("qr: %d bytes too long", len(data))
+	}
+	return encode(data, version, level, -1), nil
+}
+
+// mask -1 chooses the best
+func encode(data []byte, version int, level Level, mask int) *Code {
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	capacity := dataCodewords(version, level) * 8
+	terminator := capacity - len(bb)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			codewords[i>>3] |= 1 << uint(7-i&7)
+		}
+	}
+
+	c := newCode(version, level)
+	c.drawFunctionPatterns()
+	c.drawCodewords(addECCAndInterleave(codewords, version, level))
+
+	if mask < 0 {
+		minPenalty := -1
+		for m := 0; m < 8; m++ {
+			c.applyMask(m)
+			c.drawFormatBits(m)
+			p := c.penalty()
+			if minPenalty < 0 || p < minPenalty {
+				mask = m
+				minPenalty = p
+			}
+			c.applyMask(m) // Undo
+		}
+	}
+	c.applyMask(mask)
+	c.drawFormatBits(mask)
+	return c
+}
+
+// PNG renders the code with scale pixels per module and the 4 module
+// quiet zone around it
+func (c *Code) PNG(scale int) ([]byte, error) {
+	const border = 4
+	side := (c.Size + 2*border) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+border)*scale+dx, (y+border)*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PNG of text, level M
+func PNG(text string, scale int) ([]byte, error) {
+	c, err := Encode([]byte(text), M)
+	if err != nil {
+		return nil, err
+	}
+	return c.PNG(scale)
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(val int, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, (val>>uint(i))&1 != 0)
+	}
+}
+
+// Bits of the byte mode character count
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// Modules left for data and ECC once the function patterns are drawn
+func rawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func dataCodewords(version int, level Level) int {
+	return rawDataModules(version)/8 - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+func newCode(version int, level Level) *Code {
+	size := version*4 + 17
+	c := &Code{Size: size, version: version, level: level}
+	c.modules = make([][]bool, size)
+	c.isFunc = make([][]bool, size)
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.isFunc[i] = make([]bool, size)
+	}
+	return c
+}
+
+func (c *Code) setFunc(x int, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunc[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	// Timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.setFunc(6, i, i%2 == 0)
+		c.setFunc(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	pos := c.alignmentPositions()
+	n := len(pos)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			// Not over the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == n-1) || (i == n-1 && j == 0) {
+				continue
+			}
+			c.drawAlignment(pos[i], pos[j])
+		}
+	}
+
+	// Reserve the format bits, drawn with the mask
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// Finder pattern centered at x, y with its separator
+func (c *Code) drawFinder(x int, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= c.Size || yy < 0 || yy >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunc(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x int, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunc(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (c *Code) alignmentPositions() []int {
+	if c.version == 1 {
+		return nil
+	}
+	numAlign := c.version/7 + 2
+	step := (c.version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, c.Size-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	data := levelBits[c.level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	// Around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFunc(8, i, bit(bits, i))
+	}
+	c.setFunc(8, 7, bit(bits, 6))
+	c.setFunc(8, 8, bit(bits, 7))
+	c.setFunc(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunc(14-i, 8, bit(bits, i))
+	}
+
+	// Next to the other two finders
+	for i := 0; i < 8; i++ {
+		c.setFunc(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunc(8, c.Size-15+i, bit(bits, i))
+	}
+	c.setFunc(8, c.Size-8, true)
+}
+
+func (c *Code) drawVersion() {
+	if c.version < 7 {
+		return
+	}
+	rem := c.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := c.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		a := c.Size - 11 + i%3
+		b := i / 3
+		c.setFunc(a, b, bit(bits, i))
+		c.setFunc(b, a, bit(bits, i))
+	}
+}
+
+// Split data in blocks, add the ECC of each and interleave them
+func addECCAndInterleave(data []byte, version int, level Level) []byte {
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	rawCodewords := rawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	var blocks [][]byte
+	k := 0
+	for i := 0; i < numBlocks; i++ {
+		n := shortBlockLen - eccLen
+		if i >= numShortBlocks {
+			n++
+		}
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < numShortBlocks {
+			// Placeholder, skipped when interleaving
+			block = append(block, 0)
+		}
+		blocks = append(blocks, append(block, ecc...))
+	}
+
+	var result []byte
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-eccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// Place the codewords in the zigzag of 2 module wide columns
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Vertical timing pattern
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					// Upwards
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunc[y][x] && i < len(data)*8 {
+					c.modules[y][x] = bit(int(data[i>>3]), 7-i&7)
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunc[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// Penalty score of the symbol, the four rules of the standard
+func (c *Code) penalty() int {
+	result := 0
+	size := c.Size
+	get := func(x, y int, transpose bool) bool {
+		if transpose {
+			return c.modules[x][y]
+		}
+		return c.modules[y][x]
+	}
+	light := func(x, y int, transpose bool) bool {
+		return x < 0 || x >= size || !get(x, y, transpose)
+	}
+
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && get(x, y, transpose) == get(x-1, y, transpose) {
+					run++
+					continue
+				}
+				// Rule 1, runs of 5 or more of a color
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// Rule 3, 1:1:3:1:1 finder-like pattern with 4 light modules on a side
+			for x := 0; x+7 <= size; x++ {
+				if !(get(x, y, transpose) && !get(x+1, y, transpose) && get(x+2, y, transpose) && get(x+3, y, transpose) &&
+					get(x+4, y, transpose) && !get(x+5, y, transpose) && get(x+6, y, transpose)) {
+					continue
+				}
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					before = before && light(x-k, y, transpose)
+					after = after && light(x+6+k, y, transpose)
+				}
+				if before || after {
+					result += 40
+				}
+			}
+		}
+	}
+
+	// Rule 2, 2x2 blocks of a color
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				v := c.modules[y][x]
+				if v == c.modules[y][x+1] && v == c.modules[y+1][x] && v == c.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	// Rule 4, balance of dark and light
+	total := size * size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+	return result
+}
+
+// Reed-Solomon over GF(2^8/0x11D)
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+func gfMul(x byte, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func bit(x int, i int) bool {
+	return (x>>uint(i))&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
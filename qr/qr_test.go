@@ -0,0 +1,264 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Example of ISO/IEC 18004 annex I, 1-M
+func TestReedSolomon(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder = %v, want %v", got, want)
+	}
+}
+
+// Bits of a string of 0 and 1, most significant first
+func parseBits(s string) int {
+	n := 0
+	for _, ch := range s {
+		n <<= 1
+		if ch == '1' {
+			n |= 1
+		}
+	}
+	return n
+}
+
+func TestFormatBits(t *testing.T) {
+	// Table C.1 of the standard
+	want := map[Level][8]string{
+		L: {"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+			"110011000101111", "110001100011000", "110110001000001", "110100101110110"},
+		M: {"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+			"100010111111001", "100000011001110", "100111110010111", "100101010100000"},
+	}
+	for level, masks := range want {
+		for mask, bits := range masks {
+			c := newCode(1, level)
+			c.drawFormatBits(mask)
+			if first, second := readFormatBits(c); first != parseBits(bits) || second != first {
+				t.Errorf("level %d mask %d: format bits %015b and %015b, want %s", level, mask, first, second, bits)
+			}
+		}
+	}
+}
+
+// Both copies of the format bits
+func readFormatBits(c *Code) (int, int) {
+	first, second := 0, 0
+	for i := 0; i < 15; i++ {
+		var x, y int
+		switch {
+		case i <= 5:
+			x, y = 8, i
+		case i == 6:
+			x, y = 8, 7
+		case i == 7:
+			x, y = 8, 8
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		if c.Dark(x, y) {
+			first |= 1 << uint(i)
+		}
+		x, y = 8, c.Size-15+i
+		if i < 8 {
+			x, y = c.Size-1-i, 8
+		}
+		if c.Dark(x, y) {
+			second |= 1 << uint(i)
+		}
+	}
+	return first, second
+}
+
+func TestVersionBits(t *testing.T) {
+	// Table D.1 of the standard
+	tests := []struct {
+		version int
+		bits    string
+	}{
+		{7, "000111110010010100"},
+		{8, "001000010110111100"},
+		{21, "010101011010000011"},
+		{40, "101000110001101001"},
+	}
+	for _, tt := range tests {
+		c := newCode(tt.version, M)
+		c.drawVersion()
+		below, right := 0, 0
+		for i := 0; i < 18; i++ {
+			if c.Dark(i/3, c.Size-11+i%3) {
+				below |= 1 << uint(i)
+			}
+			if c.Dark(c.Size-11+i%3, i/3) {
+				right |= 1 << uint(i)
+			}
+		}
+		if want := parseBits(tt.bits); below != want || right != want {
+			t.Errorf("version %d: bits %018b and %018b, want %s", tt.version, below, right, tt.bits)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	// Byte mode capacities of table 7 of the standard
+	tests := []struct {
+		n       int
+		level   Level
+		version int
+	}{
+		{1, M, 1},
+		{14, M, 1},
+		{15, M, 2},
+		{26, M, 2},
+		{27, M, 3},
+		{17, L, 1},
+		{18, L, 2},
+		{213, M, 10},
+		{214, M, 11},
+		{2331, M, 40},
+		{2953, L, 40},
+	}
+	for _, tt := range tests {
+		c, err := Encode(bytes.Repeat([]byte{'a'}, tt.n), tt.level)
+		if err != nil {
+			t.Errorf("Encode %d bytes level %d: %v", tt.n, tt.level, err)
+			continue
+		}
+		if c.version != tt.version || c.Size != 17+4*tt.version {
+			t.Errorf("Encode %d bytes level %d: version %d size %d, want version %d", tt.n, tt.level, c.version, c.Size, tt.version)
+		}
+	}
+
+	if _, err := Encode(make([]byte, 2332), M); err == nil {
+		t.Errorf("Encode 2332 bytes level M: want error")
+	}
+	if _, err := Encode(make([]byte, 2954), L); err == nil {
+		t.Errorf("Encode 2954 bytes level L: want error")
+	}
+}
+
+// Codewords in placement order, after removing the mask the format bits name
+func readCodewords(c *Code) []byte {
+	format, _ := readFormatBits(c)
+	mask := ((format ^ 0x5412) >> 10) & 7
+	c.applyMask(mask)
+	defer c.applyMask(mask)
+
+	var result []byte
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.isFunc[y][x] {
+					continue
+				}
+				if i%8 == 0 {
+					result = append(result, 0)
+				}
+				if c.Dark(x, y) {
+					result[i/8] |= 1 << uint(7-i%8)
+				}
+				i++
+			}
+		}
+	}
+	return result[:rawDataModules(c.version)/8]
+}
+
+func TestEncode(t *testing.T) {
+	// Mode 0100, count 5, "hello", terminator, padding
+	data := []byte{0x40, 0x56, 0x86, 0x56, 0xC6, 0xC6, 0xF0, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC}
+	want := append(append([]byte{}, data...), rsRemainder(data, rsDivisor(10))...)
+
+	for mask := 0; mask < 8; mask++ {
+		c := encode([]byte("hello"), 1, M, mask)
+		if got := readCodewords(c); !bytes.Equal(got, want) {
+			t.Errorf("mask %d: codewords % x, want % x", mask, got, want)
+		}
+	}
+
+	c, err := Encode([]byte("hello"), M)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if got := readCodewords(c); !bytes.Equal(got, want) {
+		t.Errorf("Encode: codewords % x, want % x", got, want)
+	}
+}
+
+// 8-M has 2 blocks of 38 data codewords and 2 of 39, each with 22 ECC
+// codewords, interleaved with the short blocks skipped past their end
+func TestInterleave(t *testing.T) {
+	data := make([]byte, dataCodewords(8, M))
+	for i := range data {
+		data[i] = byte(i)
+	}
+	got := addECCAndInterleave(data, 8, M)
+	if len(got) != rawDataModules(8)/8 {
+		t.Fatalf("%d codewords, want %d", len(got), rawDataModules(8)/8)
+	}
+
+	blocks := [][]byte{data[0:38], data[38:76], data[76:115], data[115:154]}
+	var want []byte
+	for i := 0; i < 39; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				want = append(want, block[i])
+			}
+		}
+	}
+	var eccs [][]byte
+	for _, block := range blocks {
+		eccs = append(eccs, rsRemainder(block, rsDivisor(22)))
+	}
+	for i := 0; i < 22; i++ {
+		for _, ecc := range eccs {
+			want = append(want, ecc[i])
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("addECCAndInterleave = % x, want % x", got, want)
+	}
+}
+
+func TestFinders(t *testing.T) {
+	c, err := Encode([]byte(strings.Repeat("[Interface]\n", 10)), M)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	pattern := []string{
+		"#######.",
+		"#.....#.",
+		"#.###.#.",
+		"#.###.#.",
+		"#.###.#.",
+		"#.....#.",
+		"#######.",
+		"........",
+	}
+	for y, row := range pattern {
+		for x, ch := range row {
+			dark := ch == '#'
+			if c.Dark(x, y) != dark || c.Dark(c.Size-1-x, y) != dark || c.Dark(x, c.Size-1-y) != dark {
+				t.Fatalf("finder module %d,%d not %c", x, y, ch)
+			}
+		}
+	}
+	if !c.Dark(8, c.Size-8) {
+		t.Errorf("dark module missing")
+	}
+}
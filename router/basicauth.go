@@ -1,24 +1,54 @@
 package router
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/saroopmathur/rest-api/auth"
+	"github.com/saroopmathur/rest-api/config"
 	"github.com/saroopmathur/rest-api/db"
 	h "github.com/saroopmathur/rest-api/handlers"
 	m "github.com/saroopmathur/rest-api/models"
 )
 
 func setReqHeaders(r *http.Request, role string, name string, domainName string, id int, domainId int, sessionId string) {
-	r.Header.Add("Xpress-User", name)
-	r.Header.Add("Xpress-UserId", fmt.Sprintf("%d", id))
-	r.Header.Add("Xpress-Domain", domainName)
-	r.Header.Add("Xpress-DomainId", fmt.Sprintf("%d", domainId))
-	r.Header.Add("Xpress-SessionId", sessionId)
-	r.Header.Add("Xpress-Role", role)
-	r.Header.Add("Xpress-IconId", "23") // TODO - get actual value from database
+	// Set, not Add - never trust Xpress headers sent by the client
+	r.Header.Set("Xpress-User", name)
+	r.Header.Set("Xpress-UserId", fmt.Sprintf("%d", id))
+	r.Header.Set("Xpress-Domain", domainName)
+	r.Header.Set("Xpress-DomainId", fmt.Sprintf("%d", domainId))
+	r.Header.Set("Xpress-SessionId", sessionId)
+	r.Header.Set("Xpress-Role", role)
+	r.Header.Set("Xpress-IconId", "23") // TODO - get actual value from database
+}
+
+// Header carrying the client address when behind a reverse proxy, e.g.
+// X-Real-IP. Only set it if the proxy overwrites what the client sends
+var CLIENT_IP_HEADER = ""
+
+func init() {
+	config.String("CLIENT_IP_HEADER", &CLIENT_IP_HEADER)
+}
+
+// Source address of the request, for counting failed logins
+func clientIP(r *http.Request) string {
+	if CLIENT_IP_HEADER != "" {
+		if ip := strings.TrimSpace(strings.Split(r.Header.Get(CLIENT_IP_HEADER), ",")[0]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 func adminLoginMiddleware(r *http.Request) error {
@@ -28,29 +58,218 @@ func adminLoginMiddleware(r *http.Request) error {
 	var a *m.Admin2
 	var err error
 
+	ip := clientIP(r)
 	name, pass, ok := r.BasicAuth()
-	if ok {
-		a = db.GetAdminByName(name)
-		if a == nil || !h.AdminCheckPassword(a, pass) {
-			err = fmt.Errorf("invalid admin username or password")
+	if !passwordLoginAllowed() {
+		err = errPasswordLogin
+	} else if ok {
+		err = db.CheckLoginAllowed(db.ROLE_ADMIN, name, ip)
+		if err == nil {
+			a = db.GetAdminByName(name)
+			if a == nil || !h.AdminCheckPassword(a, pass) {
+				err = fmt.Errorf("invalid admin username or password")
+				db.LoginFailed(db.ROLE_ADMIN, name, ip)
+			}
 		}
 	} else {
 		err = fmt.Errorf("please enter your username and password")
 	}
 
 	if err != nil {
-		fmt.Printf("Admin Basic Authentication Failed: %s %s - %v\n", name, pass, err)
+		fmt.Printf("Admin Basic Authentication Failed: %s - %v\n", name, err)
 		return err
 	}
+	return adminLoginStep(r, a, name, "Admin")
+}
+
+// Calls of the admin and identity provider login steps, replaced in tests
+var (
+	oidcAuthenticate   = h.OIDCAuthenticate
+	oidcPrincipal      = db.OIDCPrincipal
+	checkLoginAllowed  = db.CheckLoginAllowed
+	getAdminByName     = db.GetAdminByName
+	getUserByName      = db.GetUserByName
+	provisionAdmin     = db.ProvisionAdmin
+	provisionUser      = db.ProvisionUser
+	adminMFARequired   = h.AdminMFARequired
+	createMFAChallenge = db.CreateMFAChallenge
+	loginSucceeded     = db.LoginSucceeded
+	generateAdminToken = db.GenerateAndSaveAdminToken
+	generateUserToken  = db.GenerateAndSaveUserToken
+)
+
+// Admin checked by password or the identity provider. A session is issued
+// unless MFA is required, then the challenge for the TOTP code of the
+// second step
+func adminLoginStep(r *http.Request, a *m.Admin2, name string, via string) error {
+	required, enrolled, pending := adminMFARequired(a)
+	if required && !enrolled && !pending {
+		// Domain requires MFA. Never enrolled here, the password alone
+		// must not be enough to register an authenticator
+		log.Printf("%s OK, MFA Not Enrolled: Role=%s %s@%s [%d %d]\n", via, a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID)
+		return fmt.Errorf("MFA is required in domain %s. Ask an administrator to issue your authenticator secret", a.Domain.Name)
+	}
 
 	if a.Domain.ID == db.POWERDOMAIN {
 		a.Role = db.ROLE_POWERADMIN
 		db.SetAnyDomain(&a.Domain)
 	}
 
+	if required {
+		// Second step - POST the code along with the challenge
+		challenge, err := createMFAChallenge(a.ID, a.Domain.ID, a.Role)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s OK, MFA Required: Role=%s %s@%s [%d %d]\n", via, a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID)
+		r.Header.Set("Xpress-MFAChallenge", challenge)
+		return nil
+	}
+
+	loginSucceeded(db.ROLE_ADMIN, name)
+	generateAdminToken(a)
+	log.Printf("%s Login Successful: Role=%s %s@%s [%d %d]\n", via, a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID)
+	setReqHeaders(r, a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID, a.SessionID)
+	r.Header.Set("Xpress-RefreshToken", a.RefreshToken)
+	return nil
+}
+
+// Second step of the admin login: "Authorization: Bearer <challenge>" and
+// {"code": "<TOTP or recovery code>"}
+func adminMFAMiddleware(r *http.Request) error {
+	log.Printf("============== Login as Admin - MFA ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	challenge := GetToken(r.Header.Get("Authorization"))
+	if challenge == "" {
+		err := fmt.Errorf("Unauthorized")
+		return err
+	}
+
+	var req m.MFACodeReq
+	err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req)
+	if err != nil || req.Code == "" {
+		return fmt.Errorf("please enter the code")
+	}
+
+	c, err := db.GetMFAChallenge(challenge)
+	if err != nil {
+		return err
+	}
+	a := db.GetAdminByID(c.AdminID)
+	if a == nil {
+		return db.ErrChallengeInvalid
+	}
+
+	ip := clientIP(r)
+	name := a.Name + "@" + a.Domain.Name
+	err = db.CheckLoginAllowed(db.ROLE_ADMIN, name, ip)
+	if err != nil {
+		return err
+	}
+	ok, codes := h.AdminCheckOTP(c.AdminID, req.Code)
+	if !ok {
+		db.FailMFAChallenge(c.ID)
+		db.LoginFailed(db.ROLE_ADMIN, name, ip)
+		fmt.Printf("Admin MFA Failed: %s [%d]\n", name, c.AdminID)
+		return fmt.Errorf("invalid code")
+	}
+	err = db.CompleteMFAChallenge(c.ID)
+	if err != nil {
+		return err
+	}
+	db.LoginSucceeded(db.ROLE_ADMIN, name)
+
+	// Role and domain as decided by the first step
+	a.Role = c.Role
+	if a.Domain.ID != c.DomainID {
+		if d := db.SelectDomain(c.DomainID, ""); d != nil {
+			a.Domain = *d
+		}
+	}
+
 	db.GenerateAndSaveAdminToken(a)
-	log.Printf("Admin Login Successful: Role=%s %s@%s [%d %d] %s\n", a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID, a.SessionID)
+	log.Printf("Admin Login Successful: Role=%s %s@%s [%d %d]\n", a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID)
 	setReqHeaders(r, a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID, a.SessionID)
+	r.Header.Set("Xpress-RefreshToken", a.RefreshToken)
+	if len(codes) > 0 {
+		r.Header.Set("Xpress-RecoveryCodes", strings.Join(codes, ","))
+	}
+	return nil
+}
+
+var errPasswordLogin = errors.New("password login disabled - login with the identity provider")
+var errInvalidLogin = errors.New("invalid username or password")
+
+// Users and admins login with the identity provider only, when configured
+// so. Services always use their password
+func passwordLoginAllowed() bool {
+	return auth.PASSWORD_LOGIN || !auth.OIDCEnabled()
+}
+
+// Callback from the identity provider, with the authorization code. Logs
+// into the user or admin bound to the issuer and subject of the ID token,
+// never one found by the name the provider sends. Without a binding, the
+// account is created if OIDC_AUTO_CREATE is set. Admins then go through MFA
+// as on password login
+func oidcCallbackMiddleware(r *http.Request) error {
+	log.Printf("============== Login with Identity Provider ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.URL.Path)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return fmt.Errorf("identity provider: %s %s", e, q.Get("error_description"))
+	}
+
+	id, role, err := oidcAuthenticate(q.Get("code"), q.Get("state"))
+	if err != nil {
+		log.Printf("OIDC Login Failed: %v\n", err)
+		return err
+	}
+
+	name, err := oidcPrincipal(role, id.Issuer, id.Subject, id.Domain)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		err = checkLoginAllowed(role, name, clientIP(r))
+		if err != nil {
+			return err
+		}
+	} else if !auth.OIDC_AUTO_CREATE {
+		log.Printf("OIDC Login Failed: %s %s not bound in domain %s\n", id.Issuer, id.Subject, id.Domain)
+		return fmt.Errorf("login not bound to an account in domain %s", id.Domain)
+	}
+
+	if role == db.ROLE_ADMIN {
+		var a *m.Admin2
+		if name != "" {
+			a = getAdminByName(name)
+		} else {
+			a, err = provisionAdmin(id.Issuer, id.Subject, id.Name, id.Domain)
+		}
+		if a == nil {
+			log.Printf("OIDC Login Failed: admin %s %s %v\n", id.Issuer, id.Subject, err)
+			return fmt.Errorf("no admin for this login in domain %s", id.Domain)
+		}
+		return adminLoginStep(r, a, a.Name+"@"+a.Domain.Name, "OIDC Admin")
+	}
+
+	var u *m.User2
+	if name != "" {
+		u = getUserByName(name)
+	} else {
+		u, err = provisionUser(id.Issuer, id.Subject, id.Name, id.Domain)
+	}
+	if u == nil {
+		log.Printf("OIDC Login Failed: user %s %s %v\n", id.Issuer, id.Subject, err)
+		return fmt.Errorf("no user for this login in domain %s", id.Domain)
+	}
+	loginSucceeded(db.ROLE_USER, u.Name+"@"+u.Domain.Name)
+	generateUserToken(u)
+	log.Printf("OIDC User Login Successful: %s@%s [%d %d]\n", u.Name, u.Domain.Name, u.ID, u.Domain.ID)
+	setReqHeaders(r, db.ROLE_USER, u.Name, u.Domain.Name, u.ID, u.Domain.ID, u.SessionID)
+	r.Header.Set("Xpress-RefreshToken", u.RefreshToken)
 	return nil
 }
 
@@ -62,12 +281,17 @@ func serviceLoginMiddleware(r *http.Request) error {
 	var s *m.Service2
 	var err error
 	// Login
+	ip := clientIP(r)
 	name, pass, ok := r.BasicAuth()
 	if ok {
-		log.Printf("Got Service Login Request: %s %s\n", name, pass)
-		s = db.GetServiceByName(name)
-		if s == nil || !h.ServiceCheckPassword(s, pass) {
-			err = fmt.Errorf("invalid name or password")
+		log.Printf("Got Service Login Request: %s\n", name)
+		err = db.CheckLoginAllowed(db.ROLE_SERVICE, name, ip)
+		if err == nil {
+			s = db.GetServiceByName(name)
+			if s == nil || !h.ServiceCheckPassword(s, pass) {
+				err = fmt.Errorf("invalid name or password")
+				db.LoginFailed(db.ROLE_SERVICE, name, ip)
+			}
 		}
 	} else {
 		err = fmt.Errorf("please enter your username and password")
@@ -75,9 +299,11 @@ func serviceLoginMiddleware(r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	db.LoginSucceeded(db.ROLE_SERVICE, name)
 	db.GenerateAndSaveServiceToken(s)
-	log.Printf("Service Login Successful: %s@%s [%d %d] %s\n", s.Name, s.Domain.Name, s.ID, s.Domain.ID, s.SessionID)
+	log.Printf("Service Login Successful: %s@%s [%d %d]\n", s.Name, s.Domain.Name, s.ID, s.Domain.ID)
 	setReqHeaders(r, db.ROLE_SERVICE, s.Name, s.Domain.Name, s.ID, s.Domain.ID, s.SessionID)
+	r.Header.Set("Xpress-RefreshToken", s.RefreshToken)
 	return nil
 }
 
@@ -89,12 +315,31 @@ func userLoginMiddleware(r *http.Request) error {
 	var u *m.User2
 	var err error
 
+	ip := clientIP(r)
 	user, pass, ok := r.BasicAuth()
-	if ok {
-		log.Printf("Got User Login Request: %s %s\n", user, pass)
-		u = db.GetUserByName(user)
-		if u == nil || !h.UserCheckPassword(u, pass) {
-			err = fmt.Errorf("invalid username or password")
+	if !passwordLoginAllowed() {
+		err = errPasswordLogin
+	} else if ok {
+		log.Printf("Got User Login Request: %s\n", user)
+		err = db.CheckLoginAllowed(db.ROLE_USER, user, ip)
+		if err == nil {
+			u = db.GetUserByName(user)
+			if u == nil {
+				err = errInvalidLogin
+			} else if u.External {
+				err = h.UserCheckLDAP(u, pass)
+				if err == auth.ErrLDAPInvalidCredentials {
+					err = errInvalidLogin
+				} else if err != nil {
+					// Directory down, not a failed attempt
+					return fmt.Errorf("directory unavailable. Try again later")
+				}
+			} else if !h.UserCheckPassword(u, pass) {
+				err = errInvalidLogin
+			}
+			if err != nil {
+				db.LoginFailed(db.ROLE_USER, user, ip)
+			}
 		}
 	} else {
 		err = fmt.Errorf("please enter your username and password")
@@ -102,9 +347,38 @@ func userLoginMiddleware(r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	db.LoginSucceeded(db.ROLE_USER, user)
 	db.GenerateAndSaveUserToken(u)
-	log.Printf("User Login Successful: %s@%s [%d %d] %s\n", u.Name, u.Domain.Name, u.ID, u.Domain.ID, u.SessionID)
+	log.Printf("User Login Successful: %s@%s [%d %d]\n", u.Name, u.Domain.Name, u.ID, u.Domain.ID)
 	setReqHeaders(r, db.ROLE_USER, u.Name, u.Domain.Name, u.ID, u.Domain.ID, u.SessionID)
+	r.Header.Set("Xpress-RefreshToken", u.RefreshToken)
+	return nil
+}
+
+// Exchange the refresh token sent as "Authorization: Bearer <refresh token>"
+// for a new session, then login with the new session token
+func refreshLoginMiddleware(r *http.Request) error {
+	log.Printf("============== Refresh Session ===============\n")
+	log.Printf("%s http://%s%s", r.Method, r.Host, r.RequestURI)
+
+	refresh := GetToken(r.Header.Get("Authorization"))
+	if refresh == "" {
+		err := fmt.Errorf("Unauthorized")
+		return err
+	}
+
+	token, newRefresh, err := db.RefreshSession(refresh)
+	if err != nil {
+		log.Printf("Refresh Session Failed: %v\n", err)
+		return err
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	err = tokenLoginMiddleware(r)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Xpress-RefreshToken", newRefresh)
 	return nil
 }
 
@@ -117,36 +391,41 @@ func tokenLoginMiddleware(r *http.Request) error {
 	}
 	//log.Printf("Login Authorization Token: %s\n", token)
 
-	// First check if this is a user token
-	u := db.GetUserByToken(token)
-	if u != nil {
-		// User logged in successfully
-		log.Printf("Token User Login Successful: %s@%s [%d %d] %s\n", u.Name, u.Domain.Name, u.ID, u.Domain.ID, u.SessionID)
-		setReqHeaders(r, db.ROLE_USER, u.Name, u.Domain.Name, u.ID, u.Domain.ID, token)
-		return nil
+	if auth.JWTEnabled() && auth.IsJWT(token) {
+		return jwtLoginMiddleware(r, token)
 	}
 
-	// Next check if this is a service token
-	s := db.GetServiceByToken(token)
-	if s != nil {
-		// Service logged in successfully
-		log.Printf("Token Service Login Successful: %s@%s [%d %d] %s\n", s.Name, s.Domain.Name, s.ID, s.Domain.ID, s.SessionID)
-		setReqHeaders(r, db.ROLE_SERVICE, s.Name, s.Domain.Name, s.ID, s.Domain.ID, token)
-		return nil
+	// Single lookup of the session, its role and principal
+	sess, err := db.LookupSession(token)
+	if err != nil {
+		log.Printf("Token Login Failed: %v\n", err)
+		return err
 	}
 
-	// Next check if this is an admin token
-	a := db.GetAdminByToken(token)
-	if a != nil {
-		// Admin logged in successfully
-		//log.Printf("Token Admin Login Successful: Role=%s %s@%s [%d %d] %s\n",
-		//	a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID, a.SessionID)
-		setReqHeaders(r, a.Role, a.Name, a.Domain.Name, a.ID, a.Domain.ID, token)
-		return nil
+	//log.Printf("Token Login Successful: Role=%s %s@%s [%d %d]\n",
+	//	sess.Role, sess.Name, sess.Domain.Name, sess.UID, sess.Domain.ID)
+	setReqHeaders(r, sess.Role, sess.Name, sess.Domain.Name, sess.UID, sess.Domain.ID, token)
+	return nil
+}
+
+// Verify the JWT in-process, the database is only used for the revocation list
+func jwtLoginMiddleware(r *http.Request, token string) error {
+	claims, err := auth.VerifyJWT(token)
+	if err == auth.ErrTokenExpired {
+		log.Printf("JWT Login Failed: %v\n", err)
+		return db.ErrSessionExpired
 	}
-	err := fmt.Errorf("session Expired. Login Again")
-	log.Printf("Token Login Failed: %s %s\n", token, err.Error())
-	return err
+	if err != nil {
+		log.Printf("JWT Login Failed: %v\n", err)
+		return db.ErrSessionInvalid
+	}
+	if db.IsSessionRevoked(claims.SessionID) {
+		log.Printf("JWT Login Failed: session revoked\n")
+		return db.ErrSessionInvalid
+	}
+
+	setReqHeaders(r, claims.Role, claims.Name, claims.DomainName, claims.UserID, claims.DomainID, token)
+	return nil
 }
 
 var APIBase string = "/api/v1"
@@ -154,13 +433,29 @@ var APIBase string = "/api/v1"
 func BasicAuth(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var err error
+
+		// Xpress headers are only set here, drop any sent by the client
+		for name := range r.Header {
+			if strings.HasPrefix(name, "Xpress-") {
+				r.Header.Del(name)
+			}
+		}
+
 		url := r.URL.String()
 		if url == APIBase+"/login" {
 			err = userLoginMiddleware(r)
 		} else if url == APIBase+"/servicelogin" {
 			err = serviceLoginMiddleware(r)
+		} else if url == APIBase+"/adminlogin" && r.Method == http.MethodPost {
+			err = adminMFAMiddleware(r)
 		} else if url == APIBase+"/adminlogin" {
 			err = adminLoginMiddleware(r)
+		} else if url == APIBase+"/refresh" {
+			err = refreshLoginMiddleware(r)
+		} else if r.URL.Path == APIBase+"/oidc/login" {
+			// Redirect to the identity provider, nothing to check yet
+		} else if r.URL.Path == APIBase+"/oidc/callback" {
+			err = oidcCallbackMiddleware(r)
 		} else {
 			err = tokenLoginMiddleware(r)
 			if err == nil {
@@ -182,7 +477,26 @@ func BasicAuth(handler http.Handler) http.Handler {
 			}
 		}
 
-		if err != nil {
+		var locked *db.LoginLockedError
+		if errors.As(err, &locked) {
+			// Too many failed logins, tell the client when to come back
+			if locked.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds()+0.5)))
+			}
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			resp := h.Response{Code: "rest_login_locked", Message: err.Error()}
+			resp.Data.Status = http.StatusTooManyRequests
+			json.NewEncoder(w).Encode(resp)
+		} else if errors.Is(err, db.ErrSessionExpired) {
+			// Distinct from other failures, the client should use its refresh token
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="session expired"`)
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusUnauthorized)
+			resp := h.Response{Code: "rest_session_expired", Message: err.Error()}
+			resp.Data.Status = http.StatusUnauthorized
+			json.NewEncoder(w).Encode(resp)
+		} else if err != nil {
 			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", err.Error()))
 			w.WriteHeader(401)
 			w.Write([]byte("Unauthorized.\n"))
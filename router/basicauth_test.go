@@ -0,0 +1,185 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saroopmathur/rest-api/auth"
+	"github.com/saroopmathur/rest-api/db"
+	m "github.com/saroopmathur/rest-api/models"
+)
+
+// Accounts and MFA state behind the login steps
+type fakeLogin struct {
+	id       *auth.OIDCIdentity
+	role     string
+	bound    map[string]string // subject to name@domain
+	admins   map[string]*m.Admin2
+	users    map[string]*m.User2
+	required bool
+	enrolled bool
+
+	lookedUp    []string
+	provisioned []string
+	challenges  int
+	sessions    int
+}
+
+func newFakeLogin(t *testing.T, role string, id *auth.OIDCIdentity) *fakeLogin {
+	f := &fakeLogin{id: id, role: role, bound: map[string]string{}, admins: map[string]*m.Admin2{}, users: map[string]*m.User2{}}
+
+	authenticate, principal, allowed, admin, user := oidcAuthenticate, oidcPrincipal, checkLoginAllowed, getAdminByName, getUserByName
+	newAdmin, newUser, required, challenge := provisionAdmin, provisionUser, adminMFARequired, createMFAChallenge
+	succeeded, adminToken, userToken, autoCreate := loginSucceeded, generateAdminToken, generateUserToken, auth.OIDC_AUTO_CREATE
+	t.Cleanup(func() {
+		oidcAuthenticate, oidcPrincipal, checkLoginAllowed, getAdminByName, getUserByName = authenticate, principal, allowed, admin, user
+		provisionAdmin, provisionUser, adminMFARequired, createMFAChallenge = newAdmin, newUser, required, challenge
+		loginSucceeded, generateAdminToken, generateUserToken, auth.OIDC_AUTO_CREATE = succeeded, adminToken, userToken, autoCreate
+	})
+
+	oidcAuthenticate = func(code string, state string) (*auth.OIDCIdentity, string, error) {
+		return f.id, f.role, nil
+	}
+	oidcPrincipal = func(role string, issuer string, subject string, domainName string) (string, error) {
+		if issuer != f.id.Issuer || role != f.role {
+			t.Errorf("oidcPrincipal(%s, %s)", role, issuer)
+		}
+		return f.bound[subject], nil
+	}
+	checkLoginAllowed = func(role string, name string, ip string) error { return nil }
+	getAdminByName = func(name string) *m.Admin2 {
+		f.lookedUp = append(f.lookedUp, name)
+		return f.admins[name]
+	}
+	getUserByName = func(name string) *m.User2 {
+		f.lookedUp = append(f.lookedUp, name)
+		return f.users[name]
+	}
+	// As the database: a name taken in the domain is never provisioned
+	provisionAdmin = func(issuer string, subject string, name string, domainName string) (*m.Admin2, error) {
+		f.provisioned = append(f.provisioned, name+"@"+domainName)
+		if f.admins[name+"@"+domainName] != nil {
+			return nil, errors.New("exists")
+		}
+		return &m.Admin2{ID: 99, Name: name, Domain: m.Domain{ID: 2, Name: domainName}, Role: db.ROLE_ADMIN}, nil
+	}
+	provisionUser = func(issuer string, subject string, name string, domainName string) (*m.User2, error) {
+		f.provisioned = append(f.provisioned, name+"@"+domainName)
+		if f.users[name+"@"+domainName] != nil {
+			return nil, errors.New("exists")
+		}
+		return &m.User2{ID: 99, Name: name, Domain: m.Domain{ID: 2, Name: domainName}}, nil
+	}
+	adminMFARequired = func(a *m.Admin2) (bool, bool, bool) { return f.required, f.enrolled, false }
+	createMFAChallenge = func(adminId int, domainId int, role string) (string, error) {
+		f.challenges++
+		return "challenge", nil
+	}
+	loginSucceeded = func(role string, name string) {}
+	generateAdminToken = func(a *m.Admin2) {
+		f.sessions++
+		a.SessionID = "session"
+	}
+	generateUserToken = func(u *m.User2) {
+		f.sessions++
+		u.SessionID = "session"
+	}
+	auth.OIDC_AUTO_CREATE = false
+	return f
+}
+
+func (f *fakeLogin) callback() (*http.Request, error) {
+	r := httptest.NewRequest("GET", "/api/v1/oidc/callback?code=code&state=state", nil)
+	return r, oidcCallbackMiddleware(r)
+}
+
+func oidcIdentity(subject string, name string) *auth.OIDCIdentity {
+	return &auth.OIDCIdentity{Issuer: "https://idp.example.com", Subject: subject, Name: name, Domain: "acme", Admin: true, User: true}
+}
+
+func TestOIDCAdminMFA(t *testing.T) {
+	f := newFakeLogin(t, db.ROLE_ADMIN, oidcIdentity("sub-1", "alice"))
+	f.bound["sub-1"] = "alice@acme"
+	f.admins["alice@acme"] = &m.Admin2{ID: 7, Name: "alice", Domain: m.Domain{ID: 2, Name: "acme"}, Role: db.ROLE_ADMIN}
+	f.required, f.enrolled = true, true
+
+	r, err := f.callback()
+	if err != nil {
+		t.Fatalf("oidcCallbackMiddleware: %v", err)
+	}
+	if r.Header.Get("Xpress-MFAChallenge") != "challenge" || f.challenges != 1 {
+		t.Errorf("no MFA challenge issued")
+	}
+	if f.sessions != 0 || r.Header.Get("Xpress-SessionId") != "" || r.Header.Get("Xpress-User") != "" {
+		t.Errorf("session issued before the second step")
+	}
+
+	// Without MFA the session is issued right away
+	f.required, f.enrolled = false, false
+	r, err = f.callback()
+	if err != nil || r.Header.Get("Xpress-MFAChallenge") != "" || r.Header.Get("Xpress-SessionId") != "session" || r.Header.Get("Xpress-User") != "alice" {
+		t.Errorf("login without MFA: %v %v", err, r.Header)
+	}
+
+	// Not enrolled where MFA is required, no secret is handed out
+	f.required, f.sessions, f.challenges = true, 0, 0
+	r, err = f.callback()
+	if err == nil || f.sessions != 0 || f.challenges != 0 || r.Header.Get("Xpress-MFASecret") != "" {
+		t.Errorf("login not enrolled: %v %v", err, r.Header)
+	}
+}
+
+func TestOIDCBoundAccount(t *testing.T) {
+	for _, role := range []string{db.ROLE_ADMIN, db.ROLE_USER} {
+		// The provider says root, the subject is bound to bob
+		f := newFakeLogin(t, role, oidcIdentity("sub-2", "root"))
+		f.bound["sub-2"] = "bob@acme"
+		f.admins["bob@acme"] = &m.Admin2{ID: 8, Name: "bob", Domain: m.Domain{ID: 2, Name: "acme"}, Role: db.ROLE_ADMIN}
+		f.admins["root@acme"] = &m.Admin2{ID: 1, Name: "root", Domain: m.Domain{ID: 2, Name: "acme"}, Role: db.ROLE_ADMIN}
+		f.users["bob@acme"] = &m.User2{ID: 8, Name: "bob", Domain: m.Domain{ID: 2, Name: "acme"}}
+		f.users["root@acme"] = &m.User2{ID: 1, Name: "root", Domain: m.Domain{ID: 2, Name: "acme"}}
+
+		r, err := f.callback()
+		if err != nil || r.Header.Get("Xpress-User") != "bob" {
+			t.Errorf("%s: logged in as %q, %v", role, r.Header.Get("Xpress-User"), err)
+		}
+		if len(f.lookedUp) != 1 || f.lookedUp[0] != "bob@acme" {
+			t.Errorf("%s: looked up %v", role, f.lookedUp)
+		}
+	}
+}
+
+func TestOIDCNameCollision(t *testing.T) {
+	for _, role := range []string{db.ROLE_ADMIN, db.ROLE_USER} {
+		for _, autoCreate := range []bool{false, true} {
+			// Password accounts named root exist, the subject is not bound
+			f := newFakeLogin(t, role, oidcIdentity("attacker", "root"))
+			f.admins["root@acme"] = &m.Admin2{ID: 1, Name: "root", Domain: m.Domain{ID: 2, Name: "acme"}, Role: db.ROLE_ADMIN}
+			f.users["root@acme"] = &m.User2{ID: 1, Name: "root", Domain: m.Domain{ID: 2, Name: "acme"}}
+			auth.OIDC_AUTO_CREATE = autoCreate
+
+			r, err := f.callback()
+			if err == nil || f.sessions != 0 || f.challenges != 0 || r.Header.Get("Xpress-User") != "" {
+				t.Errorf("%s auto create %t: took over root: %v %v", role, autoCreate, err, r.Header)
+			}
+			if len(f.lookedUp) != 0 {
+				t.Errorf("%s auto create %t: looked up %v by name", role, autoCreate, f.lookedUp)
+			}
+			if !autoCreate && len(f.provisioned) != 0 {
+				t.Errorf("%s: provisioned %v without OIDC_AUTO_CREATE", role, f.provisioned)
+			}
+		}
+	}
+}
+
+func TestOIDCAutoCreate(t *testing.T) {
+	f := newFakeLogin(t, db.ROLE_USER, oidcIdentity("sub-3", "carol"))
+	auth.OIDC_AUTO_CREATE = true
+
+	r, err := f.callback()
+	if err != nil || r.Header.Get("Xpress-User") != "carol" || len(f.provisioned) != 1 || f.provisioned[0] != "carol@acme" {
+		t.Errorf("auto create: %v %v %v", err, f.provisioned, r.Header)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 	"net/http"
 	"strings"
+
+	"github.com/gorilla/mux"
 )
 
 // LoggingResponseWriter will encapsulate a standard ResponseWritter with a copy of its statusCode
@@ -29,10 +31,24 @@ func (lrw *LoggingResponseWriter) WriteHeader(code int) {
 }
 
 func (lrw *LoggingResponseWriter) Write(buf []byte) (int, error) {
-	lrw.body.Write(buf)
+	if !lrw.streaming() {
+		lrw.body.Write(buf)
+	}
 	return (lrw.ResponseWriter).Write(buf)
 }
 
+// Flush lets handlers stream, Server-Sent Events
+func (lrw *LoggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Event streams are not kept for the log, they can run for hours
+func (lrw *LoggingResponseWriter) streaming() bool {
+	return strings.HasPrefix(lrw.Header().Get("Content-Type"), "text/event-stream")
+}
+
 // Logger is a gorilla/mux middleware to add log to the API
 func Logger(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -50,7 +66,10 @@ func Logger(inner http.Handler) http.Handler {
 			r.ContentLength,
 			r.RemoteAddr)
 		for k, v := range r.Header {
-			if !strings.Contains(k, "Xpress-") {
+			if k == "Authorization" {
+				// Never log credentials or session tokens
+				log.Printf("%s: [redacted]\n", k)
+			} else if !strings.Contains(k, "Xpress-") {
 				log.Printf("%s: %v\n", k, v)
 			}
 		}
@@ -62,6 +81,44 @@ func Logger(inner http.Handler) http.Handler {
 		for k, v := range respHeaders {
 			log.Printf("%s: %v\n", k, v)
 		}
-		log.Printf("%s\n", &wrapper.body)
+		if secretResponse(r) {
+			log.Printf("[%d bytes redacted]\n", wrapper.body.Len())
+		} else if wrapper.streaming() {
+			log.Printf("[event stream]\n")
+		} else if strings.HasPrefix(respHeaders.Get("Content-Type"), "image/") {
+			log.Printf("[%d bytes %s]\n", wrapper.body.Len(), respHeaders.Get("Content-Type"))
+		} else {
+			log.Printf("%s\n", &wrapper.body)
+		}
 	})
 }
+
+// Routes returning a WireGuard private key generated by the server, or a
+// TOTP secret issued to another admin
+var keyRoutes = map[string]bool{
+	"CreateUser": true,
+	"UpdateUser": true,
+	"ServiceGetWGKey": true,
+	"ServiceRotateWGKey": true,
+	"CreateDevice": true,
+	"UpdateDevice": true,
+	"UserAddDevice": true,
+	"UserUpdateDevice": true,
+	"UserOnboardDevice": true,
+	"IssueAdminMFA": true,
+}
+
+// Responses carrying session tokens, TOTP secrets, recovery codes or
+// private keys
+func secretResponse(r *http.Request) bool {
+	if route := mux.CurrentRoute(r); route != nil && keyRoutes[route.GetName()] {
+		return true
+	}
+	path := r.URL.Path
+	switch path {
+	case APIBase + "/login", APIBase + "/servicelogin", APIBase + "/adminlogin",
+		APIBase + "/refresh", APIBase + "/oidc/callback":
+		return true
+	}
+	return strings.HasPrefix(path, APIBase+"/mfa/") || strings.HasPrefix(path, APIBase+"/changedomain/")
+}
@@ -22,6 +22,8 @@ func NewRouter() *mux.Router {
 	routes = append(routes, routes5...)
 	routes = append(routes, routes6...)
 	routes = append(routes, routes7...)
+	routes = append(routes, routes8...)
+	routes = append(routes, routes9...)
 
 	for _, route := range routes {
 		sub.
@@ -37,12 +37,36 @@ var routes0 = Routes{
 		"/adminlogin",
 		handler.Login,
 	},
+	Route{
+		"Login",
+		"POST",
+		"/adminlogin",
+		handler.Login,
+	},
 	Route{
 		"Login",
 		"GET",
 		"/logout",
 		handler.Logout,
 	},
+	Route{
+		"Refresh",
+		"POST",
+		"/refresh",
+		handler.Login,
+	},
+	Route{
+		"OIDCLogin",
+		"GET",
+		"/oidc/login",
+		handler.OIDCLogin,
+	},
+	Route{
+		"OIDCCallback",
+		"GET",
+		"/oidc/callback",
+		handler.Login,
+	},
 }
 
 // For domain
@@ -83,6 +107,42 @@ var routes1 = Routes{
 		"/changedomain/{id}",
 		handler.ChangeDomain,
 	},
+	Route{
+		"ReadLDAPConfig",
+		"GET",
+		"/domains/ldap/{id}",
+		handler.ReadLDAPConfig,
+	},
+	Route{
+		"UpdateLDAPConfig",
+		"PUT",
+		"/domains/ldap/{id}",
+		handler.UpdateLDAPConfig,
+	},
+	Route{
+		"DeleteLDAPConfig",
+		"DELETE",
+		"/domains/ldap/{id}",
+		handler.DeleteLDAPConfig,
+	},
+	Route{
+		"SyncLDAP",
+		"POST",
+		"/domains/ldap/sync/{id}",
+		handler.SyncLDAP,
+	},
+	Route{
+		"ReadIPPool",
+		"GET",
+		"/domains/ippool/{id}",
+		handler.ReadIPPool,
+	},
+	Route{
+		"ReadKeyRotation",
+		"GET",
+		"/domains/keyrotation/{id}",
+		handler.ReadKeyRotation,
+	},
 }
 
 // For admin
@@ -117,6 +177,68 @@ var routes2 = Routes{
 		"/admins/{id}",
 		handler.DeleteAdmin,
 	},
+	Route{
+		"IssueAdminMFA",
+		"POST",
+		"/admins/mfa/{id}",
+		handler.IssueAdminMFA,
+	},
+	Route{
+		"ResetAdminMFA",
+		"DELETE",
+		"/admins/mfa/{id}",
+		handler.ResetAdminMFA,
+	},
+	Route{
+		"UnlockAdmin",
+		"POST",
+		"/admins/unlock/{id}",
+		handler.UnlockAdmin,
+	},
+	Route{
+		"LinkAdminOIDC",
+		"POST",
+		"/admins/oidc/{id}",
+		handler.LinkAdminOIDC,
+	},
+	Route{
+		"UnlinkAdminOIDC",
+		"DELETE",
+		"/admins/oidc/{id}",
+		handler.UnlinkAdminOIDC,
+	},
+
+	// MFA of the logged in admin
+	Route{
+		"GetMFA",
+		"GET",
+		"/mfa",
+		handler.GetMFA,
+	},
+	Route{
+		"EnrollMFA",
+		"POST",
+		"/mfa/enroll",
+		handler.EnrollMFA,
+	},
+	Route{
+		"VerifyMFA",
+		"POST",
+		"/mfa/verify",
+		handler.VerifyMFA,
+	},
+	Route{
+		"DisableMFA",
+		"POST",
+		"/mfa/disable",
+		handler.DisableMFA,
+	},
+	Route{
+		"RegenerateRecoveryCodes",
+		"POST",
+		"/mfa/recoverycodes",
+		handler.RegenerateRecoveryCodes,
+	},
 }
 
 // For user
@@ -163,6 +285,72 @@ var routes3 = Routes{
 		"/users/groups/{id}",
 		handler.ReadUserGroups,
 	},
+	Route{
+		"UnlockUser",
+		"POST",
+		"/users/unlock/{id}",
+		handler.UnlockUser,
+	},
+	Route{
+		"LinkUserOIDC",
+		"POST",
+		"/users/oidc/{id}",
+		handler.LinkUserOIDC,
+	},
+	Route{
+		"UnlinkUserOIDC",
+		"DELETE",
+		"/users/oidc/{id}",
+		handler.UnlinkUserOIDC,
+	},
+	Route{
+		"ReadUserWireGuardConf",
+		"GET",
+		"/users/wireguard/{id}",
+		handler.ReadUserWireGuardConf,
+	},
+	Route{
+		"ReadDevices",
+		"GET",
+		"/users/{id}/devices",
+		handler.ReadDevices,
+	},
+	Route{
+		"CreateDevice",
+		"POST",
+		"/users/{id}/devices",
+		handler.CreateDevice,
+	},
+	Route{
+		"ReadDevice",
+		"GET",
+		"/users/{id}/devices/{id2}",
+		handler.ReadDevice,
+	},
+	Route{
+		"UpdateDevice",
+		"PUT",
+		"/users/{id}/devices/{id2}",
+		handler.UpdateDevice,
+	},
+	Route{
+		"DeleteDevice",
+		"DELETE",
+		"/users/{id}/devices/{id2}",
+		handler.DeleteDevice,
+	},
+	Route{
+		"ReadUserAttributes",
+		"GET",
+		"/users/{id}/attributes",
+		handler.ReadUserAttributes,
+	},
+	Route{
+		"UpdateUserAttributes",
+		"PUT",
+		"/users/{id}/attributes",
+		handler.UpdateUserAttributes,
+	},
 }
 
 // For service
@@ -197,6 +385,12 @@ var routes4 = Routes{
 		"/services/{id}",
 		handler.DeleteService,
 	},
+	Route{
+		"UnlockService",
+		"POST",
+		"/services/unlock/{id}",
+		handler.UnlockService,
+	},
 }
 
 // For allowed app
@@ -238,6 +432,36 @@ var routes5 = Routes{
 		handler.DeleteApp,
 	},
 	// Policies
+	Route{
+		"SimulatePolicy",
+		"POST",
+		"/policies/simulate",
+		handler.SimulatePolicy,
+	},
+	Route{
+		"ReadPolicyRevisions",
+		"GET",
+		"/policies/revisions",
+		handler.ReadPolicyRevisions,
+	},
+	Route{
+		"ReadPolicyRevision",
+		"GET",
+		"/policies/revisions/{id}",
+		handler.ReadPolicyRevision,
+	},
+	Route{
+		"DiffPolicyRevisions",
+		"GET",
+		"/policies/revisions/{id}/diff/{id2}",
+		handler.DiffPolicyRevisions,
+	},
+	Route{
+		"RollbackPolicy",
+		"POST",
+		"/policies/rollback/{id}",
+		handler.RollbackPolicy,
+	},
 	Route{
 		"GetPolicy",
 		"GET",
@@ -288,6 +512,32 @@ var routes5 = Routes{
 		"/groups/access/{id}/{id2}",
 		handler.GroupDelAccess,
 	},
+
+	// Access Requests
+	Route{
+		"ReadAccessRequests",
+		"GET",
+		"/accessrequests",
+		handler.ReadAccessRequests,
+	},
+	Route{
+		"ReadAccessRequest",
+		"GET",
+		"/accessrequests/{id}",
+		handler.ReadAccessRequest,
+	},
+	Route{
+		"ApproveAccessRequest",
+		"POST",
+		"/accessrequests/approve/{id}",
+		handler.ApproveAccessRequest,
+	},
+	Route{
+		"DenyAccessRequest",
+		"POST",
+		"/accessrequests/deny/{id}",
+		handler.DenyAccessRequest,
+	},
 }
 
 // For user group
@@ -310,6 +560,12 @@ var routes6 = Routes{
 		"/groups/access",
 		handler.GroupAccessAll,
 	},
+	Route{
+		"PreviewGroupRule",
+		"POST",
+		"/groups/preview",
+		handler.PreviewGroupRule,
+	},
 	Route{
 		"ReadGroup",
 		"GET",
@@ -356,4 +612,162 @@ var routes7 = Routes{
 		"/groupmembers/{id}",
 		handler.ReadGroupMembers,
 	},
+	Route{
+		"AddGroupChildren",
+		"POST",
+		"/groupmembers/addgroups/{id}",
+		handler.AddGroupChildren,
+	},
+	Route{
+		"RemoveGroupChildren",
+		"POST",
+		"/groupmembers/removegroups/{id}",
+		handler.RemoveGroupChildren,
+	},
+	Route{
+		"ReadGroupChildren",
+		"GET",
+		"/groupmembers/groups/{id}",
+		handler.ReadGroupChildren,
+	},
+}
+
+// For user self-service, the client app
+var routes8 = Routes{
+	Route{
+		"UserGetProfile",
+		"GET",
+		"/userapi/profile",
+		handler.UserGetProfile,
+	},
+	Route{
+		"UserChangePassword",
+		"PUT",
+		"/userapi/password",
+		handler.UserChangePassword,
+	},
+	Route{
+		"UserSetWGKey",
+		"PUT",
+		"/userapi/wgkey",
+		handler.UserSetWGKey,
+	},
+	Route{
+		"UserGetGroups",
+		"GET",
+		"/userapi/groups",
+		handler.UserGetGroups,
+	},
+	Route{
+		"UserGetApps",
+		"GET",
+		"/userapi/apps",
+		handler.UserGetApps,
+	},
+	Route{
+		"UserWireGuardConf",
+		"GET",
+		"/userapi/wireguard.conf",
+		handler.UserWireGuardConf,
+	},
+	Route{
+		"UserGetDevices",
+		"GET",
+		"/userapi/devices",
+		handler.UserGetDevices,
+	},
+	Route{
+		"UserAddDevice",
+		"POST",
+		"/userapi/devices",
+		handler.UserAddDevice,
+	},
+	Route{
+		"UserOnboardDevice",
+		"POST",
+		"/userapi/devices/onboard",
+		handler.UserOnboardDevice,
+	},
+	Route{
+		"UserUpdateDevice",
+		"PUT",
+		"/userapi/devices/{id}",
+		handler.UserUpdateDevice,
+	},
+	Route{
+		"UserDeleteDevice",
+		"DELETE",
+		"/userapi/devices/{id}",
+		handler.UserDeleteDevice,
+	},
+	Route{
+		"UserGetAccessRequests",
+		"GET",
+		"/userapi/accessrequests",
+		handler.UserGetAccessRequests,
+	},
+	Route{
+		"UserAddAccessRequest",
+		"POST",
+		"/userapi/accessrequests",
+		handler.UserAddAccessRequest,
+	},
+	Route{
+		"UserCancelAccessRequest",
+		"DELETE",
+		"/userapi/accessrequests/{id}",
+		handler.UserCancelAccessRequest,
+	},
+	Route{
+		"UserStreamPolicies",
+		"GET",
+		"/userapi/policies/stream",
+		handler.UserStreamPolicies,
+	},
+}
+
+// For the agent on service nodes
+var routes9 = Routes{
+	Route{
+		"ServiceGetPeers",
+		"GET",
+		"/serviceapi/peers",
+		handler.ServiceGetPeers,
+	},
+	Route{
+		"ServiceStreamPeers",
+		"GET",
+		"/serviceapi/peers/stream",
+		handler.ServiceStreamPeers,
+	},
+	Route{
+		"ServiceSetWGKey",
+		"PUT",
+		"/serviceapi/wgkey",
+		handler.ServiceSetWGKey,
+	},
+	Route{
+		"ServiceGetWGKey",
+		"GET",
+		"/serviceapi/wgkey",
+		handler.ServiceGetWGKey,
+	},
+	Route{
+		"ServiceRotateWGKey",
+		"POST",
+		"/serviceapi/wgkey/rotate",
+		handler.ServiceRotateWGKey,
+	},
+	Route{
+		"ServiceWireGuardConf",
+		"GET",
+		"/serviceapi/wireguard.conf",
+		handler.ServiceWireGuardConf,
+	},
+	Route{
+		"ServiceFirewall",
+		"GET",
+		"/serviceapi/firewall",
+		handler.ServiceFirewall,
+	},
 }
@@ -0,0 +1,158 @@
+// Package schedule decides whether a time-bound access grant is in effect,
+// its validity period and recurring windows
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// Domain timezones without the zoneinfo of the host
+	_ "time/tzdata"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+var weekdays = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// Minutes since midnight of HH:MM, 24:00 is the end of the day
+func minutes(hhmm string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(hhmm, "%d:%d", &h, &m); err != nil || len(hhmm) != 5 {
+		return 0, fmt.Errorf("invalid time %s, HH:MM", hhmm)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %s, HH:MM", hhmm)
+	}
+	return h*60 + m, nil
+}
+
+func onDay(w *model.AccessWindow, day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		for _, d := range weekdays[strings.ToLower(name)] {
+			if d == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks the windows of a grant
+func Validate(windows []model.AccessWindow) error {
+	for _, w := range windows {
+		for _, name := range w.Days {
+			if weekdays[strings.ToLower(name)] == nil {
+				return fmt.Errorf("invalid day %s, sun..sat, weekdays or weekends", name)
+			}
+		}
+		start, err := minutes(w.Start)
+		if err != nil {
+			return err
+		}
+		end, err := minutes(w.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("empty window %s-%s", w.Start, w.End)
+		}
+	}
+	return nil
+}
+
+// Open reports whether t falls in one of the windows, in the timezone loc.
+// No windows - always open
+func Open(windows []model.AccessWindow, t time.Time, loc *time.Location) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	for i := range windows {
+		w := &windows[i]
+		start, err := minutes(w.Start)
+		if err != nil {
+			continue
+		}
+		end, err := minutes(w.End)
+		if err != nil {
+			continue
+		}
+		if start < end {
+			if onDay(w, t.Weekday()) && now >= start && now < end {
+				return true
+			}
+			continue
+		}
+		// Past midnight, the days are the days it starts
+		if onDay(w, t.Weekday()) && now >= start {
+			return true
+		}
+		if onDay(w, (t.Weekday()+6)%7) && now < end {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the grant is in effect at t
+func Active(g *model.AccessGrant, t time.Time, loc *time.Location) bool {
+	if g == nil {
+		return true
+	}
+	if g.ValidFrom != nil && t.Before(*g.ValidFrom) {
+		return false
+	}
+	if g.ValidUntil != nil && !t.Before(*g.ValidUntil) {
+		return false
+	}
+	return Open(g.Schedule, t, loc)
+}
+
+// Encode the windows for the schedule column, nil for none
+func Encode(windows []model.AccessWindow) interface{} {
+	if len(windows) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(windows)
+	return string(data)
+}
+
+// Decode the schedule column
+func Decode(str string) ([]model.AccessWindow, error) {
+	if str == "" {
+		return nil, nil
+	}
+	var windows []model.AccessWindow
+	if err := json.Unmarshal([]byte(str), &windows); err != nil {
+		return nil, fmt.Errorf("invalid schedule %s", str)
+	}
+	return windows, nil
+}
+
+// Location of the timezone of a domain, UTC if unknown
+func Location(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
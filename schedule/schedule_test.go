@@ -0,0 +1,170 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// Week of Monday 2026-10-19, in UTC
+func at(day time.Weekday, hhmm string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", "2026-10-19 "+hhmm)
+	if err != nil {
+		panic(err)
+	}
+	offset := (int(day) + 6) % 7
+	return t.AddDate(0, 0, offset)
+}
+
+func TestOpen(t *testing.T) {
+	if d := at(time.Monday, "00:00").Weekday(); d != time.Monday {
+		t.Fatalf("reference week starts on %v", d)
+	}
+	if d := at(time.Sunday, "00:00").Weekday(); d != time.Sunday {
+		t.Fatalf("reference Sunday is %v", d)
+	}
+
+	office := []model.AccessWindow{{Days: []string{"weekdays"}, Start: "09:00", End: "17:00"}}
+	night := []model.AccessWindow{{Days: []string{"mon"}, Start: "22:00", End: "02:00"}}
+	weekendNights := []model.AccessWindow{{Days: []string{"weekends"}, Start: "22:00", End: "06:00"}}
+	saturdayLate := []model.AccessWindow{{Days: []string{"Sat"}, Start: "23:00", End: "01:00"}}
+	everyNight := []model.AccessWindow{{Start: "22:00", End: "02:00"}}
+	evening := []model.AccessWindow{{Days: []string{"fri"}, Start: "18:00", End: "24:00"}}
+	allDay := []model.AccessWindow{{Days: []string{"wed"}, Start: "00:00", End: "24:00"}}
+	two := []model.AccessWindow{
+		{Days: []string{"tue"}, Start: "09:00", End: "10:00"},
+		{Days: []string{"thu"}, Start: "23:00", End: "01:00"},
+	}
+
+	tests := []struct {
+		name    string
+		windows []model.AccessWindow
+		t       time.Time
+		want    bool
+	}{
+		{"no windows", nil, at(time.Sunday, "03:00"), true},
+
+		{"office open", office, at(time.Wednesday, "09:00"), true},
+		{"office before", office, at(time.Wednesday, "08:59"), false},
+		{"office end excluded", office, at(time.Wednesday, "17:00"), false},
+		{"office weekend", office, at(time.Saturday, "12:00"), false},
+
+		// Windows past midnight belong to the day they start
+		{"night start", night, at(time.Monday, "22:00"), true},
+		{"night before midnight", night, at(time.Monday, "23:59"), true},
+		{"night after midnight", night, at(time.Tuesday, "00:00"), true},
+		{"night before end", night, at(time.Tuesday, "01:59"), true},
+		{"night end excluded", night, at(time.Tuesday, "02:00"), false},
+		{"night of previous day", night, at(time.Monday, "01:00"), false},
+		{"night not on tuesday", night, at(time.Tuesday, "23:00"), false},
+		{"night before start", night, at(time.Monday, "21:59"), false},
+
+		{"weekend saturday night", weekendNights, at(time.Saturday, "23:00"), true},
+		{"weekend sunday morning", weekendNights, at(time.Sunday, "05:59"), true},
+		{"weekend monday morning", weekendNights, at(time.Monday, "05:00"), true},
+		{"weekend saturday morning", weekendNights, at(time.Saturday, "05:00"), false},
+		{"weekend monday night", weekendNights, at(time.Monday, "23:00"), false},
+
+		// Saturday to Sunday wraps the week
+		{"saturday late", saturdayLate, at(time.Saturday, "23:30"), true},
+		{"saturday late into sunday", saturdayLate, at(time.Sunday, "00:30"), true},
+		{"saturday late over", saturdayLate, at(time.Sunday, "01:00"), false},
+		{"sunday late", saturdayLate, at(time.Sunday, "23:30"), false},
+
+		{"every night", everyNight, at(time.Thursday, "01:00"), true},
+		{"every night day", everyNight, at(time.Thursday, "12:00"), false},
+
+		{"until end of day", evening, at(time.Friday, "23:59"), true},
+		{"until end of day over", evening, at(time.Saturday, "00:00"), false},
+
+		{"all day", allDay, at(time.Wednesday, "00:00"), true},
+		{"all day last minute", allDay, at(time.Wednesday, "23:59"), true},
+		{"all day next day", allDay, at(time.Thursday, "00:00"), false},
+
+		{"second window", two, at(time.Friday, "00:30"), true},
+		{"between windows", two, at(time.Wednesday, "09:30"), false},
+	}
+	for _, tt := range tests {
+		if got := Open(tt.windows, tt.t, time.UTC); got != tt.want {
+			t.Errorf("%s: Open at %s = %v, want %v", tt.name, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestOpenLocation(t *testing.T) {
+	kolkata := Location("Asia/Kolkata")
+	if kolkata == time.UTC {
+		t.Fatal("Asia/Kolkata unknown")
+	}
+	office := []model.AccessWindow{{Days: []string{"mon"}, Start: "09:00", End: "17:00"}}
+	night := []model.AccessWindow{{Days: []string{"mon"}, Start: "22:00", End: "02:00"}}
+
+	tests := []struct {
+		windows []model.AccessWindow
+		t       time.Time
+		want    bool
+	}{
+		// 09:30 in Kolkata
+		{office, at(time.Monday, "04:00"), true},
+		// 17:30 in Kolkata
+		{office, at(time.Monday, "12:00"), false},
+		// Tuesday 01:00 in Kolkata, Monday in UTC
+		{night, at(time.Monday, "19:30"), true},
+		// Tuesday 02:00 in Kolkata
+		{night, at(time.Monday, "20:30"), false},
+	}
+	for _, tt := range tests {
+		if got := Open(tt.windows, tt.t, kolkata); got != tt.want {
+			t.Errorf("Open at %s UTC in Kolkata = %v, want %v", tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		window model.AccessWindow
+		valid  bool
+	}{
+		{model.AccessWindow{Start: "09:00", End: "17:00"}, true},
+		{model.AccessWindow{Days: []string{"Mon", "weekends"}, Start: "22:00", End: "02:00"}, true},
+		{model.AccessWindow{Start: "00:00", End: "24:00"}, true},
+		{model.AccessWindow{Days: []string{"monday"}, Start: "09:00", End: "17:00"}, false},
+		{model.AccessWindow{Start: "09:00", End: "09:00"}, false},
+		{model.AccessWindow{Start: "9:00", End: "17:00"}, false},
+		{model.AccessWindow{Start: "09:60", End: "17:00"}, false},
+		{model.AccessWindow{Start: "24:01", End: "17:00"}, false},
+		{model.AccessWindow{Start: "25:00", End: "17:00"}, false},
+		{model.AccessWindow{Start: "", End: "17:00"}, false},
+	}
+	for _, tt := range tests {
+		err := Validate([]model.AccessWindow{tt.window})
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tt.window, err, tt.valid)
+		}
+	}
+}
+
+func TestActive(t *testing.T) {
+	from := at(time.Monday, "09:00")
+	until := at(time.Friday, "17:00")
+	grant := &model.AccessGrant{ValidFrom: &from, ValidUntil: &until}
+
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(time.Monday, "08:59"), false},
+		{at(time.Monday, "09:00"), true},
+		{at(time.Friday, "16:59"), true},
+		{at(time.Friday, "17:00"), false},
+	}
+	for _, tt := range tests {
+		if got := Active(grant, tt.t, time.UTC); got != tt.want {
+			t.Errorf("Active at %s = %v, want %v", tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+	if !Active(nil, from, time.UTC) {
+		t.Errorf("Active(nil) = false, want true")
+	}
+}
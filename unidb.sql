@@ -42,9 +42,12 @@ CREATE TABLE public.admins (
     id integer NOT NULL,
     name character varying(50) NOT NULL,
     domain_id integer NOT NULL,
-    password character varying(100),
+    password character varying(255),
     icon integer,
-    status character(1) NOT NULL
+    status character(1) NOT NULL,
+    totp_secret character varying(64),
+    totp_enabled boolean DEFAULT false NOT NULL,
+    totp_last_step bigint DEFAULT 0 NOT NULL
 );
 
 
@@ -147,7 +150,11 @@ ALTER TABLE public.app_sessions OWNER TO postgres;
 CREATE TABLE public.domains (
     id integer NOT NULL,
     name character varying(50) NOT NULL,
-    status character(1) NOT NULL
+    status character(1) NOT NULL,
+    mfa_required boolean DEFAULT false NOT NULL,
+    ip_pool cidr,
+    key_rotation_days integer DEFAULT 0 NOT NULL,
+    timezone character varying(64) DEFAULT 'UTC'::character varying NOT NULL
 );
 
 
@@ -183,7 +190,11 @@ CREATE TABLE public.group_access_control (
     id integer NOT NULL,
     group_id integer NOT NULL,
     app_id integer NOT NULL,
-    status character(1) NOT NULL
+    status character(1) NOT NULL,
+    valid_from timestamp with time zone,
+    valid_until timestamp with time zone,
+    schedule text,
+    effect character varying(8) DEFAULT 'allow'::character varying NOT NULL
 );
 
 
@@ -268,13 +279,15 @@ CREATE TABLE public.services (
     id integer NOT NULL,
     name character varying(50) NOT NULL,
     domain_id integer NOT NULL,
-    password character varying(100),
+    password character varying(255),
     icon integer,
     status character(1) NOT NULL,
     wg_key character varying(200),
     virtual_ip inet,
     public_ip inet,
-    local_ip inet
+    local_ip inet,
+    wg_key_time timestamp without time zone,
+    wg_private_key text
 );
 
 
@@ -323,12 +336,15 @@ ALTER TABLE public.sessions_id_seq OWNER TO postgres;
 CREATE TABLE public.sessions (
     id integer DEFAULT nextval('public.sessions_id_seq'::regclass) NOT NULL,
     uid integer NOT NULL,
-    session_id character varying(50) NOT NULL,
+    session_id character varying(64) NOT NULL,
     start_time timestamp without time zone NOT NULL,
     end_time timestamp without time zone,
     role character(1) NOT NULL,
     status character(1) NOT NULL,
-    domain_id integer
+    domain_id integer,
+    last_seen timestamp without time zone,
+    refresh_token character varying(64),
+    refresh_expire_time timestamp without time zone
 );
 
 
@@ -342,7 +358,11 @@ CREATE TABLE public.user_access_control (
     id integer NOT NULL,
     user_id integer NOT NULL,
     app_id integer NOT NULL,
-    status character(1) NOT NULL
+    status character(1) NOT NULL,
+    valid_from timestamp with time zone,
+    valid_until timestamp with time zone,
+    schedule text,
+    effect character varying(8) DEFAULT 'allow'::character varying NOT NULL
 );
 
 
@@ -378,17 +398,481 @@ CREATE TABLE public.user_groups (
     id integer NOT NULL,
     name character varying(50) NOT NULL,
     domain_id integer NOT NULL,
+    status character(1) NOT NULL,
+    rule text
+);
+
+
+ALTER TABLE public.user_groups OWNER TO postgres;
+
+--
+-- Name: user_groups_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.user_groups_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.user_groups_id_seq OWNER TO postgres;
+
+--
+-- Name: user_groups_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.user_groups_id_seq OWNED BY public.user_groups.id;
+
+
+--
+-- Name: user_session_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.user_session_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    MAXVALUE 2147483647
+    CACHE 1;
+
+
+ALTER TABLE public.user_session_id_seq OWNER TO postgres;
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    name character varying(50) NOT NULL,
+    domain_id integer NOT NULL,
+    password character varying(255),
+    public_key character varying(200),
+    icon integer,
+    status character(1) NOT NULL,
+    wg_key character varying(200),
+    virtual_ip inet,
+    public_ip inet,
+    local_ip inet,
+    external boolean DEFAULT false NOT NULL,
+    wg_key_time timestamp without time zone
+);
+
+
+ALTER TABLE public.users OWNER TO postgres;
+
+--
+-- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.users_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.users_id_seq OWNER TO postgres;
+
+--
+-- Name: users_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
+
+
+--
+-- Name: admin_recovery_codes_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.admin_recovery_codes_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.admin_recovery_codes_id_seq OWNER TO postgres;
+
+--
+-- Name: admin_recovery_codes; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.admin_recovery_codes (
+    id integer DEFAULT nextval('public.admin_recovery_codes_id_seq'::regclass) NOT NULL,
+    admin_id integer NOT NULL,
+    code_hash character varying(64) NOT NULL,
+    create_time timestamp without time zone NOT NULL,
+    used_time timestamp without time zone,
+    status character(1) NOT NULL
+);
+
+
+ALTER TABLE public.admin_recovery_codes OWNER TO postgres;
+
+--
+-- Name: admin_recovery_codes_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.admin_recovery_codes_id_seq OWNED BY public.admin_recovery_codes.id;
+
+
+--
+-- Name: mfa_challenges_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.mfa_challenges_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.mfa_challenges_id_seq OWNER TO postgres;
+
+--
+-- Name: mfa_challenges; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.mfa_challenges (
+    id integer DEFAULT nextval('public.mfa_challenges_id_seq'::regclass) NOT NULL,
+    admin_id integer NOT NULL,
+    challenge character varying(64) NOT NULL,
+    domain_id integer NOT NULL,
+    role character(1) NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    start_time timestamp without time zone NOT NULL,
     status character(1) NOT NULL
 );
 
 
-ALTER TABLE public.user_groups OWNER TO postgres;
+ALTER TABLE public.mfa_challenges OWNER TO postgres;
+
+--
+-- Name: mfa_challenges_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.mfa_challenges_id_seq OWNED BY public.mfa_challenges.id;
+
+
+--
+-- Name: login_failures_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.login_failures_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.login_failures_id_seq OWNER TO postgres;
+
+--
+-- Name: login_failures; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.login_failures (
+    id integer DEFAULT nextval('public.login_failures_id_seq'::regclass) NOT NULL,
+    kind character(1) NOT NULL,
+    key character varying(255) NOT NULL,
+    failures integer DEFAULT 0 NOT NULL,
+    last_failure timestamp without time zone NOT NULL,
+    locked boolean DEFAULT false NOT NULL,
+    locked_until timestamp without time zone
+);
+
+
+ALTER TABLE public.login_failures OWNER TO postgres;
+
+--
+-- Name: login_failures_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.login_failures_id_seq OWNED BY public.login_failures.id;
+
+
+--
+-- Name: oidc_states_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.oidc_states_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.oidc_states_id_seq OWNER TO postgres;
+
+--
+-- Name: oidc_states; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.oidc_states (
+    id integer DEFAULT nextval('public.oidc_states_id_seq'::regclass) NOT NULL,
+    state character varying(64) NOT NULL,
+    nonce character varying(64) NOT NULL,
+    verifier character varying(64) NOT NULL,
+    role character(1) NOT NULL,
+    start_time timestamp without time zone NOT NULL,
+    status character(1) NOT NULL
+);
+
+
+ALTER TABLE public.oidc_states OWNER TO postgres;
+
+--
+-- Name: oidc_states_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.oidc_states_id_seq OWNED BY public.oidc_states.id;
+
+
+--
+-- Name: oidc_identities_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.oidc_identities_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.oidc_identities_id_seq OWNER TO postgres;
+
+--
+-- Name: oidc_identities; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.oidc_identities (
+    id integer DEFAULT nextval('public.oidc_identities_id_seq'::regclass) NOT NULL,
+    issuer character varying(256) NOT NULL,
+    subject character varying(256) NOT NULL,
+    role character(1) NOT NULL,
+    principal_id integer NOT NULL,
+    domain_id integer NOT NULL,
+    create_time timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.oidc_identities OWNER TO postgres;
+
+--
+-- Name: oidc_identities_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.oidc_identities_id_seq OWNED BY public.oidc_identities.id;
+
+
+--
+-- Name: domain_ldap; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.domain_ldap (
+    domain_id integer NOT NULL,
+    url character varying(255) NOT NULL,
+    start_tls boolean DEFAULT false NOT NULL,
+    bind_dn character varying(255) NOT NULL,
+    bind_password text NOT NULL,
+    base_dn character varying(255) NOT NULL,
+    user_filter character varying(255) NOT NULL,
+    user_attr character varying(50) NOT NULL,
+    group_base_dn character varying(255),
+    group_filter character varying(255),
+    group_attr character varying(50),
+    member_attr character varying(50),
+    sync boolean DEFAULT true NOT NULL,
+    last_sync timestamp without time zone
+);
+
+
+ALTER TABLE public.domain_ldap OWNER TO postgres;
+
+--
+-- Name: app_rules_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.app_rules_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.app_rules_id_seq OWNER TO postgres;
+
+--
+-- Name: app_rules; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.app_rules (
+    id integer DEFAULT nextval('public.app_rules_id_seq'::regclass) NOT NULL,
+    app_id integer NOT NULL,
+    dest cidr NOT NULL,
+    protocol character varying(4) DEFAULT 'any'::character varying NOT NULL,
+    port_from integer DEFAULT 0 NOT NULL,
+    port_to integer DEFAULT 0 NOT NULL
+);
+
+
+ALTER TABLE public.app_rules OWNER TO postgres;
+
+--
+-- Name: app_rules_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.app_rules_id_seq OWNED BY public.app_rules.id;
+
+
+--
+-- Name: devices_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.devices_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.devices_id_seq OWNER TO postgres;
+
+--
+-- Name: devices; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.devices (
+    id integer DEFAULT nextval('public.devices_id_seq'::regclass) NOT NULL,
+    user_id integer NOT NULL,
+    name character varying(50) NOT NULL,
+    platform character varying(20),
+    wg_key character varying(200),
+    wg_key_time timestamp without time zone,
+    virtual_ip inet,
+    public_ip inet,
+    local_ip inet,
+    last_seen timestamp without time zone,
+    create_time timestamp without time zone NOT NULL,
+    status character(1) NOT NULL
+);
+
+
+ALTER TABLE public.devices OWNER TO postgres;
+
+--
+-- Name: devices_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.devices_id_seq OWNED BY public.devices.id;
+
+
+--
+-- Name: access_requests_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.access_requests_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.access_requests_id_seq OWNER TO postgres;
+
+--
+-- Name: access_requests; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.access_requests (
+    id integer DEFAULT nextval('public.access_requests_id_seq'::regclass) NOT NULL,
+    user_id integer NOT NULL,
+    app_id integer NOT NULL,
+    justification text,
+    duration_hours integer DEFAULT 0 NOT NULL,
+    state character varying(16) NOT NULL,
+    access_id integer,
+    create_time timestamp with time zone,
+    update_time timestamp with time zone
+);
+
+
+ALTER TABLE public.access_requests OWNER TO postgres;
+
+--
+-- Name: access_requests_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.access_requests_id_seq OWNED BY public.access_requests.id;
+
+
+--
+-- Name: access_request_events_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.access_request_events_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER TABLE public.access_request_events_id_seq OWNER TO postgres;
+
+--
+-- Name: access_request_events; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.access_request_events (
+    id integer DEFAULT nextval('public.access_request_events_id_seq'::regclass) NOT NULL,
+    request_id integer NOT NULL,
+    state character varying(16) NOT NULL,
+    actor_role character(1),
+    actor_id integer,
+    actor_name character varying(256),
+    comment text,
+    event_time timestamp with time zone NOT NULL
+);
+
+
+ALTER TABLE public.access_request_events OWNER TO postgres;
+
+--
+-- Name: access_request_events_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.access_request_events_id_seq OWNED BY public.access_request_events.id;
+
 
 --
--- Name: user_groups_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+-- Name: group_children_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-CREATE SEQUENCE public.user_groups_id_seq
+CREATE SEQUENCE public.group_children_id_seq
     AS integer
     START WITH 1
     INCREMENT BY 1
@@ -397,55 +881,69 @@ CREATE SEQUENCE public.user_groups_id_seq
     CACHE 1;
 
 
-ALTER TABLE public.user_groups_id_seq OWNER TO postgres;
+ALTER TABLE public.group_children_id_seq OWNER TO postgres;
 
 --
--- Name: user_groups_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+-- Name: group_children; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER SEQUENCE public.user_groups_id_seq OWNED BY public.user_groups.id;
+CREATE TABLE public.group_children (
+    id integer DEFAULT nextval('public.group_children_id_seq'::regclass) NOT NULL,
+    group_id integer NOT NULL,
+    child_id integer NOT NULL
+);
 
 
+ALTER TABLE public.group_children OWNER TO postgres;
+
 --
--- Name: user_session_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+-- Name: group_children_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-CREATE SEQUENCE public.user_session_id_seq
+ALTER SEQUENCE public.group_children_id_seq OWNED BY public.group_children.id;
+
+
+--
+-- Name: user_attributes_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.user_attributes_id_seq
+    AS integer
     START WITH 1
     INCREMENT BY 1
     NO MINVALUE
-    MAXVALUE 2147483647
+    NO MAXVALUE
     CACHE 1;
 
 
-ALTER TABLE public.user_session_id_seq OWNER TO postgres;
+ALTER TABLE public.user_attributes_id_seq OWNER TO postgres;
 
 --
--- Name: users; Type: TABLE; Schema: public; Owner: postgres
+-- Name: user_attributes; Type: TABLE; Schema: public; Owner: postgres
 --
 
-CREATE TABLE public.users (
-    id integer NOT NULL,
-    name character varying(50) NOT NULL,
-    domain_id integer NOT NULL,
-    password character varying(100),
-    public_key character varying(200),
-    icon integer,
-    status character(1) NOT NULL,
-    wg_key character varying(200),
-    virtual_ip inet,
-    public_ip inet,
-    local_ip inet
+CREATE TABLE public.user_attributes (
+    id integer DEFAULT nextval('public.user_attributes_id_seq'::regclass) NOT NULL,
+    user_id integer NOT NULL,
+    name character varying(64) NOT NULL,
+    value character varying(256) NOT NULL
 );
 
 
-ALTER TABLE public.users OWNER TO postgres;
+ALTER TABLE public.user_attributes OWNER TO postgres;
 
 --
--- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+-- Name: user_attributes_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-CREATE SEQUENCE public.users_id_seq
+ALTER SEQUENCE public.user_attributes_id_seq OWNED BY public.user_attributes.id;
+
+
+--
+-- Name: policy_revisions_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.policy_revisions_id_seq
     AS integer
     START WITH 1
     INCREMENT BY 1
@@ -454,13 +952,33 @@ CREATE SEQUENCE public.users_id_seq
     CACHE 1;
 
 
-ALTER TABLE public.users_id_seq OWNER TO postgres;
+ALTER TABLE public.policy_revisions_id_seq OWNER TO postgres;
 
 --
--- Name: users_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+-- Name: policy_revisions; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
+CREATE TABLE public.policy_revisions (
+    id integer DEFAULT nextval('public.policy_revisions_id_seq'::regclass) NOT NULL,
+    domain_id integer NOT NULL,
+    revision integer NOT NULL,
+    change character varying(256),
+    actor_role character(1),
+    actor_id integer,
+    actor_name character varying(256),
+    grants integer DEFAULT 0 NOT NULL,
+    snapshot text NOT NULL,
+    create_time timestamp with time zone NOT NULL
+);
+
+
+ALTER TABLE public.policy_revisions OWNER TO postgres;
+
+--
+-- Name: policy_revisions_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.policy_revisions_id_seq OWNED BY public.policy_revisions.id;
 
 
 --
@@ -1405,6 +1923,20 @@ CREATE INDEX fki_user_domain_fk ON public.users USING btree (domain_id);
 CREATE UNIQUE INDEX gm_unique ON public.group_members USING btree (group_id, user_id);
 
 
+--
+-- Name: sessions_refresh_token_key; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX sessions_refresh_token_key ON public.sessions USING btree (refresh_token);
+
+
+--
+-- Name: sessions_session_id_key; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX sessions_session_id_key ON public.sessions USING btree (session_id);
+
+
 --
 -- Name: service_name_key; Type: INDEX; Schema: public; Owner: postgres
 --
@@ -1412,6 +1944,208 @@ CREATE UNIQUE INDEX gm_unique ON public.group_members USING btree (group_id, use
 CREATE UNIQUE INDEX service_name_key ON public.services USING btree (name);
 
 
+--
+-- Name: admin_recovery_codes admin_recovery_codes_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.admin_recovery_codes
+    ADD CONSTRAINT admin_recovery_codes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: admin_recovery_codes_admin_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX admin_recovery_codes_admin_id_idx ON public.admin_recovery_codes USING btree (admin_id);
+
+
+--
+-- Name: mfa_challenges mfa_challenges_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.mfa_challenges
+    ADD CONSTRAINT mfa_challenges_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: mfa_challenges_challenge_key; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX mfa_challenges_challenge_key ON public.mfa_challenges USING btree (challenge);
+
+
+--
+-- Name: login_failures login_failures_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.login_failures
+    ADD CONSTRAINT login_failures_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: login_failures_kind_key_key; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX login_failures_kind_key_key ON public.login_failures USING btree (kind, key);
+
+
+--
+-- Name: oidc_states oidc_states_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.oidc_states
+    ADD CONSTRAINT oidc_states_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: oidc_states_state_key; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX oidc_states_state_key ON public.oidc_states USING btree (state);
+
+
+--
+-- Name: oidc_identities oidc_identities_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.oidc_identities
+    ADD CONSTRAINT oidc_identities_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: oidc_identities_subject_key; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX oidc_identities_subject_key ON public.oidc_identities USING btree (issuer, subject, role);
+
+
+--
+-- Name: domain_ldap domain_ldap_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.domain_ldap
+    ADD CONSTRAINT domain_ldap_pkey PRIMARY KEY (domain_id);
+
+
+--
+-- Name: app_rules app_rules_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.app_rules
+    ADD CONSTRAINT app_rules_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: app_rules_app_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX app_rules_app_id_idx ON public.app_rules USING btree (app_id);
+
+
+--
+-- Name: devices devices_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.devices
+    ADD CONSTRAINT devices_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: devices_user_name_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX devices_user_name_idx ON public.devices USING btree (user_id, name) WHERE (status <> 'D'::bpchar);
+
+
+--
+-- Name: access_requests access_requests_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.access_requests
+    ADD CONSTRAINT access_requests_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: access_requests_pending_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX access_requests_pending_idx ON public.access_requests USING btree (user_id, app_id) WHERE ((state)::text = 'pending'::text);
+
+
+--
+-- Name: access_requests_state_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX access_requests_state_idx ON public.access_requests USING btree (state);
+
+
+--
+-- Name: access_request_events access_request_events_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.access_request_events
+    ADD CONSTRAINT access_request_events_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: access_request_events_request_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX access_request_events_request_idx ON public.access_request_events USING btree (request_id);
+
+
+--
+-- Name: group_children group_children_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.group_children
+    ADD CONSTRAINT group_children_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: group_children_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX group_children_idx ON public.group_children USING btree (group_id, child_id);
+
+
+--
+-- Name: group_children_child_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX group_children_child_idx ON public.group_children USING btree (child_id);
+
+
+--
+-- Name: user_attributes user_attributes_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.user_attributes
+    ADD CONSTRAINT user_attributes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_attributes_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX user_attributes_idx ON public.user_attributes USING btree (user_id, name);
+
+
+--
+-- Name: policy_revisions policy_revisions_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.policy_revisions
+    ADD CONSTRAINT policy_revisions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: policy_revisions_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX policy_revisions_idx ON public.policy_revisions USING btree (domain_id, revision);
+
+
 --
 -- Name: admins admin_domain_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -1460,6 +2194,102 @@ ALTER TABLE ONLY public.users
     ADD CONSTRAINT user_domain_fk FOREIGN KEY (domain_id) REFERENCES public.domains(id);
 
 
+--
+-- Name: admin_recovery_codes admin_recovery_codes_admin_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.admin_recovery_codes
+    ADD CONSTRAINT admin_recovery_codes_admin_fk FOREIGN KEY (admin_id) REFERENCES public.admins(id);
+
+
+--
+-- Name: mfa_challenges mfa_challenges_admin_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.mfa_challenges
+    ADD CONSTRAINT mfa_challenges_admin_fk FOREIGN KEY (admin_id) REFERENCES public.admins(id);
+
+
+--
+-- Name: domain_ldap domain_ldap_domain_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.domain_ldap
+    ADD CONSTRAINT domain_ldap_domain_fk FOREIGN KEY (domain_id) REFERENCES public.domains(id);
+
+
+--
+-- Name: app_rules app_rules_app_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.app_rules
+    ADD CONSTRAINT app_rules_app_fk FOREIGN KEY (app_id) REFERENCES public.apps(id);
+
+
+--
+-- Name: devices devices_user_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.devices
+    ADD CONSTRAINT devices_user_fk FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: access_requests access_requests_user_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.access_requests
+    ADD CONSTRAINT access_requests_user_fk FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: access_requests access_requests_app_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.access_requests
+    ADD CONSTRAINT access_requests_app_fk FOREIGN KEY (app_id) REFERENCES public.apps(id);
+
+
+--
+-- Name: access_request_events access_request_events_request_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.access_request_events
+    ADD CONSTRAINT access_request_events_request_fk FOREIGN KEY (request_id) REFERENCES public.access_requests(id);
+
+
+--
+-- Name: group_children group_children_group_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.group_children
+    ADD CONSTRAINT group_children_group_fk FOREIGN KEY (group_id) REFERENCES public.user_groups(id);
+
+
+--
+-- Name: group_children group_children_child_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.group_children
+    ADD CONSTRAINT group_children_child_fk FOREIGN KEY (child_id) REFERENCES public.user_groups(id);
+
+
+--
+-- Name: user_attributes user_attributes_user_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.user_attributes
+    ADD CONSTRAINT user_attributes_user_fk FOREIGN KEY (user_id) REFERENCES public.users(id);
+
+
+--
+-- Name: policy_revisions policy_revisions_domain_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.policy_revisions
+    ADD CONSTRAINT policy_revisions_domain_fk FOREIGN KEY (domain_id) REFERENCES public.domains(id);
+
+
 --
 -- PostgreSQL database dump complete
 --
@@ -0,0 +1,253 @@
+// Package wg renders WireGuard configurations from policies
+package wg
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	model "github.com/saroopmathur/rest-api/models"
+)
+
+// The server never has the private key of a user, the client app puts its
+// own in place of this
+const PRIVATE_KEY_PLACEHOLDER = "<private key>"
+
+type Interface struct {
+	PrivateKey string
+	Address    []string
+	ListenPort int
+	DNS        []string
+	MTU        int
+}
+
+type Peer struct {
+	Name       string
+	PublicKey  string
+	AllowedIPs []string
+	Endpoint   string
+	Keepalive  int
+}
+
+type Config struct {
+	Interface Interface
+	Peers     []*Peer
+}
+
+// WGQuick renders the config in the wg-quick(8) format
+func (c *Config) WGQuick() string {
+	return c.render(true)
+}
+
+// SetConf renders the config in the wg(8) setconf / syncconf format,
+// without the keys only wg-quick knows
+func (c *Config) SetConf() string {
+	return c.render(false)
+}
+
+func (c *Config) render(quick bool) string {
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", c.Interface.PrivateKey)
+	if quick && len(c.Interface.Address) > 0 {
+		fmt.Fprintf(&b, "Address = %s\n", strings.Join(c.Interface.Address, ", "))
+	}
+	if c.Interface.ListenPort > 0 {
+		fmt.Fprintf(&b, "ListenPort = %d\n", c.Interface.ListenPort)
+	}
+	if quick && len(c.Interface.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(c.Interface.DNS, ", "))
+	}
+	if quick && c.Interface.MTU > 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", c.Interface.MTU)
+	}
+	for _, p := range c.Peers {
+		b.WriteString("\n")
+		if p.Name != "" {
+			fmt.Fprintf(&b, "# %s\n", p.Name)
+		}
+		b.WriteString("[Peer]\n")
+		fmt.Fprintf(&b, "PublicKey = %s\n", p.PublicKey)
+		fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(p.AllowedIPs, ", "))
+		if p.Endpoint != "" {
+			fmt.Fprintf(&b, "Endpoint = %s\n", p.Endpoint)
+		}
+		if p.Keepalive > 0 {
+			fmt.Fprintf(&b, "PersistentKeepalive = %d\n", p.Keepalive)
+		}
+	}
+	return b.String()
+}
+
+// UserConfig builds the config of user u, one peer per service node its
+// policy reaches, with the allowed IPs of all its apps there
+func UserConfig(u *model.User2, policy *model.Policy) *Config {
+	c := &Config{}
+	c.Interface.PrivateKey = PRIVATE_KEY_PLACEHOLDER
+	if addr := hostPrefix(u.VirtualIP); addr != "" {
+		c.Interface.Address = []string{addr}
+	}
+	c.Interface.DNS = WG_DNS
+	c.Interface.MTU = WG_MTU
+
+	var names []string
+	for name := range policy.ServiceNodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := policy.ServiceNodes[name]
+		if s.WGKey == "" {
+			log.Printf("wg: service %s has no key, left out of the config of %s\n", s.Name, u.Name)
+			continue
+		}
+		var allowed []string
+		if addr := hostPrefix(s.VirtualIP); addr != "" {
+			allowed = append(allowed, addr)
+		}
+		for _, app := range s.Apps {
+			if len(app.Rules) == 0 {
+				allowed = append(allowed, SplitAllowedIPs(app.AllowedIPs)...)
+			}
+			for _, rule := range app.Rules {
+				allowed = append(allowed, rule.Dest)
+			}
+		}
+		allowed = MergeAllowedIPs(allowed)
+		if len(allowed) == 0 {
+			continue
+		}
+		c.Peers = append(c.Peers, &Peer{
+			Name:       s.Name,
+			PublicKey:  s.WGKey,
+			AllowedIPs: allowed,
+			Endpoint:   endpoint(s.PublicIP, s.LocalIP),
+			Keepalive:  WG_KEEPALIVE,
+		})
+	}
+	return c
+}
+
+// ServiceConfig builds the config of service node s, one peer per user
+// allowed to reach any of its apps. Users connect from anywhere, their
+// peers have no endpoint and only their virtual IP is allowed
+func ServiceConfig(s *model.Service2, apps []*model.PeerApp) *Config {
+	c := &Config{}
+	c.Interface.PrivateKey = PRIVATE_KEY_PLACEHOLDER
+	if addr := hostPrefix(s.VirtualIP); addr != "" {
+		c.Interface.Address = []string{addr}
+	}
+	c.Interface.ListenPort = WG_PORT
+
+	seen := map[string]bool{}
+	for _, app := range apps {
+		for _, u := range app.Peers {
+			if seen[u.WGKey] {
+				continue
+			}
+			seen[u.WGKey] = true
+			name := u.Name
+			if u.Device != "" {
+				name += "/" + u.Device
+			}
+			addr := hostPrefix(u.VirtualIP)
+			if addr == "" {
+				log.Printf("wg: user %s has no virtual IP, left out of the config of %s\n", name, s.Name)
+				continue
+			}
+			c.Peers = append(c.Peers, &Peer{Name: name, PublicKey: u.WGKey, AllowedIPs: []string{addr}})
+		}
+	}
+	sort.Slice(c.Peers, func(i, j int) bool { return c.Peers[i].Name < c.Peers[j].Name })
+	return c
+}
+
+// host:WG_PORT of the first address set
+func endpoint(addrs ...string) string {
+	for _, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		if i := strings.IndexByte(addr, '/'); i >= 0 {
+			addr = addr[:i]
+		}
+		return net.JoinHostPort(addr, fmt.Sprint(WG_PORT))
+	}
+	return ""
+}
+
+// An address as a single host prefix, e.g. 10.8.0.2/32
+func hostPrefix(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// SplitAllowedIPs splits the free form allowed_ips of an app on commas,
+// semicolons and spaces
+func SplitAllowedIPs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// MergeAllowedIPs normalizes addresses and CIDRs to prefixes, and drops
+// duplicates and prefixes inside others. Anything else is left out
+func MergeAllowedIPs(list []string) []string {
+	var nets []*net.IPNet
+	for _, item := range list {
+		prefix := item
+		if !strings.Contains(item, "/") {
+			prefix = hostPrefix(item)
+		}
+		_, n, err := net.ParseCIDR(prefix)
+		if err != nil {
+			log.Printf("wg: invalid allowed IP %q ignored\n", item)
+			continue
+		}
+		nets = append(nets, n)
+	}
+
+	// Widest first, so a prefix is only compared with the ones kept before it
+	sort.SliceStable(nets, func(i, j int) bool {
+		oi, _ := nets[i].Mask.Size()
+		oj, _ := nets[j].Mask.Size()
+		return oi < oj
+	})
+	var kept []*net.IPNet
+	for _, n := range nets {
+		inside := false
+		for _, k := range kept {
+			ko, kb := k.Mask.Size()
+			no, nb := n.Mask.Size()
+			if kb == nb && ko <= no && k.Contains(n.IP) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			kept = append(kept, n)
+		}
+	}
+
+	result := make([]string, len(kept))
+	for i, n := range kept {
+		result[i] = n.String()
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,27 @@
+package wg
+
+import "github.com/saroopmathur/rest-api/config"
+
+// WireGuard settings of the generated configurations, overridden from
+// .env / environment
+
+// Port service nodes listen on, the port of their endpoints
+var WG_PORT = 51820
+
+// Interface of service nodes, filtered by the generated firewall rules
+var WG_INTERFACE = "wg0"
+
+// PersistentKeepalive of clients towards service nodes, 0 - none
+var WG_KEEPALIVE = 25
+
+// DNS servers and MTU of clients, none by default
+var WG_DNS []string
+var WG_MTU = 0
+
+func init() {
+	config.Int("WG_PORT", &WG_PORT)
+	config.String("WG_INTERFACE", &WG_INTERFACE)
+	config.Int("WG_KEEPALIVE", &WG_KEEPALIVE)
+	config.Int("WG_MTU", &WG_MTU)
+	config.List("WG_DNS", &WG_DNS)
+}
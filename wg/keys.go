@@ -0,0 +1,40 @@
+package wg
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// GenerateKeyPair returns a new private key and its public key, base64 as
+// by wg genkey and wg pubkey
+func GenerateKeyPair() (string, string, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		return "", "", err
+	}
+	// Clamped as by wg genkey
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(private), base64.StdEncoding.EncodeToString(public), nil
+}
+
+// PublicKey of the base64 private key
+func PublicKey(private string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(private)
+	if err != nil || len(key) != curve25519.ScalarSize {
+		return "", fmt.Errorf("invalid private key")
+	}
+	public, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(public), nil
+}